@@ -1,62 +1,21 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"os"
 )
 
-func EncryptToFile(filename string, key []byte) { //key type?
-	//read plaintext from the file
-	toBeEncrypted, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Println("Something went wrong when reading the plaintext file", filename)
-		fmt.Println("Error", err)
-		return
-	}
-
-	//do some encrypting stuff
-	block, _ := aes.NewCipher(key)
-	iv := make([]byte, block.BlockSize())
-	rand.Read(iv)
-	stream := cipher.NewCTR(block, iv)
-	cipherArray := make([]byte, len(toBeEncrypted))
-	ciphertext := append(iv, cipherArray...)
-	stream.XORKeyStream(ciphertext[block.BlockSize():], toBeEncrypted)
-
-	//overwrite the original file (truncate it)
-	file, _ := os.Create(filename)
-	defer file.Close()
-
-	//write ciphertext to the file
-	file.WriteString(string(ciphertext))
+//EncryptToFile replaces the file with the streaming format from StreamAES.go, the key is derived from the password
+func EncryptToFile(filename string, password string) error {
+	return EncryptFile(filename, filename, password)
 }
 
-func DecryptFromFile(filename string, key []byte) {
-	//read ciphertext from the file
-	toBeDecrypted, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Println("Something went wrong when reading the ciphertext file", filename)
-		fmt.Println("Error", err)
-		return
-	}
-
-	//do some decrypting stuff
-	block, _ := aes.NewCipher(key)
-	plaintext := make([]byte, len(toBeDecrypted)-block.BlockSize())
-	iv := toBeDecrypted[:block.BlockSize()]
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(plaintext, toBeDecrypted[block.BlockSize():])
-
-	//overwrite the original file (truncate it)
-	file, _ := os.Create(filename)
-	defer file.Close()
-
-	//write plaintext to the file
-	file.WriteString(string(plaintext))
+//DecryptFromFile only replaces the file if every chunk could be decrypted and checked
+func DecryptFromFile(filename string, password string) error {
+	return DecryptFile(filename, filename, password)
 }
 
 func generateAESKey() []byte {
@@ -66,6 +25,15 @@ func generateAESKey() []byte {
 }
 
 func main() {
+	if IsFileCommand(os.Args[1:]) {
+		err := RunFileCommand(os.Args[1:])
+		if err != nil {
+			fmt.Println("Error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("First we test RSA encryption on its own")
 	RunRSA()
 
@@ -101,7 +69,8 @@ func main() {
 }
 
 func runEncryption(filename string, toBeEncrypted []byte, print bool) []byte {
-	key := generateAESKey()
+	//a random password, so the demo does not need any input
+	password := hex.EncodeToString(generateAESKey())
 
 	f, _ := os.Create(filename)
 	defer f.Close()
@@ -111,13 +80,21 @@ func runEncryption(filename string, toBeEncrypted []byte, print bool) []byte {
 		fmt.Println("Plaintext before:", string(m))
 	}
 
-	EncryptToFile(filename, key)
+	err := EncryptToFile(filename, password)
+	if err != nil {
+		fmt.Println("Something went wrong when encrypting the file", filename)
+		fmt.Println("Error", err)
+	}
 	c, _ := os.ReadFile(filename)
 	if print {
 		fmt.Println("After encryption the file contains:", string(c))
 	}
 
-	DecryptFromFile(filename, key)
+	err = DecryptFromFile(filename, password)
+	if err != nil {
+		fmt.Println("Something went wrong when decrypting the file", filename)
+		fmt.Println("Error", err)
+	}
 	d, _ := os.ReadFile(filename)
 	if print {
 		fmt.Println("After decryption the file contains:", string(d))
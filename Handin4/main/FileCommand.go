@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+//IsFileCommand tells main if it was started as a file tool, e.g. "go run . encrypt -in secret.txt"
+func IsFileCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "encrypt" || args[0] == "decrypt")
+}
+
+//RunFileCommand runs the encrypt/decrypt subcommands, without -out the input file is replaced
+func RunFileCommand(args []string) error {
+	if !IsFileCommand(args) {
+		return errors.New("usage: encrypt|decrypt -in FILE [-out FILE] [-password-file FILE]")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	in := flags.String("in", "", "file to "+command)
+	out := flags.String("out", "", "where to write the result, defaults to replacing the input file")
+	passwordFile := flags.String("password-file", "", "read the password from this file instead of AES_PASSWORD or the terminal")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if *in == "" {
+		flags.Usage()
+		return errors.New("-in is required")
+	}
+	if *out == "" {
+		*out = *in
+	}
+
+	password, err := readPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if command == "encrypt" {
+		return EncryptFile(*in, *out, password)
+	}
+	return DecryptFile(*in, *out, password)
+}
+
+//readPassword never takes the password as a flag, since flags end up in the shell history and in ps
+func readPassword(passwordFile string) (string, error) {
+	if passwordFile != "" {
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", err
+		}
+		return checkPassword(strings.TrimRight(string(password), "\r\n"))
+	}
+	if password, found := os.LookupEnv("AES_PASSWORD"); found {
+		return checkPassword(password)
+	}
+	fmt.Print("Password: ")
+	//do not echo the password when it is typed in a terminal
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return "", errors.New("could not read the password")
+		}
+		return checkPassword(string(password))
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return "", errors.New("could not read the password")
+	}
+	return checkPassword(strings.TrimRight(password, "\r\n"))
+}
+
+func checkPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("the password can not be empty")
+	}
+	return password, nil
+}
@@ -136,6 +136,15 @@ func timeVerification(noOfBytes int) {
 }
 
 func main() {
+	if IsFileCommand(os.Args[1:]) {
+		err := RunFileCommand(os.Args[1:])
+		if err != nil {
+			fmt.Println("Error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	//Generating keys for all the tests
 	KeyGen(2000)
 
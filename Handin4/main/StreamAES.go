@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//Stream format: a header followed by chunks that are each sealed with AES-GCM.
+//
+//	header: "AESSTRM" | version (1) | PBKDF2 iterations (4) | salt (16) | nonce prefix (7) | chunk size (4)
+//	chunk:  GCM(plaintext chunk), nonce = nonce prefix | chunk counter (4) | 1 if this is the last chunk else 0
+//
+//The whole header is authenticated with every chunk, and only the last chunk is sealed with the final marker,
+//so reordering, dropping or cutting off chunks (or changing the header) makes decryption fail.
+var streamMagic = []byte("AESSTRM")
+
+const (
+	streamVersion           byte = 1
+	streamSaltLength             = 16
+	streamNoncePrefixLength      = 7
+	streamHeaderLength           = 7 + 1 + 4 + streamSaltLength + streamNoncePrefixLength + 4
+	StreamDefaultChunkSize       = 64 * 1024
+	streamMaxChunkSize           = 16 * 1024 * 1024
+	StreamDefaultIterations      = 600000
+	//the header is read before anything is authenticated, so a forged count must not make us hash for hours
+	streamMaxIterations = 10 * StreamDefaultIterations
+)
+
+type streamHeader struct {
+	iterations  uint32
+	salt        []byte
+	noncePrefix []byte
+	chunkSize   uint32
+	raw         []byte
+}
+
+func makeStreamHeader(iterations uint32, chunkSize uint32) (*streamHeader, error) {
+	header := new(streamHeader)
+	header.iterations = iterations
+	header.chunkSize = chunkSize
+	header.salt = make([]byte, streamSaltLength)
+	header.noncePrefix = make([]byte, streamNoncePrefixLength)
+	if _, err := rand.Read(header.salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(header.noncePrefix); err != nil {
+		return nil, err
+	}
+
+	raw := append([]byte{}, streamMagic...)
+	raw = append(raw, streamVersion)
+	raw = binary.BigEndian.AppendUint32(raw, iterations)
+	raw = append(raw, header.salt...)
+	raw = append(raw, header.noncePrefix...)
+	raw = binary.BigEndian.AppendUint32(raw, chunkSize)
+	header.raw = raw
+	return header, nil
+}
+
+func readStreamHeader(in io.Reader) (*streamHeader, error) {
+	raw := make([]byte, streamHeaderLength)
+	if _, err := io.ReadFull(in, raw); err != nil {
+		return nil, errors.New("file is too short to be an encrypted stream")
+	}
+	if !bytes.Equal(raw[:7], streamMagic) {
+		return nil, errors.New("file is not an encrypted stream")
+	}
+	if raw[7] != streamVersion {
+		return nil, errors.New("unknown stream version")
+	}
+	header := new(streamHeader)
+	header.iterations = binary.BigEndian.Uint32(raw[8:12])
+	header.salt = raw[12 : 12+streamSaltLength]
+	header.noncePrefix = raw[12+streamSaltLength : 12+streamSaltLength+streamNoncePrefixLength]
+	header.chunkSize = binary.BigEndian.Uint32(raw[streamHeaderLength-4:])
+	header.raw = raw
+	if header.chunkSize == 0 || header.chunkSize > streamMaxChunkSize {
+		return nil, errors.New("stream has an invalid chunk size")
+	}
+	if header.iterations == 0 || header.iterations > streamMaxIterations {
+		return nil, errors.New("stream has an invalid number of iterations")
+	}
+	return header, nil
+}
+
+func (header *streamHeader) makeGCM(password string) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(password), header.salt, int(header.iterations), 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (header *streamHeader) chunkNonce(counter uint32, last bool) []byte {
+	nonce := append([]byte{}, header.noncePrefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+//EncryptStream encrypts in to out chunk by chunk, so memory use does not depend on the size of the input
+func EncryptStream(in io.Reader, out io.Writer, password string, chunkSize int) error {
+	if chunkSize <= 0 || chunkSize > streamMaxChunkSize {
+		return errors.New("invalid chunk size")
+	}
+	header, err := makeStreamHeader(StreamDefaultIterations, uint32(chunkSize))
+	if err != nil {
+		return err
+	}
+	gcm, err := header.makeGCM(password)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(header.raw); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(in)
+	plaintext := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+gcm.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, plaintext)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		//a full chunk is only the last one if nothing follows it
+		last := err != nil
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+		sealed = gcm.Seal(sealed[:0], header.chunkNonce(counter, last), plaintext[:n], header.raw)
+		if _, err := out.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("input has too many chunks")
+		}
+	}
+}
+
+//DecryptStream checks every chunk before writing it to out, and fails if the stream ends before the final chunk
+func DecryptStream(in io.Reader, out io.Writer, password string) error {
+	reader := bufio.NewReader(in)
+	header, err := readStreamHeader(reader)
+	if err != nil {
+		return err
+	}
+	gcm, err := header.makeGCM(password)
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, int(header.chunkSize)+gcm.Overhead())
+	plaintext := make([]byte, 0, header.chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+		plaintext, err = gcm.Open(plaintext[:0], header.chunkNonce(counter, last), sealed[:n], header.raw)
+		if err != nil {
+			return errors.New("wrong password, or the file was changed or cut off")
+		}
+		if _, err := out.Write(plaintext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+//EncryptFile streams inName into outName, outName is only replaced once everything has been written
+func EncryptFile(inName string, outName string, password string) error {
+	return transformFile(inName, outName, func(in io.Reader, out io.Writer) error {
+		return EncryptStream(in, out, password, StreamDefaultChunkSize)
+	})
+}
+
+//DecryptFile streams inName into outName, nothing is written to outName if any chunk fails to decrypt
+func DecryptFile(inName string, outName string, password string) error {
+	return transformFile(inName, outName, func(in io.Reader, out io.Writer) error {
+		return DecryptStream(in, out, password)
+	})
+}
+
+//transformFile writes to a temporary file next to outName and renames it, so inName and outName may be the same file
+func transformFile(inName string, outName string, transform func(io.Reader, io.Writer) error) error {
+	in, err := os.Open(inName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	temp, err := os.CreateTemp(filepath.Dir(outName), ".aesstream-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	err = transform(in, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), outName)
+}
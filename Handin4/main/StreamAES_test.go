@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestStreamRoundTripOverManyChunks(t *testing.T) {
+	plaintext := make([]byte, 3*100+17)
+	rand.Read(plaintext)
+	for _, length := range []int{0, 100, 300, len(plaintext)} {
+		encrypted := new(bytes.Buffer)
+		err := EncryptStream(bytes.NewReader(plaintext[:length]), encrypted, "password", 100)
+		if err != nil {
+			t.Fatal("Encryption failed:", err)
+		}
+		decrypted := new(bytes.Buffer)
+		err = DecryptStream(encrypted, decrypted, "password")
+		if err != nil || !bytes.Equal(decrypted.Bytes(), plaintext[:length]) {
+			t.Error("Round trip of", length, "bytes failed:", err)
+		}
+	}
+	fmt.Println("TestStreamRoundTripOverManyChunks passed")
+}
+
+func TestStreamRejectsWrongPasswordTamperingAndTruncation(t *testing.T) {
+	plaintext := make([]byte, 250)
+	encrypted := new(bytes.Buffer)
+	EncryptStream(bytes.NewReader(plaintext), encrypted, "password", 100)
+	ciphertext := encrypted.Bytes()
+	chunkLength := 100 + 16
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[streamHeaderLength+5] ^= 1
+	changedHeader := append([]byte{}, ciphertext...)
+	changedHeader[streamHeaderLength-1] ^= 1
+	//cutting off the last chunk leaves a stream that ends on a chunk not marked as the last one
+	truncated := ciphertext[:streamHeaderLength+2*chunkLength]
+	swapped := append([]byte{}, ciphertext[:streamHeaderLength]...)
+	swapped = append(swapped, ciphertext[streamHeaderLength+chunkLength:streamHeaderLength+2*chunkLength]...)
+	swapped = append(swapped, ciphertext[streamHeaderLength:streamHeaderLength+chunkLength]...)
+	swapped = append(swapped, ciphertext[streamHeaderLength+2*chunkLength:]...)
+
+	cases := map[string][]byte{"tampered": tampered, "changed header": changedHeader, "truncated": truncated, "swapped": swapped}
+	for name, broken := range cases {
+		if DecryptStream(bytes.NewReader(broken), new(bytes.Buffer), "password") == nil {
+			t.Error("Decrypting a", name, "stream should fail")
+		}
+	}
+	if DecryptStream(bytes.NewReader(ciphertext), new(bytes.Buffer), "wrong") == nil {
+		t.Error("Decrypting with the wrong password should fail")
+	}
+	fmt.Println("TestStreamRejectsWrongPasswordTamperingAndTruncation passed")
+}
+
+func TestStreamRejectsTooManyIterations(t *testing.T) {
+	encrypted := new(bytes.Buffer)
+	EncryptStream(bytes.NewReader(make([]byte, 50)), encrypted, "password", 100)
+	forged := encrypted.Bytes()
+	binary.BigEndian.PutUint32(forged[8:12], streamMaxIterations+1)
+
+	if DecryptStream(bytes.NewReader(forged), new(bytes.Buffer), "password") == nil {
+		t.Error("A stream asking for more than", streamMaxIterations, "iterations should be rejected")
+	} else {
+		fmt.Println("TestStreamRejectsTooManyIterations passed")
+	}
+}
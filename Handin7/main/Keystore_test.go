@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestKeystoreSignsAndRejectsWrongPassword(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keystore.json")
+	publicKey := Generate(filename, "p4SSw0rd1234!")
+	eBigInt, nBigInt := ConvertKeyToBigInts(publicKey)
+
+	signature, err := Sign(filename, "p4SSw0rd1234!", []byte("hello"))
+	if err != nil {
+		t.Fatal("Signing failed:", err)
+	}
+	rsa := new(RSA)
+	if !rsa.VerifyWithKey("hello", *ConvertStringToBigInt(signature), *nBigInt, eBigInt) {
+		t.Error("The signature could not be verified with the public key from Generate")
+	}
+	if _, err := Sign(filename, "wrong", []byte("hello")); err != ErrInvalidPassword {
+		t.Error("Signing with the wrong password should fail with ErrInvalidPassword, got", err)
+	}
+	fmt.Println("TestKeystoreSignsAndRejectsWrongPassword passed")
+}
+
+func TestKeystoreRejectsChangedMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keystore.json")
+	Generate(filename, "password")
+	keystore, _ := ReadKeystore(filename)
+
+	changedKey := *keystore
+	changedKey.PublicKey.N = "12345"
+	weakened := *keystore
+	weakened.KDFParams.Iterations = 1
+	for _, changed := range []Keystore{changedKey, weakened} {
+		if _, _, err := changed.Unlock("password"); err == nil {
+			t.Error("Unlocking a keystore with changed metadata should fail")
+		}
+	}
+
+	data, _ := os.ReadFile(filename)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, field := range []string{"version", "kdf", "kdfParams", "salt", "cipher", "ciphertext", "publicKey", "created"} {
+		if _, found := fields[field]; !found {
+			t.Error("Keystore file is missing", field)
+		}
+	}
+	fmt.Println("TestKeystoreRejectsChangedMetadata passed")
+}
+
+func TestLegacyKeyFileIsStillReadAndCanBeMigrated(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "legacy")
+	rsa := MakeRSA(1024)
+	hashedPW := Hash("password").Bytes()
+	passwordHash, _ := bcrypt.GenerateFromPassword(hashedPW, bcrypt.DefaultCost)
+	encrypted, _ := MakeAES().Encrypt(ConvertBigIntToString(&rsa.d)+":"+ConvertBigIntToString(&rsa.n), hashedPW)
+	os.WriteFile(filename, append(passwordHash, encrypted...), 0600)
+
+	d, _, err := LoadSecretKey(filename, "password")
+	if err != nil || d.Cmp(&rsa.d) != 0 {
+		t.Fatal("Could not read the legacy key file:", err)
+	}
+	if _, _, err := LoadSecretKey(filename, "wrong"); err != ErrInvalidPassword {
+		t.Error("Reading the legacy file with the wrong password should fail, got", err)
+	}
+
+	err = MigrateLegacyKeystore(filename, "password")
+	if err != nil {
+		t.Fatal("Migration failed:", err)
+	}
+	keystore, err := ReadKeystore(filename)
+	if err != nil || keystore.PublicKey.N != ConvertBigIntToString(&rsa.n) {
+		t.Fatal("The migrated file is not a keystore for the same key:", err)
+	}
+	migratedD, _, _ := keystore.Unlock("password")
+	if migratedD == nil || migratedD.Cmp(&rsa.d) != 0 {
+		t.Error("The migrated keystore does not contain the same secret key")
+	}
+	fmt.Println("TestLegacyKeyFileIsStillReadAndCanBeMigrated passed")
+}
@@ -4,13 +4,9 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
-	"errors"
 	"fmt"
 	"math/big"
-	"os"
 	"strings"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type RSA struct {
@@ -27,6 +23,14 @@ func MakeRSA(k int) *RSA {
 	return rsa
 }
 
+func MakeRSAWithKeys(n string, d string) *RSA {
+	rsa := new(RSA)
+	rsa.n = *ConvertStringToBigInt(n)
+	rsa.d = *ConvertStringToBigInt(d)
+	rsa.e = big.NewInt(3)
+	return rsa
+}
+
 func (rsa *RSA) KeyGen(k int) {
 	//bit length of p*q = n is k
 	rsa.e = big.NewInt(3) //given by the hand-in text
@@ -166,55 +170,45 @@ func (rsa *RSA) Verify(m string, s big.Int) bool {
 	return verified
 }
 
+//Generate writes a new key pair to a keystore file (see Keystore.go) and returns the public key as "e:n"
 func Generate(filename string, password string) string {
-	f, _ := os.Create(filename)
-	defer f.Close()
 	rsa := MakeRSA(2048)
-	secretKeyString := ConvertBigIntToString(&rsa.d) + ":" + ConvertBigIntToString(&rsa.n)
-
-	hashedPW := Hash(password).Bytes()
-
-	passwordHashToSave, _ := bcrypt.GenerateFromPassword(hashedPW, bcrypt.DefaultCost)
-
-	aes := MakeAES()
-
-	encrypted := aes.Encrypt(secretKeyString, hashedPW)
-
-	toWrite := append(passwordHashToSave, encrypted...)
-	f.Write(toWrite)
-
-	return ConvertBigIntToString(rsa.e) + ":" + ConvertBigIntToString(&rsa.n)
+	keystore, err := MakeKeystore(rsa, password)
+	if err != nil {
+		fmt.Println("Could not encrypt the secret key:", err)
+		return ""
+	}
+	err = WriteKeystore(filename, keystore)
+	if err != nil {
+		fmt.Println("Could not write the keystore:", err)
+		return ""
+	}
+	return keystore.PublicKeyString()
 }
 
+//Sign reads keystore files as well as the old bcrypt-prefixed key files
 func Sign(filename string, password string, msg []byte) (string, error) {
-
-	read_bytes, err := os.ReadFile(filename)
-
+	dBigInt, nBigInt, err := LoadSecretKey(filename, password)
+	if err == ErrInvalidPassword {
+		return "That ain't it, Chief", err
+	}
 	if err != nil {
-		return "Yo wtf", errors.New("Tried to read non-existing file")
+		return "Yo wtf", err
 	}
+	rsa := new(RSA)
+	signed := rsa.FullSign(string(msg), *nBigInt, *dBigInt)
+	return ConvertBigIntToString(signed), nil
+}
 
-	hashedPW := Hash(password).Bytes()
-
-	aes := MakeAES()
-	rsa := MakeRSA(2048)
-
-	savedPasswordHash := read_bytes[:60]
-
-	if bcrypt.CompareHashAndPassword(savedPasswordHash, hashedPW) == nil {
-		//password correct
-		decrypted := aes.Decrypt(string(read_bytes[60:]), hashedPW)
-
-		dBigInt, nBigInt := ConvertKeyToBigInts(decrypted)
-		mString := string(msg)
-		signed := rsa.FullSign(mString, *nBigInt, *dBigInt)
-		return ConvertBigIntToString(signed), nil
-
-	} else {
-		//error
-		return "That ain't it, Chief", errors.New("Invalid password")
+//decryptSecretKey also reads key files written before AES got a version header
+func decryptSecretKey(aes *AES, encrypted []byte, key []byte) (string, error) {
+	if IsVersionedCiphertext(encrypted) {
+		return aes.Decrypt(string(encrypted), key)
 	}
+	decrypted, err := aes.DecryptLegacyCTR(encrypted, key)
+	return string(decrypted), err
 }
+
 func ConvertKeyToBigInts(publicKey string) (*big.Int, *big.Int) {
 	secretKeyAsArray := strings.Split(publicKey, ":")
 	firstString := secretKeyAsArray[0]
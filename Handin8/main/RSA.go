@@ -179,7 +179,11 @@ func Generate(filename string, password string) string {
 
 	aes := MakeAES()
 
-	encrypted := aes.Encrypt(secretKeyString, hashedPW)
+	encrypted, err := aes.Encrypt(secretKeyString, hashedPW)
+	if err != nil {
+		fmt.Println("Could not encrypt the secret key:", err)
+		return ""
+	}
 
 	toWrite := append(passwordHashToSave, encrypted...)
 	f.Write(toWrite)
@@ -200,12 +204,14 @@ func Sign(filename string, password string, msg []byte) (string, error) {
 	aes := MakeAES()
 	rsa := MakeRSA(2048)
 
-	decrypted := aes.Decrypt(string(read_bytes[60:]), hashedPW)
-
 	savedPasswordHash := read_bytes[:60]
 
 	if bcrypt.CompareHashAndPassword(savedPasswordHash, hashedPW) == nil {
 		//password correct
+		decrypted, err := decryptSecretKey(aes, read_bytes[60:], hashedPW)
+		if err != nil {
+			return "Key file is damaged", err
+		}
 
 		dBigInt, nBigInt := ConvertKeyToBigInts(decrypted)
 		mString := string(msg)
@@ -217,6 +223,16 @@ func Sign(filename string, password string, msg []byte) (string, error) {
 		return "That ain't it, Chief", errors.New("invalid password")
 	}
 }
+
+//decryptSecretKey also reads key files written before AES got a version header
+func decryptSecretKey(aes *AES, encrypted []byte, key []byte) (string, error) {
+	if IsVersionedCiphertext(encrypted) {
+		return aes.Decrypt(string(encrypted), key)
+	}
+	decrypted, err := aes.DecryptLegacyCTR(encrypted, key)
+	return string(decrypted), err
+}
+
 func ConvertKeyToBigInts(publicKey string) (*big.Int, *big.Int) {
 	secretKeyAsArray := strings.Split(publicKey, ":")
 	firstString := secretKeyAsArray[0]
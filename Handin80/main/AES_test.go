@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAESEncryptDecrypt(t *testing.T) {
+	this_aes := MakeAES()
+	key := this_aes.generateAESKey()
+	encrypted, err := this_aes.Encrypt("Hej med dig, Simon :D", key)
+	if err != nil {
+		t.Fatal("Encrypt failed:", err)
+	}
+	decrypted, err := this_aes.Decrypt(string(encrypted), key)
+	if err != nil || decrypted != "Hej med dig, Simon :D" {
+		t.Error("Should have decrypted to the plaintext but got", decrypted, err)
+	} else {
+		fmt.Println("TestAESEncryptDecrypt passed")
+	}
+}
+
+func TestAESShouldRejectTamperedCiphertext(t *testing.T) {
+	this_aes := MakeAES()
+	key := this_aes.generateAESKey()
+	gcmCiphertext, _ := this_aes.EncryptWithAdditionalData([]byte("pay 10 AU"), key, []byte("header"))
+	macCiphertext, _ := this_aes.EncryptThenMAC([]byte("pay 10 AU"), key, []byte("header"))
+
+	for _, ciphertext := range [][]byte{gcmCiphertext, macCiphertext} {
+		tampered := append([]byte{}, ciphertext...)
+		tampered[len(tampered)/2] ^= 1
+		if _, err := this_aes.DecryptWithAdditionalData(tampered, key, []byte("header")); err == nil {
+			t.Error("Decrypted a ciphertext that was changed, version", ciphertext[3])
+		}
+		if _, err := this_aes.DecryptWithAdditionalData(ciphertext, key, []byte("other header")); err == nil {
+			t.Error("Decrypted with the wrong additional data, version", ciphertext[3])
+		}
+		if plaintext, err := this_aes.DecryptWithAdditionalData(ciphertext, key, []byte("header")); err != nil || string(plaintext) != "pay 10 AU" {
+			t.Error("Did not decrypt an untouched ciphertext, version", ciphertext[3], err)
+		}
+	}
+	fmt.Println("TestAESShouldRejectTamperedCiphertext done")
+}
+
+func TestAESShouldReturnErrorsInsteadOfPanicking(t *testing.T) {
+	this_aes := MakeAES()
+	if _, err := this_aes.Encrypt("hello", []byte("too short key")); err == nil {
+		t.Error("Encrypt accepted a key of the wrong length")
+	}
+	if _, err := this_aes.Decrypt("AES", this_aes.generateAESKey()); err == nil {
+		t.Error("Decrypt accepted a ciphertext shorter than the header")
+	}
+	if _, err := this_aes.DecryptLegacyCTR([]byte("short"), this_aes.generateAESKey()); err == nil {
+		t.Error("DecryptLegacyCTR accepted a ciphertext shorter than the IV")
+	} else {
+		fmt.Println("TestAESShouldReturnErrorsInsteadOfPanicking passed")
+	}
+}
+
+func TestAESShouldMigrateLegacyFiles(t *testing.T) {
+	this_aes := MakeAES()
+	key := this_aes.generateAESKey()
+
+	//write a file the way the old EncryptToFile did (iv || CTR ciphertext)
+	block, _ := aes.NewCipher(key)
+	iv := make([]byte, block.BlockSize())
+	rand.Read(iv)
+	legacy := make([]byte, len("secret d:n"))
+	cipher.NewCTR(block, iv).XORKeyStream(legacy, []byte("secret d:n"))
+	filename := filepath.Join(t.TempDir(), "legacy")
+	os.WriteFile(filename, append(iv, legacy...), 0600)
+
+	err := this_aes.MigrateFile(filename, key)
+	if err != nil {
+		t.Fatal("Migration failed:", err)
+	}
+	migrated, _ := os.ReadFile(filename)
+	decrypted, err := this_aes.Decrypt(string(migrated), key)
+	if !IsVersionedCiphertext(migrated) || err != nil || decrypted != "secret d:n" {
+		t.Error("Migrated file did not decrypt to the old plaintext:", decrypted, err)
+	} else {
+		fmt.Println("TestAESShouldMigrateLegacyFiles passed")
+	}
+}
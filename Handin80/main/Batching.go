@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//The sequencer cuts a block as soon as it is full, by number of transactions or by their size, or when its oldest
+//transaction has waited MaxDelay. Under light load a transaction waits at most MaxDelay, under heavy load blocks are
+//sent as fast as they fill up and never grow past the limits.
+
+type BatchConfig struct {
+	MaxTransactions int
+	MaxBytes        int           //the size of the marshalled transactions in a block, a single larger transaction gets a block of its own
+	MaxDelay        time.Duration //how long a transaction may wait for a block
+}
+
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{MaxTransactions: 500, MaxBytes: 1 << 20, MaxDelay: time.Second}
+}
+
+func (config BatchConfig) Validate() error {
+	if config.MaxTransactions <= 0 || config.MaxBytes <= 0 || config.MaxDelay <= 0 {
+		return errors.New("the batch limits must be positive")
+	}
+	return nil
+}
+
+type pendingTransaction struct {
+	id      string
+	size    int
+	arrived time.Time
+}
+
+//BatchRecord describes one block the sequencer sent
+type BatchRecord struct {
+	Transactions int
+	Bytes        int
+	MaxLatency   time.Duration //how long the oldest transaction in the block waited
+	MeanLatency  time.Duration
+	Full         bool //false if the block was cut because of MaxDelay
+}
+
+type BatchStats struct {
+	records []BatchRecord
+	mutex   *sync.Mutex
+}
+
+func MakeBatchStats() *BatchStats {
+	stats := new(BatchStats)
+	stats.records = make([]BatchRecord, 0)
+	stats.mutex = &sync.Mutex{}
+	return stats
+}
+
+func (stats *BatchStats) Record(record BatchRecord) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.records = append(stats.records, record)
+}
+
+func (stats *BatchStats) Records() []BatchRecord {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	return append([]BatchRecord{}, stats.records...)
+}
+
+func (stats *BatchStats) String() string {
+	records := stats.Records()
+	if len(records) == 0 {
+		return "no blocks sent"
+	}
+	sizes := make([]int, len(records))
+	full := 0
+	var meanLatency, maxLatency time.Duration
+	for i, record := range records {
+		sizes[i] = record.Transactions
+		if record.Full {
+			full++
+		}
+		meanLatency += record.MeanLatency
+		if record.MaxLatency > maxLatency {
+			maxLatency = record.MaxLatency
+		}
+	}
+	sort.Ints(sizes)
+	return fmt.Sprint(len(records), " blocks (", full, " full), transactions per block min/median/max ",
+		sizes[0], "/", sizes[len(sizes)/2], "/", sizes[len(sizes)-1], ", latency mean ",
+		meanLatency/time.Duration(len(records)), " max ", maxLatency)
+}
+
+//SetBatchConfig must be called before run
+func (peer *Peer) SetBatchConfig(config BatchConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	peer.nextBlockMutex.Lock()
+	defer peer.nextBlockMutex.Unlock()
+	peer.batchConfig = config
+	return nil
+}
+
+//addToBatch is called by the sequencer for every new transaction, and wakes HandleBlocks
+func (peer *Peer) addToBatch(transaction pendingTransaction) {
+	peer.nextBlockMutex.Lock()
+	peer.nextBlock = append(peer.nextBlock, transaction)
+	peer.nextBlockMutex.Unlock()
+	select {
+	case peer.batchSignal <- true:
+	default: //HandleBlocks has not woken up from the last signal yet
+	}
+}
+
+//nextBatchWait returns how long until the oldest waiting transaction reaches MaxDelay
+func (peer *Peer) nextBatchWait() time.Duration {
+	peer.nextBlockMutex.Lock()
+	defer peer.nextBlockMutex.Unlock()
+	if len(peer.nextBlock) == 0 {
+		return peer.batchConfig.MaxDelay
+	}
+	wait := peer.batchConfig.MaxDelay - time.Since(peer.nextBlock[0].arrived)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+//takeBatch removes the next block's transactions from nextBlock if the block is full or has waited long enough.
+//nextBlockMutex must be held
+func (peer *Peer) takeBatch(now time.Time) ([]pendingTransaction, bool) {
+	if len(peer.nextBlock) == 0 {
+		return nil, false
+	}
+	config := peer.batchConfig
+	count, size := 0, 0
+	for count < len(peer.nextBlock) && count < config.MaxTransactions {
+		if count > 0 && size+peer.nextBlock[count].size > config.MaxBytes {
+			break
+		}
+		size += peer.nextBlock[count].size
+		count++
+	}
+	full := count < len(peer.nextBlock) || count == config.MaxTransactions || size >= config.MaxBytes
+	if !full && now.Sub(peer.nextBlock[0].arrived) < config.MaxDelay {
+		return nil, false
+	}
+	batch := peer.nextBlock[:count]
+	peer.nextBlock = append([]pendingTransaction{}, peer.nextBlock[count:]...)
+	return batch, full
+}
+
+//sendReadyBlocks sends blocks for as long as there is a full or overdue batch. Blocks are signed and sent without
+//nextBlockMutex, so new transactions can still be added to the next batch meanwhile
+func (peer *Peer) sendReadyBlocks() {
+	for {
+		block, record, ready := peer.cutBlock()
+		if !ready {
+			return
+		}
+		fmt.Println("Sending block", block[len(block)-1], "with", record.Transactions, "transactions")
+		marshalled := peer.MarshalBlock(block)
+		peer.sendBlockToAllPeers(marshalled)
+		peer.batchStats.Record(record)
+	}
+}
+
+//cutBlock takes a full or overdue batch and gives it the next block number, both under nextBlockMutex so a number is
+//only used once
+func (peer *Peer) cutBlock() (Block, BatchRecord, bool) {
+	peer.nextBlockMutex.Lock()
+	defer peer.nextBlockMutex.Unlock()
+	now := time.Now()
+	batch, full := peer.takeBatch(now)
+	if batch == nil {
+		return nil, BatchRecord{}, false
+	}
+	block := make(Block, 0, len(batch)+1)
+	record := BatchRecord{Transactions: len(batch), Full: full}
+	var totalLatency time.Duration
+	for _, transaction := range batch {
+		block = append(block, transaction.id)
+		record.Bytes += transaction.size
+		latency := now.Sub(transaction.arrived)
+		totalLatency += latency
+		if latency > record.MaxLatency {
+			record.MaxLatency = latency
+		}
+	}
+	record.MeanLatency = totalLatency / time.Duration(len(batch))
+	block = append(block, strconv.Itoa(peer.blockCounterSeq))
+	peer.blockCounterSeq++
+	return block, record, true
+}
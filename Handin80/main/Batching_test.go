@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func batchingSequencerFixture(t *testing.T, config BatchConfig) *Peer {
+	peer := peerFixture()
+	peer.seqRSA = MakeRSA(1024)
+	peer.seq = true
+	err := peer.SetBatchConfig(config)
+	if err != nil {
+		t.Fatal("Could not set the batch config:", err)
+	}
+	return peer
+}
+
+func waitForBlocks(peer *Peer, blocks int, timeout time.Duration) []BatchRecord {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && len(peer.batchStats.Records()) < blocks {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return peer.batchStats.Records()
+}
+
+func TestShouldCutABlockAsSoonAsItIsFull(t *testing.T) {
+	peer := batchingSequencerFixture(t, BatchConfig{MaxTransactions: 3, MaxBytes: 1 << 20, MaxDelay: time.Hour})
+	go peer.HandleBlocks()
+	for i := 0; i < 7; i++ {
+		peer.addToBatch(pendingTransaction{id: "tx" + strconv.Itoa(i), size: 100, arrived: time.Now()})
+	}
+	records := waitForBlocks(peer, 2, 5*time.Second)
+	fmt.Println("Batches:", peer.batchStats)
+	if len(records) != 2 {
+		t.Fatal("Expected two full blocks, got", len(records))
+	}
+	for _, record := range records {
+		if record.Transactions != 3 || !record.Full {
+			t.Error("A full block should have 3 transactions, got", record.Transactions)
+		}
+	}
+	peer.nextBlockMutex.Lock()
+	waiting := len(peer.nextBlock)
+	peer.nextBlockMutex.Unlock()
+	if waiting != 1 || peer.blockCounterSeq != 2 {
+		t.Error("The last transaction should wait for MaxDelay, waiting:", waiting)
+	}
+	fmt.Println("TestShouldCutABlockAsSoonAsItIsFull passed")
+}
+
+func TestShouldTakeTransactionsWhileABlockIsBeingSent(t *testing.T) {
+	peer := batchingSequencerFixture(t, BatchConfig{MaxTransactions: 1, MaxBytes: 1 << 20, MaxDelay: time.Hour})
+	//nobody reads from the other end, so sending the block blocks until the connection is closed
+	stalled, other := net.Pipe()
+	defer other.Close()
+	peer.connections = append(peer.connections, stalled)
+	go peer.HandleBlocks()
+	peer.addToBatch(pendingTransaction{id: "tx0", size: 100, arrived: time.Now()})
+	time.Sleep(100 * time.Millisecond)
+
+	added := make(chan bool)
+	go func() {
+		peer.addToBatch(pendingTransaction{id: "tx1", size: 100, arrived: time.Now()})
+		close(added)
+	}()
+	select {
+	case <-added:
+		fmt.Println("TestShouldTakeTransactionsWhileABlockIsBeingSent passed")
+	case <-time.After(2 * time.Second):
+		t.Error("Adding a transaction should not wait for the block to be sent")
+	}
+}
+
+func TestShouldCutABlockAfterMaxDelayUnderLightLoad(t *testing.T) {
+	maxDelay := 200 * time.Millisecond
+	peer := batchingSequencerFixture(t, BatchConfig{MaxTransactions: 100, MaxBytes: 1 << 20, MaxDelay: maxDelay})
+	go peer.HandleBlocks()
+	peer.addToBatch(pendingTransaction{id: "tx0", size: 100, arrived: time.Now()})
+	time.Sleep(50 * time.Millisecond)
+	peer.addToBatch(pendingTransaction{id: "tx1", size: 100, arrived: time.Now()})
+	records := waitForBlocks(peer, 1, 5*time.Second)
+	fmt.Println("Batches:", peer.batchStats)
+	if len(records) != 1 || records[0].Transactions != 2 || records[0].Full {
+		t.Fatal("Expected one block with both transactions, got", records)
+	}
+	if records[0].MaxLatency < maxDelay || records[0].MaxLatency > maxDelay+time.Second {
+		t.Error("The oldest transaction should wait about MaxDelay, it waited", records[0].MaxLatency)
+	}
+	if records[0].MeanLatency >= records[0].MaxLatency {
+		t.Error("The newer transaction should have waited less, mean latency", records[0].MeanLatency)
+	}
+	fmt.Println("TestShouldCutABlockAfterMaxDelayUnderLightLoad passed")
+}
+
+func TestShouldLimitTheBytesInABlock(t *testing.T) {
+	peer := batchingSequencerFixture(t, BatchConfig{MaxTransactions: 100, MaxBytes: 100, MaxDelay: time.Hour})
+	now := time.Now()
+	peer.nextBlock = []pendingTransaction{{id: "a", size: 40, arrived: now}, {id: "b", size: 40, arrived: now},
+		{id: "c", size: 40, arrived: now}, {id: "big", size: 500, arrived: now}, {id: "d", size: 40, arrived: now}}
+
+	sizes := make([]int, 0)
+	for {
+		batch, full := peer.takeBatch(now)
+		if batch == nil {
+			break
+		}
+		if !full {
+			t.Error("Only full blocks should be cut before MaxDelay")
+		}
+		sizes = append(sizes, len(batch))
+	}
+	//a transaction larger than MaxBytes is sent on its own, and the last one waits for more
+	if fmt.Sprint(sizes) != "[2 1 1]" || len(peer.nextBlock) != 1 {
+		t.Error("Expected blocks of 2, 1 and 1 transactions with one waiting, got", sizes, "with", len(peer.nextBlock), "waiting")
+	}
+	if peer.SetBatchConfig(BatchConfig{MaxTransactions: 0, MaxBytes: 100, MaxDelay: time.Second}) == nil {
+		t.Error("A batch config without room for transactions should be rejected")
+	}
+	fmt.Println("TestShouldLimitTheBytesInABlock passed")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+//Blocks can arrive out of order when they take different paths through the network. A block with a higher number
+//than the one we expect is kept until the blocks before it have arrived, and the missing numbers are requested from
+//our neighbours. The last maxAppliedBlocks applied blocks are kept, so we can answer such requests from others.
+
+const blockRequestDelimiter = "getblocks"
+const maxBufferedBlocks = 1000 //blocks ahead of blockCounter we keep, a block further ahead is dropped and requested later
+const maxRequestedBlocks = 100 //block numbers asked for in one request
+const maxAppliedBlocks = 1000  //applied blocks we keep to answer requests, a peer further behind can not catch up from us
+
+//Block requests are sent like blocks, as a list of block numbers ending in a delimiter
+func MakeBlockRequest(blockNumbers []int) Block {
+	request := make(Block, 0, len(blockNumbers)+1)
+	for _, blockNo := range blockNumbers {
+		request = append(request, strconv.Itoa(blockNo))
+	}
+	return append(request, blockRequestDelimiter)
+}
+
+func IsBlockRequest(block Block) bool {
+	return len(block) > 0 && block[len(block)-1] == blockRequestDelimiter
+}
+
+func (peer *Peer) GetBlockCounter() int {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	return peer.blockCounter
+}
+
+//ReceiveBlock handles a block with a verified signature. It is passed on if it is new, applied if it is the next one,
+//or kept until the blocks before it arrive
+func (peer *Peer) ReceiveBlock(block Block, marshalled []byte) {
+	blockNo, err := strconv.Atoi(block[len(block)-3])
+	if err != nil {
+		fmt.Println("Got a block without a block number")
+		return
+	}
+	peer.blocksSentMutex.Lock()
+	if !peer.blocksSent[block[len(block)-2]] {
+		peer.blocksSent[block[len(block)-2]] = true
+		go peer.sendBlockToAllPeers(marshalled)
+	} else {
+		fmt.Println("Got a block that's seen before")
+	}
+	missing := peer.orderBlock(blockNo, block)
+	peer.blocksSentMutex.Unlock()
+
+	if len(missing) > 0 {
+		peer.RequestBlocks(missing)
+	}
+}
+
+//orderBlock applies the block and the buffered blocks following it, or buffers it and returns the block numbers
+//before it that we are still missing. blocksSentMutex must be held
+func (peer *Peer) orderBlock(blockNo int, block Block) []int {
+	expected := peer.GetBlockCounter()
+	if blockNo < expected {
+		return nil
+	}
+	if blockNo > expected {
+		if _, buffered := peer.futureBlocks[blockNo]; !buffered {
+			if len(peer.futureBlocks) >= maxBufferedBlocks {
+				fmt.Println("Too many blocks waiting, dropping block", blockNo)
+				return nil
+			}
+			fmt.Println("Got block", blockNo, "while expecting", expected, ", keeping it until the blocks before it arrive")
+			peer.futureBlocks[blockNo] = block
+		}
+		return peer.missingBlocks(expected, blockNo)
+	}
+
+	peer.applyBlock(blockNo, block)
+	for {
+		next, buffered := peer.futureBlocks[blockNo+1]
+		if !buffered {
+			return nil
+		}
+		blockNo++
+		delete(peer.futureBlocks, blockNo)
+		peer.applyBlock(blockNo, next)
+	}
+}
+
+func (peer *Peer) applyBlock(blockNo int, block Block) {
+	fmt.Println("Got a new block with the expected block number", blockNo)
+	transactions := block[:len(block)-3]
+	peer.sequencerMutex.Lock()
+	peer.blockCounter++
+	peer.sequencerMutex.Unlock()
+	peer.UpdateLedgerWithBlock(transactions)
+	peer.markSequenced(transactions)
+	peer.appliedBlocks[blockNo] = block
+	delete(peer.appliedBlocks, blockNo-maxAppliedBlocks)
+	delete(peer.blockRequested, blockNo)
+}
+
+//missingBlocks returns the numbers from from up to to that are neither buffered nor requested within the last
+//blockRequestInterval. blocksSentMutex must be held
+func (peer *Peer) missingBlocks(from int, to int) []int {
+	missing := make([]int, 0)
+	for blockNo := from; blockNo < to && len(missing) < maxRequestedBlocks; blockNo++ {
+		if _, buffered := peer.futureBlocks[blockNo]; buffered {
+			continue
+		}
+		if requested, found := peer.blockRequested[blockNo]; found && time.Since(requested) < peer.blockRequestInterval {
+			continue
+		}
+		peer.blockRequested[blockNo] = time.Now()
+		missing = append(missing, blockNo)
+	}
+	return missing
+}
+
+//RequestBlocks asks all our neighbours for the blocks, the ones that have them send them back like any other block
+func (peer *Peer) RequestBlocks(blockNumbers []int) {
+	fmt.Println("Requesting the missing blocks", blockNumbers)
+	marshalled, _ := json.Marshal(MakeBlockRequest(blockNumbers))
+	peer.sendBlockToAllPeers(marshalled)
+}
+
+//HandleBlockRequest sends the requested blocks we have, applied or buffered, to the peer asking
+func (peer *Peer) HandleBlockRequest(request Block, connection net.Conn) {
+	found := make([][]byte, 0)
+	peer.blocksSentMutex.Lock()
+	for _, number := range request[:len(request)-1] {
+		blockNo, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		block, applied := peer.appliedBlocks[blockNo]
+		if !applied {
+			block, applied = peer.futureBlocks[blockNo]
+		}
+		if applied {
+			marshalled, _ := json.Marshal(block)
+			found = append(found, marshalled)
+		}
+		if len(found) >= maxRequestedBlocks {
+			break
+		}
+	}
+	peer.blocksSentMutex.Unlock()
+
+	for _, marshalled := range found {
+		peer.SendBlock(connection, marshalled)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedBlock(sequencer *RSA, blockNo int, transactionIDs ...string) Block {
+	block := append(Block{}, transactionIDs...)
+	block = append(block, strconv.Itoa(blockNo))
+	block = sequencer.FullSignBlock(block, sequencer.n, sequencer.d)
+	return append(block, "yeet")
+}
+
+//receiveSignedBlock hands the peer a block as if it came from the network
+func receiveSignedBlock(peer *Peer, sequencer *RSA, blockNo int) {
+	block := signedBlock(sequencer, blockNo)
+	marshalled, _ := json.Marshal(block)
+	peer.ReceiveBlock(block, marshalled)
+}
+
+func TestShouldApplyBlocksInOrderWhenTheyArriveOutOfOrder(t *testing.T) {
+	sequencer := MakeRSA(1024)
+	peer := peerFixture()
+	peer.seqPk = ConvertBigIntToString(&sequencer.n)
+
+	receiveSignedBlock(peer, sequencer, 2)
+	receiveSignedBlock(peer, sequencer, 1)
+	receiveSignedBlock(peer, sequencer, 1)
+	if peer.GetBlockCounter() != 0 || len(peer.futureBlocks) != 2 {
+		t.Fatal("Blocks after a missing block should wait, blockCounter is", peer.GetBlockCounter(), "with", len(peer.futureBlocks), "waiting")
+	}
+	receiveSignedBlock(peer, sequencer, 0)
+	if peer.GetBlockCounter() != 3 || len(peer.futureBlocks) != 0 {
+		t.Error("All three blocks should be applied once the gap is filled, blockCounter is", peer.GetBlockCounter())
+	}
+	fmt.Println("TestShouldApplyBlocksInOrderWhenTheyArriveOutOfOrder passed")
+}
+
+func TestShouldRequestMissingBlocksFromNeighbours(t *testing.T) {
+	sequencer := MakeRSA(1024)
+	seqPk := ConvertBigIntToString(&sequencer.n)
+	behind := peerFixture()
+	behind.seqPk = seqPk
+	ahead := peerFixture()
+	ahead.seqPk = seqPk
+	receiveSignedBlock(ahead, sequencer, 0)
+	receiveSignedBlock(ahead, sequencer, 1)
+
+	behindConn, aheadConn := net.Pipe()
+	behind.AppendToConnections(behindConn)
+	ahead.AppendToConnections(aheadConn)
+	go behind.HandleIncomingMessagesFromPeer(behindConn)
+	go ahead.HandleIncomingMessagesFromPeer(aheadConn)
+	defer behindConn.Close()
+
+	//block 2 reaches the peer that missed 0 and 1, it asks its neighbour for them
+	receiveSignedBlock(behind, sequencer, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (behind.GetBlockCounter() != 3 || ahead.GetBlockCounter() != 3) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if behind.GetBlockCounter() != 3 {
+		t.Error("The missing blocks were not fetched, blockCounter is", behind.GetBlockCounter())
+	}
+	if ahead.GetBlockCounter() != 3 {
+		t.Error("The new block was not passed on, blockCounter is", ahead.GetBlockCounter())
+	}
+	fmt.Println("TestShouldRequestMissingBlocksFromNeighbours passed")
+}
+
+func TestShouldOnlyKeepTheLastAppliedBlocks(t *testing.T) {
+	sequencer := MakeRSA(1024)
+	peer := peerFixture()
+	peer.seqPk = ConvertBigIntToString(&sequencer.n)
+
+	for blockNo := 0; blockNo < maxAppliedBlocks+10; blockNo++ {
+		receiveSignedBlock(peer, sequencer, blockNo)
+	}
+	_, oldest := peer.appliedBlocks[9]
+	_, newest := peer.appliedBlocks[maxAppliedBlocks+9]
+	if len(peer.appliedBlocks) != maxAppliedBlocks || oldest || !newest {
+		t.Error("Only the last", maxAppliedBlocks, "blocks should be kept, there are", len(peer.appliedBlocks))
+	} else {
+		fmt.Println("TestShouldOnlyKeepTheLastAppliedBlocks passed")
+	}
+}
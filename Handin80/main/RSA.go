@@ -178,7 +178,11 @@ func Generate(filename string, password string) string {
 
 	aes := MakeAES()
 
-	encrypted := aes.Encrypt(secretKeyString, hashedPW)
+	encrypted, err := aes.Encrypt(secretKeyString, hashedPW)
+	if err != nil {
+		fmt.Println("Could not encrypt the secret key:", err)
+		return ""
+	}
 
 	toWrite := append(passwordHashToSave, encrypted...)
 	f.Write(toWrite)
@@ -187,34 +191,67 @@ func Generate(filename string, password string) string {
 }
 
 func Sign(filename string, password string, msg []byte) (string, error) {
+	rsa, failure, err := readKeyFile(filename, password)
+	if err != nil {
+		return failure, err
+	}
+	mString := string(msg)
+	signed := rsa.FullSign(mString, rsa.n, rsa.d)
+	return ConvertBigIntToString(signed), nil
+}
+
+//LoadKeyFile returns the key pair in a file written by Generate
+func LoadKeyFile(filename string, password string) (*RSA, error) {
+	rsa, _, err := readKeyFile(filename, password)
+	return rsa, err
+}
 
+//readKeyFile also returns the string Sign gives back when it fails
+func readKeyFile(filename string, password string) (*RSA, string, error) {
 	read_bytes, err := os.ReadFile(filename)
 
 	if err != nil {
-		return "Yo wtf", errors.New("tried to read non-existing file")
+		return nil, "Yo wtf", errors.New("tried to read non-existing file")
 	}
 
 	hashedPW := Hash(password).Bytes()
 
 	aes := MakeAES()
-	rsa := MakeRSA(2048)
 
+	if len(read_bytes) < 60 {
+		return nil, "Key file is damaged", errors.New("the key file is too short")
+	}
 	savedPasswordHash := read_bytes[:60]
 
 	if bcrypt.CompareHashAndPassword(savedPasswordHash, hashedPW) == nil {
 		//password correct
-		decrypted := aes.Decrypt(string(read_bytes[60:]), hashedPW)
+		decrypted, err := decryptSecretKey(aes, read_bytes[60:], hashedPW)
+		if err != nil {
+			return nil, "Key file is damaged", err
+		}
 
 		dBigInt, nBigInt := ConvertKeyToBigInts(decrypted)
-		mString := string(msg)
-		signed := rsa.FullSign(mString, *nBigInt, *dBigInt)
-		return ConvertBigIntToString(signed), nil
+		rsa := new(RSA)
+		rsa.e = big.NewInt(3)
+		rsa.n = *nBigInt
+		rsa.d = *dBigInt
+		return rsa, "", nil
 
 	} else {
 		//error
-		return "That ain't it, Chief", errors.New("invalid password")
+		return nil, "That ain't it, Chief", errors.New("invalid password")
 	}
 }
+
+//decryptSecretKey also reads key files written before AES got a version header
+func decryptSecretKey(aes *AES, encrypted []byte, key []byte) (string, error) {
+	if IsVersionedCiphertext(encrypted) {
+		return aes.Decrypt(string(encrypted), key)
+	}
+	decrypted, err := aes.DecryptLegacyCTR(encrypted, key)
+	return string(decrypted), err
+}
+
 func ConvertKeyToBigInts(publicKey string) (*big.Int, *big.Int) {
 	secretKeyAsArray := strings.Split(publicKey, ":")
 	firstString := secretKeyAsArray[0]
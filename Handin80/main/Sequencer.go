@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//With a committee the sequencer can be replaced. The committee members take turns: in view v the sequencer is member
+//v mod len(members). When a transaction has waited longer than sequencerTimeout without being put in a block, a member
+//votes for the next view. The votes are signed and sent to everyone, and when a majority of the committee has voted for
+//a view every peer switches seqPk to the new sequencer, so a single peer can not take over on its own.
+//The new sequencer continues from the highest blockCounter among the votes, so block numbers are not reused as long
+//as a majority has seen every block the old sequencer sent.
+//The committee is configured on every peer. A joining peer takes the view from the peer it joins through only if the
+//sequencer is the committee's sequencer of that view and the votes of a majority that elected the view come along.
+
+const sequencerVoteDelimiter = "seqvote"
+
+type SequencerCommittee struct {
+	Members []string //public keys (n, e is always 3), in the order they become sequencer
+}
+
+func MakeSequencerCommittee(members []string) (*SequencerCommittee, error) {
+	if len(members) == 0 {
+		return nil, errors.New("a committee needs at least one member")
+	}
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if member == "" || strings.ContainsAny(member, ":,]") || seen[member] {
+			return nil, errors.New("committee members must be distinct public keys")
+		}
+		seen[member] = true
+	}
+	committee := new(SequencerCommittee)
+	committee.Members = members
+	return committee, nil
+}
+
+//ParseSequencerCommittee reads the public keys from a file or a message, separated by newlines or commas
+func ParseSequencerCommittee(keys string) (*SequencerCommittee, error) {
+	members := strings.FieldsFunc(keys, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' || r == ' ' })
+	return MakeSequencerCommittee(members)
+}
+
+func (committee *SequencerCommittee) String() string {
+	return strings.Join(committee.Members, ",")
+}
+
+func (committee *SequencerCommittee) Sequencer(view int) string {
+	return committee.Members[view%len(committee.Members)]
+}
+
+func (committee *SequencerCommittee) Index(publicKey string) int {
+	for index, member := range committee.Members {
+		if member == publicKey {
+			return index
+		}
+	}
+	return -1
+}
+
+func (committee *SequencerCommittee) Majority() int {
+	return len(committee.Members)/2 + 1
+}
+
+type SequencerVote struct {
+	View         int
+	Candidate    string //the sequencer of View, sent along so it can be checked against the committee
+	Voter        int    //index of the voter in the committee
+	BlockCounter int    //the next block number the voter expects
+	Signature    string
+}
+
+func sequencerVoteString(view int, candidate string, blockCounter int) string {
+	return "SEQUENCER:" + strconv.Itoa(view) + ":" + candidate + ":" + strconv.Itoa(blockCounter)
+}
+
+//Votes are sent like blocks, as a list of strings ending in a delimiter
+func (vote SequencerVote) AsBlock() Block {
+	return Block{strconv.Itoa(vote.View), vote.Candidate, strconv.Itoa(vote.Voter), strconv.Itoa(vote.BlockCounter), vote.Signature, sequencerVoteDelimiter}
+}
+
+func IsSequencerVote(block Block) bool {
+	return len(block) == 6 && block[5] == sequencerVoteDelimiter
+}
+
+func SequencerVoteFromBlock(block Block) (SequencerVote, error) {
+	var vote SequencerVote
+	if !IsSequencerVote(block) {
+		return vote, errors.New("not a sequencer vote")
+	}
+	view, err1 := strconv.Atoi(block[0])
+	voter, err2 := strconv.Atoi(block[2])
+	blockCounter, err3 := strconv.Atoi(block[3])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return vote, errors.New("malformed sequencer vote")
+	}
+	vote.View = view
+	vote.Candidate = block[1]
+	vote.Voter = voter
+	vote.BlockCounter = blockCounter
+	vote.Signature = block[4]
+	return vote, nil
+}
+
+//JoinCommittee must be called before run. key is this peer's committee key, or nil if it only follows the committee
+func (peer *Peer) JoinCommittee(committee *SequencerCommittee, key *RSA) error {
+	if key != nil && committee.Index(ConvertBigIntToString(&key.n)) == -1 {
+		return errors.New("the key is not in the committee")
+	}
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	peer.committee = committee
+	peer.committeeRSA = key
+	return nil
+}
+
+func (peer *Peer) IsSequencer() bool {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	return peer.seq
+}
+
+func (peer *Peer) GetSeqPk() string {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	return peer.seqPk
+}
+
+func (peer *Peer) GetView() int {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	return peer.view
+}
+
+//seqPkMessage is what a new peer receives when it connects: the sequencer's key, and with a committee also the view,
+//our blockCounter, the committee and the votes that elected the view
+func (peer *Peer) seqPkMessage() string {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	if peer.committee == nil {
+		return peer.seqPk
+	}
+	return peer.seqPk + ":" + strconv.Itoa(peer.view) + ":" + strconv.Itoa(peer.blockCounter) + ":" + peer.committee.String() + ":" + marshalViewVotes(peer.viewVotes)
+}
+
+//The votes of a view are sent as voter/blockCounter/signature separated by ';', the view and the candidate are in the
+//message already
+func marshalViewVotes(votes []SequencerVote) string {
+	marshalled := make([]string, 0, len(votes))
+	for _, vote := range votes {
+		marshalled = append(marshalled, strconv.Itoa(vote.Voter)+"/"+strconv.Itoa(vote.BlockCounter)+"/"+vote.Signature)
+	}
+	return strings.Join(marshalled, ";")
+}
+
+func parseViewVotes(view int, candidate string, marshalled string) []SequencerVote {
+	votes := make([]SequencerVote, 0)
+	for _, field := range strings.Split(marshalled, ";") {
+		parts := strings.Split(field, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		voter, err1 := strconv.Atoi(parts[0])
+		blockCounter, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil {
+			votes = append(votes, SequencerVote{View: view, Candidate: candidate, Voter: voter, BlockCounter: blockCounter, Signature: parts[2]})
+		}
+	}
+	return votes
+}
+
+//handleSeqPkMessage returns the sequencer's key from a message made by seqPkMessage. With a committee only our own
+//committee is trusted: the key must be its sequencer of the view, and a view after the first must come with the votes
+//of a majority of the committee. Otherwise we keep the sequencer of our own view
+func (peer *Peer) handleSeqPkMessage(message string) string {
+	peer.sequencerMutex.Lock()
+	committee := peer.committee
+	ownView := peer.view
+	peer.sequencerMutex.Unlock()
+	parts := strings.SplitN(message, ":", 5)
+	if committee == nil {
+		//without a committee the sequencer can not be replaced, so its key is all there is
+		return parts[0]
+	}
+	if len(parts) != 5 {
+		fmt.Println("Got a sequencer key without a view, keeping our own sequencer")
+		return committee.Sequencer(ownView)
+	}
+	view, err1 := strconv.Atoi(parts[1])
+	blockCounter, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || view < ownView || parts[3] != committee.String() || parts[0] != committee.Sequencer(view) {
+		fmt.Println("Got a sequencer that is not the one our committee chose, keeping our own sequencer")
+		return committee.Sequencer(ownView)
+	}
+	votes := parseViewVotes(view, parts[0], parts[4])
+	if view > 0 && !peer.electedByMajority(votes) {
+		fmt.Println("Got a view without the votes of a majority of the committee, keeping our own sequencer")
+		return committee.Sequencer(ownView)
+	}
+	peer.sequencerMutex.Lock()
+	if view > peer.view {
+		peer.view = view
+		peer.viewVotes = votes
+	}
+	peer.sequencerMutex.Unlock()
+	peer.becomeSequencerIfChosen(view, blockCounter)
+	return parts[0]
+}
+
+//electedByMajority checks that a majority of the committee signed a vote for the view of the votes
+func (peer *Peer) electedByMajority(votes []SequencerVote) bool {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	voters := make(map[int]bool)
+	for _, vote := range votes {
+		if peer.verifySequencerVote(vote) {
+			voters[vote.Voter] = true
+		}
+	}
+	return len(voters) >= peer.committee.Majority()
+}
+
+//becomeSequencerIfChosen starts sequencing if our committee key is the sequencer of view, numbering blocks from blockCounter
+func (peer *Peer) becomeSequencerIfChosen(view int, blockCounter int) {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	if peer.committee == nil || peer.committeeRSA == nil || peer.seq {
+		return
+	}
+	if peer.committee.Sequencer(view) != ConvertBigIntToString(&peer.committeeRSA.n) {
+		return
+	}
+	fmt.Println("This peer is now the sequencer for view", view, "starting at block", blockCounter)
+	peer.seq = true
+	peer.seqRSA = peer.committeeRSA
+	if peer.blockCounter > blockCounter {
+		blockCounter = peer.blockCounter
+	}
+	peer.nextBlockMutex.Lock()
+	peer.blockCounterSeq = blockCounter
+	peer.nextBlock = peer.unsequencedBatch()
+	peer.nextBlockMutex.Unlock()
+	go peer.HandleBlocks()
+}
+
+//unsequencedBatch returns the transactions that are not in a block yet, oldest first. sequencerMutex must be held
+func (peer *Peer) unsequencedBatch() []pendingTransaction {
+	batch := make([]pendingTransaction, 0, len(peer.unsequenced))
+	peer.messagesSentMutex.Lock()
+	for id, arrived := range peer.unsequenced {
+		size := len(peer.MarshalTransaction(peer.messagesSent[id].transaction))
+		batch = append(batch, pendingTransaction{id: id, size: size, arrived: arrived})
+	}
+	peer.messagesSentMutex.Unlock()
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].arrived.Before(batch[j].arrived)
+	})
+	return batch
+}
+
+func (peer *Peer) markSequenced(block Block) {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	for _, transactionID := range block {
+		delete(peer.unsequenced, transactionID)
+	}
+}
+
+//WatchSequencer votes for the next sequencer whenever the current one has left a transaction waiting too long, until
+//StopWatchingSequencer is called. It only does something on committee members
+func (peer *Peer) WatchSequencer() {
+	for {
+		select {
+		case <-peer.stopWatching:
+			return
+		case <-time.After(peer.sequencerTimeout / 2):
+		}
+		if peer.suspectsSequencer() {
+			peer.VoteForNextSequencer()
+		}
+	}
+}
+
+//StopWatchingSequencer can be called more than once
+func (peer *Peer) StopWatchingSequencer() {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	select {
+	case <-peer.stopWatching:
+	default:
+		close(peer.stopWatching)
+	}
+}
+
+func (peer *Peer) suspectsSequencer() bool {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	if peer.committee == nil || peer.committeeRSA == nil || peer.seq || time.Since(peer.lastVote) < peer.sequencerTimeout {
+		return false
+	}
+	for _, received := range peer.unsequenced {
+		if time.Since(received) > peer.sequencerTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+//VoteForNextSequencer votes for the view after the last one we voted for, so a dead candidate is skipped on the next timeout
+func (peer *Peer) VoteForNextSequencer() {
+	peer.sequencerMutex.Lock()
+	view := peer.view + 1
+	if peer.votedView >= view {
+		view = peer.votedView + 1
+	}
+	peer.votedView = view
+	peer.lastVote = time.Now()
+	candidate := peer.committee.Sequencer(view)
+	vote := SequencerVote{View: view, Candidate: candidate, Voter: peer.committee.Index(ConvertBigIntToString(&peer.committeeRSA.n)), BlockCounter: peer.blockCounter}
+	signature := peer.committeeRSA.FullSign(sequencerVoteString(view, candidate, peer.blockCounter), peer.committeeRSA.n, peer.committeeRSA.d)
+	vote.Signature = ConvertBigIntToString(signature)
+	peer.sequencerMutex.Unlock()
+
+	fmt.Println("The sequencer seems to be gone, voting for view", view)
+	peer.HandleSequencerVote(vote)
+}
+
+func (peer *Peer) verifySequencerVote(vote SequencerVote) bool {
+	if vote.Voter < 0 || vote.Voter >= len(peer.committee.Members) || vote.Candidate != peer.committee.Sequencer(vote.View) {
+		return false
+	}
+	voterKey := *ConvertStringToBigInt(peer.committee.Members[vote.Voter])
+	signature := ConvertStringToBigInt(vote.Signature)
+	return peer.rsa.VerifyWithKey(sequencerVoteString(vote.View, vote.Candidate, vote.BlockCounter), *signature, voterKey, big.NewInt(3))
+}
+
+//HandleSequencerVote counts a vote from the network or from ourselves, passes new votes on, and hands over to the
+//candidate once a majority of the committee has voted for its view
+func (peer *Peer) HandleSequencerVote(vote SequencerVote) {
+	peer.sequencerMutex.Lock()
+	if peer.committee == nil || vote.View <= peer.view {
+		peer.sequencerMutex.Unlock()
+		return
+	}
+	if _, seen := peer.sequencerVotes[vote.View][vote.Voter]; seen || !peer.verifySequencerVote(vote) {
+		peer.sequencerMutex.Unlock()
+		return
+	}
+	if peer.sequencerVotes[vote.View] == nil {
+		peer.sequencerVotes[vote.View] = make(map[int]SequencerVote)
+	}
+	peer.sequencerVotes[vote.View][vote.Voter] = vote
+	votes := peer.sequencerVotes[vote.View]
+	elected := len(votes) >= peer.committee.Majority()
+	blockCounter := 0
+	for _, counted := range votes {
+		if counted.BlockCounter > blockCounter {
+			blockCounter = counted.BlockCounter
+		}
+	}
+	if elected {
+		fmt.Println("A majority of the committee voted for view", vote.View, ", the sequencer is replaced")
+		peer.view = vote.View
+		peer.seqPk = vote.Candidate
+		peer.viewVotes = make([]SequencerVote, 0, len(votes))
+		for _, counted := range votes {
+			peer.viewVotes = append(peer.viewVotes, counted)
+		}
+		peer.seq = false //HandleBlocks stops when it sees the new view
+		for view := range peer.sequencerVotes {
+			if view <= vote.View {
+				delete(peer.sequencerVotes, view)
+			}
+		}
+	}
+	peer.sequencerMutex.Unlock()
+
+	marshalled, _ := json.Marshal(vote.AsBlock())
+	peer.sendBlockToAllPeers(marshalled)
+	if elected {
+		peer.becomeSequencerIfChosen(vote.View, blockCounter)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func makeCommittee(t *testing.T, size int) (*SequencerCommittee, []*RSA) {
+	keys := make([]*RSA, size)
+	members := make([]string, size)
+	for i := range keys {
+		keys[i] = MakeRSA(1024)
+		members[i] = ConvertBigIntToString(&keys[i].n)
+	}
+	committee, err := MakeSequencerCommittee(members)
+	if err != nil {
+		t.Fatal("Could not make the committee:", err)
+	}
+	return committee, keys
+}
+
+func signedVote(key *RSA, committee *SequencerCommittee, view int, blockCounter int) SequencerVote {
+	candidate := committee.Sequencer(view)
+	signature := key.FullSign(sequencerVoteString(view, candidate, blockCounter), key.n, key.d)
+	voter := committee.Index(ConvertBigIntToString(&key.n))
+	return SequencerVote{View: view, Candidate: candidate, Voter: voter, BlockCounter: blockCounter, Signature: ConvertBigIntToString(signature)}
+}
+
+func TestSequencerIsOnlyReplacedByAMajorityOfTheCommittee(t *testing.T) {
+	committee, keys := makeCommittee(t, 3)
+	peer := peerFixture()
+	peer.JoinCommittee(committee, nil)
+	peer.seqPk = committee.Sequencer(0)
+
+	outsider := MakeRSA(1024)
+	forged := signedVote(outsider, committee, 1, 0)
+	forged.Voter = 2
+	peer.HandleSequencerVote(forged)
+	peer.HandleSequencerVote(signedVote(keys[2], committee, 1, 4))
+	peer.HandleSequencerVote(signedVote(keys[2], committee, 1, 4))
+	if peer.GetSeqPk() != committee.Sequencer(0) {
+		t.Fatal("One committee member, voting twice, should not be able to replace the sequencer")
+	}
+	peer.HandleSequencerVote(signedVote(keys[0], committee, 1, 3))
+	if peer.GetSeqPk() != committee.Sequencer(1) || peer.GetView() != 1 {
+		t.Error("Two of three votes should replace the sequencer")
+	}
+	//an old vote must not bring back an earlier sequencer
+	peer.HandleSequencerVote(signedVote(keys[1], committee, 1, 3))
+	if peer.GetView() != 1 {
+		t.Error("Votes for the current view should be ignored")
+	}
+	fmt.Println("TestSequencerIsOnlyReplacedByAMajorityOfTheCommittee passed")
+}
+
+func createCommitteePeer(ip string, port string, committee *SequencerCommittee, key *RSA) (*Peer, net.Listener) {
+	fixedUriStrategy := MakeFixedUriStrategy(ip, port)
+	fixedInputStrategy := MakeFixedInputStrategy(*MakeSignedTransaction("acc1", "acc2", 100, "yeet"))
+	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
+	messageSendingStrategy := new(RealMessageSendingStrategy)
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	peer.batchConfig.MaxDelay = 100 * time.Millisecond
+	peer.sequencerTimeout = time.Second
+	peer.JoinCommittee(committee, key)
+
+	peer.JoinNetwork(peer.GetURI())
+	listener := peer.StartListeningForConnections()
+	peer.AddSelfToConnectionsURI()
+	peer.BroadcastPresence(peer.ip + ":" + peer.port)
+
+	go peer.SendMessages()
+	go peer.WatchSequencer()
+	go takeNewConnectionsHelp(peer, listener)
+	return peer, listener
+}
+
+func waitFor(condition func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return condition()
+}
+
+func blockCounterOf(peer *Peer) int {
+	peer.sequencerMutex.Lock()
+	defer peer.sequencerMutex.Unlock()
+	return peer.blockCounter
+}
+
+func TestCommitteeTakesOverWhenTheSequencerDies(t *testing.T) {
+	committee, keys := makeCommittee(t, 3)
+	first, firstListener := createCommitteePeer("fa", "fa", committee, keys[0])
+	second, secondListener := createCommitteePeer(first.ip, first.port, committee, keys[1])
+	defer secondListener.Close()
+	third, thirdListener := createCommitteePeer(first.ip, first.port, committee, keys[2])
+	defer thirdListener.Close()
+	if !first.IsSequencer() || third.GetSeqPk() != committee.Sequencer(0) {
+		t.Fatal("The first committee member should start as the sequencer")
+	}
+
+	account := MakeRSA(1024)
+	from := ConvertBigIntToString(&account.n)
+	secret := ConvertBigIntToString(&account.d)
+	second.outbound <- *MakeSignedTransaction(from, "bob", 1, secret)
+	if !waitFor(func() bool { return blockCounterOf(third) == 1 }, 5*time.Second) {
+		t.Fatal("The first sequencer did not order the first transaction")
+	}
+
+	//the sequencer dies
+	first.StopWatchingSequencer()
+	firstListener.Close()
+	for _, connection := range first.GetConnections() {
+		connection.Close()
+	}
+	second.outbound <- *MakeSignedTransaction(from, "bob", 2, secret)
+	if !waitFor(func() bool { return second.IsSequencer() && third.GetSeqPk() == committee.Sequencer(1) }, 10*time.Second) {
+		t.Fatal("The second committee member should have taken over")
+	}
+	//the new sequencer numbers its first block 1, so the others accept it
+	if !waitFor(func() bool { return blockCounterOf(third) == 2 }, 5*time.Second) {
+		t.Error("The block from the new sequencer was not accepted, blockCounter is", blockCounterOf(third))
+	}
+	fmt.Println("TestCommitteeTakesOverWhenTheSequencerDies passed")
+}
+
+func TestJoiningPeerOnlyTakesASequencerTheCommitteeChose(t *testing.T) {
+	committee, keys := makeCommittee(t, 3)
+	message := func(seqPk string, view int, votes ...SequencerVote) string {
+		return seqPk + ":" + strconv.Itoa(view) + ":5:" + committee.String() + ":" + marshalViewVotes(votes)
+	}
+	peer := peerFixture()
+	peer.JoinCommittee(committee, nil)
+
+	outsider := ConvertBigIntToString(&MakeRSA(1024).n)
+	rejected := []string{
+		message(outsider, 0),
+		message(committee.Sequencer(0), 1),
+		message(committee.Sequencer(1), 1),
+		message(committee.Sequencer(1), 1, signedVote(keys[0], committee, 1, 5)),
+		message(committee.Sequencer(1), 1, signedVote(keys[0], committee, 1, 5), signedVote(keys[0], committee, 1, 5)),
+		committee.Sequencer(1),
+	}
+	for _, seqPkMessage := range rejected {
+		if peer.handleSeqPkMessage(seqPkMessage) != committee.Sequencer(0) || peer.GetView() != 0 {
+			t.Error("The joining peer should keep the first sequencer when it gets", seqPkMessage[:20], "...")
+		}
+	}
+	elected := message(committee.Sequencer(1), 1, signedVote(keys[0], committee, 1, 5), signedVote(keys[2], committee, 1, 5))
+	peer.seqPk = peer.handleSeqPkMessage(elected) //as JoinNetwork does
+	if peer.seqPk != committee.Sequencer(1) || peer.GetView() != 1 {
+		t.Error("The joining peer should take a view that a majority voted for")
+	}
+	//and it can pass the view on to the next peer that joins
+	next := peerFixture()
+	next.JoinCommittee(committee, nil)
+	if next.handleSeqPkMessage(peer.seqPkMessage()) != committee.Sequencer(1) {
+		t.Error("The votes of the view should be passed on to new peers")
+	}
+
+	follower := peerFixture()
+	follower.handleSeqPkMessage(elected)
+	if follower.committee != nil {
+		t.Error("A peer should not take the committee from the peer it joins through")
+	}
+	fmt.Println("TestJoiningPeerOnlyTakesASequencerTheCommitteeChose passed")
+}
+
+func TestWatchingTheSequencerCanBeStopped(t *testing.T) {
+	peer := peerFixture()
+	peer.sequencerTimeout = 20 * time.Millisecond
+	stopped := make(chan bool)
+	go func() {
+		peer.WatchSequencer()
+		close(stopped)
+	}()
+	peer.StopWatchingSequencer()
+	peer.StopWatchingSequencer()
+	select {
+	case <-stopped:
+		fmt.Println("TestWatchingTheSequencerCanBeStopped passed")
+	case <-time.After(time.Second):
+		t.Error("WatchSequencer should return when it is stopped")
+	}
+}
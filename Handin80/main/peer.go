@@ -3,10 +3,11 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -45,11 +46,29 @@ type Peer struct {
 	seqRSA                 *RSA           //this only exists for the sequencer
 	seqPk                  string         //the public key for the sequencer (everyone has this)
 	blocksSent             BlocksSent
-	blocksSentMutex        *sync.Mutex
-	nextBlock              Block
+	blocksSentMutex        *sync.Mutex          //Mutex for blocksSent and the block buffer fields below
+	futureBlocks           map[int]Block        //blocks that arrived before the blocks preceding them, by block number
+	appliedBlocks          map[int]Block        //the last maxAppliedBlocks blocks put in the ledger, kept to answer block requests
+	blockRequested         map[int]time.Time    //when we last asked our neighbours for a missing block
+	blockRequestInterval   time.Duration        //how long we wait for a requested block before asking again
+	nextBlock              []pendingTransaction //the transactions the sequencer has not put in a block yet
 	nextBlockMutex         *sync.Mutex
 	blockCounter           int
 	blockCounterSeq        int
+	batchConfig            BatchConfig                   //when the sequencer cuts a block
+	batchSignal            chan bool                     //wakes HandleBlocks when a transaction is added to nextBlock
+	batchStats             *BatchStats                   //the blocks this peer has sent as sequencer
+	committee              *SequencerCommittee           //the peers that take turns being sequencer, nil if the sequencer can not be replaced
+	committeeRSA           *RSA                          //our committee key, if we are in the committee
+	view                   int                           //how many times the sequencer has been replaced
+	votedView              int                           //the last view we voted for
+	lastVote               time.Time                     //when we last voted for a new sequencer
+	sequencerVotes         map[int]map[int]SequencerVote //votes by view and voter
+	viewVotes              []SequencerVote               //the votes that elected the current view, new peers check them
+	stopWatching           chan bool                     //closed by StopWatchingSequencer
+	unsequenced            map[string]time.Time          //transactions that are not in a block yet, and when we got them
+	sequencerTimeout       time.Duration                 //how long a transaction may wait for a block before we vote for a new sequencer
+	sequencerMutex         *sync.Mutex                   //Mutex for seq, seqRSA, seqPk, blockCounter and the fields above
 }
 
 func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrategy, message MessageSendingStrategy) *Peer {
@@ -69,24 +88,85 @@ func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrate
 	peer.connectionsURIMutex = &sync.Mutex{}
 	peer.blocksSent = make(map[string]bool)
 	peer.blocksSentMutex = &sync.Mutex{}
-	peer.nextBlock = make([]string, 0)
+	peer.futureBlocks = make(map[int]Block)
+	peer.appliedBlocks = make(map[int]Block)
+	peer.blockRequested = make(map[int]time.Time)
+	peer.blockRequestInterval = time.Second
+	peer.nextBlock = make([]pendingTransaction, 0)
 	peer.nextBlockMutex = &sync.Mutex{}
 	peer.rsa = MakeRSA(2000)
 	peer.blockCounter = 0
 	peer.blockCounterSeq = 0
+	peer.batchConfig = DefaultBatchConfig()
+	peer.batchSignal = make(chan bool, 1)
+	peer.batchStats = MakeBatchStats()
+	peer.committee = nil
+	peer.committeeRSA = nil
+	peer.view = 0
+	peer.votedView = 0
+	peer.sequencerVotes = make(map[int]map[int]SequencerVote)
+	peer.viewVotes = nil
+	peer.stopWatching = make(chan bool)
+	peer.unsequenced = make(map[string]time.Time)
+	peer.sequencerTimeout = 30 * time.Second
+	peer.sequencerMutex = &sync.Mutex{}
 	return peer
 }
 
 func main() {
+	committeeFile := flag.String("committee", "", "file with the public keys of the peers that take turns being sequencer, one per line")
+	committeeKey := flag.String("committee-key", "", "key file made by Generate with this peer's committee key, the password is asked for")
+	batchConfig := DefaultBatchConfig()
+	flag.IntVar(&batchConfig.MaxTransactions, "batch-max-tx", batchConfig.MaxTransactions, "the most transactions the sequencer puts in a block")
+	flag.IntVar(&batchConfig.MaxBytes, "batch-max-bytes", batchConfig.MaxBytes, "the most bytes of transactions the sequencer puts in a block")
+	flag.DurationVar(&batchConfig.MaxDelay, "batch-max-delay", batchConfig.MaxDelay, "how long a transaction may wait before the sequencer sends a block")
+	flag.Parse()
+
 	//Intialize strategy and peer
 	commandLineUriStrategy := new(CommandLineUriStrategy) // Strategy to get the URI from user command-line input
 	commandLineUserInputStrategy := new(CommandLineUserInputStrategy)
 	outboundIPStrategy := new(RealOutboundIPStrategy)
 	messageSendingStrategy := new(RealMessageSendingStrategy)
 	peer := MakePeer(commandLineUriStrategy, commandLineUserInputStrategy, outboundIPStrategy, messageSendingStrategy)
+	err := peer.SetBatchConfig(batchConfig)
+	if err != nil {
+		fmt.Println("Invalid batch settings:", err)
+		os.Exit(1)
+	}
+	if *committeeFile != "" {
+		err := peer.setupCommittee(*committeeFile, *committeeKey)
+		if err != nil {
+			fmt.Println("Could not set up the sequencer committee:", err)
+			os.Exit(1)
+		}
+	}
 	peer.run()
 }
 
+func (peer *Peer) setupCommittee(committeeFile string, keyFile string) error {
+	keys, err := os.ReadFile(committeeFile)
+	if err != nil {
+		return err
+	}
+	committee, err := ParseSequencerCommittee(string(keys))
+	if err != nil {
+		return err
+	}
+	var key *RSA
+	if keyFile != "" {
+		fmt.Println("Enter the password of the committee key:")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		key, err = LoadKeyFile(keyFile, strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return peer.JoinCommittee(committee, key)
+}
+
 func (peer *Peer) run() {
 	//ask for IP and port of an existing peer via user input or other strategy
 	otherURI := peer.GetURI()
@@ -114,8 +194,11 @@ func (peer *Peer) run() {
 	//set up a thread to send outbound messages
 	go peer.SendMessages()
 
-	if peer.seq {
+	//with a committee the sequencer starts HandleBlocks when it is chosen
+	if peer.committee == nil && peer.IsSequencer() {
 		go peer.HandleBlocks()
+	} else if peer.committee != nil {
+		go peer.WatchSequencer()
 	}
 
 	//listen for connections from other peers
@@ -125,21 +208,17 @@ func (peer *Peer) run() {
 }
 
 func (peer *Peer) HandleBlocks() {
+	view := peer.GetView()
 	for {
-		time.Sleep(10 * time.Second)
-		peer.nextBlockMutex.Lock()
-		if len(peer.nextBlock) == 0 {
-			peer.nextBlockMutex.Unlock()
-		} else {
-			peer.nextBlock = append(peer.nextBlock, strconv.Itoa(peer.blockCounterSeq))
-			fmt.Println("Handleblock hath awoken and shall cast the sendtoall spell - 'and the wicked shall fear it and the righteous shall rejoice', Book of Einstein, 13,37")
-			marshalled := peer.MarshalBlock(peer.nextBlock)
-			peer.sendBlockToAllPeers(marshalled)
-			peer.nextBlock = make([]string, 0)
-			peer.blockCounterSeq++
-			peer.nextBlockMutex.Unlock()
+		//sleep until the oldest transaction is due, or until a new transaction may have filled the block
+		select {
+		case <-peer.batchSignal:
+		case <-time.After(peer.nextBatchWait()):
 		}
-
+		if peer.GetView() != view {
+			return //another sequencer has taken over
+		}
+		peer.sendReadyBlocks()
 	}
 }
 
@@ -203,6 +282,14 @@ func (peer *Peer) JoinNetwork(uri string) net.Conn {
 	fmt.Println("Connecting to uri: ", uri)
 	out_conn, err := net.Dial("tcp", uri)
 	if err != nil {
+		if peer.committee != nil {
+			fmt.Println("No peer found, starting new  peer to peer network - the first committee member is the sequencer")
+			peer.sequencerMutex.Lock()
+			peer.seqPk = peer.committee.Sequencer(0)
+			peer.sequencerMutex.Unlock()
+			peer.becomeSequencerIfChosen(0, 0)
+			return nil
+		}
 		fmt.Println("No peer found, starting new  peer to peer network - you are the sequencer")
 		peer.seq = true
 		peer.seqRSA = MakeRSA(2000)
@@ -219,7 +306,10 @@ func (peer *Peer) JoinNetwork(uri string) net.Conn {
 		peer.connectionsURI = peer.ReceiveConnectionsURI(out_conn)
 		peer.connectionsURIMutex.Unlock()
 		//receive seqPk
-		peer.seqPk = peer.ReceiveSeqPk(out_conn)
+		seqPk := peer.ReceiveSeqPk(out_conn)
+		peer.sequencerMutex.Lock()
+		peer.seqPk = seqPk
+		peer.sequencerMutex.Unlock()
 		//handle messages
 		go peer.HandleIncomingMessagesFromPeer(out_conn)
 
@@ -239,9 +329,25 @@ func (peer *Peer) ConnectToPeer(uri string) {
 	}
 }
 
+//readJoinMessage reads one message without buffering, a bufio.Reader could read past it and take the next message
+//away from the reader that HandleIncomingMessagesFromPeer makes afterwards
+func readJoinMessage(coming_from net.Conn) ([]byte, error) {
+	message := make([]byte, 0)
+	next := make([]byte, 1)
+	for {
+		_, err := io.ReadFull(coming_from, next)
+		if err != nil {
+			return message, err
+		}
+		message = append(message, next[0])
+		if next[0] == ']' {
+			return message, nil
+		}
+	}
+}
+
 func (peer *Peer) ReceiveConnectionsURI(coming_from net.Conn) ConnectionsURI {
-	reader := bufio.NewReader(coming_from)
-	marshalled, err := reader.ReadBytes(']')
+	marshalled, err := readJoinMessage(coming_from)
 	if err != nil {
 		fmt.Println("Lost connection to peer")
 		panic(-1)
@@ -251,14 +357,13 @@ func (peer *Peer) ReceiveConnectionsURI(coming_from net.Conn) ConnectionsURI {
 }
 
 func (peer *Peer) ReceiveSeqPk(coming_from net.Conn) string {
-	reader := bufio.NewReader(coming_from)
-	bytes, err := reader.ReadBytes(']')
+	bytes, err := readJoinMessage(coming_from)
 	if err != nil {
 		fmt.Println("Lost connection to peer")
 		panic(-1)
 	}
 	asString := string(bytes)
-	seqPk := asString[:len(asString)-1]
+	seqPk := peer.handleSeqPkMessage(asString[:len(asString)-1])
 	fmt.Println("received this seqPk" + seqPk)
 	return seqPk
 }
@@ -293,13 +398,16 @@ func (peer *Peer) SendMessages() {
 
 			peer.messagesSentMutex.Unlock()
 
+			peer.sequencerMutex.Lock()
+			peer.unsequenced[message.ID] = time.Now()
+			isSequencer := peer.seq
+			peer.sequencerMutex.Unlock()
+
 			//send the message out to all peers in the network
 			peer.messageSendingStrategy.SendMessageToAllPeers(message, peer)
 
-			if peer.seq {
-				peer.nextBlockMutex.Lock()
-				peer.nextBlock = append(peer.nextBlock, message.ID)
-				peer.nextBlockMutex.Unlock()
+			if isSequencer {
+				peer.addToBatch(pendingTransaction{id: message.ID, size: len(peer.MarshalTransaction(message)), arrived: time.Now()})
 			}
 
 		} else {
@@ -330,14 +438,14 @@ func (peer *Peer) SendConnectionsURI(conn net.Conn) {
 }
 
 func (peer *Peer) SendSeqPk(conn net.Conn) {
-	toBeSent := []byte(peer.seqPk + "]")
+	toBeSent := []byte(peer.seqPkMessage() + "]")
 	_, err := conn.Write(toBeSent)
 	if err != nil {
 		fmt.Println("Tried to send to a lost connection")
 		//delete the missing connection
 		peer.DeleteFromConnections(conn)
 	}
-	fmt.Println("sent this seqPk: " + peer.seqPk)
+	fmt.Println("sent this seqPk: " + peer.GetSeqPk())
 }
 
 func (peer *Peer) AppendToConnections(conn net.Conn) {
@@ -437,28 +545,19 @@ func (peer *Peer) HandleIncomingMessagesFromPeer(connection net.Conn) {
 					//this was not a block, but a seqPk or connectionsURI
 					fmt.Println("received a seqPk or connectionsURI")
 					continue
+				} else if IsSequencerVote(demarshalled) {
+					vote, err := SequencerVoteFromBlock(demarshalled)
+					if err == nil {
+						peer.HandleSequencerVote(vote)
+					}
+				} else if IsBlockRequest(demarshalled) {
+					peer.HandleBlockRequest(demarshalled, connection)
 				} else {
 					//this was a block
 					fmt.Println("received (probably) a block")
-					if demarshalled[len(demarshalled)-1] == "yeet" && peer.rsa.VerifyBlock(demarshalled, peer.seqPk) {
+					if len(demarshalled) >= 3 && demarshalled[len(demarshalled)-1] == "yeet" && peer.rsa.VerifyBlock(demarshalled, peer.GetSeqPk()) {
 						//fmt.Println("Verified a demarshalled block")
-						peer.blocksSentMutex.Lock()
-						if !peer.blocksSent[demarshalled[len(demarshalled)-2]] {
-							//fmt.Println("Got a previously unseen block")
-							peer.blocksSent[demarshalled[len(demarshalled)-2]] = true
-							go peer.sendBlockToAllPeers(marshalled)
-							blockNo, _ := strconv.Atoi(demarshalled[len(demarshalled)-3])
-							if blockNo == peer.blockCounter {
-								fmt.Println("Got a new block with the expected block number, sending the block to all peers")
-								peer.blockCounter++
-								peer.UpdateLedgerWithBlock(demarshalled[:len(demarshalled)-3])
-							} else {
-								fmt.Println("Got an unexpected block number", blockNo, "expected", peer.blockCounter)
-							}
-						} else {
-							fmt.Println("Got a block that's seen before")
-						}
-						peer.blocksSentMutex.Unlock()
+						peer.ReceiveBlock(demarshalled, marshalled)
 					} else {
 						fmt.Println("Rejected a block")
 					}
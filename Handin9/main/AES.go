@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+//Every ciphertext made by Encrypt starts with "AES" and a version byte, so we can tell the formats apart when reading old files.
+//Ciphertexts without the header are the old unauthenticated CTR format (iv || ciphertext), which can only be read with DecryptLegacyCTR
+const (
+	AESVersionGCM     byte = 1
+	AESVersionCTRHMAC byte = 2
+)
+
+var aesMagic = []byte("AES")
+
+const aesHeaderLength = 4
+const aesMacLength = sha256.Size
+
+type AES struct {
+}
+
+func MakeAES() *AES {
+	this_aes := new(AES)
+	return this_aes
+}
+
+//Encrypt uses AES-GCM, so Decrypt will notice if the ciphertext was changed
+func (this_aes *AES) Encrypt(plaintext string, key []byte) ([]byte, error) {
+	return this_aes.EncryptWithAdditionalData([]byte(plaintext), key, nil)
+}
+
+func (this_aes *AES) Decrypt(encrypted string, key []byte) (string, error) {
+	plaintext, err := this_aes.DecryptWithAdditionalData([]byte(encrypted), key, nil)
+	return string(plaintext), err
+}
+
+//EncryptWithAdditionalData encrypts with AES-GCM, the additional data is authenticated but not encrypted and must be given again when decrypting
+func (this_aes *AES) EncryptWithAdditionalData(plaintext []byte, key []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := this_aes.makeGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := makeAESHeader(AESVersionGCM)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := append(append([]byte{}, header...), nonce...)
+	return gcm.Seal(ciphertext, nonce, plaintext, append(header, additionalData...)), nil
+}
+
+//EncryptThenMAC encrypts with AES-CTR and appends an HMAC-SHA256 of everything before it, for when GCM can not be used
+func (this_aes *AES) EncryptThenMAC(plaintext []byte, key []byte, additionalData []byte) ([]byte, error) {
+	encryptionKey, macKey := splitAESKey(key)
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	_, err = rand.Read(iv)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := append(makeAESHeader(AESVersionCTRHMAC), iv...)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCTR(block, iv).XORKeyStream(encrypted, plaintext)
+	ciphertext = append(ciphertext, encrypted...)
+	return append(ciphertext, computeAESMac(macKey, ciphertext, additionalData)...), nil
+}
+
+//DecryptWithAdditionalData reads both versioned formats and fails if the ciphertext, the header or the additional data was changed
+func (this_aes *AES) DecryptWithAdditionalData(encrypted []byte, key []byte, additionalData []byte) ([]byte, error) {
+	if !IsVersionedCiphertext(encrypted) {
+		return nil, errors.New("ciphertext has no version header, use DecryptLegacyCTR for old files")
+	}
+	header := encrypted[:aesHeaderLength]
+	switch header[3] {
+	case AESVersionGCM:
+		gcm, err := this_aes.makeGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(encrypted) < aesHeaderLength+gcm.NonceSize()+gcm.Overhead() {
+			return nil, errors.New("ciphertext is too short")
+		}
+		nonce := encrypted[aesHeaderLength : aesHeaderLength+gcm.NonceSize()]
+		return gcm.Open(nil, nonce, encrypted[aesHeaderLength+gcm.NonceSize():], append(append([]byte{}, header...), additionalData...))
+	case AESVersionCTRHMAC:
+		encryptionKey, macKey := splitAESKey(key)
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(encrypted) < aesHeaderLength+block.BlockSize()+aesMacLength {
+			return nil, errors.New("ciphertext is too short")
+		}
+		macStart := len(encrypted) - aesMacLength
+		if !hmac.Equal(encrypted[macStart:], computeAESMac(macKey, encrypted[:macStart], additionalData)) {
+			return nil, errors.New("message authentication failed")
+		}
+		iv := encrypted[aesHeaderLength : aesHeaderLength+block.BlockSize()]
+		plaintext := make([]byte, macStart-aesHeaderLength-block.BlockSize())
+		cipher.NewCTR(block, iv).XORKeyStream(plaintext, encrypted[aesHeaderLength+block.BlockSize():macStart])
+		return plaintext, nil
+	}
+	return nil, errors.New("unknown ciphertext version")
+}
+
+//DecryptLegacyCTR reads ciphertexts made before the version header was added (iv || ciphertext, no authentication)
+func (this_aes *AES) DecryptLegacyCTR(encrypted []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < block.BlockSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	plaintext := make([]byte, len(encrypted)-block.BlockSize())
+	iv := encrypted[:block.BlockSize()]
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, encrypted[block.BlockSize():])
+	return plaintext, nil
+}
+
+//MigrateCiphertext turns an old CTR ciphertext into the current GCM format, versioned ciphertexts are returned unchanged
+func (this_aes *AES) MigrateCiphertext(encrypted []byte, key []byte) ([]byte, error) {
+	if IsVersionedCiphertext(encrypted) {
+		return encrypted, nil
+	}
+	plaintext, err := this_aes.DecryptLegacyCTR(encrypted, key)
+	if err != nil {
+		return nil, err
+	}
+	return this_aes.EncryptWithAdditionalData(plaintext, key, nil)
+}
+
+//MigrateFile rewrites a file encrypted by the old EncryptToFile in the current format
+func (this_aes *AES) MigrateFile(filename string, key []byte) error {
+	encrypted, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	migrated, err := this_aes.MigrateCiphertext(encrypted, key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, migrated, 0600)
+}
+
+//SealWithNonce encrypts and authenticates with AES-GCM, the caller must never use the same nonce twice with one key
+func (this_aes *AES) SealWithNonce(plaintext []byte, key []byte, nonce []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := this_aes.makeGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+//OpenWithNonce reverses SealWithNonce and returns an error if the ciphertext or the additional data was changed
+func (this_aes *AES) OpenWithNonce(ciphertext []byte, key []byte, nonce []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := this_aes.makeGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func IsVersionedCiphertext(encrypted []byte) bool {
+	return len(encrypted) >= aesHeaderLength && bytes.Equal(encrypted[:3], aesMagic)
+}
+
+func makeAESHeader(version byte) []byte {
+	return append(append([]byte{}, aesMagic...), version)
+}
+
+func (this_aes *AES) makeGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//splitAESKey derives independent keys for CTR and HMAC, using one key for both would be a bad idea
+func splitAESKey(key []byte) ([]byte, []byte) {
+	encryptionMac := hmac.New(sha256.New, key)
+	encryptionMac.Write([]byte("AES-CTR encryption"))
+	authenticationMac := hmac.New(sha256.New, key)
+	authenticationMac.Write([]byte("HMAC-SHA256 authentication"))
+	return encryptionMac.Sum(nil), authenticationMac.Sum(nil)
+}
+
+func computeAESMac(macKey []byte, ciphertext []byte, additionalData []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(additionalData)))
+	mac.Write(length)
+	mac.Write(additionalData)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+func (this_aes *AES) generateAESKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
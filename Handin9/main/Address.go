@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+//Address is what accounts are called in the ledger and in transactions. It is a hash of the account's public key (n for
+//RSA keys, "ed25519:<hex>" for Ed25519 keys) with a version byte and a checksum, encoded in base58 like Bitcoin addresses,
+//so a mistyped address is rejected instead of silently creating a new account.
+type Address string
+
+const (
+	addressVersion        = 0x2a
+	addressHashLength     = 20
+	addressChecksumLength = 4
+	base58Alphabet        = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+var ErrInvalidAddress = errors.New("not a valid address, check it for typos")
+
+func AddressOfPublicKey(publicKey string) Address {
+	hashed := sha256.Sum256([]byte(publicKey))
+	payload := append([]byte{addressVersion}, hashed[:addressHashLength]...)
+	return Address(base58Encode(append(payload, addressChecksum(payload)...)))
+}
+
+//Valid tells whether the address is exactly what AddressOfPublicKey gives, with a correct checksum and no whitespace
+func (address Address) Valid() bool {
+	parsed, err := ParseAddress(string(address))
+	return err == nil && parsed == address
+}
+
+//ParseAddress checks the checksum of an address typed by a user, surrounding whitespace is ignored
+func ParseAddress(address string) (Address, error) {
+	trimmed := strings.TrimSpace(address)
+	decoded, ok := base58Decode(trimmed)
+	if !ok || len(decoded) != 1+addressHashLength+addressChecksumLength || decoded[0] != addressVersion {
+		return "", ErrInvalidAddress
+	}
+	payload := decoded[:1+addressHashLength]
+	if !bytes.Equal(addressChecksum(payload), decoded[1+addressHashLength:]) {
+		return "", ErrInvalidAddress
+	}
+	return Address(trimmed), nil
+}
+
+func addressChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:addressChecksumLength]
+}
+
+func base58Encode(data []byte) string {
+	number := big.NewInt(0).SetBytes(data)
+	base := big.NewInt(58)
+	remainder := big.NewInt(0)
+	encoded := make([]byte, 0)
+	for number.Sign() > 0 {
+		number.DivMod(number, base, remainder)
+		encoded = append(encoded, base58Alphabet[remainder.Int64()])
+	}
+	//every leading zero byte is written as a leading '1'
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func base58Decode(encoded string) ([]byte, bool) {
+	number := big.NewInt(0)
+	base := big.NewInt(58)
+	leadingZeros := 0
+	for i, c := range encoded {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, false
+		}
+		if digit == 0 && i == leadingZeros {
+			leadingZeros++
+		}
+		number.Mul(number, base)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+	return append(make([]byte, leadingZeros), number.Bytes()...), encoded != ""
+}
+
+//AddressRegistry remembers the public key behind every address seen in a signed transaction or a block,
+//so a transaction from a known address can be verified even if it does not carry the public key
+type AddressRegistry struct {
+	publicKeys map[Address]string
+	lock       *sync.Mutex
+}
+
+func MakeAddressRegistry() *AddressRegistry {
+	registry := new(AddressRegistry)
+	registry.publicKeys = make(map[Address]string)
+	registry.lock = &sync.Mutex{}
+	return registry
+}
+
+//Learn adds the public key and returns its address
+func (registry *AddressRegistry) Learn(publicKey string) Address {
+	address := AddressOfPublicKey(publicKey)
+	registry.lock.Lock()
+	registry.publicKeys[address] = publicKey
+	registry.lock.Unlock()
+	return address
+}
+
+func (registry *AddressRegistry) PublicKey(address Address) (string, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	publicKey, found := registry.publicKeys[address]
+	return publicKey, found
+}
+
+//Resolve fills in the public key of a transaction that does not carry one, if the address is known
+func (registry *AddressRegistry) Resolve(transaction SignedTransaction) SignedTransaction {
+	if transaction.PublicKey == "" {
+		transaction.PublicKey, _ = registry.PublicKey(transaction.From)
+	}
+	return transaction
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAddressesCatchTypos(t *testing.T) {
+	address := GenesisRSA("1").Address()
+	if parsed, err := ParseAddress(" " + string(address) + "\n"); err != nil || parsed != address {
+		t.Error("A valid address should parse, got", err)
+	}
+	for i := range address {
+		typo := []byte(address)
+		if typo[i] == '2' {
+			typo[i] = '3'
+		} else {
+			typo[i] = '2'
+		}
+		if _, err := ParseAddress(string(typo)); err != ErrInvalidAddress {
+			t.Error("A typo at position", i, "was not caught")
+		}
+	}
+	if _, err := ParseAddress("0OIl"); err != ErrInvalidAddress {
+		t.Error("Characters outside base58 should be rejected")
+	}
+	if AddressOfPublicKey("1") == AddressOfPublicKey("2") {
+		t.Error("Different keys should have different addresses")
+	}
+	fmt.Println("TestAddressesCatchTypos passed")
+}
+
+func TestRegistryLetsTransactionsLeaveOutKnownPublicKeys(t *testing.T) {
+	peer := peerFixture()
+	sender := MakeRSA(2048)
+	peer.ledger.AddGenesisAccount(sender.Address())
+
+	first := MakeUnsignedTransaction(sender.Address(), GenesisRSA("1").Address(), 10)
+	sender.SignTransaction(first)
+	second := MakeUnsignedTransaction(sender.Address(), GenesisRSA("1").Address(), 10)
+	sender.SignTransaction(second)
+	second.PublicKey = ""
+
+	if peer.UpdateLedger(second) {
+		t.Error("A transaction without its public key should fail while the address is unknown")
+	}
+	if !peer.UpdateLedger(first) {
+		t.Fatal("The first transaction should be put in the ledger")
+	}
+	if publicKey, _ := peer.registry.PublicKey(sender.Address()); publicKey != sender.PublicKeyString() {
+		t.Error("The registry should learn the public key from the transaction")
+	}
+	if !peer.UpdateLedger(second) {
+		t.Error("The public key of a known address should be found in the registry")
+	}
+
+	//a public key that does not belong to the address is never accepted
+	other := MakeRSA(2048)
+	forged := MakeUnsignedTransaction(sender.Address(), other.Address(), 10)
+	other.SignTransaction(forged)
+	if peer.UpdateLedger(forged) {
+		t.Error("A transaction signed by another key should be rejected")
+	}
+	fmt.Println("TestRegistryLetsTransactionsLeaveOutKnownPublicKeys passed")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+//bench-report reads the output of "go test -bench" and prints the mean of every benchmark, and with two files
+//the change from the first to the second. Run each benchmark several times (-count 5) so one slow run does not decide it.
+
+const benchReportCommand = "bench-report"
+
+//BenchmarkResult holds the means over all runs of one benchmark, a value is -1 if it was not measured
+type BenchmarkResult struct {
+	Runs        int
+	NsPerOp     float64
+	MBPerSec    float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+}
+
+//the -8 at the end of the name is GOMAXPROCS, it is left out so runs on different machines can be compared
+var benchmarkName = regexp.MustCompile(`^(Benchmark\S+?)(-\d+)?(\s|$)`)
+
+//the code under test can print between the name and the results, then they end up on different lines
+var benchmarkMeasurements = regexp.MustCompile(`(?:^|\s)(\d+)\s+(\S+ ns/op.*)$`)
+
+func IsBenchReportCommand(args []string) bool {
+	return len(args) > 0 && args[0] == benchReportCommand
+}
+
+func RunBenchReport(args []string, out io.Writer) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: " + benchReportCommand + " results.txt [new-results.txt]")
+	}
+	results := make([]map[string]BenchmarkResult, 0)
+	for _, filename := range args[1:] {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		parsed, err := ParseBenchmarkResults(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		results = append(results, parsed)
+	}
+	if len(results) == 1 {
+		WriteBenchmarkReport(out, results[0])
+	} else {
+		WriteBenchmarkComparison(out, results[0], results[1])
+	}
+	return nil
+}
+
+func ParseBenchmarkResults(in io.Reader) (map[string]BenchmarkResult, error) {
+	sums := make(map[string]*BenchmarkResult)
+	counts := make(map[string]map[string]int)
+	name := ""
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := benchmarkName.FindStringSubmatch(line); match != nil {
+			name = match[1]
+		}
+		match := benchmarkMeasurements.FindStringSubmatch(line)
+		if match == nil || name == "" {
+			continue
+		}
+		if sums[name] == nil {
+			sums[name] = &BenchmarkResult{}
+			counts[name] = make(map[string]int)
+		}
+		sums[name].Runs++
+		fields := strings.Fields(match[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			unit := fields[i+1]
+			switch unit {
+			case "ns/op":
+				sums[name].NsPerOp += value
+			case "MB/s":
+				sums[name].MBPerSec += value
+			case "B/op":
+				sums[name].BytesPerOp += value
+			case "allocs/op":
+				sums[name].AllocsPerOp += value
+			default:
+				continue
+			}
+			counts[name][unit]++
+		}
+		name = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	results := make(map[string]BenchmarkResult)
+	for name, sum := range sums {
+		count := counts[name]
+		results[name] = BenchmarkResult{
+			Runs:        sum.Runs,
+			NsPerOp:     benchmarkMean(sum.NsPerOp, count["ns/op"]),
+			MBPerSec:    benchmarkMean(sum.MBPerSec, count["MB/s"]),
+			BytesPerOp:  benchmarkMean(sum.BytesPerOp, count["B/op"]),
+			AllocsPerOp: benchmarkMean(sum.AllocsPerOp, count["allocs/op"]),
+		}
+	}
+	return results, nil
+}
+
+func benchmarkMean(sum float64, count int) float64 {
+	if count == 0 {
+		return -1
+	}
+	return sum / float64(count)
+}
+
+func WriteBenchmarkReport(out io.Writer, results map[string]BenchmarkResult) {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "benchmark\truns\tns/op\tMB/s\tB/op\tallocs/op\t")
+	for _, name := range sortedBenchmarkNames(results, nil) {
+		result := results[name]
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\t\n", name, result.Runs, formatBenchmarkValue(result.NsPerOp),
+			formatBenchmarkValue(result.MBPerSec), formatBenchmarkValue(result.BytesPerOp), formatBenchmarkValue(result.AllocsPerOp))
+	}
+	writer.Flush()
+}
+
+//WriteBenchmarkComparison shows old and new ns/op and allocs/op, a negative change is an improvement
+func WriteBenchmarkComparison(out io.Writer, old map[string]BenchmarkResult, new map[string]BenchmarkResult) {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "benchmark\told ns/op\tnew ns/op\tchange\told allocs/op\tnew allocs/op\tchange\t")
+	for _, name := range sortedBenchmarkNames(old, new) {
+		oldResult, inOld := old[name]
+		newResult, inNew := new[name]
+		if !inOld {
+			oldResult = BenchmarkResult{NsPerOp: -1, AllocsPerOp: -1}
+		}
+		if !inNew {
+			newResult = BenchmarkResult{NsPerOp: -1, AllocsPerOp: -1}
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name,
+			formatBenchmarkValue(oldResult.NsPerOp), formatBenchmarkValue(newResult.NsPerOp), benchmarkChange(oldResult.NsPerOp, newResult.NsPerOp),
+			formatBenchmarkValue(oldResult.AllocsPerOp), formatBenchmarkValue(newResult.AllocsPerOp), benchmarkChange(oldResult.AllocsPerOp, newResult.AllocsPerOp))
+	}
+	writer.Flush()
+}
+
+func sortedBenchmarkNames(first map[string]BenchmarkResult, second map[string]BenchmarkResult) []string {
+	names := make([]string, 0)
+	for name := range first {
+		names = append(names, name)
+	}
+	for name := range second {
+		if _, found := first[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formatBenchmarkValue(value float64) string {
+	if value < 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func benchmarkChange(old float64, new float64) string {
+	if old <= 0 || new < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (new-old)/old*100)
+}
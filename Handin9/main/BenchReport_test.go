@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBenchReportComparesMeansOfRepeatedRuns(t *testing.T) {
+	old := `goos: linux
+BenchmarkHash/64B-8         	 1000000	      1000 ns/op	  64.00 MB/s	     100 B/op	       2 allocs/op
+BenchmarkHash/64B-8         	 1000000	      3000 ns/op	  21.33 MB/s	     100 B/op	       2 allocs/op
+BenchmarkFullSign-8         	Verifying draw:
+    1000	    500000 ns/op
+PASS`
+	new := `BenchmarkHash/64B-4         	 1000000	      1000 ns/op	  64.00 MB/s	     100 B/op	       1 allocs/op
+BenchmarkKeyGen/ed25519-4   	   10000	     20000 ns/op`
+
+	oldResults, _ := ParseBenchmarkResults(strings.NewReader(old))
+	newResults, _ := ParseBenchmarkResults(strings.NewReader(new))
+	hash := oldResults["BenchmarkHash/64B"]
+	if hash.Runs != 2 || hash.NsPerOp != 2000 || hash.AllocsPerOp != 2 {
+		t.Error("Expected the mean of two runs, got", hash)
+	}
+	if oldResults["BenchmarkFullSign"].NsPerOp != 500000 {
+		t.Error("Results printed on the line after the name should be found, got", oldResults["BenchmarkFullSign"])
+	}
+	if oldResults["BenchmarkFullSign"].AllocsPerOp != -1 {
+		t.Error("A value that was not measured should be -1")
+	}
+
+	var out bytes.Buffer
+	WriteBenchmarkComparison(&out, oldResults, newResults)
+	report := out.String()
+	if !strings.Contains(report, "-50.0%") {
+		t.Error("The report should show that Hash got twice as fast:\n" + report)
+	}
+	if len(strings.Split(strings.TrimSpace(report), "\n")) != 4 {
+		t.Error("The report should have a header and a line per benchmark in either file:\n" + report)
+	}
+	fmt.Println("TestBenchReportComparesMeansOfRepeatedRuns passed")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//Run with
+//	go test -run XXX -bench . -benchmem -count 5 > new.txt
+//and compare two runs with
+//	go run . bench-report old.txt new.txt
+
+func BenchmarkKeyGen(b *testing.B) {
+	for _, bits := range []int{1024, 2048, 3072} {
+		b.Run("rsa-pss-"+strconv.Itoa(bits), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				MakeRSA(bits)
+			}
+		})
+	}
+	b.Run("rsa-legacy-2000", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			MakeLegacyRSA(2000)
+		}
+	})
+	b.Run("ed25519", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			MakeEd25519Account()
+		}
+	})
+}
+
+func BenchmarkFullSign(b *testing.B) {
+	rsa := GenesisRSA("1")
+	for i := 0; i < b.N; i++ {
+		rsa.FullSign("LOTTERY:123:"+strconv.Itoa(i), rsa.n, rsa.d)
+	}
+}
+
+func BenchmarkVerifyWithKey(b *testing.B) {
+	rsa := GenesisRSA("1")
+	signature := rsa.FullSign("hello", rsa.n, rsa.d)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rsa.VerifyWithKey("hello", *signature, rsa.n, big.NewInt(3))
+	}
+}
+
+func BenchmarkSignMessage(b *testing.B) {
+	accounts := map[string]*RSA{"rsa-legacy": GenesisRSA("1"), "rsa-pss": MakeRSA(2048), "ed25519": MakeEd25519Account()}
+	for name, account := range accounts {
+		account := account
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				account.SignMessage("hello")
+			}
+		})
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	for _, size := range []int{64, 1024, 1 << 20} {
+		message := strings.Repeat("a", size)
+		b.Run(strconv.Itoa(size)+"B", func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				Hash(message)
+			}
+		})
+	}
+}
+
+func BenchmarkAES(b *testing.B) {
+	aes := MakeAES()
+	key := make([]byte, 32)
+	rand.Read(key)
+	for _, size := range []int{1024, 64 * 1024} {
+		plaintext := strings.Repeat("a", size)
+		encrypted, _ := aes.Encrypt(plaintext, key)
+		b.Run("encrypt-"+strconv.Itoa(size)+"B", func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				aes.Encrypt(plaintext, key)
+			}
+		})
+		b.Run("decrypt-"+strconv.Itoa(size)+"B", func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				aes.Decrypt(string(encrypted), key)
+			}
+		})
+	}
+}
+
+//every goroutine moves money between its own pair of accounts, so they only share the ledger's lock
+func BenchmarkLedgerTransactionContention(b *testing.B) {
+	ledger := MakeLedger()
+	transactions := make(chan *SignedTransaction, 64)
+	for i := 0; i < 64; i++ {
+		from := AddressOfPublicKey("from" + strconv.Itoa(i))
+		to := AddressOfPublicKey("to" + strconv.Itoa(i))
+		ledger.Accounts[from] = 1 << 40
+		ledger.Accounts[to] = 0
+		transactions <- MakeUnsignedTransaction(from, to, 2)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		transaction := <-transactions
+		for pb.Next() {
+			ledger.Transaction(transaction)
+		}
+	})
+}
+
+//makeChain builds a chain of depth blocks below the genesis block and returns the root and the hash of the last block
+func makeChain(depth int) (*BlockTree, string) {
+	root := MakeBlockTree(MakeBlockTreeNode("genesis", 0, "", Block{}, ""))
+	leaf := root
+	for slot := 1; slot <= depth; slot++ {
+		child := MakeBlockTree(MakeBlockTreeNode("vk", slot, "draw", Block{strconv.Itoa(slot)}, "sigma"))
+		leaf.AddChild(child)
+		leaf = child
+	}
+	return root, leaf.Node.OwnBlockHash
+}
+
+func BenchmarkBlockTree(b *testing.B) {
+	for _, depth := range []int{100, 1000} {
+		root, leafHash := makeChain(depth)
+		b.Run("insert-at-depth-"+strconv.Itoa(depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				//AddChildAt without its printing
+				root.Search(leafHash).AddChild(MakeBlockTree(MakeBlockTreeNode("vk", depth+1, "draw", Block{strconv.Itoa(i)}, "sigma")))
+			}
+		})
+		root, _ = makeChain(depth)
+		b.Run("longest-chain-leaf-at-depth-"+strconv.Itoa(depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				root.GetLongestChainLeaf()
+			}
+		})
+	}
+}
+
+//BenchmarkBlockValidation checks a winning block with 64 transactions and puts them in the ledger, like a peer does when a block arrives
+func BenchmarkBlockValidation(b *testing.B) {
+	winner := MakeRSA(2048)
+	sender := MakeRSA(2048)
+	peer := peerFixture()
+	peer.rsa = winner
+	peer.hardness = *big.NewInt(1)
+	peer.genesisLedger.AddGenesisAccount(winner.Address())
+
+	blockData := Block{}
+	for i := 0; i < 64; i++ {
+		transaction := MakeUnsignedTransaction(sender.Address(), winner.Address(), 2)
+		sender.SignTransaction(transaction)
+		peer.messagesSent[transaction.ID] = TransactionStruct{sent: true, transaction: *transaction}
+		blockData = append(blockData, transaction.ID)
+	}
+	_, draw := peer.EnterLottery(1, peer.seed)
+	block := append(append(Block{}, blockData...), "BLOCK", winner.PublicKeyString(), "1", draw, "prevBlockHash")
+	block = append(block, winner.CreateBlockSignature(1, blockData, "prevBlockHash"))
+	block = append(block, ConvertBigIntToString(Hash(strings.Join(block, ":"))), "yeet")
+
+	for _, cached := range []bool{false, true} {
+		name := "cold"
+		cache := MakeSignatureCache(0)
+		if cached {
+			name = "cached"
+			cache = MakeSignatureCache(signatureCacheSize)
+		}
+		b.Run(name, func(b *testing.B) {
+			defer func(old *SignatureCache) { VerificationCache = old }(VerificationCache)
+			VerificationCache = cache
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				peer.ledger = MakeLedger()
+				peer.ledger.AddGenesisAccount(sender.Address())
+				b.StartTimer()
+				if !peer.VerifyWinningBlock(*peer.rsa, block, peer.seed) || !peer.UpdateLedgerWithBlock(block) {
+					b.Fatal("The block did not validate")
+				}
+			}
+		})
+	}
+}
@@ -1,33 +1,43 @@
 package main
 
-import "fmt"
-
 type BlockTree struct {
 	Node     *BlockTreeNode
 	children []*BlockTree
 	parent   *BlockTree
+	logger   *Logger //children get the logger of the tree they are added to
 }
 
+var defaultBlockTreeLogger = DefaultLogger.For("blocktree")
+
 func MakeBlockTree(root *BlockTreeNode) *BlockTree {
 	blockTree := new(BlockTree)
 	blockTree.Node = root
 	blockTree.parent = nil
 	blockTree.children = make([]*BlockTree, 0)
+	blockTree.logger = defaultBlockTreeLogger
 
 	return blockTree
 }
 
+func (blockTree *BlockTree) SetLogger(logger *Logger) {
+	blockTree.logger = logger
+	for _, blockTreeChild := range blockTree.children {
+		blockTreeChild.SetLogger(logger)
+	}
+}
+
 func (blockTree *BlockTree) AddChild(tree *BlockTree) {
 	blockTree.children = append(blockTree.children, tree)
 	tree.parent = blockTree
+	tree.SetLogger(blockTree.logger)
 }
 
 func (blockTree *BlockTree) AddChildAt(tree *BlockTree, blockHash string) {
 	foundTree := blockTree.Search(blockHash)
 	if foundTree == nil {
-		fmt.Println("Tried to find a node by a hash that does not exist:", blockHash)
+		blockTree.logger.Warn("Tried to find a node by a hash that does not exist", "hash", blockHash)
 	} else {
-		fmt.Println("Adding to block with slotNumber:", foundTree.Node.Slot)
+		blockTree.logger.Debug("Adding to block", "slotNumber", foundTree.Node.Slot)
 		foundTree.AddChild(tree)
 	}
 }
@@ -66,11 +76,15 @@ func (blockTree *BlockTree) getPointerToPrevBlockAux(currentLength int) (*BlockT
 	return maxBlockPointer, maxLength
 }
 
+//PrintTree logs every node at debug level, so it walks the tree only when debug logging is on
 func (blockTree *BlockTree) PrintTree() {
+	if !blockTree.logger.Enabled(LevelDebug) {
+		return
+	}
 	if blockTree.Node.OwnBlockHash == "genesis" {
-		fmt.Println("The genesis node has", len(blockTree.children), "children")
+		blockTree.logger.Debug("Genesis node", "children", len(blockTree.children))
 	} else {
-		fmt.Println("This node with slot", blockTree.Node.Slot, "has", len(blockTree.children), "children and parent slot", blockTree.parent.Node.Slot)
+		blockTree.logger.Debug("Node", "slot", blockTree.Node.Slot, "children", len(blockTree.children), "parentSlot", blockTree.parent.Node.Slot)
 	}
 	for _, blockTreeChild := range blockTree.children {
 		blockTreeChild.PrintTree()
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -24,7 +23,7 @@ func MakeBlockTreeNode(vk string, slot int, draw string, blockData Block, signat
 
 	if slot == 0 {
 		blockTreeNode.OwnBlockHash = "genesis"
-		fmt.Println("Nice, I'm the genesis block!")
+		defaultBlockTreeLogger.Debug("Nice, I'm the genesis block!")
 	} else {
 		stringToHash := "BLOCK" + ":" + vk + ":" + strconv.Itoa(slot) + ":" + draw + ":" + strings.Join(blockData, ":") + ":" + signature
 		blockTreeNode.OwnBlockHash = ConvertBigIntToString(Hash(stringToHash))
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+//Ed25519 accounts have a 32 byte public key instead of a 600 digit modulus. An Ed25519 signature is sent as
+//"ed25519:<hex public key><hex signature>", so it can be checked against the address alone (see Address.go).
+//Ed25519 signatures are deterministic, so they can also be used for lottery draws.
+const SignatureAlgorithmEd25519 = "ed25519"
+
+//MakeEd25519Account makes a new account with an Ed25519 key, it is kept in an RSA like every other account key in the peer
+func MakeEd25519Account() *RSA {
+	seed := make([]byte, ed25519.SeedSize)
+	_, err := rand.Read(seed)
+	if err != nil {
+		panic(err)
+	}
+	return MakeEd25519AccountFromSeed(seed)
+}
+
+func MakeEd25519AccountFromSeed(seed []byte) *RSA {
+	rsa := new(RSA)
+	rsa.ed25519 = ed25519.NewKeyFromSeed(seed)
+	return rsa
+}
+
+func Ed25519PublicKeyString(publicKey ed25519.PublicKey) string {
+	return SignatureAlgorithmEd25519 + ":" + hex.EncodeToString(publicKey)
+}
+
+//PublicKeyString is n for RSA keys and "ed25519:<hex>" for Ed25519 keys, it is the VK in blocks and what addresses are made from
+func (rsa *RSA) PublicKeyString() string {
+	if rsa.ed25519 != nil {
+		return Ed25519PublicKeyString(rsa.ed25519.Public().(ed25519.PublicKey))
+	}
+	return ConvertBigIntToString(&rsa.n)
+}
+
+func (rsa *RSA) Address() Address {
+	return AddressOfPublicKey(rsa.PublicKeyString())
+}
+
+func (rsa *RSA) IsEd25519() bool {
+	return rsa.ed25519 != nil
+}
+
+func (rsa *RSA) signEd25519(message string) string {
+	signature := ed25519.Sign(rsa.ed25519, []byte(message))
+	publicKey := rsa.ed25519.Public().(ed25519.PublicKey)
+	return EncodeSignature(SignatureAlgorithmEd25519, append(append([]byte{}, publicKey...), signature...))
+}
+
+//verifyEd25519 accepts both the public key string and the address as the key
+func verifyEd25519(message string, signature []byte, key string) bool {
+	if len(signature) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return false
+	}
+	publicKey := ed25519.PublicKey(signature[:ed25519.PublicKeySize])
+	publicKeyString := Ed25519PublicKeyString(publicKey)
+	if publicKeyString != key && AddressOfPublicKey(publicKeyString) != Address(key) {
+		return false
+	}
+	return ed25519.Verify(publicKey, []byte(message), signature[ed25519.PublicKeySize:])
+}
+
+func ed25519Seed(rsa *RSA) string {
+	return hex.EncodeToString(rsa.ed25519.Seed())
+}
+
+func parseEd25519Seed(seed string) (*RSA, error) {
+	decoded, err := hex.DecodeString(seed)
+	if err != nil || len(decoded) != ed25519.SeedSize {
+		return nil, errors.New("Key file is damaged")
+	}
+	return MakeEd25519AccountFromSeed(decoded), nil
+}
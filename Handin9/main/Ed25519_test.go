@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEd25519AccountsSignTransactionsWithShortAddresses(t *testing.T) {
+	account := MakeEd25519Account()
+	address := account.Address()
+	if _, err := ParseAddress(string(address)); err != nil || len(address) > 40 {
+		t.Error("Expected a short valid address, got", address)
+	}
+	transaction := MakeUnsignedTransaction(address, "someone", 10)
+	account.SignTransaction(transaction)
+	verifier := new(RSA)
+	if !verifier.VerifyTransaction(*transaction) {
+		t.Error("The Ed25519 transaction did not verify")
+	}
+
+	//someone else's key can not sign for the address, even with a valid signature of their own
+	other := MakeEd25519Account()
+	forged := MakeUnsignedTransaction(address, "someone", 10)
+	forged.ID = transaction.ID
+	other.SignTransaction(forged)
+	if verifier.VerifyTransaction(*forged) {
+		t.Error("A signature by another key should not verify for the address")
+	}
+	transaction.Amount = 11
+	if verifier.VerifyTransaction(*transaction) {
+		t.Error("A changed transaction should not verify")
+	}
+	fmt.Println("TestEd25519AccountsSignTransactionsWithShortAddresses passed")
+}
+
+func TestEd25519AccountsWinTheLotteryAndSignBlocks(t *testing.T) {
+	account := MakeEd25519Account()
+	peer := peerFixtureRSA(*account)
+	peer.rsa = account
+	peer.genesisLedger.AddGenesisAccount(account.Address())
+	peer.hardness = *big.NewInt(1)
+
+	won, draw := peer.EnterLottery(1, peer.seed)
+	if !won {
+		t.Fatal("An account with stake should win when the hardness is 1")
+	}
+	block := Block{"someData", "BLOCK", account.PublicKeyString(), strconv.Itoa(1), draw, "prevBlockHash"}
+	block = append(block, account.CreateBlockSignature(1, Block{"someData"}, "prevBlockHash"))
+	block = append(block, ConvertBigIntToString(Hash(strings.Join(block, ":"))), "yeet")
+	if !peer.VerifyWinningBlock(*peer.rsa, block, peer.seed) {
+		t.Error("The block of the Ed25519 account did not verify")
+	}
+	fmt.Println("TestEd25519AccountsWinTheLotteryAndSignBlocks passed")
+}
+
+func TestWalletStoresEd25519Accounts(t *testing.T) {
+	wallet := MakeWallet(t.TempDir())
+	publicKey, err := wallet.CreateEd25519Account("ed", "pw")
+	if err != nil {
+		t.Fatal("Could not create an Ed25519 account:", err)
+	}
+	if walletAddress, _ := wallet.Address("ed"); AddressOfPublicKey(publicKey) != walletAddress {
+		t.Error("The wallet should show the address without the password")
+	}
+	if _, err := wallet.Unlock("ed", "wrong"); err != ErrInvalidPassword {
+		t.Error("Unlocking with the wrong password should fail, got", err)
+	}
+	account, err := wallet.Unlock("ed", "pw")
+	if err != nil || account.PublicKeyString() != publicKey {
+		t.Fatal("Could not unlock the Ed25519 account:", err)
+	}
+	transaction, _ := wallet.SignTransaction("ed", "someone", 5)
+	if transaction.From != account.Address() || !account.VerifyTransaction(*transaction) {
+		t.Error("The wallet should sign from the address of the account")
+	}
+	fmt.Println("TestWalletStoresEd25519Accounts passed")
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+const maxFrameLength = 16 * 1024 * 1024
+
+//EncryptedTransportStrategy wraps another transport and encrypts every connection with a fresh AES session key.
+//Both peers agree on the session key with ephemeral X25519 keys and sign the exchange with their account keys, so each
+//learns which account it is talking to and someone in the middle can not swap the keys without an account's private key.
+//From then on every write is sent as one AES-GCM frame. The account key is only ever used to sign.
+type EncryptedTransportStrategy struct {
+	inner TransportStrategy
+	rsa   *RSA
+	aes   *AES
+	lock  *sync.Mutex
+}
+
+func MakeEncryptedTransportStrategy(inner TransportStrategy) *EncryptedTransportStrategy {
+	transportStrategy := new(EncryptedTransportStrategy)
+	transportStrategy.inner = inner
+	transportStrategy.aes = MakeAES()
+	transportStrategy.lock = &sync.Mutex{}
+	return transportStrategy
+}
+
+func (transportStrategy *EncryptedTransportStrategy) UseAccount(rsa *RSA) {
+	transportStrategy.lock.Lock()
+	transportStrategy.rsa = rsa
+	transportStrategy.lock.Unlock()
+}
+
+func (transportStrategy *EncryptedTransportStrategy) getRSA() *RSA {
+	transportStrategy.lock.Lock()
+	defer transportStrategy.lock.Unlock()
+	return transportStrategy.rsa
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Dial(uri string) (net.Conn, error) {
+	return transportStrategy.inner.Dial(uri)
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Listen(address string) (net.Listener, string, error) {
+	return transportStrategy.inner.Listen(address)
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Send(conn net.Conn, message []byte) error {
+	return transportStrategy.inner.Send(conn, message)
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Receive(conn net.Conn) *bufio.Reader {
+	return transportStrategy.inner.Receive(conn)
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Forget(conn net.Conn) {
+	transportStrategy.inner.Forget(conn)
+}
+
+func (transportStrategy *EncryptedTransportStrategy) Handshake(conn net.Conn, dialer bool) (net.Conn, string, error) {
+	conn, identity, err := transportStrategy.innerHandshake(conn, dialer)
+	if err != nil {
+		return nil, "", err
+	}
+	sessionKey, otherIdentity, err := transportStrategy.exchangeSessionKey(conn, dialer)
+	if err != nil {
+		return nil, "", err
+	}
+	if identity != "" && identity != otherIdentity {
+		return nil, "", errors.New("the key exchange and the inner handshake name different account keys")
+	}
+	return makeSecureConn(conn, sessionKey, dialer, transportStrategy.aes), otherIdentity, nil
+}
+
+//lets an inner TLS transport authenticate the connection first
+func (transportStrategy *EncryptedTransportStrategy) innerHandshake(conn net.Conn, dialer bool) (net.Conn, string, error) {
+	handshakingTransport, canHandshake := transportStrategy.inner.(HandshakingTransportStrategy)
+	if !canHandshake {
+		return conn, "", nil
+	}
+	return handshakingTransport.Handshake(conn, dialer)
+}
+
+//keyExchangeTranscript is what each side signs, it binds both ephemeral keys and the role of the signer to the account key
+func keyExchangeTranscript(role string, listenerKey []byte, dialerKey []byte) string {
+	return "ENCRYPTEDTRANSPORT:" + role + ":" + hex.EncodeToString(listenerKey) + ":" + hex.EncodeToString(dialerKey)
+}
+
+//exchangeSessionKey runs an ephemeral X25519 exchange. The listener sends its ephemeral key, the dialer answers with its own
+//ephemeral key, account key and signature, and the listener ends with its account key and signature. It returns the
+//session key and the verified account key of the other end
+func (transportStrategy *EncryptedTransportStrategy) exchangeSessionKey(conn net.Conn, dialer bool) ([]byte, string, error) {
+	rsa := transportStrategy.getRSA()
+	if rsa == nil {
+		return nil, "", errors.New("no account key has been given to the encrypted transport")
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	ownKey := ephemeral.PublicKey().Bytes()
+
+	var listenerKey, dialerKey []byte
+	var otherKey []byte
+	var otherIdentity, otherSignature string
+	if dialer {
+		otherKey, err = readFrame(conn)
+		if err != nil {
+			return nil, "", err
+		}
+		listenerKey, dialerKey = otherKey, ownKey
+		err = writeFrames(conn, ownKey, []byte(rsa.PublicKeyString()), []byte(rsa.SignMessage(keyExchangeTranscript("dialer", listenerKey, dialerKey))))
+		if err != nil {
+			return nil, "", err
+		}
+		otherIdentity, otherSignature, err = readIdentity(conn)
+	} else {
+		err = writeFrame(conn, ownKey)
+		if err != nil {
+			return nil, "", err
+		}
+		otherKey, err = readFrame(conn)
+		if err != nil {
+			return nil, "", err
+		}
+		listenerKey, dialerKey = ownKey, otherKey
+		otherIdentity, otherSignature, err = readIdentity(conn)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	otherRole := "listener"
+	if !dialer {
+		otherRole = "dialer"
+	}
+	verifier := new(RSA)
+	if !verifier.VerifySignature(keyExchangeTranscript(otherRole, listenerKey, dialerKey), otherSignature, otherIdentity) {
+		return nil, "", errors.New("the key exchange is not signed by the account key of the other peer")
+	}
+	if !dialer {
+		err = writeFrames(conn, []byte(rsa.PublicKeyString()), []byte(rsa.SignMessage(keyExchangeTranscript("listener", listenerKey, dialerKey))))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	otherPublicKey, err := ecdh.X25519().NewPublicKey(otherKey)
+	if err != nil {
+		return nil, "", err
+	}
+	shared, err := ephemeral.ECDH(otherPublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	sessionKey := sha256.Sum256(append(append(append([]byte{}, shared...), listenerKey...), dialerKey...))
+	return sessionKey[:], otherIdentity, nil
+}
+
+func readIdentity(conn net.Conn) (string, string, error) {
+	identity, err := readFrame(conn)
+	if err != nil {
+		return "", "", err
+	}
+	signature, err := readFrame(conn)
+	return string(identity), string(signature), err
+}
+
+func writeFrames(conn io.Writer, frames ...[]byte) error {
+	for _, frame := range frames {
+		err := writeFrame(conn, frame)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFrame(conn io.Writer, frame []byte) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(frame)))
+	_, err := conn.Write(append(header, frame...))
+	return err
+}
+
+func readFrame(conn io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > maxFrameLength {
+		return nil, errors.New("frame is too long")
+	}
+	frame := make([]byte, length)
+	_, err = io.ReadFull(conn, frame)
+	return frame, err
+}
+
+//secureConn encrypts every Write as one frame, each direction has its own key derived from the session key and counts its own nonces
+type secureConn struct {
+	net.Conn
+	aes          *AES
+	sendKey      []byte
+	receiveKey   []byte
+	sendCount    uint64
+	receiveCount uint64
+	sendLock     *sync.Mutex
+	receiveLock  *sync.Mutex
+	plaintext    []byte //decrypted bytes not yet read
+}
+
+func deriveDirectionKey(sessionKey []byte, direction string) []byte {
+	hashed := sha256.Sum256(append(append([]byte{}, sessionKey...), []byte(direction)...))
+	return hashed[:]
+}
+
+func makeSecureConn(conn net.Conn, sessionKey []byte, dialer bool, aes *AES) *secureConn {
+	secure := new(secureConn)
+	secure.Conn = conn
+	secure.aes = aes
+	dialerKey := deriveDirectionKey(sessionKey, "dialer")
+	listenerKey := deriveDirectionKey(sessionKey, "listener")
+	if dialer {
+		secure.sendKey, secure.receiveKey = dialerKey, listenerKey
+	} else {
+		secure.sendKey, secure.receiveKey = listenerKey, dialerKey
+	}
+	secure.sendLock = &sync.Mutex{}
+	secure.receiveLock = &sync.Mutex{}
+	return secure
+}
+
+func frameNonce(count uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], count)
+	return nonce
+}
+
+func (secure *secureConn) sealFrame(plaintext []byte) ([]byte, error) {
+	ciphertext, err := secure.aes.SealWithNonce(plaintext, secure.sendKey, frameNonce(secure.sendCount), nil)
+	secure.sendCount++
+	return ciphertext, err
+}
+
+func (secure *secureConn) openFrame(ciphertext []byte) ([]byte, error) {
+	plaintext, err := secure.aes.OpenWithNonce(ciphertext, secure.receiveKey, frameNonce(secure.receiveCount), nil)
+	if err != nil {
+		return nil, errors.New("received a frame that was tampered with or out of order")
+	}
+	secure.receiveCount++
+	return plaintext, nil
+}
+
+func (secure *secureConn) Write(p []byte) (int, error) {
+	secure.sendLock.Lock()
+	defer secure.sendLock.Unlock()
+	ciphertext, err := secure.sealFrame(p)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFrame(secure.Conn, ciphertext)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (secure *secureConn) Read(p []byte) (int, error) {
+	secure.receiveLock.Lock()
+	defer secure.receiveLock.Unlock()
+	for len(secure.plaintext) == 0 {
+		ciphertext, err := readFrame(secure.Conn)
+		if err != nil {
+			return 0, err
+		}
+		secure.plaintext, err = secure.openFrame(ciphertext)
+		if err != nil {
+			//once a frame is rejected the stream can not be trusted any more
+			secure.Conn.Close()
+			return 0, err
+		}
+	}
+	n := copy(p, secure.plaintext)
+	secure.plaintext = secure.plaintext[n:]
+	return n, nil
+}
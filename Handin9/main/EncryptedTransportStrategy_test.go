@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncryptedPeersExchangeConnectionsURI(t *testing.T) {
+	pipeTransportStrategy := MakePipeTransportStrategy()
+	peer1, listener1 := createPeerWithTransport("fa", "fa", MakeEncryptedTransportStrategy(pipeTransportStrategy))
+	defer listener1.Close()
+
+	peer2, listener2 := createPeerWithTransport(peer1.ip, peer1.port, MakeEncryptedTransportStrategy(pipeTransportStrategy))
+	defer listener2.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	length := peer2.peerTable.Len()
+	if length != 2 {
+		t.Error("Peer2 should know 2 URIs after joining over an encrypted channel, got", length)
+	} else {
+		fmt.Println("TestEncryptedPeersExchangeConnectionsURI passed")
+	}
+}
+
+func TestEncryptedChannelRejectsTamperedCiphertext(t *testing.T) {
+	dialerConn, listenerConn := makeEncryptedPair(t)
+
+	//seal a frame the way the dialer would, flip one bit and send it on the raw connection
+	ciphertext, _ := dialerConn.sealFrame([]byte("hello]"))
+	ciphertext[3] ^= 1
+	writeFrame(dialerConn.Conn, ciphertext)
+
+	buffer := make([]byte, 32)
+	_, err := listenerConn.Read(buffer)
+	if err == nil {
+		t.Error("Read a frame that was tampered with")
+	} else {
+		fmt.Println("TestEncryptedChannelRejectsTamperedCiphertext passed")
+	}
+}
+
+func TestEncryptedChannelHidesAndDeliversMessages(t *testing.T) {
+	dialerConn, listenerConn := makeEncryptedPair(t)
+
+	dialerConn.Write([]byte("first]"))
+	listenerConn.Write([]byte("second]"))
+	buffer := make([]byte, 32)
+	n1, err1 := listenerConn.Read(buffer)
+	first := string(buffer[:n1])
+	n2, err2 := dialerConn.Read(buffer)
+	second := string(buffer[:n2])
+	if err1 != nil || err2 != nil || first != "first]" || second != "second]" {
+		t.Error("Got", first, second, err1, err2)
+	} else {
+		fmt.Println("TestEncryptedChannelHidesAndDeliversMessages passed")
+	}
+}
+
+func makeEncryptedPair(t *testing.T) (*secureConn, *secureConn) {
+	pipeTransportStrategy := MakePipeTransportStrategy()
+	listenerTransport := MakeEncryptedTransportStrategy(pipeTransportStrategy)
+	listenerTransport.UseAccount(MakeRSA(2000))
+	dialerTransport := MakeEncryptedTransportStrategy(pipeTransportStrategy)
+	dialerTransport.UseAccount(MakeRSA(2000))
+
+	listener, port, _ := listenerTransport.Listen("localhost")
+	defer listener.Close()
+	rawDialer, _ := dialerTransport.Dial("localhost:" + port)
+	rawListener, _ := listener.Accept()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _, err := listenerTransport.Handshake(rawListener, false)
+		if err != nil {
+			t.Error("Listener handshake failed:", err)
+		}
+		accepted <- conn
+	}()
+	dialed, _, err := dialerTransport.Handshake(rawDialer, true)
+	if err != nil {
+		t.Fatal("Dialer handshake failed:", err)
+	}
+	return dialed.(*secureConn), (<-accepted).(*secureConn)
+}
+
+func TestEncryptedHandshakeAuthenticatesEd25519Accounts(t *testing.T) {
+	pipeTransportStrategy := MakePipeTransportStrategy()
+	listenerAccount, dialerAccount := MakeEd25519Account(), MakeEd25519Account()
+	listenerTransport := MakeEncryptedTransportStrategy(pipeTransportStrategy)
+	listenerTransport.UseAccount(listenerAccount)
+	dialerTransport := MakeEncryptedTransportStrategy(pipeTransportStrategy)
+	dialerTransport.UseAccount(dialerAccount)
+
+	listener, port, _ := listenerTransport.Listen("localhost")
+	defer listener.Close()
+	rawDialer, _ := dialerTransport.Dial("localhost:" + port)
+	rawListener, _ := listener.Accept()
+
+	accepted := make(chan string)
+	go func() {
+		_, identity, _ := listenerTransport.Handshake(rawListener, false)
+		accepted <- identity
+	}()
+	_, identity, err := dialerTransport.Handshake(rawDialer, true)
+	if err != nil || identity != listenerAccount.PublicKeyString() {
+		t.Error("The dialer should learn the account key of the listener, got", identity, err)
+	}
+	if identity := <-accepted; identity != dialerAccount.PublicKeyString() {
+		t.Error("The listener should learn the account key of the dialer, got", identity)
+	} else {
+		fmt.Println("TestEncryptedHandshakeAuthenticatesEd25519Accounts passed")
+	}
+}
+
+func TestEncryptedHandshakeRejectsAReplacedKey(t *testing.T) {
+	pipeTransportStrategy := MakePipeTransportStrategy()
+	dialerTransport := MakeEncryptedTransportStrategy(pipeTransportStrategy)
+	dialerTransport.UseAccount(MakeRSA(2000))
+	listener, port, _ := pipeTransportStrategy.Listen("localhost")
+	defer listener.Close()
+	rawDialer, _ := dialerTransport.Dial("localhost:" + port)
+	rawListener, _ := listener.Accept()
+
+	//someone in the middle sends its own ephemeral key, but only has a signature of the honest listener's key
+	go func() {
+		honest, _ := ecdh.X25519().GenerateKey(rand.Reader)
+		replaced, _ := ecdh.X25519().GenerateKey(rand.Reader)
+		writeFrame(rawListener, replaced.PublicKey().Bytes())
+		dialerKey, _ := readFrame(rawListener)
+		readIdentity(rawListener)
+		account := MakeRSA(2000)
+		signature := account.SignMessage(keyExchangeTranscript("listener", honest.PublicKey().Bytes(), dialerKey))
+		writeFrames(rawListener, []byte(account.PublicKeyString()), []byte(signature))
+	}()
+	_, _, err := dialerTransport.Handshake(rawDialer, true)
+	if err == nil {
+		t.Error("The dialer should not accept a key exchange the listener's account did not sign")
+	} else {
+		fmt.Println("TestEncryptedHandshakeRejectsAReplacedKey passed")
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//With inventory gossip a peer does not send new transactions and blocks to its neighbours, it announces their IDs in
+//an inv message. A neighbour that does not have one yet asks for it with a getdata message, and only then gets the
+//whole thing. Every peer remembers what each connection has announced or sent, so it announces nothing back to the
+//peer it came from. When an item we asked for does not come within inventoryRequestTimeout, we ask the next neighbour
+//that announced it. Flood gossip sends everything to everyone, like before, and is kept to compare the two.
+
+const inventoryDelimiter = "inv"
+const getDataDelimiter = "getdata"
+const transactionItem = "tx:"
+const blockItem = "block:"
+const maxInventoryItems = 1000                  //more items in one inv or getdata is a malformed message
+const inventoryRequestTimeout = 2 * time.Second //after this we ask the next neighbour that announced the item
+const maxAnnouncers = 8                         //neighbours we remember per requested item, to ask in turn
+const maxBlockData = 1000                       //blocks we keep to answer getdata, the oldest is forgotten first
+
+//inventoryRequest is an item we asked a neighbour for, with the other neighbours that announced it
+type inventoryRequest struct {
+	asked      net.Conn
+	announcers []net.Conn
+	timer      *time.Timer
+}
+
+type GossipMode int
+
+const (
+	InventoryGossip GossipMode = iota
+	FloodGossip
+)
+
+func (mode GossipMode) String() string {
+	if mode == FloodGossip {
+		return "flood"
+	}
+	return "inv"
+}
+
+func ParseGossipMode(name string) (GossipMode, error) {
+	switch name {
+	case "inv":
+		return InventoryGossip, nil
+	case "flood":
+		return FloodGossip, nil
+	}
+	return InventoryGossip, errors.New("unknown gossip mode " + name + ", use inv or flood")
+}
+
+//GossipStats counts the gossip a peer sends, by kind of message: inv, getdata, transaction and block
+type GossipStats struct {
+	messages map[string]int
+	bytes    map[string]int
+	mutex    *sync.Mutex
+}
+
+func MakeGossipStats() *GossipStats {
+	stats := new(GossipStats)
+	stats.messages = make(map[string]int)
+	stats.bytes = make(map[string]int)
+	stats.mutex = &sync.Mutex{}
+	return stats
+}
+
+func (stats *GossipStats) Record(kind string, bytes int) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.messages[kind]++
+	stats.bytes[kind] += bytes
+}
+
+//Add counts the gossip of another peer too, to get the total for a network
+func (stats *GossipStats) Add(other *GossipStats) {
+	other.mutex.Lock()
+	messages := make(map[string]int)
+	bytes := make(map[string]int)
+	for kind, count := range other.messages {
+		messages[kind] = count
+		bytes[kind] = other.bytes[kind]
+	}
+	other.mutex.Unlock()
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	for kind, count := range messages {
+		stats.messages[kind] += count
+		stats.bytes[kind] += bytes[kind]
+	}
+}
+
+func (stats *GossipStats) Messages(kind string) int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	return stats.messages[kind]
+}
+
+func (stats *GossipStats) Bytes(kind string) int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	return stats.bytes[kind]
+}
+
+func (stats *GossipStats) TotalMessages() int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	total := 0
+	for _, count := range stats.messages {
+		total += count
+	}
+	return total
+}
+
+func (stats *GossipStats) TotalBytes() int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	total := 0
+	for _, count := range stats.bytes {
+		total += count
+	}
+	return total
+}
+
+func (stats *GossipStats) String() string {
+	stats.mutex.Lock()
+	kinds := make([]string, 0, len(stats.messages))
+	for kind := range stats.messages {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprint(kind, " ", stats.messages[kind], " messages/", stats.bytes[kind], " bytes")
+	}
+	stats.mutex.Unlock()
+	if len(parts) == 0 {
+		return "no gossip sent"
+	}
+	return strings.Join(parts, ", ")
+}
+
+//SetGossipMode must be called before Start
+func (peer *Peer) SetGossipMode(mode GossipMode) {
+	peer.inventoryMutex.Lock()
+	defer peer.inventoryMutex.Unlock()
+	peer.gossipMode = mode
+}
+
+//Inventories and requests are sent like blocks, as a list of items ending in a delimiter
+func MakeInventory(items []string) Block {
+	return append(append(Block{}, items...), inventoryDelimiter)
+}
+
+func IsInventory(block Block) bool {
+	return len(block) > 0 && block[len(block)-1] == inventoryDelimiter
+}
+
+func MakeGetData(items []string) Block {
+	return append(append(Block{}, items...), getDataDelimiter)
+}
+
+func IsGetData(block Block) bool {
+	return len(block) > 0 && block[len(block)-1] == getDataDelimiter
+}
+
+//knows marks that the connection has the item, so we do not announce it there
+func (peer *Peer) knows(conn net.Conn, item string) {
+	peer.inventoryMutex.Lock()
+	defer peer.inventoryMutex.Unlock()
+	known := peer.inventoryKnown[conn]
+	if known == nil || len(known) >= maxSeenPerConnection {
+		known = make(map[string]bool)
+		peer.inventoryKnown[conn] = known
+	}
+	known[item] = true
+}
+
+//forgetInventory is called by DeleteFromConnections, the connection is no longer asked for the items it announced
+func (peer *Peer) forgetInventory(conn net.Conn) {
+	peer.inventoryMutex.Lock()
+	defer peer.inventoryMutex.Unlock()
+	delete(peer.inventoryKnown, conn)
+	for _, request := range peer.inventoryRequested {
+		for i, announcer := range request.announcers {
+			if announcer == conn {
+				request.announcers = append(request.announcers[:i], request.announcers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//RelayTransaction sends a new transaction on, or announces it, to the connections that do not have it
+func (peer *Peer) RelayTransaction(transaction SignedTransaction) {
+	peer.relay(transactionItem+transaction.ID, "transaction", peer.MarshalTransaction(transaction))
+}
+
+//RelayBlock sends a new block on, or announces it. We keep the block to answer getdata, also when it is our own
+func (peer *Peer) RelayBlock(marshalled []byte) {
+	block, err := peer.DemarshalBlock(marshalled)
+	if err != nil || len(block) < 2 {
+		return
+	}
+	item := blockItem + block[len(block)-2]
+	peer.inventoryMutex.Lock()
+	if _, found := peer.blockData[item]; !found {
+		peer.blockDataOrder = append(peer.blockDataOrder, item)
+		if len(peer.blockDataOrder) > maxBlockData {
+			delete(peer.blockData, peer.blockDataOrder[0])
+			peer.blockDataOrder = peer.blockDataOrder[1:]
+		}
+	}
+	peer.blockData[item] = marshalled
+	peer.inventoryMutex.Unlock()
+	peer.relay(item, "block", marshalled)
+}
+
+func (peer *Peer) relay(item string, kind string, data []byte) {
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+
+	peer.inventoryMutex.Lock()
+	mode := peer.gossipMode
+	targets := make(Connections, 0, len(connections))
+	for _, conn := range connections {
+		if mode == FloodGossip || !peer.inventoryKnown[conn][item] {
+			targets = append(targets, conn)
+		}
+	}
+	peer.inventoryMutex.Unlock()
+
+	if mode == FloodGossip {
+		for _, conn := range targets {
+			peer.gossipStats.Record(kind, len(data))
+			peer.SendBlock(conn, data)
+		}
+		return
+	}
+	inventory, _ := json.Marshal(MakeInventory([]string{item}))
+	for _, conn := range targets {
+		peer.knows(conn, item)
+		peer.gossipStats.Record(inventoryDelimiter, len(inventory))
+		peer.SendBlock(conn, inventory)
+	}
+}
+
+func (peer *Peer) hasItem(item string) bool {
+	if strings.HasPrefix(item, transactionItem) {
+		peer.messagesSentMutex.Lock()
+		defer peer.messagesSentMutex.Unlock()
+		return peer.messagesSent[strings.TrimPrefix(item, transactionItem)].sent
+	}
+	peer.blocksSentMutex.Lock()
+	defer peer.blocksSentMutex.Unlock()
+	return peer.blocksSent[strings.TrimPrefix(item, blockItem)]
+}
+
+//HandleInventory asks the connection for the announced items we do not have. If we already asked another neighbour
+//for an item, the connection is remembered to be asked when that request times out
+func (peer *Peer) HandleInventory(conn net.Conn, inventory Block) {
+	items := inventory[:len(inventory)-1]
+	if len(items) > maxInventoryItems {
+		peer.Penalize(conn, MalformedMessage)
+		return
+	}
+	wanted := make([]string, 0, len(items))
+	for _, item := range items {
+		if !strings.HasPrefix(item, transactionItem) && !strings.HasPrefix(item, blockItem) {
+			continue
+		}
+		peer.knows(conn, item)
+		if peer.hasItem(item) {
+			continue
+		}
+		peer.inventoryMutex.Lock()
+		request, found := peer.inventoryRequested[item]
+		if found {
+			request.addAnnouncer(conn)
+		} else if len(peer.inventoryRequested) < maxSeenPerConnection {
+			peer.requestItem(item, conn)
+			wanted = append(wanted, item)
+		}
+		peer.inventoryMutex.Unlock()
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	peer.sendGetData(conn, wanted)
+}
+
+func (request *inventoryRequest) addAnnouncer(conn net.Conn) {
+	if conn == request.asked || len(request.announcers) >= maxAnnouncers {
+		return
+	}
+	for _, announcer := range request.announcers {
+		if announcer == conn {
+			return
+		}
+	}
+	request.announcers = append(request.announcers, conn)
+}
+
+//requestItem notes that we ask the connection for the item and starts the timer to ask the next one. inventoryMutex
+//must be held
+func (peer *Peer) requestItem(item string, conn net.Conn) {
+	request := &inventoryRequest{asked: conn}
+	request.timer = time.AfterFunc(inventoryRequestTimeout, func() { peer.retryRequest(item, request) })
+	peer.inventoryRequested[item] = request
+}
+
+//retryRequest asks the next neighbour that announced the item, if it has not come yet. When nobody is left the request
+//is forgotten, so the next announcement fetches the item again
+func (peer *Peer) retryRequest(item string, request *inventoryRequest) {
+	arrived := peer.hasItem(item)
+	peer.inventoryMutex.Lock()
+	if peer.inventoryRequested[item] != request {
+		peer.inventoryMutex.Unlock()
+		return
+	}
+	if arrived || !peer.Running() || len(request.announcers) == 0 {
+		delete(peer.inventoryRequested, item)
+		peer.inventoryMutex.Unlock()
+		return
+	}
+	next := request.announcers[0]
+	request.announcers = request.announcers[1:]
+	request.asked = next
+	request.timer = time.AfterFunc(inventoryRequestTimeout, func() { peer.retryRequest(item, request) })
+	peer.inventoryMutex.Unlock()
+	peer.logger.Debug("Asking another neighbour for an item that did not come", "item", item)
+	peer.sendGetData(next, []string{item})
+}
+
+func (peer *Peer) sendGetData(conn net.Conn, items []string) {
+	getData, _ := json.Marshal(MakeGetData(items))
+	peer.gossipStats.Record(getDataDelimiter, len(getData))
+	peer.SendBlock(conn, getData)
+}
+
+//HandleGetData sends the requested items we have, one message each, in the same form as when they are flooded
+func (peer *Peer) HandleGetData(conn net.Conn, getData Block) {
+	items := getData[:len(getData)-1]
+	if len(items) > maxInventoryItems {
+		peer.Penalize(conn, MalformedMessage)
+		return
+	}
+	for _, item := range items {
+		if strings.HasPrefix(item, transactionItem) {
+			peer.messagesSentMutex.Lock()
+			sent := peer.messagesSent[strings.TrimPrefix(item, transactionItem)]
+			peer.messagesSentMutex.Unlock()
+			if sent.sent {
+				marshalled := peer.MarshalTransaction(sent.transaction)
+				peer.knows(conn, item)
+				peer.gossipStats.Record("transaction", len(marshalled))
+				peer.SendBlock(conn, marshalled)
+			}
+		} else if strings.HasPrefix(item, blockItem) {
+			peer.inventoryMutex.Lock()
+			marshalled, found := peer.blockData[item]
+			peer.inventoryMutex.Unlock()
+			if found {
+				peer.knows(conn, item)
+				peer.gossipStats.Record("block", len(marshalled))
+				peer.SendBlock(conn, marshalled)
+			}
+		}
+	}
+}
+
+//forgetRequest lets the next announcement of the item fetch it again
+func (peer *Peer) forgetRequest(item string) {
+	peer.inventoryMutex.Lock()
+	defer peer.inventoryMutex.Unlock()
+	if request, found := peer.inventoryRequested[item]; found {
+		request.timer.Stop()
+		delete(peer.inventoryRequested, item)
+	}
+}
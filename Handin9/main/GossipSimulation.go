@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+)
+
+//gossip-sim builds a network of peers in memory (with the pipe transport), lets random peers make transactions and
+//measures the gossip it takes until every peer has all of them, once with flood gossip and once with inventory gossip.
+//No genesis block is sent, so only transactions are gossiped.
+
+const gossipSimulationCommand = "gossip-sim"
+
+type GossipSimulationConfig struct {
+	Peers        int
+	Outbound     int //connections each peer dials, see PeerTableConfig.TargetOutbound
+	Transactions int
+	Timeout      time.Duration //how long to wait for every peer to have every transaction
+}
+
+func DefaultGossipSimulationConfig() GossipSimulationConfig {
+	return GossipSimulationConfig{Peers: 10, Outbound: 3, Transactions: 50, Timeout: time.Minute}
+}
+
+func (config GossipSimulationConfig) Validate() error {
+	if config.Peers < 2 || config.Outbound <= 0 || config.Transactions <= 0 || config.Timeout <= 0 {
+		return errors.New("the simulation needs at least 2 peers, and positive outbound connections, transactions and timeout")
+	}
+	return nil
+}
+
+type GossipSimulationResult struct {
+	Mode      GossipMode
+	Stats     *GossipStats //the gossip sent by all peers together
+	Delivered int          //transactions that reached a peer, at most peers * transactions
+	Duration  time.Duration
+}
+
+//simulatedUserInputStrategy gives a simulated peer its account, the simulation makes the transactions itself
+type simulatedUserInputStrategy struct {
+	account *RSA
+}
+
+func (inputStrategy *simulatedUserInputStrategy) GetAccount() *RSA {
+	return inputStrategy.account
+}
+
+func (inputStrategy *simulatedUserInputStrategy) HandleIncomingFromUser() (SignedTransaction, error) {
+	return SignedTransaction{}, ErrUserQuit
+}
+
+func IsGossipSimulationCommand(args []string) bool {
+	return len(args) > 0 && args[0] == gossipSimulationCommand
+}
+
+func RunGossipSimulation(args []string, out io.Writer) error {
+	config := DefaultGossipSimulationConfig()
+	flags := flag.NewFlagSet(gossipSimulationCommand, flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.IntVar(&config.Peers, "peers", config.Peers, "peers in the network")
+	flags.IntVar(&config.Outbound, "outbound", config.Outbound, "connections each peer dials")
+	flags.IntVar(&config.Transactions, "transactions", config.Transactions, "transactions made by random peers")
+	flags.DurationVar(&config.Timeout, "timeout", config.Timeout, "how long to wait for the transactions to reach every peer")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	err = config.Validate()
+	if err != nil {
+		return err
+	}
+	results := make([]GossipSimulationResult, 0, 2)
+	for _, mode := range []GossipMode{FloodGossip, InventoryGossip} {
+		result, err := SimulateGossip(config, mode)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	WriteGossipSimulationReport(out, config, results)
+	return nil
+}
+
+func SimulateGossip(config GossipSimulationConfig, mode GossipMode) (GossipSimulationResult, error) {
+	transportStrategy := MakePipeTransportStrategy()
+	logger := MakeLogger(io.Discard, LevelOff, false)
+	peerTableConfig := DefaultPeerTableConfig()
+	peerTableConfig.TargetOutbound = config.Outbound
+	peers := make([]*Peer, 0, config.Peers)
+	defer func() {
+		for _, peer := range peers {
+			peer.Stop()
+		}
+	}()
+
+	for i := 0; i < config.Peers; i++ {
+		//the first peer finds nobody, the others join a random peer that is already in the network
+		ip, port := "sim", "0"
+		if i > 0 {
+			joined := peers[rand.Intn(len(peers))]
+			ip, port = joined.ip, joined.port
+		}
+		inputStrategy := &simulatedUserInputStrategy{account: MakeRSA(1024)}
+		peer := MakePeer(MakeFixedUriStrategy(ip, port), inputStrategy, MakeFixedOutboundIPStrategy("sim"), new(RealMessageSendingStrategy), transportStrategy)
+		peer.SetLogger(logger)
+		peer.connectionThreshold = math.MaxInt32 //never send a genesis block
+		peer.SetPeerTableConfig(peerTableConfig)
+		peer.SetGossipMode(mode)
+		err := peer.Start(context.Background())
+		if err != nil {
+			return GossipSimulationResult{}, err
+		}
+		peers = append(peers, peer)
+	}
+	//the accepting side adds a connection a little after the dialing side
+	waitForSimulation(config.Timeout, func() bool {
+		inbound, outbound := 0, 0
+		for _, peer := range peers {
+			inbound += peer.InboundCount()
+			outbound += peer.OutboundCount()
+		}
+		return inbound == outbound
+	})
+
+	transactionIDs := make([]string, config.Transactions)
+	start := time.Now()
+	for i := range transactionIDs {
+		sender := peers[rand.Intn(len(peers))]
+		receiver := peers[rand.Intn(len(peers))]
+		transaction := MakeUnsignedTransaction(sender.rsa.Address(), receiver.rsa.Address(), 1)
+		sender.rsa.SignTransaction(transaction)
+		transactionIDs[i] = transaction.ID
+		sender.enqueue(*transaction)
+	}
+	delivered := func() int {
+		count := 0
+		for _, peer := range peers {
+			peer.messagesSentMutex.Lock()
+			for _, id := range transactionIDs {
+				if peer.messagesSent[id].sent {
+					count++
+				}
+			}
+			peer.messagesSentMutex.Unlock()
+		}
+		return count
+	}
+	waitForSimulation(config.Timeout, func() bool { return delivered() == len(peers)*len(transactionIDs) })
+	result := GossipSimulationResult{Mode: mode, Stats: MakeGossipStats(), Delivered: delivered(), Duration: time.Since(start)}
+	for _, peer := range peers {
+		result.Stats.Add(peer.gossipStats)
+	}
+	return result, nil
+}
+
+func waitForSimulation(timeout time.Duration, done func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func WriteGossipSimulationReport(out io.Writer, config GossipSimulationConfig, results []GossipSimulationResult) {
+	fmt.Fprintln(out, config.Peers, "peers dialing", config.Outbound, "each,", config.Transactions, "transactions")
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "gossip\ttransactions sent\tinv\tgetdata\tmessages\tbytes\tdelivered\ttime\t")
+	for _, result := range results {
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%d/%d\t%s\t\n", result.Mode, result.Stats.Messages("transaction"),
+			result.Stats.Messages(inventoryDelimiter), result.Stats.Messages(getDataDelimiter), result.Stats.TotalMessages(),
+			result.Stats.TotalBytes(), result.Delivered, config.Peers*config.Transactions, result.Duration.Round(time.Millisecond))
+	}
+	writer.Flush()
+}
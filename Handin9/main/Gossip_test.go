@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func smallGossipSimulation() GossipSimulationConfig {
+	return GossipSimulationConfig{Peers: 5, Outbound: 2, Transactions: 10, Timeout: 20 * time.Second}
+}
+
+func TestInventoryGossipDeliversTransactionsToAllPeers(t *testing.T) {
+	config := smallGossipSimulation()
+	result, err := SimulateGossip(config, InventoryGossip)
+	if err != nil {
+		t.Fatal("Could not run the simulation:", err)
+	}
+	if result.Delivered != config.Peers*config.Transactions {
+		t.Error("Every peer should get every transaction, delivered", result.Delivered, "of", config.Peers*config.Transactions)
+	}
+	if result.Stats.Messages(inventoryDelimiter) == 0 || result.Stats.Messages(getDataDelimiter) == 0 {
+		t.Error("Inventory gossip should announce and request the transactions, got", result.Stats)
+	}
+	//each peer but the one that made it fetches a transaction once, unless a request times out
+	if result.Stats.Messages("transaction") > (config.Peers-1)*config.Transactions*2 {
+		t.Error("Transactions should only be sent when requested, got", result.Stats)
+	}
+	fmt.Println("TestInventoryGossipDeliversTransactionsToAllPeers passed")
+}
+
+func TestInventoryGossipUsesLessBandwidthThanFlooding(t *testing.T) {
+	config := smallGossipSimulation()
+	flood, err := SimulateGossip(config, FloodGossip)
+	if err != nil {
+		t.Fatal("Could not run the simulation:", err)
+	}
+	inventory, err := SimulateGossip(config, InventoryGossip)
+	if err != nil {
+		t.Fatal("Could not run the simulation:", err)
+	}
+	if flood.Delivered != inventory.Delivered {
+		t.Error("Both kinds of gossip should deliver everything, flood delivered", flood.Delivered, "and inv", inventory.Delivered)
+	}
+	if inventory.Stats.TotalBytes() >= flood.Stats.TotalBytes() {
+		t.Error("Inventory gossip should send fewer bytes, it sent", inventory.Stats.TotalBytes(), "and flooding", flood.Stats.TotalBytes())
+	}
+
+	var report bytes.Buffer
+	WriteGossipSimulationReport(&report, config, []GossipSimulationResult{flood, inventory})
+	if !strings.Contains(report.String(), "flood") || !strings.Contains(report.String(), "inv") {
+		t.Error("The report should have a row for each kind of gossip, got", report.String())
+	}
+	fmt.Println("TestInventoryGossipUsesLessBandwidthThanFlooding passed")
+}
+
+//connectGossipNeighbour dials the peer and passes on the inv and getdata messages the peer sends it
+func connectGossipNeighbour(t *testing.T, transportStrategy TransportStrategy, peer *Peer) (net.Conn, chan Block) {
+	received := make(chan Block, 10)
+	neighbour, _ := dialFakeNeighbour(t, transportStrategy, peer.ip+":"+peer.port, func(message []byte) {
+		var block Block
+		if json.Unmarshal(message, &block) == nil && (IsGetData(block) || IsInventory(block)) {
+			received <- block
+		}
+	})
+	return neighbour, received
+}
+
+func TestPeerOnlyRequestsAnnouncedItemsItDoesNotHave(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener.Close()
+	neighbour, received := connectGossipNeighbour(t, transportStrategy, peer)
+
+	sender := MakeRSA(1024)
+	known := MakeSignedTransaction(sender.PublicKeyString(), AddressOfPublicKey(sender.PublicKeyString()), 1, ConvertBigIntToString(&sender.d))
+	peer.messagesSentMutex.Lock()
+	peer.messagesSent[known.ID] = TransactionStruct{transaction: *known, sent: true}
+	peer.messagesSentMutex.Unlock()
+	inventory, _ := json.Marshal(MakeInventory([]string{transactionItem + known.ID, transactionItem + "unknown", "nonsense"}))
+	transportStrategy.Send(neighbour, inventory)
+	select {
+	case getData := <-received:
+		if !IsGetData(getData) || len(getData) != 2 || getData[0] != transactionItem+"unknown" {
+			t.Error("The peer should only ask for the transaction it does not have, it asked for", getData)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The peer did not ask for the announced transaction")
+	}
+
+	//the neighbour announced the transaction itself, so it is not announced back
+	peer.RelayTransaction(*known)
+	select {
+	case block := <-received:
+		t.Error("A transaction should not be announced to a neighbour that has it, got", block)
+	case <-time.After(300 * time.Millisecond):
+	}
+	fmt.Println("TestPeerOnlyRequestsAnnouncedItemsItDoesNotHave passed")
+}
+
+func TestPeerAsksTheNextAnnouncerWhenAnItemDoesNotCome(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener.Close()
+	first, firstReceived := connectGossipNeighbour(t, transportStrategy, peer)
+	second, secondReceived := connectGossipNeighbour(t, transportStrategy, peer)
+
+	inventory, _ := json.Marshal(MakeInventory([]string{transactionItem + "unknown"}))
+	transportStrategy.Send(first, inventory)
+	select {
+	case <-firstReceived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The peer did not ask the first neighbour for the item")
+	}
+	transportStrategy.Send(second, inventory)
+	select {
+	case getData := <-secondReceived:
+		t.Fatal("The peer should wait for the first neighbour before asking the second, it asked for", getData)
+	case <-time.After(inventoryRequestTimeout / 2):
+	}
+	//the first neighbour never answers
+	select {
+	case getData := <-secondReceived:
+		if len(getData) != 2 || getData[0] != transactionItem+"unknown" {
+			t.Error("The peer should ask the second neighbour for the item, it asked for", getData)
+		}
+	case <-time.After(inventoryRequestTimeout + 5*time.Second):
+		t.Fatal("The peer did not ask the second neighbour after the first did not answer")
+	}
+	//nobody is left to ask, so the next announcement asks again
+	time.Sleep(inventoryRequestTimeout + 500*time.Millisecond)
+	transportStrategy.Send(first, inventory)
+	select {
+	case <-firstReceived:
+		fmt.Println("TestPeerAsksTheNextAnnouncerWhenAnItemDoesNotCome passed")
+	case <-time.After(5 * time.Second):
+		t.Error("The peer should ask again once every announcer has been asked")
+	}
+}
+
+func TestPeerRejectsABlockThatDoesNotMatchItsID(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener.Close()
+	neighbour, _ := connectGossipNeighbour(t, transportStrategy, peer)
+
+	block, _ := peer.DemarshalBlock(invalidBlocks(peer, 1)[0])
+	id := block[len(block)-2]
+	block[len(block)-4] = "other prev"
+	marshalled, _ := json.Marshal(block)
+	if BlockIDMatches(block) {
+		t.Fatal("The changed block should not match its ID")
+	}
+	transportStrategy.Send(neighbour, marshalled)
+	if !waitUntil(func() bool {
+		connections := peer.GetConnections()
+		return len(connections) == 1 && peer.MisbehaviourScore(connections[0]) < DefaultMisbehaviourConfig().MaxScore
+	}, 5*time.Second) {
+		t.Error("A block that does not match its ID should be penalized")
+	}
+	peer.blocksSentMutex.Lock()
+	seen := peer.blocksSent[id]
+	peer.blocksSentMutex.Unlock()
+	if seen {
+		t.Error("A block that does not match its ID should not count as the block with that ID")
+	} else {
+		fmt.Println("TestPeerRejectsABlockThatDoesNotMatchItsID passed")
+	}
+}
+
+func TestPeerOnlyKeepsTheLastBlocksToAnswerGetData(t *testing.T) {
+	peer := peerFixture()
+	first := ""
+	for i := 0; i <= maxBlockData; i++ {
+		marshalled := peer.MarshalBlock(Block{"BLOCK", strconv.Itoa(i)})
+		peer.RelayBlock(marshalled)
+		if first == "" {
+			block, _ := peer.DemarshalBlock(marshalled)
+			first = blockItem + block[len(block)-2]
+		}
+	}
+	if _, found := peer.blockData[first]; found || len(peer.blockData) != maxBlockData || len(peer.blockDataOrder) != maxBlockData {
+		t.Error("Only the last", maxBlockData, "blocks should be kept, got", len(peer.blockData))
+	} else {
+		fmt.Println("TestPeerOnlyKeepsTheLastBlocksToAnswerGetData passed")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//A seed phrase is 16 words for 16 random bytes, a version word and a checksum word, every word stands for one byte.
+//All account keys are derived from the phrase, so writing the phrase down is enough to restore every account.
+//
+//	seed          = PBKDF2-SHA256(phrase, "seed phrase" + passphrase, 2048 iterations, 64 bytes)
+//	account seed  = HMAC-SHA256(seed, "account:" + index)
+//	account key   = MakeRSAFromReader(2000, DRBG(account seed))
+//
+//The version names the key derivation, so a phrase always gives back the keys it was made with. Version 1 phrases
+//have no version word (17 words) and derive textbook RSA keys with e=3 using MakeLegacyRSAFromReader, version 2
+//phrases derive RSA-PSS keys with e=65537.
+const (
+	seedPhraseEntropy    = 16
+	seedPhraseIterations = 2048
+	hdAccountKeyLength   = 2000
+	seedPhraseVersion    = 2 //the version NewSeedPhrase makes
+)
+
+var seedPhraseWords = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alert", "alien", "alley", "amber", "angle",
+	"ankle", "apple", "april", "apron", "arena", "armor", "arrow", "atlas", "attic", "audio", "autumn", "bacon",
+	"badge", "bagel", "baker", "bamboo", "banana", "banjo", "barley", "barrel", "basil", "basket", "beach",
+	"beard", "beaver", "bench", "berry", "bison", "blanket", "blossom", "board", "bonus", "border", "bottle",
+	"bounce", "bracket", "branch", "bread", "brick", "bridge", "bronze", "broom", "bubble", "bucket", "bundle",
+	"butter", "button", "cabin", "cactus", "camel", "canal", "candle", "canoe", "canvas", "canyon", "carbon",
+	"carpet", "carrot", "castle", "cattle", "cedar", "cellar", "cement", "cherry", "chess", "chicken", "circle",
+	"citrus", "clock", "cloud", "clover", "cobra", "coconut", "coffee", "comet", "copper", "coral", "cotton",
+	"cousin", "coyote", "cradle", "crater", "crayon", "cricket", "crystal", "curtain", "cushion", "daisy",
+	"dancer", "debris", "desert", "diamond", "dinner", "dolphin", "donkey", "dragon", "drawer", "dream", "drum",
+	"eagle", "earth", "echo", "eclipse", "elbow", "ember", "engine", "falcon", "feather", "fence", "fiber",
+	"fiddle", "finger", "forest", "fossil", "fox", "galaxy", "garden", "garlic", "gecko", "ginger", "giraffe",
+	"glacier", "globe", "glove", "goat", "gravel", "guitar", "hammer", "harbor", "hazel", "helmet", "heron",
+	"hockey", "honey", "horizon", "hotel", "husky", "igloo", "island", "ivory", "jacket", "jaguar", "jelly",
+	"jigsaw", "jungle", "kayak", "kernel", "kettle", "kitten", "koala", "ladder", "lagoon", "lantern", "laptop",
+	"lemon", "leopard", "lettuce", "lizard", "llama", "lobster", "magnet", "mango", "maple", "marble", "meadow",
+	"melon", "meteor", "mirror", "mitten", "monkey", "moose", "mosaic", "muffin", "napkin", "nectar", "needle",
+	"noodle", "oasis", "ocean", "olive", "onion", "orbit", "orchid", "otter", "oyster", "paddle", "panda",
+	"parrot", "peanut", "pebble", "pencil", "pepper", "piano", "pigeon", "pillow", "pirate", "planet", "pocket",
+	"potato", "puzzle", "quartz", "rabbit", "raccoon", "radio", "raisin", "rocket", "saddle", "salmon",
+	"sandal", "saturn", "scarf", "shadow", "shelf", "shovel", "silver", "spider", "sponge", "statue", "summit",
+	"sunset", "swan", "tablet", "teapot", "tiger", "timber", "tomato", "trumpet", "tulip", "turtle", "valley",
+	"velvet", "violin", "volcano", "wagon", "walnut", "walrus", "whistle", "willow", "window", "winter",
+	"wizard", "yogurt", "zebra", "zipper",
+}
+
+type HDWallet struct {
+	seed    []byte
+	version int
+}
+
+func NewSeedPhrase() (string, error) {
+	entropy := make([]byte, seedPhraseEntropy)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return "", err
+	}
+	entropy = append(entropy, seedPhraseVersion)
+	checksum := sha256.Sum256(entropy)
+	words := make([]string, 0, seedPhraseEntropy+2)
+	for _, b := range append(entropy, checksum[0]) {
+		words = append(words, seedPhraseWords[b])
+	}
+	return strings.Join(words, " "), nil
+}
+
+//ValidateSeedPhrase catches typos, a wrong word is caught by the checksum with probability 255/256
+func ValidateSeedPhrase(phrase string) error {
+	_, err := seedPhraseVersionOf(phrase)
+	return err
+}
+
+//seedPhraseVersionOf checks the phrase and returns the version of its key derivation
+func seedPhraseVersionOf(phrase string) (int, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != seedPhraseEntropy+1 && len(words) != seedPhraseEntropy+2 {
+		return 0, errors.New("a seed phrase has " + strconv.Itoa(seedPhraseEntropy+2) + " words, or " + strconv.Itoa(seedPhraseEntropy+1) + " for version 1")
+	}
+	values := make([]byte, 0, len(words))
+	for _, word := range words {
+		index := seedPhraseWordIndex(word)
+		if index < 0 {
+			return 0, errors.New("\"" + word + "\" is not a seed phrase word")
+		}
+		values = append(values, byte(index))
+	}
+	checked, checksum := values[:len(values)-1], values[len(values)-1]
+	hashed := sha256.Sum256(checked)
+	if hashed[0] != checksum {
+		return 0, errors.New("the seed phrase checksum does not match, check the words for typos")
+	}
+	if len(checked) == seedPhraseEntropy {
+		return 1, nil
+	}
+	version := int(checked[seedPhraseEntropy])
+	if version < 2 || version > seedPhraseVersion {
+		return 0, errors.New("the seed phrase has an unknown version " + strconv.Itoa(version))
+	}
+	return version, nil
+}
+
+func seedPhraseWordIndex(word string) int {
+	for index, candidate := range seedPhraseWords {
+		if candidate == word {
+			return index
+		}
+	}
+	return -1
+}
+
+//MakeHDWallet derives the seed from the phrase, the optional passphrase gives a completely different set of accounts
+func MakeHDWallet(phrase string, passphrase string) (*HDWallet, error) {
+	version, err := seedPhraseVersionOf(phrase)
+	if err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(strings.ToLower(phrase)), " ")
+	hdWallet := new(HDWallet)
+	hdWallet.version = version
+	hdWallet.seed = pbkdf2.Key([]byte(normalized), []byte("seed phrase"+passphrase), seedPhraseIterations, 64, sha256.New)
+	return hdWallet, nil
+}
+
+//DeriveAccount always gives the same key pair for the same phrase, passphrase and index
+func (hdWallet *HDWallet) DeriveAccount(index uint32) *RSA {
+	mac := hmac.New(sha256.New, hdWallet.seed)
+	mac.Write([]byte("account:" + strconv.FormatUint(uint64(index), 10)))
+	if hdWallet.version == 1 {
+		return MakeLegacyRSAFromReader(hdAccountKeyLength, makeHMACDRBG(mac.Sum(nil)))
+	}
+	return MakeRSAFromReader(hdAccountKeyLength, makeHMACDRBG(mac.Sum(nil)))
+}
+
+//hmacDRBG is an endless stream of HMAC-SHA256(seed, counter) blocks
+type hmacDRBG struct {
+	seed    []byte
+	counter uint64
+	buffer  []byte
+}
+
+func makeHMACDRBG(seed []byte) *hmacDRBG {
+	drbg := new(hmacDRBG)
+	drbg.seed = seed
+	return drbg
+}
+
+func (drbg *hmacDRBG) Read(p []byte) (int, error) {
+	for read := 0; read < len(p); {
+		if len(drbg.buffer) == 0 {
+			mac := hmac.New(sha256.New, drbg.seed)
+			counter := make([]byte, 8)
+			binary.BigEndian.PutUint64(counter, drbg.counter)
+			mac.Write(counter)
+			drbg.buffer = mac.Sum(nil)
+			drbg.counter++
+		}
+		n := copy(p[read:], drbg.buffer)
+		drbg.buffer = drbg.buffer[n:]
+		read += n
+	}
+	return len(p), nil
+}
+
+//RestoreAccounts puts the first count derived accounts into the wallet as hd-0, hd-1, ... and returns their public keys,
+//accounts that are already in the wallet are skipped
+func (wallet *Wallet) RestoreAccounts(hdWallet *HDWallet, count int, password string) ([]string, error) {
+	publicKeys := make([]string, 0, count)
+	for index := 0; index < count; index++ {
+		name := HDAccountName(uint32(index))
+		if wallet.HasAccount(name) {
+			publicKey, err := wallet.PublicKey(name)
+			if err != nil {
+				return nil, err
+			}
+			publicKeys = append(publicKeys, publicKey)
+			continue
+		}
+		publicKey, err := wallet.ImportAccount(name, password, hdWallet.DeriveAccount(uint32(index)))
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+func HDAccountName(index uint32) string {
+	return "hd-" + strconv.FormatUint(uint64(index), 10)
+}
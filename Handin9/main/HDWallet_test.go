@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSeedPhraseDerivesTheSameAccountsAgain(t *testing.T) {
+	phrase, _ := NewSeedPhrase()
+	if err := ValidateSeedPhrase(phrase); err != nil {
+		t.Fatal("A new seed phrase should be valid:", err)
+	}
+	hdWallet, _ := MakeHDWallet(phrase, "")
+	restored, _ := MakeHDWallet(strings.ToUpper(phrase), "")
+	first := hdWallet.DeriveAccount(0)
+	if first.n.Cmp(&restored.DeriveAccount(0).n) != 0 {
+		t.Error("The same phrase should give the same account")
+	}
+	if first.n.Cmp(&hdWallet.DeriveAccount(1).n) == 0 {
+		t.Error("Different account numbers should give different accounts")
+	}
+	withPassphrase, _ := MakeHDWallet(phrase, "extra")
+	if first.n.Cmp(&withPassphrase.DeriveAccount(0).n) == 0 {
+		t.Error("A passphrase should give different accounts")
+	}
+	if first.n.BitLen() != 2000 {
+		t.Error("Derived keys should have 2000 bits, got", first.n.BitLen())
+	}
+	transaction := MakeSignedTransaction(ConvertBigIntToString(&first.n), "someone", 10, ConvertBigIntToString(&first.d))
+	if !first.VerifyTransaction(*transaction) {
+		t.Error("A derived key should sign transactions that verify")
+	}
+	fmt.Println("TestSeedPhraseDerivesTheSameAccountsAgain passed")
+}
+
+func TestSeedPhraseChecksumCatchesTypos(t *testing.T) {
+	phrase, _ := NewSeedPhrase()
+	words := strings.Fields(phrase)
+	if ValidateSeedPhrase(strings.Join(words[:16], " ")) == nil {
+		t.Error("A phrase with a missing word should be rejected")
+	}
+	if ValidateSeedPhrase(strings.Replace(phrase, words[0], "notaword", 1)) == nil {
+		t.Error("A phrase with an unknown word should be rejected")
+	}
+	fmt.Println("TestSeedPhraseChecksumCatchesTypos passed")
+}
+
+func TestWalletRestoresAccountsFromSeedPhrase(t *testing.T) {
+	phrase, _ := NewSeedPhrase()
+	hdWallet, _ := MakeHDWallet(phrase, "")
+	original, err := MakeWallet(t.TempDir()).RestoreAccounts(hdWallet, 2, "pw")
+	if err != nil {
+		t.Fatal("Could not add derived accounts:", err)
+	}
+
+	//the wallet directory is lost, restore into a new one from the phrase alone
+	restoredHD, _ := MakeHDWallet(phrase, "")
+	wallet := MakeWallet(t.TempDir())
+	restored, _ := wallet.RestoreAccounts(restoredHD, 2, "newpw")
+	if strings.Join(original, ",") != strings.Join(restored, ",") {
+		t.Error("Restored accounts should have the same public keys")
+	}
+	if _, err := wallet.Unlock(HDAccountName(1), "newpw"); err != nil {
+		t.Error("Could not unlock a restored account:", err)
+	}
+	fmt.Println("TestWalletRestoresAccountsFromSeedPhrase passed")
+}
+
+func TestVersion1SeedPhraseRestoresTheKeysItWasMadeWith(t *testing.T) {
+	//made before seed phrases had a version word, its accounts were derived with e=3
+	phrase := "fiber debris debris ember basil fiber debris debris ember basil fiber debris debris ember basket basket cloud"
+	hdWallet, err := MakeHDWallet(phrase, "")
+	if err != nil {
+		t.Fatal("A version 1 phrase should still be accepted:", err)
+	}
+	account := hdWallet.DeriveAccount(0)
+	if account.e.Cmp(big.NewInt(3)) != 0 || !strings.HasPrefix(ConvertBigIntToString(&account.n), "7419061051151251268889569479123450162165") {
+		t.Error("A version 1 phrase should restore the same e=3 key as before")
+	}
+
+	newPhrase, _ := NewSeedPhrase()
+	newWallet, _ := MakeHDWallet(newPhrase, "")
+	if len(strings.Fields(newPhrase)) != 18 || newWallet.DeriveAccount(0).e.Int64() != rsaPublicExponent {
+		t.Error("New phrases should have a version word and derive keys with e=65537")
+	} else {
+		fmt.Println("TestVersion1SeedPhraseRestoresTheKeysItWasMadeWith passed")
+	}
+}
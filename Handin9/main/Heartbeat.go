@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+//Every Interval a peer pings all its connections. Any message counts as a sign of life, so a connection we have not
+//heard from in Timeout is closed. When a connection we dialed is lost we dial the same address once more, and if that
+//fails the address is removed from our peer table and announced dead. Anyone can send such a notice, so the other peers
+//only take it as a hint: they dial the address themselves and only remove it and pass the notice on if that fails too.
+//Then we dial new peers from the peer table until we are back at TargetOutbound.
+
+const pingDelimiter = "ping"
+const pongDelimiter = "pong"
+const deadPeerDelimiter = "dead"
+
+type HeartbeatConfig struct {
+	Interval time.Duration //how often we ping our connections and reconnect if needed
+	Timeout  time.Duration //how long a connection may be silent before we close it
+}
+
+func DefaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{Interval: 5 * time.Second, Timeout: 15 * time.Second}
+}
+
+func (config HeartbeatConfig) Validate() error {
+	if config.Interval <= 0 || config.Timeout <= config.Interval {
+		return errors.New("the heartbeat timeout must be longer than the interval")
+	}
+	return nil
+}
+
+//SetHeartbeatConfig must be called before Start
+func (peer *Peer) SetHeartbeatConfig(config HeartbeatConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	peer.heartbeatMutex.Lock()
+	defer peer.heartbeatMutex.Unlock()
+	peer.heartbeatConfig = config
+	return nil
+}
+
+//Pings, pongs and dead peer notices are sent like blocks, as a list of strings ending in a delimiter
+func IsPing(block Block) bool {
+	return len(block) == 1 && block[0] == pingDelimiter
+}
+
+func IsPong(block Block) bool {
+	return len(block) == 1 && block[0] == pongDelimiter
+}
+
+func IsDeadPeerNotice(block Block) bool {
+	return len(block) == 2 && block[1] == deadPeerDelimiter
+}
+
+func (peer *Peer) heardFrom(conn net.Conn) {
+	peer.heartbeatMutex.Lock()
+	defer peer.heartbeatMutex.Unlock()
+	peer.lastHeard[conn] = time.Now()
+}
+
+//forgetHeartbeat is called by DeleteFromConnections, uri is the address of an outbound connection or ""
+func (peer *Peer) forgetHeartbeat(conn net.Conn, uri string) {
+	peer.heartbeatMutex.Lock()
+	defer peer.heartbeatMutex.Unlock()
+	delete(peer.lastHeard, conn)
+	if uri != "" {
+		peer.lostURIs = append(peer.lostURIs, uri)
+	}
+}
+
+//silentFor returns how long since we heard from the connection, a connection we have not seen before starts now
+func (peer *Peer) silentFor(conn net.Conn) time.Duration {
+	peer.heartbeatMutex.Lock()
+	defer peer.heartbeatMutex.Unlock()
+	heard, found := peer.lastHeard[conn]
+	if !found {
+		peer.lastHeard[conn] = time.Now()
+		return 0
+	}
+	return time.Since(heard)
+}
+
+func (peer *Peer) HandleHeartbeats() {
+	for {
+		peer.heartbeatMutex.Lock()
+		interval := peer.heartbeatConfig.Interval
+		peer.heartbeatMutex.Unlock()
+		if !peer.sleep(interval) {
+			return
+		}
+		peer.SendHeartbeats()
+		peer.Reconnect()
+	}
+}
+
+//SendHeartbeats closes the connections that have been silent too long and pings the others
+func (peer *Peer) SendHeartbeats() {
+	peer.heartbeatMutex.Lock()
+	timeout := peer.heartbeatConfig.Timeout
+	peer.heartbeatMutex.Unlock()
+
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+
+	ping, _ := json.Marshal(Block{pingDelimiter})
+	for _, conn := range connections {
+		silent := peer.silentFor(conn)
+		if silent > timeout {
+			peer.logger.Info("Closing a connection that stopped answering", "silent", silent)
+			conn.Close()
+			peer.DeleteFromConnections(conn)
+		} else {
+			peer.SendBlock(conn, ping)
+		}
+	}
+}
+
+//Reconnect dials the lost outbound peers again, announces the ones that are gone, and tops up the outbound connections
+func (peer *Peer) Reconnect() {
+	peer.heartbeatMutex.Lock()
+	lost := peer.lostURIs
+	peer.lostURIs = make([]string, 0)
+	peer.heartbeatMutex.Unlock()
+
+	for _, uri := range lost {
+		if peer.ConnectToPeer(uri) {
+			peer.logger.Info("Reconnected to peer", "uri", uri)
+		} else {
+			peer.logger.Info("Peer is gone, telling the network", "uri", uri)
+			peer.DeleteFromConnectionsURI(uri)
+			peer.BroadcastDeadPeer(uri)
+		}
+	}
+	peer.ConnectToMorePeers()
+}
+
+func (peer *Peer) BroadcastDeadPeer(uri string) {
+	notice := MarshalList(Block{uri, deadPeerDelimiter})
+	peer.SendBlockToAllPeers(notice)
+}
+
+//HandleDeadPeer checks the address unless we are connected to it ourselves or are checking it already. It is dialed in
+//the background, so the notice does not hold up the messages behind it
+func (peer *Peer) HandleDeadPeer(uri string) {
+	if uri == peer.URI() || !peer.peerTable.Contains(uri) {
+		return
+	}
+	peer.heartbeatMutex.Lock()
+	timeout := peer.heartbeatConfig.Timeout
+	peer.heartbeatMutex.Unlock()
+	peer.connectionsMutex.Lock()
+	for conn, outboundURI := range peer.outboundURIs {
+		if outboundURI == uri && peer.silentFor(conn) < timeout {
+			peer.connectionsMutex.Unlock()
+			peer.logger.Debug("Got told a peer is gone, but it still answers us", "uri", uri)
+			return
+		}
+	}
+	peer.connectionsMutex.Unlock()
+	peer.heartbeatMutex.Lock()
+	defer peer.heartbeatMutex.Unlock()
+	if peer.deadPeerProbes[uri] {
+		return
+	}
+	peer.deadPeerProbes[uri] = true
+	go peer.probeDeadPeer(uri)
+}
+
+//probeDeadPeer dials the address, and removes it and passes the notice on only if we cannot reach it either
+func (peer *Peer) probeDeadPeer(uri string) {
+	defer func() {
+		peer.heartbeatMutex.Lock()
+		delete(peer.deadPeerProbes, uri)
+		peer.heartbeatMutex.Unlock()
+	}()
+	conn, err := peer.transportStrategy.Dial(uri)
+	if err == nil {
+		conn.Close()
+		peer.peerTable.MarkReachable(uri)
+		peer.logger.Debug("Got told a peer is gone, but it still answers", "uri", uri)
+		return
+	}
+	if !peer.Running() || !peer.peerTable.Contains(uri) {
+		return
+	}
+	peer.logger.Info("Removing a peer that is gone", "uri", uri)
+	peer.DeleteFromConnectionsURI(uri)
+	peer.BroadcastDeadPeer(uri)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func fastHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{Interval: 100 * time.Millisecond, Timeout: time.Second}
+}
+
+func createPeerWithHeartbeats(ip string, port string, transportStrategy TransportStrategy, config PeerTableConfig) (*Peer, net.Listener) {
+	peer, listener := createPeerWithTransport(ip, port, transportStrategy, withPeerTableConfig(config))
+	peer.SetHeartbeatConfig(fastHeartbeatConfig())
+	go peer.HandleHeartbeats()
+	return peer, listener
+}
+
+//stopPeer makes the peer unreachable, as if its process died
+func stopPeer(peer *Peer, listener net.Listener) {
+	listener.Close()
+	for _, conn := range peer.GetConnections() {
+		conn.Close()
+	}
+}
+
+func TestPeersThatAnswerPingsStayConnected(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer1, listener1 := createPeerWithHeartbeats("fa", "fa", transportStrategy, DefaultPeerTableConfig())
+	defer listener1.Close()
+	peer2, listener2 := createPeerWithHeartbeats(peer1.ip, peer1.port, transportStrategy, DefaultPeerTableConfig())
+	defer listener2.Close()
+
+	//much longer than the timeout, only the pings and pongs keep the connection open
+	time.Sleep(2500 * time.Millisecond)
+	if peer1.InboundCount() != 1 || peer2.OutboundCount() != 1 {
+		t.Error("The peers should still be connected, got", peer1.InboundCount(), "and", peer2.OutboundCount())
+	}
+	fmt.Println("TestPeersThatAnswerPingsStayConnected passed")
+}
+
+func TestSilentConnectionIsClosedAfterTheTimeout(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithHeartbeats("fa", "fa", transportStrategy, DefaultPeerTableConfig())
+	defer listener.Close()
+
+	//a connection that reads nothing and never answers
+	silent, err := transportStrategy.Dial(peer.ip + ":" + peer.port)
+	if err != nil {
+		t.Fatal("Could not dial the peer:", err)
+	}
+	defer silent.Close()
+	time.Sleep(100 * time.Millisecond)
+	if peer.InboundCount() != 1 {
+		t.Fatal("The peer should have accepted the connection")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if peer.InboundCount() != 0 {
+		t.Error("The silent connection should have been closed")
+	}
+	fmt.Println("TestSilentConnectionIsClosedAfterTheTimeout passed")
+}
+
+func TestDeadPeerIsRemovedNetworkWideAndReplaced(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	config := DefaultPeerTableConfig()
+	config.TargetOutbound = 2
+	first, firstListener := createPeerWithHeartbeats("fa", "fa", transportStrategy, config)
+	defer firstListener.Close()
+	dying, dyingListener := createPeerWithHeartbeats(first.ip, first.port, transportStrategy, config)
+	watcher, watcherListener := createPeerWithHeartbeats(first.ip, first.port, transportStrategy, config)
+	defer watcherListener.Close()
+	spare, spareListener := createPeerWithHeartbeats(first.ip, first.port, transportStrategy, config)
+	defer spareListener.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	dyingURI := dying.ip + ":" + dying.port
+	spareURI := spare.ip + ":" + spare.port
+	if !first.peerTable.Contains(dyingURI) || !watcher.peerTable.Contains(spareURI) {
+		t.Fatal("The peers should know each other before one dies")
+	}
+	stopPeer(dying, dyingListener)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (first.peerTable.Contains(dyingURI) || watcher.peerTable.Contains(dyingURI) || spare.peerTable.Contains(dyingURI)) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if first.peerTable.Contains(dyingURI) || watcher.peerTable.Contains(dyingURI) || spare.peerTable.Contains(dyingURI) {
+		t.Error("Every peer should have removed the dead peer's address")
+	}
+	//the watcher was connected to the dead peer, it dials another peer to get back to its target
+	time.Sleep(200 * time.Millisecond)
+	if watcher.OutboundCount() != config.TargetOutbound {
+		t.Error("The watcher should have reconnected to", config.TargetOutbound, "peers, it has", watcher.OutboundCount())
+	}
+	fmt.Println("TestDeadPeerIsRemovedNetworkWideAndReplaced passed")
+}
+
+func TestDeadPeerNoticeIsCheckedBeforeTheAddressIsRemoved(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	first, firstListener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer firstListener.Close()
+	second, secondListener := createPeerWithTransport(first.ip, first.port, transportStrategy)
+	defer secondListener.Close()
+	secondURI := second.ip + ":" + second.port
+	goneURI := first.ip + ":9999" //nobody listens there
+	first.peerTable.Add(goneURI)
+	if !waitUntil(func() bool { return first.peerTable.Contains(secondURI) }, 5*time.Second) {
+		t.Fatal("The first peer should know the second peer")
+	}
+
+	//the second peer only has an inbound connection to the first, so the first can only find out by dialing it
+	liar := connectMaliciousPeer(t, transportStrategy, first.ip+":"+first.port)
+	liar.send(transportStrategy, [][]byte{MarshalList(Block{secondURI, deadPeerDelimiter}), MarshalList(Block{goneURI, deadPeerDelimiter})})
+	if !waitUntil(func() bool { return !first.peerTable.Contains(goneURI) }, 5*time.Second) {
+		t.Error("An address nobody answers on should be removed")
+	}
+	if !first.peerTable.Contains(secondURI) {
+		t.Error("An address that still answers should be kept when someone says it is gone")
+	} else {
+		fmt.Println("TestDeadPeerNoticeIsCheckedBeforeTheAddressIsRemoved passed")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//A keystore file is a JSON envelope around the secret key (d and n). The AES key is derived from the password with a
+//salted KDF, and everything in the envelope except the ciphertext is used as additional data, so the public key
+//or the KDF parameters can not be swapped without decryption failing. Nothing in the file can be used to check a
+//password guess faster than running the KDF.
+const (
+	KeystoreVersion      = 1
+	KeystoreKDF          = "pbkdf2-sha256"
+	KeystoreCipher       = "aes-256-gcm"
+	KeystoreIterations   = 600000
+	keystoreSaltLength   = 16
+	keystoreKeyLength    = 32
+	keystoreMinIteration = 10000
+	legacyBcryptLength   = 60
+)
+
+var ErrInvalidPassword = errors.New("Invalid password")
+
+type KeystoreKDFParams struct {
+	Iterations int `json:"iterations"`
+	KeyLength  int `json:"keyLength"`
+}
+
+type KeystorePublicKey struct {
+	E string `json:"e"`
+	N string `json:"n"`
+}
+
+type Keystore struct {
+	Version    int               `json:"version"`
+	KeyType    string            `json:"keyType,omitempty"` //empty for RSA keys, "ed25519" for Ed25519 keys
+	KDF        string            `json:"kdf"`
+	KDFParams  KeystoreKDFParams `json:"kdfParams"`
+	Salt       string            `json:"salt"`
+	Cipher     string            `json:"cipher"`
+	Ciphertext string            `json:"ciphertext"`
+	PublicKey  KeystorePublicKey `json:"publicKey"`
+	Created    time.Time         `json:"created"`
+}
+
+//MakeKeystore encrypts the secret key of rsa under password
+func MakeKeystore(rsa *RSA, password string) (*Keystore, error) {
+	salt := make([]byte, keystoreSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	keystore := new(Keystore)
+	keystore.Version = KeystoreVersion
+	keystore.KDF = KeystoreKDF
+	keystore.KDFParams = KeystoreKDFParams{Iterations: KeystoreIterations, KeyLength: keystoreKeyLength}
+	keystore.Salt = hex.EncodeToString(salt)
+	keystore.Cipher = KeystoreCipher
+	keystore.Created = time.Now().UTC().Truncate(time.Second)
+	var secretKeyString string
+	if rsa.IsEd25519() {
+		//an Ed25519 key is stored as its seed, N is the public key string so it can be read without the password
+		keystore.KeyType = SignatureAlgorithmEd25519
+		keystore.PublicKey = KeystorePublicKey{N: rsa.PublicKeyString()}
+		secretKeyString = ed25519Seed(rsa)
+	} else {
+		keystore.PublicKey = KeystorePublicKey{E: ConvertBigIntToString(rsa.e), N: ConvertBigIntToString(&rsa.n)}
+		secretKeyString = ConvertBigIntToString(&rsa.d) + ":" + ConvertBigIntToString(&rsa.n)
+	}
+
+	key, err := keystore.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := MakeAES().EncryptWithAdditionalData([]byte(secretKeyString), key, keystore.additionalData())
+	if err != nil {
+		return nil, err
+	}
+	keystore.Ciphertext = hex.EncodeToString(encrypted)
+	return keystore, nil
+}
+
+//Unlock returns d and n, or ErrInvalidPassword if the password is wrong or the file was changed
+func (keystore *Keystore) Unlock(password string) (*big.Int, *big.Int, error) {
+	if keystore.KeyType != "" {
+		return nil, nil, errors.New("keystore does not hold an RSA key")
+	}
+	decrypted, err := keystore.decrypt(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, n, err := parseSecretKey(decrypted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ConvertBigIntToString(n) != keystore.PublicKey.N {
+		return nil, nil, errors.New("keystore secret key does not belong to its public key")
+	}
+	return d, n, nil
+}
+
+//UnlockAccount returns the account key of any key type
+func (keystore *Keystore) UnlockAccount(password string) (*RSA, error) {
+	if keystore.KeyType == "" {
+		d, n, err := keystore.Unlock(password)
+		if err != nil {
+			return nil, err
+		}
+		return MakeRSAWithKeys(ConvertBigIntToString(n), ConvertBigIntToString(d)), nil
+	}
+	if keystore.KeyType != SignatureAlgorithmEd25519 {
+		return nil, errors.New("unknown keystore key type")
+	}
+	decrypted, err := keystore.decrypt(password)
+	if err != nil {
+		return nil, err
+	}
+	rsa, err := parseEd25519Seed(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	if rsa.PublicKeyString() != keystore.PublicKey.N {
+		return nil, errors.New("keystore secret key does not belong to its public key")
+	}
+	return rsa, nil
+}
+
+func (keystore *Keystore) decrypt(password string) (string, error) {
+	key, err := keystore.deriveKey(password)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := hex.DecodeString(keystore.Ciphertext)
+	if err != nil {
+		return "", errors.New("keystore ciphertext is not hex")
+	}
+	decrypted, err := MakeAES().DecryptWithAdditionalData(encrypted, key, keystore.additionalData())
+	if err != nil {
+		return "", ErrInvalidPassword
+	}
+	return string(decrypted), nil
+}
+
+//PublicKeyString returns the public key in the "e:n" form that Generate returns
+func (keystore *Keystore) PublicKeyString() string {
+	return keystore.PublicKey.E + ":" + keystore.PublicKey.N
+}
+
+func (keystore *Keystore) deriveKey(password string) ([]byte, error) {
+	if keystore.Version != KeystoreVersion {
+		return nil, errors.New("unknown keystore version")
+	}
+	if keystore.KDF != KeystoreKDF || keystore.Cipher != KeystoreCipher {
+		return nil, errors.New("unsupported keystore KDF or cipher")
+	}
+	//a file with lowered parameters would make guessing the password cheap
+	if keystore.KDFParams.Iterations < keystoreMinIteration || keystore.KDFParams.KeyLength != keystoreKeyLength {
+		return nil, errors.New("keystore KDF parameters are too weak")
+	}
+	salt, err := hex.DecodeString(keystore.Salt)
+	if err != nil || len(salt) < keystoreSaltLength {
+		return nil, errors.New("keystore salt is invalid")
+	}
+	return pbkdf2.Key([]byte(password), salt, keystore.KDFParams.Iterations, keystore.KDFParams.KeyLength, sha256.New), nil
+}
+
+//additionalData is the envelope without the ciphertext
+func (keystore *Keystore) additionalData() []byte {
+	withoutCiphertext := *keystore
+	withoutCiphertext.Ciphertext = ""
+	data, _ := json.Marshal(withoutCiphertext)
+	return data
+}
+
+func WriteKeystore(filename string, keystore *Keystore) error {
+	data, err := json.MarshalIndent(keystore, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+func ReadKeystore(filename string) (*Keystore, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeystore(data)
+}
+
+func parseKeystore(data []byte) (*Keystore, error) {
+	keystore := new(Keystore)
+	err := json.Unmarshal(data, keystore)
+	if err != nil {
+		return nil, errors.New("keystore is not valid JSON")
+	}
+	return keystore, nil
+}
+
+//LoadSecretKey reads both keystore files and the old files made of a bcrypt hash followed by the AES ciphertext
+func LoadSecretKey(filename string, password string) (*big.Int, *big.Int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, errors.New("Tried to read non-existing file")
+	}
+	if IsLegacyKeystore(data) {
+		return loadLegacySecretKey(data, password)
+	}
+	keystore, err := parseKeystore(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keystore.Unlock(password)
+}
+
+//LoadAccount is LoadSecretKey for accounts of any key type
+func LoadAccount(filename string, password string) (*RSA, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("Tried to read non-existing file")
+	}
+	if IsLegacyKeystore(data) {
+		d, n, err := loadLegacySecretKey(data, password)
+		if err != nil {
+			return nil, err
+		}
+		return MakeRSAWithKeys(ConvertBigIntToString(n), ConvertBigIntToString(d)), nil
+	}
+	keystore, err := parseKeystore(data)
+	if err != nil {
+		return nil, err
+	}
+	return keystore.UnlockAccount(password)
+}
+
+//MigrateLegacyKeystore rewrites an old key file as a keystore, files that are already keystores are left alone
+func MigrateLegacyKeystore(filename string, password string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if !IsLegacyKeystore(data) {
+		return nil
+	}
+	d, n, err := loadLegacySecretKey(data, password)
+	if err != nil {
+		return err
+	}
+	rsa := MakeRSAWithKeys(ConvertBigIntToString(n), ConvertBigIntToString(d))
+	keystore, err := MakeKeystore(rsa, password)
+	if err != nil {
+		return err
+	}
+	return WriteKeystore(filename, keystore)
+}
+
+func IsLegacyKeystore(data []byte) bool {
+	return len(data) > legacyBcryptLength && bytes.HasPrefix(data, []byte("$2"))
+}
+
+func loadLegacySecretKey(data []byte, password string) (*big.Int, *big.Int, error) {
+	hashedPW := Hash(password).Bytes()
+	if bcrypt.CompareHashAndPassword(data[:legacyBcryptLength], hashedPW) != nil {
+		return nil, nil, ErrInvalidPassword
+	}
+	decrypted, err := decryptSecretKey(MakeAES(), data[legacyBcryptLength:], hashedPW)
+	if err != nil {
+		return nil, nil, errors.New("Key file is damaged")
+	}
+	return parseSecretKey(decrypted)
+}
+
+//decryptSecretKey also reads key files written before AES got a version header
+func decryptSecretKey(aes *AES, encrypted []byte, key []byte) (string, error) {
+	if IsVersionedCiphertext(encrypted) {
+		return aes.Decrypt(string(encrypted), key)
+	}
+	decrypted, err := aes.DecryptLegacyCTR(encrypted, key)
+	return string(decrypted), err
+}
+
+func parseSecretKey(secretKey string) (*big.Int, *big.Int, error) {
+	d, n, found := strings.Cut(secretKey, ":")
+	if !found {
+		return nil, nil, errors.New("Key file is damaged")
+	}
+	dBigInt, okD := big.NewInt(0).SetString(d, 10)
+	nBigInt, okN := big.NewInt(0).SetString(n, 10)
+	if !okD || !okN {
+		return nil, nil, errors.New("Key file is damaged")
+	}
+	return dBigInt, nBigInt, nil
+}
@@ -1,37 +1,52 @@
 package main
 
 import (
-	"fmt"
-	"strings"
 	"sync"
 )
 
+//Accounts are kept by address, a transaction to an address that is not valid is refused so no one can pay to an account
+//that no key belongs to
 type Ledger struct {
-	Accounts map[string]int
+	Accounts map[Address]int
 	lock     sync.Mutex
+	logger   *Logger
 }
 
 func MakeLedger() *Ledger {
 	ledger := new(Ledger)
-	ledger.Accounts = make(map[string]int)
+	ledger.Accounts = make(map[Address]int)
+	ledger.logger = DefaultLogger.For("ledger")
 	return ledger
 }
 
+func (l *Ledger) SetLogger(logger *Logger) {
+	l.logger = logger
+}
+
 func (l *Ledger) Transaction(t *SignedTransaction) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	from := strings.TrimRight(t.From, "\r\n")
-	to := strings.TrimRight(t.To, "\r\n")
+	from := t.From
+	to := t.To
+	if !to.Valid() {
+		l.logger.Warn("Transaction to an invalid address", "to", to)
+		return false
+	}
+	//the peer verifies signatures before this, but a multisig account must never pay out below its threshold
+	if IsMultisigPublicKey(t.PublicKey) && !MultisigApproved(*t) {
+		l.logger.Warn("Multisig transaction does not have enough signatures", "from", from)
+		return false
+	}
 	//check whether accounts exist, otherwise create them
 	fromBalance, from_exists := l.Accounts[from]
 	if !from_exists {
-		fmt.Println("Adding new account 'from'")
+		l.logger.Debug("Adding new account 'from'", "account", from)
 		l.Accounts[from] = 0
 		fromBalance = 0
 	}
 	_, to_exists := l.Accounts[to]
 	if !to_exists {
-		fmt.Println("Adding new account 'to'")
+		l.logger.Debug("Adding new account 'to'", "account", to)
 		l.Accounts[to] = 0
 	}
 
@@ -40,7 +55,7 @@ func (l *Ledger) Transaction(t *SignedTransaction) bool {
 		l.Accounts[to] += (t.Amount - 1)
 		return true
 	} else {
-		fmt.Println("Oh no, fromBalance is ", fromBalance, ", while the amount to pay is ", t.Amount, ", from account:", from)
+		l.logger.Warn("Balance too low for transaction", "balance", fromBalance, "amount", t.Amount, "from", from)
 		return false
 	}
 }
@@ -49,43 +64,49 @@ func (l *Ledger) Print() {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	fmt.Println("Ledger state:")
+	if !l.logger.Enabled(LevelDebug) {
+		return
+	}
+	l.logger.Debug("Ledger state:")
 	for acc, balance := range l.Accounts {
-		fmt.Println("Account", acc[:3], "has balance", balance, "AU")
+		l.logger.Debug("Account balance", "account", acc, "balance", balance)
 	}
 }
 
-func (l *Ledger) GiveRewardForStake(publicKey string, noOfTransactions int) {
+func (l *Ledger) GiveRewardForStake(address Address, noOfTransactions int) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	realPK := strings.TrimRight(publicKey, "\r\n")
 	reward := 10 + noOfTransactions
-	fmt.Println("Awarding the block creator", reward, "AU for that amount of transactions in block + 10")
+	l.logger.Debug("Awarding the block creator 10 AU plus 1 AU per transaction in the block", "account", address, "reward", reward)
+
+	l.Accounts[address] += reward
+}
 
-	l.Accounts[realPK] += reward
+func (l *Ledger) Balance(address Address) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.Accounts[address]
 }
 
-func (l *Ledger) AddAccount(newAcc string) bool {
+func (l *Ledger) AddAccount(newAcc Address) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	realAcc := strings.TrimRight(newAcc, "\r\n")
-	_, from_exists := l.Accounts[realAcc]
+	_, from_exists := l.Accounts[newAcc]
 	if !from_exists {
-		l.Accounts[realAcc] = 0
+		l.Accounts[newAcc] = 0
 		return true
 	}
 	return false
 }
 
-func (l *Ledger) AddGenesisAccount(newAcc string) bool {
+func (l *Ledger) AddGenesisAccount(newAcc Address) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	realAcc := strings.TrimRight(newAcc, "\r\n")
-	_, from_exists := l.Accounts[realAcc]
+	_, from_exists := l.Accounts[newAcc]
 	if !from_exists {
-		l.Accounts[realAcc] = 1000000
+		l.Accounts[newAcc] = 1000000
 		return true
 	}
 	return false
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Start brings the peer into the network and returns, the peer then runs until the context is cancelled, Stop is called
+//or the user quits. Stopping waits for the background loops (lottery, gossip, heartbeats, sending) to end, sends the
+//transactions still waiting in the outbound channel, tells the other peers that we leave, closes the connections and
+//saves the peer table. The leave message is signed with our account key, so only we can make the network drop our URI.
+//Only the peers that learned our key by dialing us with an authenticating transport can check that. The others, which
+//with the default TCP transport is all of them, take it as a dead peer notice (see Heartbeat.go): they dial the URI and
+//only drop it and pass a dead peer notice on if we no longer answer, so a forged leave cannot remove a live peer.
+
+const leaveDelimiter = "leave"
+const leaveMaxAge = time.Minute //a leave message older than this is ignored, so it cannot be replayed after we rejoin
+
+var ErrUserQuit = errors.New("the user quit the program")
+
+type lifecycle struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mutex     *sync.Mutex     //makes adding workers and cancelling one step, so Stop never waits for a worker started too late
+	workers   *sync.WaitGroup //the loops Stop waits for, started with goWorker
+	listener  net.Listener
+	stateFile string //where the peer table is saved, "" if it is not
+	stopOnce  *sync.Once
+	stopErr   error
+	done      chan bool //closed when the peer has stopped
+}
+
+func makeLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel, mutex: &sync.Mutex{}, workers: &sync.WaitGroup{}, stopOnce: &sync.Once{}, done: make(chan bool)}
+}
+
+//SetStateFile must be called before Start, the peer table is loaded from the file when starting and saved to it when stopping
+func (peer *Peer) SetStateFile(filename string) {
+	peer.lifecycle.stateFile = filename
+}
+
+func (peer *Peer) Start(ctx context.Context) error {
+	peer.lifecycle.mutex.Lock()
+	peer.lifecycle.ctx, peer.lifecycle.cancel = context.WithCancel(ctx)
+	peer.lifecycle.mutex.Unlock()
+
+	//ask for IP and port of an existing peer via user input or other strategy
+	otherURI := peer.GetURI()
+
+	err := peer.SetupAccount()
+	if err != nil {
+		return err
+	}
+	peer.injectLogger()
+	peer.UseAccountForTransport()
+
+	//addresses saved last time we ran, JoinNetwork dials them if the given peer does not give us enough
+	if peer.lifecycle.stateFile != "" {
+		err = peer.peerTable.Load(peer.lifecycle.stateFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	//connect to the given IP and port
+	peer.JoinNetwork(otherURI)
+
+	//listen for connections on own ip and port to which other peers can connect
+	listener, err := peer.listen()
+	if err != nil {
+		peer.Stop()
+		return err
+	}
+	peer.lifecycle.listener = listener
+
+	//add yourself to the peer table and broadcast the new presence so everyone can add you
+	peer.AddSelfToConnectionsURI()
+	peer.BroadcastPresence(peer.URI())
+
+	//take input from the user, this blocks on the user so Stop does not wait for it
+	go peer.HandleIncomingFromUser()
+
+	//set up a thread to send outbound messages
+	peer.goWorker(peer.SendMessages)
+
+	//exchange addresses with the neighbours and keep up the outbound connections
+	peer.goWorker(peer.HandlePeerTable)
+
+	//ping the neighbours, close silent connections and reconnect when we lose peers
+	peer.goWorker(peer.HandleHeartbeats)
+
+	//listen for connections from other peers
+	peer.goWorker(func() {
+		for peer.Running() {
+			peer.TakeNewConnection(listener)
+		}
+	})
+
+	//the context may also be cancelled from outside, e.g. on an interrupt
+	go func() {
+		<-peer.lifecycle.ctx.Done()
+		peer.Stop()
+	}()
+	return nil
+}
+
+//Stop can be called more than once and from any goroutine, it returns when the peer has stopped
+func (peer *Peer) Stop() error {
+	peer.lifecycle.stopOnce.Do(func() {
+		peer.lifecycle.stopErr = peer.shutdown()
+		close(peer.lifecycle.done)
+	})
+	<-peer.lifecycle.done
+	return peer.lifecycle.stopErr
+}
+
+//Done is closed when the peer has stopped
+func (peer *Peer) Done() <-chan bool {
+	return peer.lifecycle.done
+}
+
+func (peer *Peer) Running() bool {
+	return peer.lifecycle.ctx.Err() == nil
+}
+
+func (peer *Peer) shutdown() error {
+	peer.logger.Info("Stopping")
+	peer.lifecycle.mutex.Lock()
+	peer.lifecycle.cancel()
+	peer.lifecycle.mutex.Unlock()
+	if peer.lifecycle.listener != nil {
+		peer.lifecycle.listener.Close()
+	}
+	peer.lifecycle.workers.Wait()
+
+	peer.drainOutbound()
+	if peer.ip != "" {
+		peer.BroadcastLeave()
+	}
+	peer.flushSendQueues()
+
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+	for _, conn := range connections {
+		conn.Close()
+		peer.DeleteFromConnections(conn)
+	}
+
+	if peer.lifecycle.stateFile != "" {
+		err := peer.peerTable.Save(peer.lifecycle.stateFile)
+		if err != nil {
+			peer.logger.Error("Could not save the peer table", "file", peer.lifecycle.stateFile, "error", err)
+			return err
+		}
+	}
+	peer.logger.Info("Stopped")
+	return nil
+}
+
+//goWorker runs the loop in a goroutine that Stop waits for, unless the peer is already stopping
+func (peer *Peer) goWorker(loop func()) {
+	peer.lifecycle.mutex.Lock()
+	defer peer.lifecycle.mutex.Unlock()
+	if !peer.Running() {
+		return
+	}
+	peer.lifecycle.workers.Add(1)
+	go func() {
+		defer peer.lifecycle.workers.Done()
+		loop()
+	}()
+}
+
+//sleep waits for the duration and returns false if the peer stopped in the meantime
+func (peer *Peer) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-peer.lifecycle.ctx.Done():
+		return false
+	}
+}
+
+//enqueue hands a transaction to SendMessages, it is dropped if the peer is stopping
+func (peer *Peer) enqueue(message SignedTransaction) {
+	select {
+	case peer.outbound <- message:
+	case <-peer.lifecycle.ctx.Done():
+	}
+}
+
+//drainOutbound sends the transactions that were handed to the outbound channel while SendMessages stopped
+func (peer *Peer) drainOutbound() {
+	for {
+		select {
+		case message := <-peer.outbound:
+			peer.sendOutbound(message)
+		default:
+			return
+		}
+	}
+}
+
+func leaveMessageToSign(uri string, timestamp string) string {
+	return "LEAVE:" + uri + ":" + timestamp
+}
+
+//A leave message is sent like a block: our URI, our public key, the time, the signature and the delimiter
+func (peer *Peer) MakeLeaveMessage(now time.Time) Block {
+	uri := peer.URI()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := peer.rsa.SignMessage(leaveMessageToSign(uri, timestamp))
+	return Block{uri, peer.rsa.PublicKeyString(), timestamp, signature, leaveDelimiter}
+}
+
+func IsLeaveMessage(block Block) bool {
+	return len(block) == 5 && block[4] == leaveDelimiter
+}
+
+func (peer *Peer) BroadcastLeave() {
+	marshalled := MarshalList(peer.MakeLeaveMessage(time.Now()))
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+	for _, conn := range connections {
+		peer.SendBlock(conn, marshalled)
+	}
+}
+
+//VerifyLeaveMessage checks the signature and the age. The message must be signed with the key the peer at the URI proved
+//it owns when we connected to it with an authenticating transport, without that anyone could sign for the URI
+func (peer *Peer) VerifyLeaveMessage(leave Block, now time.Time) error {
+	uri, publicKey, timestamp, signature := leave[0], leave[1], leave[2], leave[3]
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("the leave message has no valid time")
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > leaveMaxAge || age < -leaveMaxAge {
+		return errors.New("the leave message is too old or from the future")
+	}
+	knownKey := peer.peerTable.Key(uri)
+	if knownKey == "" {
+		return errors.New("we do not know the key of the peer at that address")
+	}
+	if knownKey != publicKey {
+		return errors.New("the leave message is not signed by the peer at that address")
+	}
+	if !peer.rsa.VerifySignature(leaveMessageToSign(uri, timestamp), signature, publicKey) {
+		return errors.New("the leave message has an invalid signature")
+	}
+	return nil
+}
+
+//HandleLeave removes the URI and passes the message on if the URI was in our table. Our outbound connections to it
+//are no longer counted, so the heartbeat does not dial it again when they close. If we do not know the key of the peer
+//at the URI we cannot check the message, so we check that the peer is gone by dialing it instead
+func (peer *Peer) HandleLeave(leave Block, marshalled []byte) {
+	uri := leave[0]
+	if uri == peer.URI() || !peer.peerTable.Contains(uri) {
+		return
+	}
+	if peer.peerTable.Key(uri) == "" {
+		peer.logger.Debug("Cannot check a leave message without the key, checking that the peer is gone", "uri", uri)
+		peer.HandleDeadPeer(uri)
+		return
+	}
+	err := peer.VerifyLeaveMessage(leave, time.Now())
+	if err != nil {
+		peer.logger.Warn("Rejected a leave message", "uri", uri, "error", err)
+		return
+	}
+	peer.logger.Info("Peer left the network", "uri", uri)
+	peer.DeleteFromConnectionsURI(uri)
+	peer.connectionsMutex.Lock()
+	for conn, outboundURI := range peer.outboundURIs {
+		if outboundURI == uri {
+			delete(peer.outboundURIs, conn)
+		}
+	}
+	peer.connectionsMutex.Unlock()
+
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+	for _, conn := range connections {
+		peer.SendBlock(conn, marshalled)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//quittingInputStrategy gives the peer an account, so Start does not ask for keys, and quits when told to
+type quittingInputStrategy struct {
+	account *RSA
+	quit    chan bool
+}
+
+func (inputStrategy *quittingInputStrategy) GetAccount() *RSA {
+	return inputStrategy.account
+}
+
+func (inputStrategy *quittingInputStrategy) HandleIncomingFromUser() (SignedTransaction, error) {
+	<-inputStrategy.quit
+	return SignedTransaction{}, ErrUserQuit
+}
+
+func startPeer(t *testing.T, ip string, port string, transportStrategy TransportStrategy, config HeartbeatConfig, stateFile string) (*Peer, *quittingInputStrategy) {
+	inputStrategy := &quittingInputStrategy{account: MakeRSA(1024), quit: make(chan bool)}
+	peer := MakePeer(MakeFixedUriStrategy(ip, port), inputStrategy, MakeFixedOutboundIPStrategy("localhost"), MakeStubbedMessageSendingStrategy(), transportStrategy)
+	peer.SetHeartbeatConfig(config)
+	peer.SetStateFile(stateFile)
+	err := peer.Start(context.Background())
+	if err != nil {
+		t.Fatal("Could not start the peer:", err)
+	}
+	return peer, inputStrategy
+}
+
+func waitUntil(condition func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestStoppedPeerIsDroppedByTheOthers(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer1, _ := startPeer(t, "fa", "fa", transportStrategy, fastHeartbeatConfig(), "")
+	defer peer1.Stop()
+	peer2, _ := startPeer(t, peer1.ip, peer1.port, transportStrategy, fastHeartbeatConfig(), "")
+	defer peer2.Stop()
+	peer3, _ := startPeer(t, peer1.ip, peer1.port, transportStrategy, fastHeartbeatConfig(), "")
+	uri3 := peer3.ip + ":" + peer3.port
+	if !waitUntil(func() bool { return peer1.peerTable.Contains(uri3) && peer2.peerTable.Contains(uri3) }, 5*time.Second) {
+		t.Fatal("The peers never learned about the third peer")
+	}
+
+	err := peer3.Stop()
+	if err != nil {
+		t.Fatal("Could not stop the peer:", err)
+	}
+	select {
+	case <-peer3.Done():
+	default:
+		t.Error("Done should be closed once Stop returns")
+	}
+	if len(peer3.GetConnections()) != 0 {
+		t.Error("The stopped peer should have closed its connections, it has", len(peer3.GetConnections()))
+	}
+	if _, err := transportStrategy.Dial(uri3); err == nil {
+		t.Error("The stopped peer should not take connections anymore")
+	}
+	if !waitUntil(func() bool { return !peer1.peerTable.Contains(uri3) && !peer2.peerTable.Contains(uri3) }, 5*time.Second) {
+		t.Fatal("The other peers should drop the address of a peer that left")
+	}
+	//a few heartbeats later they have not dialed it again or learned it back
+	time.Sleep(500 * time.Millisecond)
+	if peer1.peerTable.Contains(uri3) || peer2.peerTable.Contains(uri3) {
+		t.Error("The address of the peer that left came back")
+	}
+	if peer3.Stop() != nil {
+		t.Error("Stopping twice should do nothing")
+	}
+	fmt.Println("TestStoppedPeerIsDroppedByTheOthers passed")
+}
+
+func TestPeerStopsWhenTheUserQuits(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, inputStrategy := startPeer(t, "fa", "fa", transportStrategy, fastHeartbeatConfig(), "")
+	peer.SetSystemRunning()
+	close(inputStrategy.quit)
+	select {
+	case <-peer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The peer did not stop when the user quit")
+	}
+	if peer.Running() {
+		t.Error("A stopped peer should not be running")
+	}
+	fmt.Println("TestPeerStopsWhenTheUserQuits passed")
+}
+
+func TestOnlyTheLeavingPeerCanSignItsLeaveMessage(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, _ := createPeerWithTransport("fa", "fa", transportStrategy)
+	leaving, _ := createPeerWithTransport("fa", "fa", transportStrategy)
+	now := time.Now()
+	leave := leaving.MakeLeaveMessage(now)
+	uri := leave[0]
+	peer.peerTable.Add(uri)
+	if peer.VerifyLeaveMessage(leave, now) == nil {
+		t.Error("A leave message should be rejected when we do not know the key of the peer at the address")
+	}
+	peer.peerTable.SetKey(uri, leaving.rsa.PublicKeyString())
+	if !IsLeaveMessage(leave) || peer.VerifyLeaveMessage(leave, now) != nil {
+		t.Fatal("A fresh leave message from the peer itself should be accepted")
+	}
+
+	forged := append(Block{}, leave...)
+	forged[0] = "localhost:1234"
+	if peer.VerifyLeaveMessage(forged, now) == nil {
+		t.Error("A leave message for another address should not verify")
+	}
+	if peer.VerifyLeaveMessage(leave, now.Add(2*leaveMaxAge)) == nil {
+		t.Error("An old leave message should be rejected, it could be replayed after the peer rejoins")
+	}
+	peer.peerTable.SetKey(uri, MakeRSA(1024).PublicKeyString())
+	if peer.VerifyLeaveMessage(leave, now) == nil {
+		t.Error("The leave message must be signed by the key we know for the address")
+	}
+
+	marshalled, _ := json.Marshal(leave)
+	peer.HandleLeave(leave, marshalled)
+	if !peer.peerTable.Contains(uri) {
+		t.Error("A rejected leave message should not remove the address")
+	}
+	fmt.Println("TestOnlyTheLeavingPeerCanSignItsLeaveMessage passed")
+}
+
+func TestLeaveWithoutAKnownKeyIsCheckedByDialing(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, _ := createPeerWithTransport("fa", "fa", transportStrategy)
+	leaving, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	leave := leaving.MakeLeaveMessage(time.Now())
+	uri := leave[0]
+	peer.peerTable.Add(uri)
+	marshalled, _ := json.Marshal(leave)
+
+	//anyone can sign a leave message for a URI when we do not know its key, so a peer that still answers is kept
+	peer.HandleLeave(leave, marshalled)
+	time.Sleep(200 * time.Millisecond)
+	if !peer.peerTable.Contains(uri) {
+		t.Error("A leave message we cannot check should not remove a peer that still answers")
+	}
+
+	listener.Close()
+	peer.HandleLeave(leave, marshalled)
+	if !waitUntil(func() bool { return !peer.peerTable.Contains(uri) }, 5*time.Second) {
+		t.Error("A peer that left and no longer answers should be removed")
+	} else {
+		fmt.Println("TestLeaveWithoutAKnownKeyIsCheckedByDialing passed")
+	}
+}
+
+func TestPeerTableIsSavedWhenStoppingAndLoadedWhenStarting(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "peers.json")
+	transportStrategy := MakePipeTransportStrategy()
+	peer, _ := startPeer(t, "fa", "fa", transportStrategy, DefaultHeartbeatConfig(), stateFile)
+	peer.peerTable.Add("localhost:1111")
+	peer.peerTable.MarkReachable("localhost:2222")
+	peer.Stop()
+
+	restarted, _ := startPeer(t, "fa", "fa", transportStrategy, DefaultHeartbeatConfig(), stateFile)
+	defer restarted.Stop()
+	score, found := restarted.peerTable.Score("localhost:2222")
+	if !restarted.peerTable.Contains("localhost:1111") || !found || score != 1 {
+		t.Error("The saved addresses and scores should be loaded, got", restarted.peerTable.URIs(), "and score", score)
+	}
+	if restarted.peerTable.Contains(peer.ip + ":" + peer.port) {
+		t.Error("Our own old address should not be saved")
+	}
+	fmt.Println("TestPeerTableIsSavedWhenStoppingAndLoadedWhenStarting passed")
+}
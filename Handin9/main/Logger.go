@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//an entry is written if its level is at least the level of its component
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "off"}
+
+var DefaultLogger = MakeLogger(os.Stdout, LevelInfo, false)
+
+type logOutput struct {
+	out        io.Writer
+	json       bool
+	level      LogLevel
+	components map[string]LogLevel //levels of single components, overriding level
+	lock       *sync.Mutex
+}
+
+//Logger writes levelled log entries for one component (peer, ledger, rsa, blocktree). The loggers made with For share
+//their output and levels, so changing the level of one component later also changes it for the loggers already handed out.
+//Anything that is expensive to compute only for the log should be behind a check of Enabled.
+type Logger struct {
+	component string
+	output    *logOutput
+}
+
+func MakeLogger(out io.Writer, level LogLevel, json bool) *Logger {
+	output := new(logOutput)
+	output.out = out
+	output.json = json
+	output.level = level
+	output.components = make(map[string]LogLevel)
+	output.lock = &sync.Mutex{}
+	logger := new(Logger)
+	logger.output = output
+	return logger
+}
+
+//For returns a logger for the component that writes to the same output
+func (logger *Logger) For(component string) *Logger {
+	componentLogger := new(Logger)
+	componentLogger.component = component
+	componentLogger.output = logger.output
+	return componentLogger
+}
+
+func (logger *Logger) SetLevel(level LogLevel) {
+	logger.output.lock.Lock()
+	defer logger.output.lock.Unlock()
+	logger.output.level = level
+}
+
+func (logger *Logger) SetJSON(json bool) {
+	logger.output.lock.Lock()
+	defer logger.output.lock.Unlock()
+	logger.output.json = json
+}
+
+func (logger *Logger) SetComponentLevel(component string, level LogLevel) {
+	logger.output.lock.Lock()
+	defer logger.output.lock.Unlock()
+	logger.output.components[component] = level
+}
+
+//SetComponentLevels takes a list like "rsa=debug,peer=warn"
+func (logger *Logger) SetComponentLevels(levels string) error {
+	for _, setting := range strings.Split(levels, ",") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("expected component=level, got " + setting)
+		}
+		level, err := ParseLogLevel(parts[1])
+		if err != nil {
+			return err
+		}
+		logger.SetComponentLevel(strings.TrimSpace(parts[0]), level)
+	}
+	return nil
+}
+
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return LogLevel(level), nil
+		}
+	}
+	return LevelOff, errors.New("unknown log level " + name + ", expected one of " + strings.Join(logLevelNames, ", "))
+}
+
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelOff {
+		return "unknown"
+	}
+	return logLevelNames[level]
+}
+
+func (logger *Logger) Enabled(level LogLevel) bool {
+	logger.output.lock.Lock()
+	defer logger.output.lock.Unlock()
+	return logger.enabled(level)
+}
+
+func (logger *Logger) enabled(level LogLevel) bool {
+	minimum, found := logger.output.components[logger.component]
+	if !found {
+		minimum = logger.output.level
+	}
+	return level != LevelOff && level >= minimum
+}
+
+//The fields are pairs of a name and a value, like Info("Received seed", "seed", 42)
+func (logger *Logger) Debug(message string, fields ...interface{}) {
+	logger.Log(LevelDebug, message, fields...)
+}
+
+func (logger *Logger) Info(message string, fields ...interface{}) {
+	logger.Log(LevelInfo, message, fields...)
+}
+
+func (logger *Logger) Warn(message string, fields ...interface{}) {
+	logger.Log(LevelWarn, message, fields...)
+}
+
+func (logger *Logger) Error(message string, fields ...interface{}) {
+	logger.Log(LevelError, message, fields...)
+}
+
+func (logger *Logger) Log(level LogLevel, message string, fields ...interface{}) {
+	logger.output.lock.Lock()
+	defer logger.output.lock.Unlock()
+	if !logger.enabled(level) {
+		return
+	}
+	now := time.Now()
+	if logger.output.json {
+		logger.writeJSON(now, level, message, fields)
+	} else {
+		logger.writeText(now, level, message, fields)
+	}
+}
+
+func (logger *Logger) writeText(now time.Time, level LogLevel, message string, fields []interface{}) {
+	line := now.Format("15:04:05.000") + " " + fmt.Sprintf("%-5s", strings.ToUpper(level.String()))
+	if logger.component != "" {
+		line += " [" + logger.component + "]"
+	}
+	line += " " + message
+	for i := 0; i < len(fields); i += 2 {
+		line += " " + fmt.Sprint(fields[i]) + "=" + fmt.Sprint(logFieldValue(fields, i+1))
+	}
+	fmt.Fprintln(logger.output.out, line)
+}
+
+func (logger *Logger) writeJSON(now time.Time, level LogLevel, message string, fields []interface{}) {
+	entry := make(map[string]interface{})
+	for i := 0; i < len(fields); i += 2 {
+		value := logFieldValue(fields, i+1)
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprint(value)
+		}
+		entry[fmt.Sprint(fields[i])] = value
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["component"] = logger.component
+	entry["msg"] = message
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	logger.output.out.Write(append(bytes, '\n'))
+}
+
+//a field without a value is logged with the value "(missing)" instead of being dropped
+func logFieldValue(fields []interface{}, index int) interface{} {
+	if index >= len(fields) {
+		return "(missing)"
+	}
+	return fields[index]
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFiltersByLevelAndComponent(t *testing.T) {
+	var out bytes.Buffer
+	logger := MakeLogger(&out, LevelInfo, true)
+	logger.SetComponentLevels("rsa=debug,ledger=off")
+	logger.For("peer").Debug("hidden")
+	logger.For("peer").Info("Received genesis block", "seed", 42)
+	logger.For("rsa").Debug("Verifying draw", "slotNumber", 3)
+	logger.For("ledger").Error("hidden")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected the peer info and the rsa debug entry, got:\n" + out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal("An entry is not JSON:", err)
+	}
+	if entry["component"] != "peer" || entry["level"] != "info" || entry["msg"] != "Received genesis block" || entry["seed"] != 42.0 {
+		t.Error("The entry does not have the right fields:", entry)
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Error("An unknown level should be rejected")
+	}
+	fmt.Println("TestLoggerFiltersByLevelAndComponent passed")
+}
+
+func TestPeerComponentsLogThroughTheInjectedLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := MakeLogger(&out, LevelWarn, false)
+	peer := peerFixture()
+	peer.SetLogger(logger)
+	rsa := GenesisRSA("1")
+	signature := rsa.FullSign("hello", rsa.n, rsa.d)
+
+	//at warn the extra signature is not made and nothing is written
+	peer.rsa.VerifyWithKey("hello", *signature, rsa.n, big.NewInt(3))
+	peer.ledger.Print()
+	if out.Len() != 0 {
+		t.Error("Nothing should be logged below warn, got:\n" + out.String())
+	}
+
+	logger.SetComponentLevel("rsa", LevelDebug)
+	peer.rsa.VerifyWithKey("hello", *signature, rsa.n, big.NewInt(3))
+	if !strings.Contains(out.String(), "[rsa] Verifying with key") {
+		t.Error("The rsa debug entry should go to the injected logger, got:\n" + out.String())
+	}
+	fmt.Println("TestPeerComponentsLogThroughTheInjectedLogger passed")
+}
@@ -1,7 +1,5 @@
 package main
 
-import "fmt"
-
 type MessageSendingStrategy interface {
 	SendMessageToAllPeers(message SignedTransaction, peer *Peer)
 }
@@ -9,9 +7,19 @@ type MessageSendingStrategy interface {
 type RealMessageSendingStrategy struct {
 }
 
+//SendMessageToAllPeers announces the transaction or floods it, see Gossip.go. A multisig proposal is always sent in
+//full, it is passed on again every time it gets more signatures under the same ID
 func (realMessageSendingStrategy *RealMessageSendingStrategy) SendMessageToAllPeers(message SignedTransaction, peer *Peer) {
-	for _, connection := range peer.connections {
-		fmt.Println("Sending", message, "to", connection)
+	if !IsMultisigPublicKey(message.PublicKey) {
+		peer.logger.Debug("Relaying transaction", "id", message.ID)
+		peer.RelayTransaction(message)
+		return
+	}
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+	for _, connection := range connections {
+		peer.logger.Debug("Sending transaction", "id", message.ID, "to", connection.RemoteAddr())
 		peer.SendMessage(connection, message)
 	}
 }
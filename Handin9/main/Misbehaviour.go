@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+//Every connection starts with MaxScore points. A peer that breaks the protocol loses points for each violation, and
+//when it reaches 0 we close the connection and ban its host for BanDuration. Connections to and from a banned host
+//are refused until the ban ends. An honest peer never sends us the same transaction or block twice, because it only
+//passes on what was new to it, so duplicates from one connection cost a little too, and a flood of them gets it banned.
+
+type Violation int
+
+const (
+	InvalidBlock       Violation = iota //a block that fails VerifyWinningBlock or is too short to be one
+	MalformedMessage                    //a message that is neither a transaction, a presence nor a list of strings
+	InvalidTransaction                  //a transaction with a bad signature or amount
+	DuplicateMessage                    //a transaction or block the connection has sent before
+)
+
+const maxSeenPerConnection = 10000 //the IDs remembered per connection to find duplicates, forgotten all at once after this
+
+func (violation Violation) String() string {
+	switch violation {
+	case InvalidBlock:
+		return "invalid block"
+	case MalformedMessage:
+		return "malformed message"
+	case InvalidTransaction:
+		return "invalid transaction"
+	case DuplicateMessage:
+		return "duplicate message"
+	}
+	return "unknown violation"
+}
+
+type MisbehaviourConfig struct {
+	MaxScore    int
+	Penalties   map[Violation]int //points lost for each violation
+	BanDuration time.Duration
+}
+
+func DefaultMisbehaviourConfig() MisbehaviourConfig {
+	penalties := map[Violation]int{InvalidBlock: 50, MalformedMessage: 20, InvalidTransaction: 10, DuplicateMessage: 2}
+	return MisbehaviourConfig{MaxScore: 100, Penalties: penalties, BanDuration: time.Hour}
+}
+
+func (config MisbehaviourConfig) Validate() error {
+	if config.MaxScore <= 0 || config.BanDuration <= 0 {
+		return errors.New("the misbehaviour score and the ban duration must be positive")
+	}
+	for _, violation := range []Violation{InvalidBlock, MalformedMessage, InvalidTransaction, DuplicateMessage} {
+		if config.Penalties[violation] < 0 {
+			return errors.New("the penalty for a " + violation.String() + " must not be negative")
+		}
+	}
+	return nil
+}
+
+//SetMisbehaviourConfig must be called before Start
+func (peer *Peer) SetMisbehaviourConfig(config MisbehaviourConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	peer.misbehaviourMutex.Lock()
+	defer peer.misbehaviourMutex.Unlock()
+	peer.misbehaviourConfig = config
+	return nil
+}
+
+//remoteHost is what we ban, the port of a connection from another peer changes every time it connects
+func remoteHost(conn net.Conn) string {
+	address := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+//Penalize takes the points for the violation from the connection, and returns true if it was closed and its host banned
+func (peer *Peer) Penalize(conn net.Conn, violation Violation) bool {
+	peer.misbehaviourMutex.Lock()
+	score, found := peer.misbehaviourScores[conn]
+	if !found {
+		score = peer.misbehaviourConfig.MaxScore
+	}
+	score -= peer.misbehaviourConfig.Penalties[violation]
+	peer.misbehaviourScores[conn] = score
+	host := remoteHost(conn)
+	banned := score <= 0
+	if banned {
+		peer.bans[host] = time.Now().Add(peer.misbehaviourConfig.BanDuration)
+	}
+	banDuration := peer.misbehaviourConfig.BanDuration
+	peer.misbehaviourMutex.Unlock()
+
+	peer.logger.Warn("Peer misbehaved", "host", host, "violation", violation, "score", score)
+	if banned {
+		peer.logger.Warn("Banning peer", "host", host, "duration", banDuration)
+		peer.connectionsMutex.Lock()
+		uri := peer.outboundURIs[conn]
+		delete(peer.outboundURIs, conn) //we do not want the heartbeat to dial it again
+		peer.connectionsMutex.Unlock()
+		if uri != "" {
+			peer.DeleteFromConnectionsURI(uri)
+		}
+		conn.Close()
+		peer.DeleteFromConnections(conn)
+	}
+	return banned
+}
+
+func (peer *Peer) MisbehaviourScore(conn net.Conn) int {
+	peer.misbehaviourMutex.Lock()
+	defer peer.misbehaviourMutex.Unlock()
+	score, found := peer.misbehaviourScores[conn]
+	if !found {
+		return peer.misbehaviourConfig.MaxScore
+	}
+	return score
+}
+
+//IsBanned also forgets the ban if it has ended
+func (peer *Peer) IsBanned(host string) bool {
+	peer.misbehaviourMutex.Lock()
+	defer peer.misbehaviourMutex.Unlock()
+	until, found := peer.bans[host]
+	if found && time.Now().After(until) {
+		delete(peer.bans, host)
+		return false
+	}
+	return found
+}
+
+//seenFrom returns true if the connection has sent us the ID before
+func (peer *Peer) seenFrom(conn net.Conn, id string) bool {
+	peer.misbehaviourMutex.Lock()
+	defer peer.misbehaviourMutex.Unlock()
+	seen := peer.seenOnConnection[conn]
+	if seen == nil || len(seen) >= maxSeenPerConnection {
+		seen = make(map[string]bool)
+		peer.seenOnConnection[conn] = seen
+	}
+	if seen[id] {
+		return true
+	}
+	seen[id] = true
+	return false
+}
+
+//forgetMisbehaviour is called by DeleteFromConnections, the ban of the host stays
+func (peer *Peer) forgetMisbehaviour(conn net.Conn) {
+	peer.misbehaviourMutex.Lock()
+	defer peer.misbehaviourMutex.Unlock()
+	delete(peer.misbehaviourScores, conn)
+	delete(peer.seenOnConnection, conn)
+}
+
+//checkTransaction returns the violation if the transaction can not be valid. A transaction from an address we have
+//no public key for can not be checked yet, it is passed on and checked when it is in a block
+//checkMultisigProposal is checkTransaction for a multisig transaction that may still be collecting signatures, it may
+//have fewer signatures than the threshold but every one it has must verify
+func (peer *Peer) checkMultisigProposal(transaction SignedTransaction) (Violation, bool) {
+	policy, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil || policy.Address() != transaction.From || !transaction.To.Valid() || transaction.Amount < 1 {
+		return InvalidTransaction, true
+	}
+	if len(ValidMultisigSignatures(transaction)) != len(transaction.Signatures) {
+		return InvalidTransaction, true
+	}
+	return 0, false
+}
+
+func (peer *Peer) checkTransaction(transaction SignedTransaction) (Violation, bool) {
+	if !transaction.To.Valid() {
+		return InvalidTransaction, true
+	}
+	resolved := peer.registry.Resolve(transaction)
+	if resolved.PublicKey == "" {
+		return 0, false
+	}
+	if transaction.Amount < 1 || !peer.rsa.VerifyTransaction(resolved) {
+		return InvalidTransaction, true
+	}
+	return 0, false
+}
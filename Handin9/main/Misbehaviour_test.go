@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//maliciousPeer dials the honest peer like any other peer would, and then breaks the protocol
+type maliciousPeer struct {
+	conn   net.Conn
+	closed chan bool //closed when the honest peer hangs up
+}
+
+//dialFakeNeighbour dials the peer at the URI and hands every message the peer sends it to onMessage, the returned
+//channel is closed when the peer hangs up. With a nil onMessage nothing is read, so the writes to it block once the
+//transport buffer is full, and the channel is never closed
+func dialFakeNeighbour(t *testing.T, transportStrategy TransportStrategy, uri string, onMessage func(message []byte)) (net.Conn, chan bool) {
+	conn, err := transportStrategy.Dial(uri)
+	if err != nil {
+		t.Fatal("The fake neighbour could not dial:", err)
+	}
+	closed := make(chan bool)
+	if onMessage == nil {
+		return conn, closed
+	}
+	go func() {
+		reader := transportStrategy.Receive(conn)
+		for {
+			message, err := reader.ReadBytes(']')
+			if err != nil {
+				close(closed)
+				return
+			}
+			onMessage(message)
+		}
+	}()
+	return conn, closed
+}
+
+func connectMaliciousPeer(t *testing.T, transportStrategy TransportStrategy, uri string) *maliciousPeer {
+	//read everything the honest peer sends, so its sends do not block
+	conn, closed := dialFakeNeighbour(t, transportStrategy, uri, func(message []byte) {})
+	return &maliciousPeer{conn: conn, closed: closed}
+}
+
+func (malicious *maliciousPeer) send(transportStrategy TransportStrategy, messages [][]byte) {
+	for _, message := range messages {
+		transportStrategy.Send(malicious.conn, message)
+	}
+}
+
+func (malicious *maliciousPeer) hungUpWithin(timeout time.Duration) bool {
+	select {
+	case <-malicious.closed:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func invalidBlocks(peer *Peer, count int) [][]byte {
+	attacker := MakeRSA(1024)
+	messages := make([][]byte, count)
+	for i := range messages {
+		//well formed and signed, but the attacker has no stake so it cannot have won
+		slot := i + 1
+		draw := attacker.SignDeterministic("LOTTERY:" + strconv.Itoa(peer.seed) + ":" + strconv.Itoa(slot))
+		block := Block{"BLOCK", attacker.PublicKeyString(), strconv.Itoa(slot), draw, "prev", attacker.CreateBlockSignature(slot, Block{}, "prev")}
+		messages[i] = peer.MarshalBlock(block)
+	}
+	return messages
+}
+
+//forgedTransactions changes the amount of a signed transaction, each copy gets its own ID so it is not a duplicate
+func forgedTransactions(peer *Peer, valid SignedTransaction, count int) [][]byte {
+	messages := make([][]byte, count)
+	for i := range messages {
+		forged := valid
+		forged.ID = valid.ID + "-" + strconv.Itoa(i)
+		forged.Amount = 1000
+		messages[i] = peer.MarshalTransaction(forged)
+	}
+	return messages
+}
+
+//toInvalidAddresses sends signed transactions to an address with a broken checksum
+func toInvalidAddresses(peer *Peer, sender *RSA, count int) [][]byte {
+	messages := make([][]byte, count)
+	for i := range messages {
+		to := []byte(AddressOfPublicKey(MakeRSA(1024).PublicKeyString()))
+		to[len(to)-1] = base58Alphabet[(strings.IndexByte(base58Alphabet, to[len(to)-1])+1)%len(base58Alphabet)]
+		messages[i] = peer.MarshalTransaction(*MakeSignedTransaction(sender.PublicKeyString(), Address(to), 10, ConvertBigIntToString(&sender.d)))
+	}
+	return messages
+}
+
+//forgedMultisigProposals change the amount of a multisig proposal after one of the signers signed it
+func forgedMultisigProposals(peer *Peer, count int) [][]byte {
+	signer := MakeRSA(1024)
+	policy, _ := MakeMultisigPolicy(2, []string{signer.PublicKeyString(), MakeRSA(1024).PublicKeyString()})
+	messages := make([][]byte, count)
+	for i := range messages {
+		forged := MakeMultisigTransaction(policy, AddressOfPublicKey("receiver"), 10)
+		signer.SignMultisigTransaction(forged)
+		forged.Amount = 1000 + i
+		messages[i] = peer.MarshalTransaction(*forged)
+	}
+	return messages
+}
+
+func repeatedMessage(message []byte, count int) [][]byte {
+	messages := make([][]byte, count)
+	for i := range messages {
+		messages[i] = message
+	}
+	return messages
+}
+
+func TestMaliciousPeersAreBannedForEachKindOfViolation(t *testing.T) {
+	sender := MakeRSA(1024)
+	valid := MakeSignedTransaction(sender.PublicKeyString(), AddressOfPublicKey(MakeRSA(1024).PublicKeyString()), 10, ConvertBigIntToString(&sender.d))
+
+	config := DefaultMisbehaviourConfig()
+	cases := []struct {
+		violation Violation
+		messages  func(peer *Peer) [][]byte
+	}{
+		{InvalidBlock, func(peer *Peer) [][]byte { return invalidBlocks(peer, 5) }},
+		{MalformedMessage, func(peer *Peer) [][]byte { return repeatedMessage([]byte(`{"ID": not json]`), 10) }},
+		{InvalidTransaction, func(peer *Peer) [][]byte { return forgedTransactions(peer, *valid, 20) }},
+		{InvalidTransaction, func(peer *Peer) [][]byte { return toInvalidAddresses(peer, sender, 20) }},
+		{InvalidTransaction, func(peer *Peer) [][]byte { return forgedMultisigProposals(peer, 20) }},
+		{DuplicateMessage, func(peer *Peer) [][]byte { return repeatedMessage(peer.MarshalTransaction(*valid), 80) }},
+	}
+	for _, c := range cases {
+		transportStrategy := MakePipeTransportStrategy()
+		peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+		peer.slotNumber = 1 //past the genesis block, so blocks are verified
+		malicious := connectMaliciousPeer(t, transportStrategy, peer.ip+":"+peer.port)
+
+		messages := c.messages(peer)
+		allowed := (config.MaxScore+config.Penalties[c.violation]-1)/config.Penalties[c.violation] - 1
+		if c.violation == DuplicateMessage {
+			allowed++ //the first copy is fine
+		}
+		malicious.send(transportStrategy, messages[:allowed])
+		if malicious.hungUpWithin(500 * time.Millisecond) {
+			t.Error("A peer should be allowed", allowed, "of", c.violation, "before it is banned")
+		}
+		malicious.send(transportStrategy, messages[allowed:])
+		if !malicious.hungUpWithin(5 * time.Second) {
+			t.Error("A peer sending a", c.violation, "should be banned after", allowed+1, "messages")
+		}
+		if peer.InboundCount() != 0 {
+			t.Error("The banned peer should be removed from the connections")
+		}
+		//the ban is on the host, so it cannot just connect again
+		again := connectMaliciousPeer(t, transportStrategy, peer.ip+":"+peer.port)
+		if !again.hungUpWithin(5 * time.Second) {
+			t.Error("A banned peer should not be able to connect again")
+		}
+		listener.Close()
+	}
+	fmt.Println("TestMaliciousPeersAreBannedForEachKindOfViolation passed")
+}
+
+func TestBanEndsAfterBanDuration(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener.Close()
+	config := DefaultMisbehaviourConfig()
+	config.BanDuration = 300 * time.Millisecond
+	peer.SetMisbehaviourConfig(config)
+
+	malicious := connectMaliciousPeer(t, transportStrategy, peer.ip+":"+peer.port)
+	malicious.send(transportStrategy, repeatedMessage([]byte(`not json]`), 5))
+	if !malicious.hungUpWithin(5 * time.Second) {
+		t.Fatal("The peer should have been banned")
+	}
+	time.Sleep(500 * time.Millisecond)
+	again := connectMaliciousPeer(t, transportStrategy, peer.ip+":"+peer.port)
+	if again.hungUpWithin(500 * time.Millisecond) {
+		t.Error("The peer should be able to connect again once the ban has ended")
+	}
+	if !waitUntil(func() bool { return peer.InboundCount() == 1 }, 5*time.Second) {
+		t.Fatal("The new connection was not accepted")
+	}
+	if peer.MisbehaviourScore(peer.GetConnections()[0]) != config.MaxScore {
+		t.Error("The new connection should start with a clean score")
+	}
+	fmt.Println("TestBanEndsAfterBanDuration passed")
+}
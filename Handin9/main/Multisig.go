@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A multisig account is defined by n public keys and a threshold k. Its "public key" is the policy string
+//"multisig:<k>:<key 1>,...,<key n>" (keys sorted), so its address commits to both the keys and the threshold.
+//A transaction from it carries the policy as PublicKey and one signature per signer in Signatures, keyed by the
+//index of the signer's key in the policy. Until k signers have signed, the transaction is passed around the
+//network as a proposal (see Peer.HandleMultisigProposal) and every peer that owns one of the keys can add its signature.
+//Proposals are kept by MultisigProposalHash, so one that reuses the ID of another can not take its place. A peer only
+//keeps proposals for accounts it signs for or that have the money, and only a few from each connection, so making up
+//policies does not fill the pending set.
+const multisigPrefix = "multisig:"
+
+const (
+	maxMultisigProposals              = 1000
+	maxMultisigProposalsPerConnection = 20
+	multisigProposalTimeout           = time.Hour
+)
+
+type MultisigPolicy struct {
+	Threshold  int
+	PublicKeys []string
+}
+
+func MakeMultisigPolicy(threshold int, publicKeys []string) (*MultisigPolicy, error) {
+	policy := new(MultisigPolicy)
+	policy.Threshold = threshold
+	policy.PublicKeys = append([]string{}, publicKeys...)
+	sort.Strings(policy.PublicKeys)
+	if threshold < 1 || threshold > len(publicKeys) {
+		return nil, errors.New("the threshold must be between 1 and the number of keys")
+	}
+	for i, publicKey := range policy.PublicKeys {
+		if publicKey == "" || strings.Contains(publicKey, ",") || IsMultisigPublicKey(publicKey) {
+			return nil, errors.New("not a valid public key for a multisig account: " + publicKey)
+		}
+		if i > 0 && policy.PublicKeys[i-1] == publicKey {
+			return nil, errors.New("a key can only be in a multisig account once")
+		}
+	}
+	return policy, nil
+}
+
+func ParseMultisigPolicy(publicKey string) (*MultisigPolicy, error) {
+	if !IsMultisigPublicKey(publicKey) {
+		return nil, errors.New("not a multisig account")
+	}
+	threshold, keys, found := strings.Cut(strings.TrimPrefix(publicKey, multisigPrefix), ":")
+	k, err := strconv.Atoi(threshold)
+	if !found || err != nil {
+		return nil, errors.New("not a valid multisig policy")
+	}
+	policy, err := MakeMultisigPolicy(k, strings.Split(keys, ","))
+	if err != nil {
+		return nil, err
+	}
+	//only the canonical form is accepted, otherwise one policy would have many addresses
+	if policy.String() != publicKey {
+		return nil, errors.New("multisig keys are not sorted")
+	}
+	return policy, nil
+}
+
+func IsMultisigPublicKey(publicKey string) bool {
+	return strings.HasPrefix(publicKey, multisigPrefix)
+}
+
+func (policy *MultisigPolicy) String() string {
+	return multisigPrefix + strconv.Itoa(policy.Threshold) + ":" + strings.Join(policy.PublicKeys, ",")
+}
+
+func (policy *MultisigPolicy) Address() Address {
+	return AddressOfPublicKey(policy.String())
+}
+
+//signerIndex is the index of the key in the policy, or -1 if it is not one of the signers
+func (policy *MultisigPolicy) signerIndex(publicKey string) int {
+	index := sort.SearchStrings(policy.PublicKeys, publicKey)
+	if index == len(policy.PublicKeys) || policy.PublicKeys[index] != publicKey {
+		return -1
+	}
+	return index
+}
+
+//MakeMultisigTransaction makes an unsigned transaction from the multisig account, the signers add their signatures with SignMultisigTransaction
+func MakeMultisigTransaction(policy *MultisigPolicy, to Address, amount int) *SignedTransaction {
+	transaction := MakeUnsignedTransaction(policy.Address(), to, amount)
+	transaction.PublicKey = policy.String()
+	return transaction
+}
+
+//SignMultisigTransaction adds this account's signature, it fails if the account is not one of the signers
+func (rsa *RSA) SignMultisigTransaction(transaction *SignedTransaction) error {
+	policy, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil {
+		return err
+	}
+	index := policy.signerIndex(rsa.PublicKeyString())
+	if index < 0 {
+		return errors.New("this account is not a signer of the multisig account")
+	}
+	signatures := make(map[int]string)
+	for signer, signature := range transaction.Signatures {
+		signatures[signer] = signature
+	}
+	signatures[index] = rsa.SignMessage(transactionSigningString(*transaction, rsa.SignatureAlgorithm()))
+	transaction.Signatures = signatures
+	return nil
+}
+
+//ValidMultisigSignatures returns the signatures that verify under their signer's key, invalid ones are dropped
+func ValidMultisigSignatures(transaction SignedTransaction) map[int]string {
+	valid := make(map[int]string)
+	policy, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil || policy.Address() != transaction.From {
+		return valid
+	}
+	verifier := new(RSA)
+	for signer, signature := range transaction.Signatures {
+		if signer < 0 || signer >= len(policy.PublicKeys) {
+			continue
+		}
+		algorithm, _ := DecodeSignature(signature)
+		if verifier.VerifySignature(transactionSigningString(transaction, algorithm), signature, policy.PublicKeys[signer]) {
+			valid[signer] = signature
+		}
+	}
+	return valid
+}
+
+//MultisigProposalHash names a proposal by everything the signers sign, but not by the signatures collected so far
+func MultisigProposalHash(transaction SignedTransaction) string {
+	contents, _ := json.Marshal([]string{transaction.ID, string(transaction.From), string(transaction.To), strconv.Itoa(transaction.Amount), transaction.PublicKey})
+	hashed := sha256.Sum256(contents)
+	return hex.EncodeToString(hashed[:])
+}
+
+//multisigItem names a proposal with the signatures it has so far, a connection that sends the same one twice is
+//sending a duplicate
+func multisigItem(transaction SignedTransaction) string {
+	signers := make([]int, 0, len(transaction.Signatures))
+	for signer := range transaction.Signatures {
+		signers = append(signers, signer)
+	}
+	sort.Ints(signers)
+	return multisigPrefix + MultisigProposalHash(transaction) + ":" + strings.Trim(fmt.Sprint(signers), "[]")
+}
+
+//MultisigApproved is true when at least threshold different signers have signed the transaction
+func MultisigApproved(transaction SignedTransaction) bool {
+	policy, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil {
+		return false
+	}
+	return len(ValidMultisigSignatures(transaction)) >= policy.Threshold
+}
+
+//MergeMultisigSignatures adds the valid signatures of other to transaction, it returns true if any were new
+func MergeMultisigSignatures(transaction *SignedTransaction, other SignedTransaction) bool {
+	if other.ID != transaction.ID || other.From != transaction.From || other.To != transaction.To ||
+		other.Amount != transaction.Amount || other.PublicKey != transaction.PublicKey {
+		DefaultLogger.For("multisig").Warn("Got two different multisig transactions with the same ID, ignoring the second", "id", transaction.ID)
+		return false
+	}
+	merged := ValidMultisigSignatures(*transaction)
+	added := false
+	for signer, signature := range ValidMultisigSignatures(other) {
+		if _, found := merged[signer]; !found {
+			merged[signer] = signature
+			added = true
+		}
+	}
+	transaction.Signatures = merged
+	return added
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultisigAccountsNeedThresholdSignatures(t *testing.T) {
+	alice := MakeRSA(2048)
+	bob := MakeEd25519Account()
+	carol := MakeRSA(2048)
+	policy, err := MakeMultisigPolicy(2, []string{alice.PublicKeyString(), bob.PublicKeyString(), carol.PublicKeyString()})
+	if err != nil {
+		t.Fatal("Could not make the policy:", err)
+	}
+	if _, err := MakeMultisigPolicy(4, policy.PublicKeys); err == nil {
+		t.Error("A threshold above the number of keys should be rejected")
+	}
+	ledger := MakeLedger()
+	ledger.AddGenesisAccount(policy.Address())
+
+	transaction := MakeMultisigTransaction(policy, AddressOfPublicKey("receiver"), 100)
+	alice.SignMultisigTransaction(transaction)
+	verifier := new(RSA)
+	if verifier.VerifyTransaction(*transaction) || ledger.Transaction(transaction) {
+		t.Error("One signature should not be enough for a 2-of-3 account")
+	}
+	alice.SignMultisigTransaction(transaction)
+	if verifier.VerifyTransaction(*transaction) {
+		t.Error("The same signer twice should not be enough")
+	}
+	if MakeRSA(2048).SignMultisigTransaction(transaction) == nil {
+		t.Error("Someone who is not a signer should not be able to sign")
+	}
+	bob.SignMultisigTransaction(transaction)
+	if !verifier.VerifyTransaction(*transaction) || !ledger.Transaction(transaction) {
+		t.Error("Two signatures should be enough for a 2-of-3 account")
+	}
+
+	//lowering the threshold gives another address, so the signatures no longer match the account
+	lowered, _ := MakeMultisigPolicy(1, policy.PublicKeys)
+	weakened := *transaction
+	weakened.PublicKey = lowered.String()
+	if verifier.VerifyTransaction(weakened) {
+		t.Error("Changing the policy of a transaction should make it invalid")
+	}
+	fmt.Println("TestMultisigAccountsNeedThresholdSignatures passed")
+}
+
+func TestPeersCollectMultisigSignaturesOverTheNetwork(t *testing.T) {
+	proposer := peerFixture()
+	signer := peerFixture()
+	policy, _ := MakeMultisigPolicy(2, []string{proposer.rsa.PublicKeyString(), signer.rsa.PublicKeyString()})
+
+	transaction := MakeMultisigTransaction(policy, AddressOfPublicKey("receiver"), 10)
+	proposer.ProposeMultisigTransaction(*transaction)
+	sent := proposer.messageSendingStrategy.(*StubbedMessageSendingStrategy).messagesSent[transaction.ID].transaction
+	if len(sent.Signatures) != 1 {
+		t.Fatal("The proposer should send the transaction with its own signature")
+	}
+
+	//the wire format ends every message at the first ']', so the signatures must not contain one
+	received, err := signer.DemarshalTransaction(proposer.MarshalTransaction(sent))
+	if err != nil || len(received.Signatures) != 1 {
+		t.Fatal("The proposal did not survive marshalling:", err)
+	}
+	signer.HandleMultisigProposal(nil, received)
+	pending := signer.PendingMultisigTransactions()
+	hash := MultisigProposalHash(*transaction)
+	if len(pending) != 1 || pending[hash].ID != transaction.ID {
+		t.Fatal("The signer should keep the proposal until the user approves it")
+	}
+	shown := make(chan SignedTransaction, 1)
+	go func() {
+		approved, _ := signer.ApproveMultisigTransaction(hash)
+		shown <- approved
+	}()
+	select {
+	case approved := <-signer.outbound:
+		if !signer.rsa.VerifyTransaction(approved) {
+			t.Error("The approved transaction should verify")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The transaction was not sent out after it got enough signatures")
+	}
+	if approved := <-shown; approved.To != transaction.To || approved.Amount != transaction.Amount {
+		t.Error("Approving should show what is signed, got", approved)
+	}
+	if len(signer.PendingMultisigTransactions()) != 0 {
+		t.Error("An approved transaction should not be pending any more")
+	}
+	fmt.Println("TestPeersCollectMultisigSignaturesOverTheNetwork passed")
+}
+
+func TestMultisigProposalsAreKeptByTheirContents(t *testing.T) {
+	proposer := peerFixture()
+	signer := peerFixture()
+	policy, _ := MakeMultisigPolicy(2, []string{proposer.rsa.PublicKeyString(), signer.rsa.PublicKeyString()})
+
+	//nobody signed it, so it is not kept
+	unsigned := MakeMultisigTransaction(policy, AddressOfPublicKey("receiver"), 10)
+	signer.HandleMultisigProposal(nil, *unsigned)
+	if len(signer.PendingMultisigTransactions()) != 0 {
+		t.Error("A proposal without a signature from one of the signers should not be kept")
+	}
+
+	honest := MakeMultisigTransaction(policy, AddressOfPublicKey("receiver"), 10)
+	proposer.rsa.SignMultisigTransaction(honest)
+	signer.HandleMultisigProposal(nil, *honest)
+	//the same ID paying someone else must not replace the honest proposal or its approval
+	replaced := *honest
+	replaced.To = AddressOfPublicKey("thief")
+	replaced.Signatures = nil
+	proposer.rsa.SignMultisigTransaction(&replaced)
+	signer.HandleMultisigProposal(nil, replaced)
+
+	pending := signer.PendingMultisigTransactions()
+	if len(pending) != 2 || pending[MultisigProposalHash(*honest)].To != honest.To {
+		t.Error("Proposals with the same ID and different contents should be kept apart, got", pending)
+	}
+	if _, err := signer.ApproveMultisigTransaction("unknown"); err == nil {
+		t.Error("Approving a proposal that is not pending should fail")
+	}
+
+	for _, proposal := range signer.multisigPending {
+		proposal.received = time.Now().Add(-2 * multisigProposalTimeout)
+	}
+	if len(signer.PendingMultisigTransactions()) != 0 {
+		t.Error("Proposals should expire")
+	} else {
+		fmt.Println("TestMultisigProposalsAreKeptByTheirContents passed")
+	}
+}
+
+func TestPeersOnlyKeepMultisigProposalsTheyCareAbout(t *testing.T) {
+	peer := peerFixture()
+	proposer := MakeRSA(1024)
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+
+	//neither signers nor money on the account, so this is just a way to fill our memory
+	unfunded, _ := MakeMultisigPolicy(2, []string{proposer.PublicKeyString(), MakeRSA(1024).PublicKeyString()})
+	proposal := MakeMultisigTransaction(unfunded, AddressOfPublicKey("receiver"), 10)
+	proposer.SignMultisigTransaction(proposal)
+	peer.HandleMultisigProposal(conn, *proposal)
+	if len(peer.PendingMultisigTransactions()) != 0 {
+		t.Error("A proposal that does not involve us from an account without money should not be kept")
+	}
+
+	//a funded account is kept, but only up to the limit for each connection
+	funded, _ := MakeMultisigPolicy(2, []string{proposer.PublicKeyString(), MakeRSA(1024).PublicKeyString()})
+	peer.ledger.AddGenesisAccount(funded.Address())
+	for i := 0; i < maxMultisigProposalsPerConnection+5; i++ {
+		proposal := MakeMultisigTransaction(funded, AddressOfPublicKey("receiver"), 10+i)
+		proposer.SignMultisigTransaction(proposal)
+		peer.HandleMultisigProposal(conn, *proposal)
+	}
+	if pending := len(peer.PendingMultisigTransactions()); pending != maxMultisigProposalsPerConnection {
+		t.Error("Expected", maxMultisigProposalsPerConnection, "proposals from one connection, got", pending)
+	}
+	proposal = MakeMultisigTransaction(funded, AddressOfPublicKey("receiver"), 1)
+	proposer.SignMultisigTransaction(proposal)
+	peer.HandleMultisigProposal(other, *proposal)
+	if pending := len(peer.PendingMultisigTransactions()); pending != maxMultisigProposalsPerConnection+1 {
+		t.Error("A full connection should not keep out proposals from another, got", pending)
+	} else {
+		fmt.Println("TestPeersOnlyKeepMultisigProposalsTheyCareAbout passed")
+	}
+}
+
+func TestWalletProposesAndSignsMultisigTransactions(t *testing.T) {
+	receiver := AddressOfPublicKey(MakeRSA(1024).PublicKeyString())
+	proposerWallet := MakeWallet(t.TempDir())
+	proposerInput := MakeWalletUserInputStrategy(proposerWallet, "alice", strings.NewReader("y\npw\npw\npw\n"))
+	signerWallet := MakeWallet(t.TempDir())
+	signerInput := MakeWalletUserInputStrategy(signerWallet, "carol", strings.NewReader("y\npw\npw\npw\n"))
+	if proposerInput.Setup() != nil || signerInput.Setup() != nil {
+		t.Fatal("Could not set up the wallets")
+	}
+	proposer := createPeerWithUserInput(proposerInput)
+	proposer.SetupAccount()
+	signer := createPeerWithUserInput(signerInput)
+	signer.SetupAccount()
+	proposerKey, _ := proposerWallet.PublicKey("alice")
+	signerKey, _ := signerWallet.PublicKey("carol")
+
+	//m proposes, then the user quits
+	proposerInput.reader = bufio.NewReader(strings.NewReader("m\n2\n" + proposerKey + "," + signerKey + "\n" + string(receiver) + "\n7\n"))
+	proposerInput.UseMultisigSigner(proposer)
+	if _, err := proposerInput.HandleIncomingFromUser(); err != ErrUserQuit {
+		t.Error("Expected the user to quit after proposing, got", err)
+	}
+	var proposal SignedTransaction
+	for _, message := range proposer.messageSendingStrategy.(*StubbedMessageSendingStrategy).messagesSent {
+		proposal = message.transaction
+	}
+	if proposal.To != receiver || proposal.Amount != 7 || len(proposal.Signatures) != 1 {
+		t.Fatal("The proposal with the proposer's signature should be sent, got", proposal)
+	}
+
+	//s shows the pending proposal and signs the one the user picks
+	signer.HandleMultisigProposal(nil, proposal)
+	signerInput.reader = bufio.NewReader(strings.NewReader("s\n" + MultisigProposalHash(proposal) + "\n"))
+	signerInput.UseMultisigSigner(signer)
+	go signerInput.HandleIncomingFromUser()
+	select {
+	case approved := <-signer.outbound:
+		if approved.To != receiver || !signer.rsa.VerifyTransaction(approved) {
+			t.Error("The signed transaction should verify, got", approved)
+		} else {
+			fmt.Println("TestWalletProposesAndSignsMultisigTransactions passed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The transaction was not sent out after the user signed it")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//A peer listens on one address and tells the others another one: behind a NAT the port is forwarded from the router,
+//and a peer bound to every interface (0.0.0.0 or ::) has to name one address the others can dial. Without either the
+//peer binds the outbound IP on a free port and tells the others that, like before.
+//
+//URIs are host:port with IPv6 hosts in brackets, e.g. [::1]:5000. Messages on the wire end in ']', so a ']' inside a
+//message is written as \u005d, which json.Unmarshal turns back into ']'. Lists of strings carrying URIs are marshalled
+//with MarshalList, presences escape the URI with escapeDelimiter.
+
+const escapedDelimiter = `\u005d`
+
+func escapeDelimiter(s string) string {
+	return strings.ReplaceAll(s, "]", escapedDelimiter)
+}
+
+func unescapeDelimiter(s string) string {
+	return strings.ReplaceAll(s, escapedDelimiter, "]")
+}
+
+//MarshalList marshals a list of strings like json.Marshal, with only its closing bracket left as a ']'
+func MarshalList(list []string) []byte {
+	if list == nil {
+		list = []string{} //not null, that has no closing bracket
+	}
+	marshalled, _ := json.Marshal(list)
+	body := escapeDelimiter(string(marshalled[:len(marshalled)-1]))
+	return []byte(body + "]")
+}
+
+func validateHostPort(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 {
+		return errors.New("the port of " + address + " must be a number from 0 to 65535")
+	}
+	return nil
+}
+
+//SetListenAddress is the host:port to bind, an empty host binds every interface and port 0 picks a free port.
+//Must be called before Start
+func (peer *Peer) SetListenAddress(address string) error {
+	if address != "" {
+		err := validateHostPort(address)
+		if err != nil {
+			return err
+		}
+	}
+	peer.listenAddress = address
+	return nil
+}
+
+//SetAdvertisedAddress is the host:port the other peers dial, an empty host is the outbound IP and port 0 the port we
+//listen on. Must be called before Start
+func (peer *Peer) SetAdvertisedAddress(address string) error {
+	if address != "" {
+		err := validateHostPort(address)
+		if err != nil {
+			return err
+		}
+	}
+	peer.advertisedAddress = address
+	return nil
+}
+
+//URI is the address we tell the other peers
+func (peer *Peer) URI() string {
+	return net.JoinHostPort(peer.ip, peer.port)
+}
+
+func isUnspecifiedHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+//bindAddress is where listen binds, with no listen address that is the outbound IP
+func (peer *Peer) bindAddress() string {
+	if peer.listenAddress == "" {
+		return net.JoinHostPort(peer.outboundIPStrategy.GetOutboundIP(), "0")
+	}
+	return peer.listenAddress
+}
+
+//advertise works out the host and port we tell the others, once we listen on the port
+func (peer *Peer) advertise(bindHost string, port string) (string, string) {
+	host, advertisedPort := splitListenAddress(peer.advertisedAddress)
+	if host == "" {
+		host = bindHost
+		if isUnspecifiedHost(bindHost) {
+			host = peer.outboundIPStrategy.GetOutboundIP()
+		}
+	}
+	if advertisedPort == "0" {
+		advertisedPort = port
+	}
+	return host, advertisedPort
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooseOutboundIPPrefersReachableAddresses(t *testing.T) {
+	loopback4, loopback6 := net.ParseIP("127.0.0.1"), net.ParseIP("::1")
+	linkLocal, global6, private4 := net.ParseIP("fe80::1"), net.ParseIP("2001:db8::5"), net.ParseIP("192.168.1.20")
+	cases := []struct {
+		ips      []net.IP
+		expected string
+	}{
+		{[]net.IP{loopback4, loopback6, linkLocal, global6, private4}, "192.168.1.20"},
+		{[]net.IP{loopback4, linkLocal, global6}, "2001:db8::5"},
+		{[]net.IP{loopback6, linkLocal, loopback4}, "127.0.0.1"},
+		{[]net.IP{linkLocal, loopback6}, "::1"},
+		{[]net.IP{}, "127.0.0.1"},
+	}
+	for _, c := range cases {
+		if chosen := ChooseOutboundIP(c.ips); chosen != c.expected {
+			t.Error("Expected", c.expected, "to be chosen from", c.ips, "but got", chosen)
+		}
+	}
+	fmt.Println("TestChooseOutboundIPPrefersReachableAddresses passed")
+}
+
+func TestOutboundIPIsFoundWithoutARoute(t *testing.T) {
+	//offline the UDP dial fails and the interfaces are asked instead, online it just works
+	for _, strategy := range []OutboundIPStrategy{new(RealOutboundIPStrategy), new(InterfaceOutboundIPStrategy)} {
+		ip := strategy.GetOutboundIP()
+		if net.ParseIP(ip) == nil {
+			t.Errorf("%T should give an IP address, got %q", strategy, ip)
+		}
+	}
+	fmt.Println("TestOutboundIPIsFoundWithoutARoute passed")
+}
+
+func TestListAndPresenceWithIPv6URIsSurviveTheFraming(t *testing.T) {
+	list := []string{"[::1]:5000", "[2001:db8::5]:6000", addressGossipDelimiter}
+	presence := escapeDelimiter("[::1]:5000") + "uri]"
+	reader := bufio.NewReader(bytes.NewReader(append(MarshalList(list), presence...)))
+
+	message, err := reader.ReadBytes(']')
+	var received []string
+	if err != nil || json.Unmarshal(message, &received) != nil || strings.Join(received, ",") != strings.Join(list, ",") {
+		t.Error("The list should be read back as one message, got", string(message))
+	}
+	message, err = reader.ReadBytes(']')
+	if err != nil || unescapeDelimiter(strings.TrimSuffix(string(message), "uri]")) != "[::1]:5000" {
+		t.Error("The presence should be read back as one message, got", string(message))
+	}
+	fmt.Println("TestListAndPresenceWithIPv6URIsSurviveTheFraming passed")
+}
+
+func startIPv6Peer(t *testing.T, transportStrategy TransportStrategy, join string) *Peer {
+	host, port, _ := net.SplitHostPort(join)
+	inputStrategy := &quittingInputStrategy{account: MakeRSA(1024), quit: make(chan bool)}
+	peer := MakePeer(MakeFixedUriStrategy(host, port), inputStrategy, MakeFixedOutboundIPStrategy("::1"), MakeStubbedMessageSendingStrategy(), transportStrategy)
+	peer.SetHeartbeatConfig(fastHeartbeatConfig())
+	err := peer.Start(context.Background())
+	if err != nil {
+		t.Fatal("Could not start the peer:", err)
+	}
+	return peer
+}
+
+func TestPeersFindEachOtherOverIPv6(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer1 := startIPv6Peer(t, transportStrategy, "[::1]:0")
+	defer peer1.Stop()
+	if peer1.URI() != "[::1]:"+peer1.port {
+		t.Fatal("An IPv6 URI should have the host in brackets, got", peer1.URI())
+	}
+	peer2 := startIPv6Peer(t, transportStrategy, peer1.URI())
+	defer peer2.Stop()
+	peer3 := startIPv6Peer(t, transportStrategy, peer2.URI())
+	defer peer3.Stop()
+
+	//peer1 learns about peer3 from a presence, peer3 about peer1 from the addresses peer2 sends it
+	learned := func() bool {
+		return peer1.peerTable.Contains(peer2.URI()) && peer1.peerTable.Contains(peer3.URI()) && peer3.peerTable.Contains(peer1.URI())
+	}
+	if !waitUntil(learned, 5*time.Second) {
+		t.Error("The peers should learn each other's IPv6 URIs, peer1 knows", peer1.peerTable.URIs(), "and peer3", peer3.peerTable.URIs())
+	}
+	fmt.Println("TestPeersFindEachOtherOverIPv6 passed")
+}
+
+func TestPeerListensOnOneAddressAndAdvertisesAnother(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not find a free port:", err)
+	}
+	_, freePort, _ := net.SplitHostPort(free.Addr().String())
+	free.Close()
+
+	cases := []struct {
+		listen    string
+		advertise string
+		expected  func(port string) string //the URI we tell the others, given the port we listen on
+	}{
+		{"127.0.0.1:" + freePort, "203.0.113.7:7000", func(port string) string { return "203.0.113.7:7000" }},
+		{"0.0.0.0:0", "", func(port string) string { return "192.0.2.1:" + port }},
+		{"127.0.0.1:0", ":7000", func(port string) string { return "127.0.0.1:7000" }},
+		{"[::1]:0", "", func(port string) string { return "[::1]:" + port }},
+	}
+	for _, c := range cases {
+		peer := MakePeer(MakeFixedUriStrategy("localhost", "0"), MakeFixedInputStrategy(SignedTransaction{}), MakeFixedOutboundIPStrategy("192.0.2.1"), MakeStubbedMessageSendingStrategy(), MakeTCPTransportStrategy())
+		if peer.SetListenAddress(c.listen) != nil || peer.SetAdvertisedAddress(c.advertise) != nil {
+			t.Fatal("Could not set the addresses", c.listen, "and", c.advertise)
+		}
+		listener, err := peer.listen()
+		if err != nil {
+			if strings.Contains(c.listen, "::") {
+				t.Log("No IPv6 loopback on this machine:", err)
+				continue
+			}
+			t.Fatal("Could not listen on", c.listen, err)
+		}
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		if c.listen == "127.0.0.1:"+freePort && port != freePort {
+			t.Error("The peer should listen on the port it was given, it listens on", port)
+		}
+		if peer.URI() != c.expected(port) {
+			t.Error("Listening on", c.listen, "and advertising", c.advertise, "should give", c.expected(port), "but gave", peer.URI())
+		}
+		listener.Close()
+	}
+
+	peer := MakePeer(MakeFixedUriStrategy("localhost", "0"), MakeFixedInputStrategy(SignedTransaction{}), MakeFixedOutboundIPStrategy("localhost"), MakeStubbedMessageSendingStrategy(), MakeTCPTransportStrategy())
+	for _, invalid := range []string{"localhost", "localhost:http", "localhost:70000", "::1:5000"} {
+		if peer.SetListenAddress(invalid) == nil || peer.SetAdvertisedAddress(invalid) == nil {
+			t.Error(invalid, "should not be accepted as an address")
+		}
+	}
+	fmt.Println("TestPeerListensOnOneAddressAndAdvertisesAnother passed")
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net"
 )
 
@@ -14,14 +13,56 @@ type RealOutboundIPStrategy struct {
 
 // Get preferred outbound IP of this machine
 // From SO: https://stackoverflow.com/a/37382208 (thank you, Simon :D)
+//Dialing UDP sends nothing, it only asks the OS which address it would use. Without a route (offline) we ask the
+//network interfaces instead
 func (outboundIPStrategy *RealOutboundIPStrategy) GetOutboundIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+	for _, target := range []string{"8.8.8.8:80", "[2001:4860:4860::8888]:80"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		localAddr := conn.LocalAddr().(*net.UDPAddr)
+		conn.Close()
+		return localAddr.IP.String()
+	}
+	return new(InterfaceOutboundIPStrategy).GetOutboundIP()
+}
+
+//InterfaceOutboundIPStrategy picks an address of one of the network interfaces, see ChooseOutboundIP
+type InterfaceOutboundIPStrategy struct {
+}
+
+func (outboundIPStrategy *InterfaceOutboundIPStrategy) GetOutboundIP() string {
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		fmt.Println("Mistake while trying to get own IP")
+		DefaultLogger.For("peer").Warn("Could not list the network interfaces", "error", err)
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, isIPNet := addr.(*net.IPNet); isIPNet {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ChooseOutboundIP(ips)
+}
+
+//ChooseOutboundIP prefers an address other machines can reach: IPv4 before IPv6, then loopback so a machine without a
+//network can still run peers on its own. Link-local IPv6 addresses need a zone to be dialed, so they are skipped
+func ChooseOutboundIP(ips []net.IP) string {
+	preferences := []func(ip net.IP) bool{
+		func(ip net.IP) bool { return ip.IsGlobalUnicast() && ip.To4() != nil },
+		func(ip net.IP) bool { return ip.IsGlobalUnicast() },
+		func(ip net.IP) bool { return ip.IsLoopback() && ip.To4() != nil },
+		func(ip net.IP) bool { return ip.IsLoopback() },
+	}
+	for _, preferred := range preferences {
+		for _, ip := range ips {
+			if preferred(ip) {
+				return ip.String()
+			}
+		}
 	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+	return "127.0.0.1"
 }
 
 type FixedOutboundIPStrategy struct {
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//The peer table holds a bounded number of addresses of other peers. A peer learns addresses when it joins, from
+//presence announcements and from the random subsets its neighbours send it every GossipInterval. Addresses we
+//could connect to gain score and addresses we could not connect to lose it, so when the table is full a stale
+//address is replaced first, and an address that keeps failing is forgotten. The table can be saved when the peer
+//stops and loaded when it starts again, see SetStateFile.
+
+const addressGossipDelimiter = "addr"
+const maxAddressScore = 10
+const staleAddressScore = -3 //an address is forgotten when its score drops to this
+
+type PeerTableConfig struct {
+	MaxAddresses   int           //addresses kept in the table
+	TargetOutbound int           //connections we dial and keep up
+	MaxInbound     int           //connections we accept, a peer connecting after that only gets some addresses
+	GossipSize     int           //addresses sent to a joining peer and in every exchange
+	GossipInterval time.Duration //how often we send addresses to a random neighbour and dial more peers if needed
+}
+
+func DefaultPeerTableConfig() PeerTableConfig {
+	return PeerTableConfig{MaxAddresses: 1000, TargetOutbound: 10, MaxInbound: 40, GossipSize: 30, GossipInterval: 30 * time.Second}
+}
+
+func (config PeerTableConfig) Validate() error {
+	if config.MaxAddresses <= 0 || config.TargetOutbound < 0 || config.MaxInbound < 0 || config.GossipSize <= 0 || config.GossipInterval <= 0 {
+		return errors.New("the peer table needs room for addresses and a positive gossip size and interval")
+	}
+	return nil
+}
+
+type peerAddress struct {
+	uri      string
+	score    int
+	lastSeen time.Time //when we last connected to it or heard about it
+	key      string    //the account key of the peer, if an authenticating transport told us when we connected to it
+}
+
+//savedAddress is how an address is written to the state file
+type savedAddress struct {
+	URI   string `json:"uri"`
+	Score int    `json:"score"`
+}
+
+type PeerTable struct {
+	config    PeerTableConfig
+	self      string //our own address, it is never dialed or evicted
+	addresses map[string]*peerAddress
+	order     []string //the addresses in the order they were added
+	mutex     *sync.Mutex
+	random    *rand.Rand
+}
+
+func MakePeerTable(config PeerTableConfig) *PeerTable {
+	table := new(PeerTable)
+	table.config = config
+	table.addresses = make(map[string]*peerAddress)
+	table.order = make([]string, 0)
+	table.mutex = &sync.Mutex{}
+	table.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return table
+}
+
+func (table *PeerTable) Config() PeerTableConfig {
+	return table.config
+}
+
+func (table *PeerTable) SetSelf(uri string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.self = uri
+	if table.addresses[uri] == nil {
+		table.insert(uri)
+	}
+}
+
+//Add returns true if the address was new and there was room for it
+func (table *PeerTable) Add(uri string) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	if uri == "" {
+		return false
+	}
+	if address, found := table.addresses[uri]; found {
+		address.lastSeen = time.Now()
+		return false
+	}
+	if len(table.order) >= table.config.MaxAddresses && !table.evict() {
+		return false
+	}
+	table.insert(uri)
+	return true
+}
+
+//AddAll adds the addresses and returns the ones that were new
+func (table *PeerTable) AddAll(uris []string) []string {
+	added := make([]string, 0)
+	for _, uri := range uris {
+		if table.Add(uri) {
+			added = append(added, uri)
+		}
+	}
+	return added
+}
+
+func (table *PeerTable) insert(uri string) {
+	table.addresses[uri] = &peerAddress{uri: uri, lastSeen: time.Now()}
+	table.order = append(table.order, uri)
+}
+
+//evict removes the address with the lowest score, the least recently seen of those. Addresses that we have
+//connected to are kept over new ones. The mutex must be held
+func (table *PeerTable) evict() bool {
+	var worst *peerAddress
+	for _, address := range table.addresses {
+		if address.uri == table.self {
+			continue
+		}
+		if worst == nil || address.score < worst.score || (address.score == worst.score && address.lastSeen.Before(worst.lastSeen)) {
+			worst = address
+		}
+	}
+	if worst == nil || worst.score > 0 {
+		return false
+	}
+	table.remove(worst.uri)
+	return true
+}
+
+func (table *PeerTable) Remove(uri string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.remove(uri)
+}
+
+func (table *PeerTable) remove(uri string) {
+	if table.addresses[uri] == nil || uri == table.self {
+		return
+	}
+	delete(table.addresses, uri)
+	for index, listed := range table.order {
+		if listed == uri {
+			table.order = append(table.order[:index], table.order[index+1:]...)
+			break
+		}
+	}
+}
+
+//MarkReachable is called when we connected to the address
+func (table *PeerTable) MarkReachable(uri string) {
+	table.Add(uri)
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	address, found := table.addresses[uri]
+	if !found {
+		return
+	}
+	if address.score < maxAddressScore {
+		address.score++
+	}
+	address.lastSeen = time.Now()
+}
+
+//MarkFailed is called when we could not connect to the address, it returns true if the address was forgotten
+func (table *PeerTable) MarkFailed(uri string) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	address, found := table.addresses[uri]
+	if !found || uri == table.self {
+		return false
+	}
+	if address.score > 0 {
+		address.score = 0 //it used to work, but we should not prefer it anymore
+	}
+	address.score--
+	if address.score <= staleAddressScore {
+		table.remove(uri)
+		return true
+	}
+	return false
+}
+
+//SetKey remembers the account key that the peer at the address proved it owns
+func (table *PeerTable) SetKey(uri string, key string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	address, found := table.addresses[uri]
+	if found {
+		address.key = key
+	}
+}
+
+//Key returns the account key of the peer at the address, or "" if we do not know it
+func (table *PeerTable) Key(uri string) string {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	address, found := table.addresses[uri]
+	if !found {
+		return ""
+	}
+	return address.key
+}
+
+func (table *PeerTable) Score(uri string) (int, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	address, found := table.addresses[uri]
+	if !found {
+		return 0, false
+	}
+	return address.score, true
+}
+
+func (table *PeerTable) Contains(uri string) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return table.addresses[uri] != nil
+}
+
+func (table *PeerTable) Len() int {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return len(table.order)
+}
+
+//URIs returns all addresses in the order they were added
+func (table *PeerTable) URIs() ConnectionsURI {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return append(ConnectionsURI{}, table.order...)
+}
+
+//Sample returns up to n random addresses to send to another peer, leaving out addresses we could not connect to
+func (table *PeerTable) Sample(n int) ConnectionsURI {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	sample := make(ConnectionsURI, 0, len(table.order))
+	for _, uri := range table.order {
+		if table.addresses[uri].score >= 0 {
+			sample = append(sample, uri)
+		}
+	}
+	table.random.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	if len(sample) > n {
+		sample = sample[:n]
+	}
+	return sample
+}
+
+//DialCandidates returns up to n addresses to connect to, best score first and in random order among equal scores
+func (table *PeerTable) DialCandidates(n int, exclude map[string]bool) []string {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	candidates := make([]*peerAddress, 0)
+	for _, uri := range table.order {
+		if uri != table.self && !exclude[uri] {
+			candidates = append(candidates, table.addresses[uri])
+		}
+	}
+	table.random.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	uris := make([]string, 0, n)
+	for i := 0; i < len(candidates) && i < n; i++ {
+		uris = append(uris, candidates[i].uri)
+	}
+	return uris
+}
+
+//Save writes the addresses and their scores to the file, leaving out our own address
+func (table *PeerTable) Save(filename string) error {
+	table.mutex.Lock()
+	saved := make([]savedAddress, 0, len(table.order))
+	for _, uri := range table.order {
+		if uri != table.self {
+			saved = append(saved, savedAddress{URI: uri, Score: table.addresses[uri].score})
+		}
+	}
+	table.mutex.Unlock()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	//write to a temporary file first, so a crash while saving does not lose the old table
+	err = os.WriteFile(filename+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+//Load adds the addresses saved in the file, a missing file is an empty table
+func (table *PeerTable) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []savedAddress
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return err
+	}
+	for _, address := range saved {
+		table.Add(address.URI)
+		table.mutex.Lock()
+		if table.addresses[address.URI] != nil {
+			table.addresses[address.URI].score = address.Score
+		}
+		table.mutex.Unlock()
+	}
+	return nil
+}
+
+//Address gossip is sent like a block, as a list of addresses ending in a delimiter
+func MakeAddressGossip(uris []string) Block {
+	return append(append(Block{}, uris...), addressGossipDelimiter)
+}
+
+func IsAddressGossip(block Block) bool {
+	return len(block) > 0 && block[len(block)-1] == addressGossipDelimiter
+}
+
+//SetPeerTableConfig must be called before Start, it starts with an empty table
+func (peer *Peer) SetPeerTableConfig(config PeerTableConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	peer.peerTable = MakePeerTable(config)
+	return nil
+}
+
+func (peer *Peer) AppendToOutboundConnections(conn net.Conn, uri string) {
+	peer.connectionsMutex.Lock()
+	peer.connections = append(peer.connections, conn)
+	peer.outboundURIs[conn] = uri
+	peer.addSendQueue(conn)
+	peer.connectionsMutex.Unlock()
+}
+
+func (peer *Peer) OutboundCount() int {
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	return len(peer.outboundURIs)
+}
+
+func (peer *Peer) InboundCount() int {
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	return len(peer.connections) - len(peer.outboundURIs)
+}
+
+//ConnectToMorePeers dials addresses from the peer table until we have TargetOutbound outbound connections
+func (peer *Peer) ConnectToMorePeers() {
+	peer.connectionsMutex.Lock()
+	missing := peer.peerTable.Config().TargetOutbound - len(peer.outboundURIs)
+	connected := make(map[string]bool)
+	for _, uri := range peer.outboundURIs {
+		connected[uri] = true
+	}
+	peer.connectionsMutex.Unlock()
+	if missing <= 0 {
+		return
+	}
+	for _, uri := range peer.peerTable.DialCandidates(missing, connected) {
+		if peer.ConnectToPeer(uri) {
+			peer.logger.Debug("Connected to peer from the peer table", "uri", uri)
+		} else {
+			peer.logger.Debug("Could not connect to peer from the peer table", "uri", uri)
+		}
+	}
+}
+
+//GossipAddresses sends a random sample of our peer table to a random neighbour
+func (peer *Peer) GossipAddresses() {
+	peer.connectionsMutex.Lock()
+	if len(peer.connections) == 0 {
+		peer.connectionsMutex.Unlock()
+		return
+	}
+	neighbour := peer.connections[rand.Intn(len(peer.connections))]
+	peer.connectionsMutex.Unlock()
+
+	sample := peer.peerTable.Sample(peer.peerTable.Config().GossipSize)
+	marshalled := MarshalList(MakeAddressGossip(sample))
+	peer.SendBlock(neighbour, marshalled)
+}
+
+//HandleAddressGossip adds the addresses to the peer table, unlike a presence they are not passed on
+func (peer *Peer) HandleAddressGossip(gossip Block) {
+	added := peer.peerTable.AddAll(gossip[:len(gossip)-1])
+	peer.logger.Debug("Got addresses from a neighbour", "new", len(added), "peers", peer.peerTable.Len())
+}
+
+func (peer *Peer) HandlePeerTable() {
+	for peer.sleep(peer.peerTable.Config().GossipInterval) {
+		peer.GossipAddresses()
+		peer.ConnectToMorePeers()
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPeerTableIsBoundedAndReplacesStaleAddresses(t *testing.T) {
+	config := DefaultPeerTableConfig()
+	config.MaxAddresses = 3
+	table := MakePeerTable(config)
+	table.SetSelf("self:1")
+	table.Add("a:1")
+	table.Add("b:1")
+	table.MarkReachable("a:1")
+
+	//b has never been reached, so it makes room for c
+	if !table.Add("c:1") || table.Contains("b:1") || table.Len() != 3 {
+		t.Error("A new address should replace the address we never reached, table is", table.URIs())
+	}
+	table.MarkReachable("c:1")
+	if table.Add("d:1") || table.Len() != 3 {
+		t.Error("Addresses we have reached should not be replaced by new ones, table is", table.URIs())
+	}
+	if !table.Contains("self:1") {
+		t.Error("Our own address should never be evicted")
+	}
+	fmt.Println("TestPeerTableIsBoundedAndReplacesStaleAddresses passed")
+}
+
+func TestPeerTableForgetsAddressesThatKeepFailing(t *testing.T) {
+	table := MakePeerTable(DefaultPeerTableConfig())
+	table.Add("gone:1")
+	table.Add("alive:1")
+	table.MarkReachable("alive:1")
+	table.MarkFailed("gone:1")
+	for _, uri := range table.Sample(10) {
+		if uri == "gone:1" {
+			t.Error("An address we could not reach should not be gossiped")
+		}
+	}
+	if candidates := table.DialCandidates(2, nil); len(candidates) != 2 || candidates[0] != "alive:1" {
+		t.Error("The reachable address should be dialed first, got", candidates)
+	}
+	table.MarkFailed("gone:1")
+	if forgotten := table.MarkFailed("gone:1"); !forgotten || table.Contains("gone:1") {
+		t.Error("An address that failed three times should be forgotten")
+	}
+	fmt.Println("TestPeerTableForgetsAddressesThatKeepFailing passed")
+}
+
+func TestPeerTableSamplesRandomSubsets(t *testing.T) {
+	table := MakePeerTable(DefaultPeerTableConfig())
+	for i := 0; i < 50; i++ {
+		table.Add("localhost:" + strconv.Itoa(i))
+	}
+	first := table.Sample(10)
+	second := table.Sample(10)
+	seen := make(map[string]bool)
+	for _, uri := range first {
+		seen[uri] = true
+	}
+	if len(first) != 10 || len(seen) != 10 {
+		t.Fatal("Expected 10 different addresses, got", first)
+	}
+	if testEq(first, second) {
+		t.Error("Two samples of 10 out of 50 addresses should differ")
+	}
+	fmt.Println("TestPeerTableSamplesRandomSubsets passed")
+}
+
+func withPeerTableConfig(config PeerTableConfig) func(peer *Peer) {
+	return func(peer *Peer) {
+		peer.SetPeerTableConfig(config)
+	}
+}
+
+func TestPeersKeepToTheirConnectionTargets(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	config := DefaultPeerTableConfig()
+	config.TargetOutbound = 2
+	config.MaxInbound = 2
+	first, firstListener := createPeerWithTransport("fa", "fa", transportStrategy, withPeerTableConfig(config))
+	defer firstListener.Close()
+	peers := []*Peer{first}
+	for i := 0; i < 5; i++ {
+		peer, listener := createPeerWithTransport(first.ip, first.port, transportStrategy, withPeerTableConfig(config))
+		defer listener.Close()
+		peers = append(peers, peer)
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for i, peer := range peers {
+		if peer.InboundCount() > config.MaxInbound || peer.OutboundCount() > config.TargetOutbound {
+			t.Error("Peer", i, "has", peer.InboundCount(), "inbound and", peer.OutboundCount(), "outbound connections")
+		}
+	}
+	//the last peer was turned away by the first, but got addresses from it to connect to instead,
+	//HandlePeerTable would dial them on its next round
+	last := peers[len(peers)-1]
+	last.ConnectToMorePeers()
+	if last.OutboundCount() != config.TargetOutbound {
+		t.Error("The last peer should have found", config.TargetOutbound, "peers to connect to, it has", last.OutboundCount())
+	}
+	fmt.Println("TestPeersKeepToTheirConnectionTargets passed")
+}
+
+func TestPeersLearnAddressesThroughGossip(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer1, listener1 := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener1.Close()
+	peer2, listener2 := createPeerWithTransport(peer1.ip, peer1.port, transportStrategy)
+	defer listener2.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	//addresses peer1 learned after peer2 joined, so only gossip can tell peer2 about them
+	peer1.peerTable.AddAll([]string{"localhost:1001", "localhost:1002"})
+	peer1.GossipAddresses()
+	time.Sleep(100 * time.Millisecond)
+	if !peer2.peerTable.Contains("localhost:1001") || !peer2.peerTable.Contains("localhost:1002") {
+		t.Error("peer2 should have learned the addresses, it knows", peer2.peerTable.URIs())
+	}
+	fmt.Println("TestPeersLearnAddressesThroughGossip passed")
+}
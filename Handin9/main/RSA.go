@@ -2,20 +2,38 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
+	cryptorsa "crypto/rsa"
 	"crypto/sha256"
-	"fmt"
+	"io"
 	"math/big"
 	"strconv"
 	"strings"
 )
 
 type RSA struct {
-	d big.Int
-	e *big.Int
-	n big.Int
-	p *big.Int
-	q *big.Int
+	d       big.Int
+	e       *big.Int
+	n       big.Int
+	p       *big.Int
+	q       *big.Int
+	private *cryptorsa.PrivateKey //set for keys with e=65537 that sign with RSA-PSS, nil for legacy textbook keys with e=3
+	ed25519 ed25519.PrivateKey    //set for Ed25519 accounts (see Ed25519.go), which have no RSA key at all
+	logger  *Logger               //nil for the many RSA objects only used to verify, they log to DefaultLogger
+}
+
+var defaultRSALogger = DefaultLogger.For("rsa")
+
+func (rsa *RSA) SetLogger(logger *Logger) {
+	rsa.logger = logger
+}
+
+func (rsa *RSA) log() *Logger {
+	if rsa.logger == nil {
+		return defaultRSALogger
+	}
+	return rsa.logger
 }
 
 func MakeRSA(k int) *RSA {
@@ -24,15 +42,51 @@ func MakeRSA(k int) *RSA {
 	return rsa
 }
 
+//MakeLegacyRSA makes a textbook RSA key with e=3, only the genesis keys may sign with those (see LegacySignaturesAllowed)
+func MakeLegacyRSA(k int) *RSA {
+	rsa := new(RSA)
+	rsa.LegacyKeyGen(k)
+	return rsa
+}
+
+//MakeRSAWithKeys works for both kinds of keys, if d belongs to e=65537 the primes are found again so the key can use RSA-PSS
 func MakeRSAWithKeys(n string, d string) *RSA {
 	rsa := new(RSA)
 	rsa.n = *ConvertStringToBigInt(n)
 	rsa.d = *ConvertStringToBigInt(d)
 	rsa.e = big.NewInt(3)
+	p, q := recoverPrimes(&rsa.n, &rsa.d, rsaPublicExponent)
+	if p != nil {
+		rsa.e = big.NewInt(rsaPublicExponent)
+		rsa.p = p
+		rsa.q = q
+		rsa.usePrimes()
+	}
+	return rsa
+}
+
+//MakeRSAFromReader only uses random for the key, so the same random bytes always give the same key pair
+func MakeRSAFromReader(k int, random io.Reader) *RSA {
+	rsa := new(RSA)
+	rsa.KeyGenFrom(k, random)
 	return rsa
 }
 
+//KeyGen makes an RSA-PSS key with e=65537 using crypto/rsa
 func (rsa *RSA) KeyGen(k int) {
+	private, err := cryptorsa.GenerateKey(rand.Reader, k)
+	if err != nil {
+		panic(err)
+	}
+	rsa.private = private
+	rsa.e = big.NewInt(int64(private.E))
+	rsa.n.Set(private.N)
+	rsa.d.Set(private.D)
+	rsa.p = private.Primes[0]
+	rsa.q = private.Primes[1]
+}
+
+func (rsa *RSA) LegacyKeyGen(k int) {
 	//bit length of p*q = n is k
 	rsa.e = big.NewInt(3) //given by the hand-in text
 	rsa.p = rsa.GeneratePrime(k)
@@ -41,6 +95,35 @@ func (rsa *RSA) KeyGen(k int) {
 	rsa.d = rsa.GenerateD()
 }
 
+//MakeLegacyRSAFromReader gives the textbook e=3 key that MakeRSAFromReader gave before RSA-PSS, for version 1 seed phrases
+func MakeLegacyRSAFromReader(k int, random io.Reader) *RSA {
+	rsa := new(RSA)
+	rsa.LegacyKeyGenFrom(k, random)
+	return rsa
+}
+
+func (rsa *RSA) KeyGenFrom(k int, random io.Reader) {
+	rsa.e = big.NewInt(rsaPublicExponent)
+	rsa.primesFrom(k, random)
+	rsa.usePrimes()
+}
+
+func (rsa *RSA) LegacyKeyGenFrom(k int, random io.Reader) {
+	rsa.e = big.NewInt(3)
+	rsa.primesFrom(k, random)
+}
+
+//primesFrom depends on e, the primes are chosen so that gcd(prime-1, e) is 1
+func (rsa *RSA) primesFrom(k int, random io.Reader) {
+	rsa.p = rsa.GeneratePrimeFrom(k, random)
+	rsa.q = rsa.GeneratePrimeFrom(k, random)
+	for rsa.p.Cmp(rsa.q) == 0 {
+		rsa.q = rsa.GeneratePrimeFrom(k, random)
+	}
+	rsa.n.Mul(rsa.p, rsa.q)
+	rsa.d = rsa.GenerateD()
+}
+
 func (rsa *RSA) Encrypt(message *big.Int) big.Int {
 	//c = m ^ e mod n
 	var cipher big.Int
@@ -73,7 +156,7 @@ func (rsa *RSA) GeneratePrime(k int) *big.Int {
 	for {
 		prime, err := rand.Prime(rand.Reader, k/2) //set the length to k/2
 		if err != nil {
-			fmt.Print(err) //print the error and try again
+			rsa.log().Warn("Could not generate a prime, trying again", "error", err)
 			continue
 		}
 		var x big.Int
@@ -81,8 +164,32 @@ func (rsa *RSA) GeneratePrime(k int) *big.Int {
 		mutuallyPrime := (x) //this is (prime-1) mod e
 
 		//prime should be a probable prime
-		//mutuallyPrime should not be 0 (this means that gcd(prime-1,e) is 1 when e=3)
-		if prime.ProbablyPrime(1) && (mutuallyPrime.Cmp(big.NewInt(0)) != 0) {
+		//mutuallyPrime should not be 0 (this means that gcd(prime-1,e) is 1 when e is a prime)
+		if prime.ProbablyPrime(20) && (mutuallyPrime.Cmp(big.NewInt(0)) != 0) {
+			return prime
+		}
+	}
+}
+
+//GeneratePrimeFrom does not use rand.Prime, which may mix in its own randomness, so a seeded DRBG gives the same prime every time
+func (rsa *RSA) GeneratePrimeFrom(k int, random io.Reader) *big.Int {
+	bits := k / 2
+	candidate := make([]byte, (bits+7)/8)
+	for {
+		_, err := io.ReadFull(random, candidate)
+		if err != nil {
+			panic(err) //a DRBG never runs out, so this can only be a programming error
+		}
+		prime := big.NewInt(0).SetBytes(candidate)
+		//cut it to the right length, set the two top bits so p*q has k bits, and make it odd
+		prime.Rsh(prime, uint(len(candidate)*8-bits))
+		prime.SetBit(prime, bits-1, 1)
+		prime.SetBit(prime, bits-2, 1)
+		prime.SetBit(prime, 0, 1)
+
+		var x big.Int
+		x.Mod(x.Sub(prime, big.NewInt(1)), rsa.e)
+		if prime.ProbablyPrime(20) && (x.Cmp(big.NewInt(0)) != 0) {
 			return prime
 		}
 	}
@@ -118,73 +225,80 @@ func (rsa *RSA) FullSign(message string, keyN big.Int, keyD big.Int) *big.Int {
 }
 
 func (rsa *RSA) FullSignTransaction(transaction *SignedTransaction, keyN string, keyD string) {
-	n := ConvertStringToBigInt(keyN)
-	d := ConvertStringToBigInt(keyD)
-	stringToSign := transaction.ID + transaction.From + transaction.To + strconv.Itoa(transaction.Amount)
-	transaction.Signature = ConvertBigIntToString(rsa.FullSign(stringToSign, *n, *d))
+	MakeRSAWithKeys(keyN, keyD).SignTransaction(transaction)
 }
 
-func (rsa *RSA) VerifyTransaction(transaction SignedTransaction) bool {
-	stringToVerify := transaction.ID + transaction.From + transaction.To + strconv.Itoa(transaction.Amount)
-
-	signature := ConvertStringToBigInt(transaction.Signature)
+//SignTransaction uses the account's own signature algorithm, the algorithm is part of the signature
+func (rsa *RSA) SignTransaction(transaction *SignedTransaction) {
+	transaction.PublicKey = rsa.PublicKeyString()
+	transaction.Signature = rsa.SignMessage(transactionSigningString(*transaction, rsa.SignatureAlgorithm()))
+}
 
-	keyN := *ConvertStringToBigInt(transaction.From)
+//VerifyTransaction needs the public key in the transaction (see AddressRegistry.Resolve), and it must belong to From.
+//Transactions from multisig accounts need signatures from at least threshold of the signers
+func (rsa *RSA) VerifyTransaction(transaction SignedTransaction) bool {
+	if transaction.PublicKey == "" || AddressOfPublicKey(transaction.PublicKey) != transaction.From {
+		return false
+	}
+	if IsMultisigPublicKey(transaction.PublicKey) {
+		return MultisigApproved(transaction)
+	}
+	algorithm, _ := DecodeSignature(transaction.Signature)
+	return rsa.VerifySignature(transactionSigningString(transaction, algorithm), transaction.Signature, transaction.PublicKey)
+}
 
-	verified := rsa.VerifyWithKey(stringToVerify, *signature, keyN, big.NewInt(3))
-	return verified
+//the algorithm is signed along with the transaction, except for legacy signatures which were made before it existed
+func transactionSigningString(transaction SignedTransaction, algorithm string) string {
+	signingString := transaction.ID + string(transaction.From) + string(transaction.To) + strconv.Itoa(transaction.Amount)
+	if algorithm == SignatureAlgorithmLegacyRSA {
+		return signingString
+	}
+	return algorithm + ":" + signingString
 }
 
 func (rsa *RSA) FullSignBlock(block Block, keyN big.Int, keyD big.Int) Block {
 	stringToSign := strings.Join(block, ":")
-	fmt.Println("The string to sign:", stringToSign)
-	signature := ConvertBigIntToString(rsa.FullSign(stringToSign, keyN, keyD))
+	rsa.log().Debug("Signing block", "string", stringToSign)
+	signature := MakeRSAWithKeys(ConvertBigIntToString(&keyN), ConvertBigIntToString(&keyD)).SignMessage(stringToSign)
 	block = append(block, signature)
 	return block
 }
 
 func (rsa *RSA) CreateBlockSignature(slotNumber int, nextBlockData Block, prevBlockHash string) string {
 	toSign := "BLOCK" + ":" + strconv.Itoa(slotNumber) + ":" + strings.Join(nextBlockData, ":") + ":" + prevBlockHash
-	return ConvertBigIntToString(rsa.FullSign(toSign, rsa.n, rsa.d))
+	return rsa.SignMessage(toSign)
 }
 
-//Verify that decrypting sigma with keyN gives the checkString
+//Verify that sigma is a signature of the checkString under keyN
 func (rsa *RSA) VerifyBlockSignature(slotNumber int, nextBlockData Block, prevBlockHash string, sigma string, keyN string) bool {
 	checkString := "BLOCK" + ":" + strconv.Itoa(slotNumber) + ":" + strings.Join(nextBlockData, ":") + ":" + prevBlockHash
-	keyNreal := *ConvertStringToBigInt(keyN)
-	verified := rsa.VerifyWithKey(checkString, *ConvertStringToBigInt(sigma), keyNreal, big.NewInt(3)) //Decrypt sigma with keyNreal and check that it gives checkString
-	return verified
+	return rsa.VerifySignature(checkString, sigma, keyN)
 }
 
 func (rsa *RSA) VerifyDraw(draw string, slotNumber int, seed int, keyN string) bool {
-	fmt.Println("Verifying draw: ")
-	fmt.Println("slotNumber: ", slotNumber)
-	fmt.Println("seed: ", seed)
-	fmt.Println("keyN: ", keyN)
-
 	checkString := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(slotNumber)
-	fmt.Println("checkString", checkString)
-	keyNreal := *ConvertStringToBigInt(keyN)
-	verified := rsa.VerifyWithKey(checkString, *ConvertStringToBigInt(draw), keyNreal, big.NewInt(3))
-	return verified
+	rsa.log().Debug("Verifying draw", "slotNumber", slotNumber, "seed", seed, "keyN", keyN, "checkString", checkString)
+	//a randomized signature would let the winner sign again and again until the draw wins
+	if !IsDeterministicSignature(draw) {
+		return false
+	}
+	return rsa.VerifySignature(checkString, draw, keyN)
 }
 
 func (rsa *RSA) VerifyBlock(block Block, keyN string) bool {
-	signature := ConvertStringToBigInt(block[len(block)-2])
+	signature := block[len(block)-2]
 	block = block[:len(block)-2] //-2 because we also append the delimiter in the block
 	stringToVerify := strings.Join(block, ":")
-
-	keyNreal := *ConvertStringToBigInt(keyN)
-
-	verified := rsa.VerifyWithKey(stringToVerify, *signature, keyNreal, big.NewInt(3))
-	return verified
+	return rsa.VerifySignature(stringToVerify, signature, keyN)
 }
 
 func (rsa *RSA) VerifyWithKey(m string, s big.Int, keyN big.Int, keyE *big.Int) bool {
 	decryptedSignature := rsa.EncryptWithKey(&s, keyN, keyE)
-	fmt.Println("drawstring:", decryptedSignature)
 	hashed := Hash(m)
-	fmt.Println("checkString:", rsa.FullSign(m, keyN, *keyE))
+	//signing again only shows what the signature should have been, so it is only done when someone reads it
+	if rsa.log().Enabled(LevelDebug) {
+		rsa.log().Debug("Verifying with key", "drawstring", &decryptedSignature, "checkString", rsa.FullSign(m, keyN, *keyE))
+	}
 	var verified bool
 	if bytes.Equal(hashed.Bytes(), decryptedSignature.Bytes()) {
 		verified = true
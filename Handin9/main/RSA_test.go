@@ -70,18 +70,16 @@ func TestMakeSignedTransaction(t *testing.T) {
 	publicKey := ConvertBigIntToString(&rsa.n)
 	secretKeyD := ConvertBigIntToString(&rsa.d)
 	st := MakeSignedTransaction(publicKey, "test", 200, secretKeyD)
-	stringToSign := st.ID + st.From + st.To + strconv.Itoa(st.Amount)
-	signAsBig := rsa.FullSign(stringToSign, *ConvertStringToBigInt(publicKey), *ConvertStringToBigInt(secretKeyD))
-	sign := ConvertBigIntToString(signAsBig)
+	algorithm, _ := DecodeSignature(st.Signature)
 
-	if st.From != publicKey {
+	if st.From != AddressOfPublicKey(publicKey) || st.PublicKey != publicKey {
 		fmt.Println("TestMakeSignedTransaction Failed 1")
 	} else if st.To != "test" {
 		fmt.Println("TestMakeSignedTransaction Failed 2")
 	} else if st.Amount != 200 {
 		fmt.Println("TestMakeSignedTransaction Failed 3")
-	} else if st.Signature != sign {
-		fmt.Println("TestMakeSignedTransaction Failed 4, expected:", sign, "got ", st.Signature)
+	} else if algorithm != SignatureAlgorithmRSAPSS || !rsa.VerifyTransaction(*st) {
+		fmt.Println("TestMakeSignedTransaction Failed 4, expected an RSA-PSS signature, got ", st.Signature)
 	} else {
 		fmt.Println("TestMakeSignedTransaction Passed")
 	}
@@ -132,12 +130,10 @@ func TestSignVerifyBlock(t *testing.T) {
 func TestVerifyWinningBlock(t *testing.T) {
 	rsa := MakeRSA(2000)
 	peer := peerFixtureRSA(*rsa)
-	n := rsa.n
-	d := rsa.d
 	seed := 123456789
 	//hardness := 1
 	toSign := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(1) //seed, slot
-	draw := rsa.FullSign(toSign, n, d)
+	draw := rsa.SignDeterministic(toSign)
 
 	//HandleWinning block construction:
 	block := make([]string, 0)
@@ -146,7 +142,7 @@ func TestVerifyWinningBlock(t *testing.T) {
 	block = append(block, "BLOCK")                       //BLOCK
 	block = append(block, ConvertBigIntToString(&rsa.n)) //Public key / VK
 	block = append(block, strconv.Itoa(1))               //Slotnumber
-	block = append(block, ConvertBigIntToString(draw))   //Draw
+	block = append(block, draw)                          //Draw
 	prevBlockHash := "prevBlockHash"
 	block = append(block, prevBlockHash) //Hash
 	sigma := rsa.CreateBlockSignature(1, blockData, prevBlockHash)
@@ -171,8 +167,6 @@ func TestVerifyWinningBlock(t *testing.T) {
 
 func TestHardnessWinning(t *testing.T) {
 	rsa := MakeRSA(2000)
-	n := rsa.n
-	d := rsa.d
 	seed := 123456789
 	wins := 0
 	runs := 2000
@@ -182,7 +176,7 @@ func TestHardnessWinning(t *testing.T) {
 
 	for i := 0; i < runs; i++ {
 		toSign := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(i) //seed, slot
-		draw := ConvertBigIntToString(rsa.FullSign(toSign, n, d))
+		draw := rsa.SignDeterministic(toSign)
 		drawHash := Hash(draw)
 		PKaccount := big.NewInt(1000000)
 
@@ -203,9 +197,10 @@ func peerFixtureRSA(rsa RSA) *Peer {
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
+	transportStrategy := MakeTCPTransportStrategy()
 
-	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 	peer.hardness = *big.NewInt(1)
-	peer.ledger.AddGenesisAccount(ConvertBigIntToString(&rsa.n))
+	peer.ledger.AddGenesisAccount(rsa.Address())
 	return peer
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+//Every connection has its own queue and a writer goroutine that is the only one writing to it, so a broadcast only
+//puts the message in the queues and never waits for a slow peer. When the queue of a connection is full the peer at
+//the other end does not keep up: with DropMessages the message is dropped (with inventory gossip the peer asks another
+//neighbour that announced the item when a requested item does not come), with DisconnectSlowPeer the connection is
+//closed. A write that takes longer than WriteTimeout closes the connection with both policies. Connections we have not
+//added yet, e.g. one we turn away because we have enough, are written to directly.
+
+const outboundBufferSize = 256 //transactions from the user and from other peers waiting for SendMessages
+
+type SlowPeerPolicy int
+
+const (
+	DropMessages SlowPeerPolicy = iota
+	DisconnectSlowPeer
+)
+
+func (policy SlowPeerPolicy) String() string {
+	if policy == DisconnectSlowPeer {
+		return "disconnect"
+	}
+	return "drop"
+}
+
+func ParseSlowPeerPolicy(name string) (SlowPeerPolicy, error) {
+	switch name {
+	case "drop":
+		return DropMessages, nil
+	case "disconnect":
+		return DisconnectSlowPeer, nil
+	}
+	return DropMessages, errors.New("unknown slow peer policy " + name + ", use drop or disconnect")
+}
+
+type SendQueueConfig struct {
+	QueueSize    int //messages waiting to be written to each connection
+	Policy       SlowPeerPolicy
+	WriteTimeout time.Duration
+}
+
+func DefaultSendQueueConfig() SendQueueConfig {
+	return SendQueueConfig{QueueSize: 256, Policy: DropMessages, WriteTimeout: 10 * time.Second}
+}
+
+func (config SendQueueConfig) Validate() error {
+	if config.QueueSize <= 0 || config.WriteTimeout <= 0 {
+		return errors.New("the send queue size and the write timeout must be positive")
+	}
+	return nil
+}
+
+//SetSendQueueConfig applies to the connections made after it
+func (peer *Peer) SetSendQueueConfig(config SendQueueConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	peer.sendQueueConfig = config
+	return nil
+}
+
+type sendQueue struct {
+	messages     chan []byte
+	writeTimeout time.Duration
+	closed       bool      //no more messages are queued, guarded by connectionsMutex
+	done         chan bool //closed when the writer has written or given up on the last message
+}
+
+//addSendQueue starts the writer of a new connection, connectionsMutex must be held
+func (peer *Peer) addSendQueue(conn net.Conn) {
+	queue := &sendQueue{
+		messages:     make(chan []byte, peer.sendQueueConfig.QueueSize),
+		writeTimeout: peer.sendQueueConfig.WriteTimeout,
+		done:         make(chan bool),
+	}
+	peer.sendQueues[conn] = queue
+	go peer.writeQueue(conn, queue)
+}
+
+//closeSendQueue lets the writer finish what is queued and return, connectionsMutex must be held
+func (peer *Peer) closeSendQueue(queue *sendQueue) {
+	if !queue.closed {
+		queue.closed = true
+		close(queue.messages)
+	}
+}
+
+func (peer *Peer) writeQueue(conn net.Conn, queue *sendQueue) {
+	defer close(queue.done)
+	for message := range queue.messages {
+		conn.SetWriteDeadline(time.Now().Add(queue.writeTimeout))
+		err := peer.transportStrategy.Send(conn, message)
+		if err != nil {
+			peer.logger.Warn("Tried to send to a lost connection", "error", err)
+			conn.Close()
+			peer.DeleteFromConnections(conn)
+			//DeleteFromConnections closed the queue, what is left in it is dropped
+			for range queue.messages {
+			}
+			return
+		}
+	}
+}
+
+//send queues the message for the connection, see the top of the file for what happens when the queue is full
+func (peer *Peer) send(conn net.Conn, message []byte) {
+	peer.connectionsMutex.Lock()
+	queue, found := peer.sendQueues[conn]
+	if !found {
+		peer.connectionsMutex.Unlock()
+		err := peer.transportStrategy.Send(conn, message)
+		if err != nil {
+			peer.logger.Warn("Tried to send to a lost connection")
+			peer.DeleteFromConnections(conn)
+		}
+		return
+	}
+	if queue.closed {
+		peer.connectionsMutex.Unlock()
+		return
+	}
+	select {
+	case queue.messages <- message:
+		peer.connectionsMutex.Unlock()
+		return
+	default:
+	}
+	peer.droppedMessages++
+	policy := peer.sendQueueConfig.Policy
+	peer.connectionsMutex.Unlock()
+
+	if policy == DisconnectSlowPeer {
+		peer.logger.Warn("Disconnecting a peer that does not keep up", "remote", conn.RemoteAddr().String())
+		conn.Close()
+		peer.DeleteFromConnections(conn)
+	} else {
+		peer.logger.Debug("Dropped a message to a peer that does not keep up", "remote", conn.RemoteAddr().String())
+	}
+}
+
+//flushSendQueues waits until the queued messages are written or the write timeout passes, Stop calls it before closing
+//the connections so the leave message gets out
+func (peer *Peer) flushSendQueues() {
+	peer.connectionsMutex.Lock()
+	timeout := peer.sendQueueConfig.WriteTimeout
+	queues := make([]*sendQueue, 0, len(peer.sendQueues))
+	for _, queue := range peer.sendQueues {
+		peer.closeSendQueue(queue)
+		queues = append(queues, queue)
+	}
+	peer.connectionsMutex.Unlock()
+
+	deadline := time.After(timeout)
+	for _, queue := range queues {
+		select {
+		case <-queue.done:
+		case <-deadline:
+			peer.logger.Warn("Gave up writing the last messages to a slow peer")
+			return
+		}
+	}
+}
+
+//DroppedMessages counts the messages dropped because the queue of a connection was full
+func (peer *Peer) DroppedMessages() int {
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	return peer.droppedMessages
+}
+
+//QueuedMessages is how many messages are waiting to be written to the connection
+func (peer *Peer) QueuedMessages(conn net.Conn) int {
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	queue, found := peer.sendQueues[conn]
+	if !found {
+		return 0
+	}
+	return len(queue.messages)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//countingPeer reads everything the honest peer sends and counts the test messages
+type countingPeer struct {
+	received int
+	lock     *sync.Mutex
+}
+
+func connectCountingPeer(t *testing.T, transportStrategy TransportStrategy, uri string) *countingPeer {
+	counting := &countingPeer{lock: &sync.Mutex{}}
+	dialFakeNeighbour(t, transportStrategy, uri, func(message []byte) {
+		if strings.Contains(string(message), "queue-test") {
+			counting.lock.Lock()
+			counting.received++
+			counting.lock.Unlock()
+		}
+	})
+	return counting
+}
+
+func (counting *countingPeer) Received() int {
+	counting.lock.Lock()
+	defer counting.lock.Unlock()
+	return counting.received
+}
+
+//a slow peer dials and never reads, so with a small pipe buffer the writes to it block
+func connectSlowPeer(t *testing.T, transportStrategy TransportStrategy, uri string) net.Conn {
+	conn, _ := dialFakeNeighbour(t, transportStrategy, uri, nil)
+	return conn
+}
+
+func queueTestMessage() []byte {
+	return []byte(`["` + strings.Repeat("x", 200) + `","queue-test"]`)
+}
+
+//broadcast sends the messages a little apart, so the queue of a peer that reads keeps up
+func broadcastQueueTestMessages(peer *Peer, count int) {
+	for i := 0; i < count; i++ {
+		peer.SendBlockToAllPeers(queueTestMessage())
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func startQueueTest(t *testing.T, config SendQueueConfig) (*Peer, net.Listener, *countingPeer) {
+	transportStrategy := MakePipeTransportStrategy()
+	transportStrategy.SetBufferSize(1024)
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	peer.SetSendQueueConfig(config)
+	fast := connectCountingPeer(t, transportStrategy, peer.ip+":"+peer.port)
+	connectSlowPeer(t, transportStrategy, peer.ip+":"+peer.port)
+	if !waitUntil(func() bool { return peer.InboundCount() == 2 }, 5*time.Second) {
+		t.Fatal("The peers were not accepted")
+	}
+	return peer, listener, fast
+}
+
+func TestSlowPeerDoesNotStallBroadcastsToOthers(t *testing.T) {
+	config := SendQueueConfig{QueueSize: 50, Policy: DropMessages, WriteTimeout: time.Minute}
+	peer, listener, fast := startQueueTest(t, config)
+	defer listener.Close()
+
+	start := time.Now()
+	broadcastQueueTestMessages(peer, 100)
+	if time.Since(start) > 2*time.Second {
+		t.Error("Broadcasting should not wait for the slow peer, it took", time.Since(start))
+	}
+	if !waitUntil(func() bool { return fast.Received() == 100 }, 5*time.Second) {
+		t.Error("The fast peer should get every message, it got", fast.Received())
+	}
+	if peer.DroppedMessages() == 0 {
+		t.Error("The messages that did not fit in the queue of the slow peer should be dropped")
+	}
+	if peer.InboundCount() != 2 {
+		t.Error("With the drop policy the slow peer should stay connected")
+	}
+	fmt.Println("TestSlowPeerDoesNotStallBroadcastsToOthers passed")
+}
+
+func TestSlowPeerIsDisconnectedWhenItsQueueIsFull(t *testing.T) {
+	config := SendQueueConfig{QueueSize: 50, Policy: DisconnectSlowPeer, WriteTimeout: time.Minute}
+	peer, listener, fast := startQueueTest(t, config)
+	defer listener.Close()
+
+	broadcastQueueTestMessages(peer, 100)
+	if !waitUntil(func() bool { return peer.InboundCount() == 1 }, 5*time.Second) {
+		t.Error("The slow peer should be disconnected")
+	}
+	if !waitUntil(func() bool { return fast.Received() == 100 }, 5*time.Second) {
+		t.Error("The fast peer should get every message, it got", fast.Received())
+	}
+	fmt.Println("TestSlowPeerIsDisconnectedWhenItsQueueIsFull passed")
+}
+
+func TestWriteTimeoutClosesAStuckConnection(t *testing.T) {
+	config := SendQueueConfig{QueueSize: 100, Policy: DropMessages, WriteTimeout: 200 * time.Millisecond}
+	peer, listener, fast := startQueueTest(t, config)
+	defer listener.Close()
+
+	broadcastQueueTestMessages(peer, 20)
+	if !waitUntil(func() bool { return peer.InboundCount() == 1 }, 5*time.Second) {
+		t.Error("A connection whose writes time out should be closed")
+	}
+	if peer.DroppedMessages() != 0 || fast.Received() != 20 {
+		t.Error("Nothing should be dropped while the queues have room, dropped", peer.DroppedMessages(), "and the fast peer got", fast.Received())
+	}
+	fmt.Println("TestWriteTimeoutClosesAStuckConnection passed")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	cryptorsa "crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Signatures are sent as "<algorithm>:<hex signature>", so old and new signatures can be told apart while accounts migrate.
+//Signatures without a prefix are the old textbook RSA signatures (decimal m^d mod n with e=3), which are only accepted
+//from the genesis keys and only while LegacySignaturesAllowed is set.
+const (
+	SignatureAlgorithmLegacyRSA = "rsa-legacy"
+	SignatureAlgorithmRSAPSS    = "rsa-pss-sha256"
+	SignatureAlgorithmRSAPKCS1  = "rsa-pkcs1v15-sha256" //deterministic, used for lottery draws
+)
+
+const rsaPublicExponent = 65537
+
+//LegacySignaturesAllowed is turned on with -legacy-signatures for a network that still runs on the genesis accounts.
+//Each key is tied to one algorithm: the genesis keys only sign with textbook RSA and every other RSA key only with the
+//padded schemes. Otherwise a key would have two deterministic signatures, and so two lottery tickets, per slot: a genesis
+//holder can factor n from d and sign with PKCS #1 v1.5, and some e=65537 keys also have an inverse of 3.
+var LegacySignaturesAllowed = false
+
+var genesisKeys map[string]bool
+var genesisKeysOnce sync.Once
+
+func IsGenesisKey(keyN string) bool {
+	genesisKeysOnce.Do(func() {
+		genesisKeys = make(map[string]bool)
+		for number := 1; number <= 10; number++ {
+			genesisKeys[GenesisRSA(strconv.Itoa(number)).PublicKeyString()] = true
+		}
+	})
+	return genesisKeys[keyN]
+}
+
+//warnIfLegacySignaturesNotAllowed is called when the user picks a genesis account
+func warnIfLegacySignaturesNotAllowed() {
+	if !LegacySignaturesAllowed {
+		fmt.Println("The genesis keys sign with textbook RSA, start every peer with -legacy-signatures or nobody accepts them")
+	}
+}
+
+func EncodeSignature(algorithm string, signature []byte) string {
+	return algorithm + ":" + hex.EncodeToString(signature)
+}
+
+//DecodeSignature returns the algorithm and the signature bytes, for legacy signatures the bytes are those of the decimal number
+func DecodeSignature(signature string) (string, []byte) {
+	algorithm, encoded, found := strings.Cut(signature, ":")
+	if !found {
+		return SignatureAlgorithmLegacyRSA, ConvertStringToBigInt(signature).Bytes()
+	}
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil {
+		return algorithm, nil
+	}
+	return algorithm, decoded
+}
+
+func (rsa *RSA) SignatureAlgorithm() string {
+	if rsa.ed25519 != nil {
+		return SignatureAlgorithmEd25519
+	}
+	if rsa.private == nil {
+		return SignatureAlgorithmLegacyRSA
+	}
+	return SignatureAlgorithmRSAPSS
+}
+
+//SignMessage signs with RSA-PSS, or with textbook RSA if this is a legacy key
+func (rsa *RSA) SignMessage(message string) string {
+	if rsa.ed25519 != nil {
+		return rsa.signEd25519(message)
+	}
+	if rsa.private == nil {
+		return ConvertBigIntToString(rsa.FullSign(message, rsa.n, rsa.d))
+	}
+	hashed := sha256.Sum256([]byte(message))
+	signature, err := cryptorsa.SignPSS(rand.Reader, rsa.private, crypto.SHA256, hashed[:], nil)
+	if err != nil {
+		panic(err) //only fails for broken keys, which usePrimes already refuses
+	}
+	return EncodeSignature(SignatureAlgorithmRSAPSS, signature)
+}
+
+//SignDeterministic gives the same signature every time, which the lottery needs so a draw can not be redone for a better ticket.
+//RSA-PSS is randomized, so PKCS #1 v1.5 is used instead
+func (rsa *RSA) SignDeterministic(message string) string {
+	if rsa.ed25519 != nil {
+		return rsa.signEd25519(message)
+	}
+	if rsa.private == nil {
+		return ConvertBigIntToString(rsa.FullSign(message, rsa.n, rsa.d))
+	}
+	hashed := sha256.Sum256([]byte(message))
+	signature, err := cryptorsa.SignPKCS1v15(nil, rsa.private, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(err)
+	}
+	return EncodeSignature(SignatureAlgorithmRSAPKCS1, signature)
+}
+
+//VerifySignature checks a signature made by SignMessage or SignDeterministic under the public key keyN (see PublicKeyString).
+//Results are kept in VerificationCache
+func (rsa *RSA) VerifySignature(message string, signature string, keyN string) bool {
+	algorithm, _ := DecodeSignature(signature)
+	//checked before the cache, the flag can change after a legacy signature was cached
+	legacy := algorithm == SignatureAlgorithmLegacyRSA
+	if legacy != IsGenesisKey(keyN) || legacy && !LegacySignaturesAllowed {
+		return false
+	}
+	verified, found := VerificationCache.Lookup(message, signature, keyN)
+	if !found {
+		verified = rsa.verifySignatureUncached(message, signature, keyN)
+		VerificationCache.Store(message, signature, keyN, verified)
+	}
+	return verified
+}
+
+func (rsa *RSA) verifySignatureUncached(message string, signature string, keyN string) bool {
+	algorithm, signatureBytes := DecodeSignature(signature)
+	if algorithm == SignatureAlgorithmEd25519 {
+		return verifyEd25519(message, signatureBytes, keyN)
+	}
+	publicKey := &cryptorsa.PublicKey{N: ConvertStringToBigInt(keyN), E: rsaPublicExponent}
+	hashed := sha256.Sum256([]byte(message))
+	switch algorithm {
+	case SignatureAlgorithmLegacyRSA:
+		return rsa.VerifyWithKey(message, *big.NewInt(0).SetBytes(signatureBytes), *publicKey.N, big.NewInt(3))
+	case SignatureAlgorithmRSAPSS:
+		return publicKey.N.BitLen() >= 1024 && cryptorsa.VerifyPSS(publicKey, crypto.SHA256, hashed[:], signatureBytes, nil) == nil
+	case SignatureAlgorithmRSAPKCS1:
+		return publicKey.N.BitLen() >= 1024 && cryptorsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signatureBytes) == nil
+	}
+	return false
+}
+
+//IsDeterministicSignature is false for randomized schemes, which must not be used where the signature value matters (the lottery)
+func IsDeterministicSignature(signature string) bool {
+	algorithm, _ := DecodeSignature(signature)
+	return algorithm == SignatureAlgorithmLegacyRSA || algorithm == SignatureAlgorithmRSAPKCS1 || algorithm == SignatureAlgorithmEd25519
+}
+
+//usePrimes makes a crypto/rsa key from p and q, so the account can make standard signatures
+func (rsa *RSA) usePrimes() {
+	private := &cryptorsa.PrivateKey{
+		PublicKey: cryptorsa.PublicKey{N: new(big.Int).Set(&rsa.n), E: int(rsa.e.Int64())},
+		D:         new(big.Int).Set(&rsa.d),
+		Primes:    []*big.Int{rsa.p, rsa.q},
+	}
+	private.Precompute()
+	if private.Validate() == nil {
+		rsa.private = private
+	}
+}
+
+//recoverPrimes factors n from d when d belongs to the exponent e (the usual method from the RSA paper: e*d-1 is a multiple
+//of lcm(p-1, q-1), which lets us find a square root of 1 other than 1 and -1). It returns nil if d is not an inverse of e
+func recoverPrimes(n *big.Int, d *big.Int, e int64) (*big.Int, *big.Int) {
+	one := big.NewInt(1)
+	if n.BitLen() < 1024 || d.Sign() <= 0 {
+		return nil, nil
+	}
+	test := big.NewInt(2)
+	signed := new(big.Int).Exp(test, d, n)
+	if signed.Exp(signed, big.NewInt(e), n).Cmp(test) != 0 {
+		return nil, nil
+	}
+	k := new(big.Int).Mul(d, big.NewInt(e))
+	k.Sub(k, one)
+	oddPart := new(big.Int).Set(k)
+	for oddPart.Bit(0) == 0 {
+		oddPart.Rsh(oddPart, 1)
+	}
+	minusOne := new(big.Int).Sub(n, one)
+	for g := int64(2); g < 200; g++ {
+		x := new(big.Int).Exp(big.NewInt(g), oddPart, n)
+		for t := new(big.Int).Set(oddPart); t.Cmp(k) < 0; t.Lsh(t, 1) {
+			if x.Cmp(one) == 0 || x.Cmp(minusOne) == 0 {
+				break
+			}
+			squared := new(big.Int).Exp(x, big.NewInt(2), n)
+			if squared.Cmp(one) == 0 {
+				p := new(big.Int).GCD(nil, nil, x.Sub(x, one), n)
+				return p, new(big.Int).Div(n, p)
+			}
+			x = squared
+		}
+	}
+	return nil, nil
+}
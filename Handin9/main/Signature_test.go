@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestNewAccountsSignWithRSAPSS(t *testing.T) {
+	rsa := MakeRSA(2048)
+	publicKey := ConvertBigIntToString(&rsa.n)
+	if rsa.e.Int64() != 65537 {
+		t.Error("New keys should use e=65537, got", rsa.e)
+	}
+	signature := rsa.SignMessage("hello")
+	if !strings.HasPrefix(signature, SignatureAlgorithmRSAPSS+":") {
+		t.Error("Expected an RSA-PSS signature, got", signature)
+	}
+	if !rsa.VerifySignature("hello", signature, publicKey) {
+		t.Error("The RSA-PSS signature did not verify")
+	}
+	if rsa.VerifySignature("hellO", signature, publicKey) {
+		t.Error("The signature should not verify for another message")
+	}
+	if rsa.VerifySignature("hello", strings.Replace(signature, SignatureAlgorithmRSAPSS, SignatureAlgorithmRSAPKCS1, 1), publicKey) {
+		t.Error("Changing the algorithm of a signature should make it invalid")
+	}
+
+	//a key read back from n and d alone (e.g. from a keystore) should find its primes and keep signing with RSA-PSS
+	restored := MakeRSAWithKeys(publicKey, ConvertBigIntToString(&rsa.d))
+	if restored.SignatureAlgorithm() != SignatureAlgorithmRSAPSS || !rsa.VerifySignature("hello", restored.SignMessage("hello"), publicKey) {
+		t.Error("A restored key should sign with RSA-PSS")
+	}
+	fmt.Println("TestNewAccountsSignWithRSAPSS passed")
+}
+
+func TestLegacySignaturesCanBeTurnedOff(t *testing.T) {
+	genesis := GenesisRSA("1")
+	if genesis.SignatureAlgorithm() != SignatureAlgorithmLegacyRSA {
+		t.Error("The genesis keys should still be legacy keys")
+	}
+	transaction := MakeUnsignedTransaction(genesis.Address(), "someone", 10)
+	genesis.SignTransaction(transaction)
+	if !genesis.VerifyTransaction(*transaction) {
+		t.Error("A legacy signature should verify while legacy signatures are allowed")
+	}
+	LegacySignaturesAllowed = false
+	defer func() { LegacySignaturesAllowed = true }()
+	if genesis.VerifyTransaction(*transaction) {
+		t.Error("A legacy signature should be rejected when legacy signatures are turned off")
+	}
+	fmt.Println("TestLegacySignaturesCanBeTurnedOff passed")
+}
+
+func TestLotteryDrawsMustBeDeterministic(t *testing.T) {
+	rsa := MakeRSA(2048)
+	publicKey := ConvertBigIntToString(&rsa.n)
+	checkString := "LOTTERY:1:2"
+	draw := rsa.SignDeterministic(checkString)
+	if draw != rsa.SignDeterministic(checkString) {
+		t.Error("Signing the same draw twice should give the same signature")
+	}
+	if !rsa.VerifyDraw(draw, 2, 1, publicKey) {
+		t.Error("The deterministic draw did not verify")
+	}
+	if rsa.VerifyDraw(rsa.SignMessage(checkString), 2, 1, publicKey) {
+		t.Error("A randomized RSA-PSS draw should be rejected")
+	}
+	fmt.Println("TestLotteryDrawsMustBeDeterministic passed")
+}
+
+func TestEachKeyHasOneKindOfLotteryDraw(t *testing.T) {
+	checkString := "LOTTERY:1:2"
+	verifier := new(RSA)
+
+	//a genesis holder can factor n and sign with PKCS #1 v1.5 under the same modulus
+	genesis := GenesisRSA("1")
+	if !verifier.VerifyDraw(genesis.SignDeterministic(checkString), 2, 1, genesis.PublicKeyString()) {
+		t.Error("The textbook draw of a genesis key should verify while legacy signatures are allowed")
+	}
+	p, q := recoverPrimes(&genesis.n, &genesis.d, 3)
+	if p == nil {
+		t.Fatal("Could not factor the genesis key")
+	}
+	factored := &RSA{n: genesis.n, e: big.NewInt(rsaPublicExponent), p: p, q: q}
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, big.NewInt(1)), new(big.Int).Sub(q, big.NewInt(1)))
+	if factored.d.ModInverse(factored.e, phi) == nil {
+		t.Fatal("65537 has no inverse for this genesis key")
+	}
+	factored.usePrimes()
+	if verifier.VerifyDraw(factored.SignDeterministic(checkString), 2, 1, genesis.PublicKeyString()) {
+		t.Error("A genesis key should not get a second draw with PKCS #1 v1.5")
+	}
+
+	//and some e=65537 keys can sign with e=3 too
+	for {
+		rsa := MakeRSA(1024)
+		phi := new(big.Int).Mul(new(big.Int).Sub(rsa.p, big.NewInt(1)), new(big.Int).Sub(rsa.q, big.NewInt(1)))
+		legacyD := new(big.Int).ModInverse(big.NewInt(3), phi)
+		if legacyD == nil {
+			continue
+		}
+		legacyDraw := ConvertBigIntToString(rsa.FullSign(checkString, rsa.n, *legacyD))
+		if !verifier.VerifyDraw(rsa.SignDeterministic(checkString), 2, 1, rsa.PublicKeyString()) {
+			t.Error("The PKCS #1 v1.5 draw of a new key should verify")
+		}
+		if verifier.VerifyDraw(legacyDraw, 2, 1, rsa.PublicKeyString()) {
+			t.Error("A new key should not get a second draw with textbook RSA")
+		}
+		break
+	}
+	fmt.Println("TestEachKeyHasOneKindOfLotteryDraw passed")
+}
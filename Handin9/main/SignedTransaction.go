@@ -7,14 +7,25 @@ import (
 )
 
 type SignedTransaction struct {
-	ID        string
-	From      string
-	To        string
-	Signature string
-	Amount    int
+	ID         string
+	From       Address
+	To         Address
+	PublicKey  string `json:",omitempty"` //public key of From, may be left out once the other peers know the address
+	Signature  string
+	Amount     int
+	Signatures map[int]string `json:",omitempty"` //signatures of the signers of a multisig account (see Multisig.go), by signer
 }
 
-func MakeSignedTransaction(from string, to string, amount int, keyD string) *SignedTransaction {
+//MakeSignedTransaction sends from the account with public key keyN and secret key keyD
+func MakeSignedTransaction(keyN string, to Address, amount int, keyD string) *SignedTransaction {
+	transaction := MakeUnsignedTransaction(AddressOfPublicKey(keyN), to, amount)
+	rsa := new(RSA)
+	rsa.FullSignTransaction(transaction, keyN, keyD)
+	return transaction
+}
+
+//MakeUnsignedTransaction gives the transaction a fresh ID, it must be signed (e.g. by a Wallet) before it is sent
+func MakeUnsignedTransaction(from Address, to Address, amount int) *SignedTransaction {
 	transaction := new(SignedTransaction)
 	rand.Seed(time.Now().UnixNano())
 	integer := rand.Int()
@@ -22,8 +33,5 @@ func MakeSignedTransaction(from string, to string, amount int, keyD string) *Sig
 	transaction.From = from
 	transaction.To = to
 	transaction.Amount = amount
-	keyN := transaction.From
-	rsa := MakeRSA(2000)
-	rsa.FullSignTransaction(transaction, keyN, keyD)
 	return transaction
 }
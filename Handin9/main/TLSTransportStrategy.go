@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//Private OID for the certificate extension that binds the TLS key to the account key
+var accountIdentityOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1}
+
+const tlsSniffTimeout = 500 * time.Millisecond
+
+//a peer that has not finished the TLS handshake by then is dropped
+const tlsHandshakeTimeout = 10 * time.Second
+
+//every TLS record of a handshake starts with this content type
+const tlsHandshakeRecord = 0x16
+
+type accountIdentity struct {
+	PublicKey string //the account's public key, see PublicKeyString
+	Signature string //signature of the certificate's public key under the account key
+}
+
+//AccountBoundTransportStrategy is implemented by transports that need the peer's account key, the peer calls UseAccount whenever its key changes
+type AccountBoundTransportStrategy interface {
+	UseAccount(rsa *RSA)
+}
+
+//HandshakingTransportStrategy is implemented by transports that authenticate the other end before the connection is used.
+//It returns the connection to use from now on and the account key of the other peer ("" if it is unknown)
+type HandshakingTransportStrategy interface {
+	Handshake(conn net.Conn, dialer bool) (net.Conn, string, error)
+}
+
+//TLSTransportStrategy wraps another transport in mutual TLS. Every certificate is self-signed with a fresh ECDSA key and carries a signature
+//of that key made with the peer's RSA account key, so the other end learns (and verifies) which account it is talking to.
+//If required is false, plaintext peers are still accepted and we fall back to plaintext when dialing a peer that does not speak TLS.
+//A peer that answers with a TLS handshake and then fails it (e.g. with a bad certificate) is never retried in plaintext.
+type TLSTransportStrategy struct {
+	inner       TransportStrategy
+	required    bool
+	certificate *tls.Certificate
+	identity    string
+	lock        *sync.Mutex
+}
+
+func MakeTLSTransportStrategy(inner TransportStrategy, required bool) *TLSTransportStrategy {
+	transportStrategy := new(TLSTransportStrategy)
+	transportStrategy.inner = inner
+	transportStrategy.required = required
+	transportStrategy.lock = &sync.Mutex{}
+	return transportStrategy
+}
+
+func (transportStrategy *TLSTransportStrategy) UseAccount(rsa *RSA) {
+	certificate, err := MakeAccountCertificate(rsa)
+	if err != nil {
+		panic(err)
+	}
+	transportStrategy.lock.Lock()
+	transportStrategy.certificate = certificate
+	transportStrategy.identity = rsa.PublicKeyString()
+	transportStrategy.lock.Unlock()
+}
+
+func (transportStrategy *TLSTransportStrategy) getCertificate() (*tls.Certificate, error) {
+	transportStrategy.lock.Lock()
+	defer transportStrategy.lock.Unlock()
+	if transportStrategy.certificate == nil {
+		return nil, errors.New("no account key has been given to the TLS transport")
+	}
+	return transportStrategy.certificate, nil
+}
+
+func (transportStrategy *TLSTransportStrategy) makeConfig() *tls.Config {
+	config := new(tls.Config)
+	config.MinVersion = tls.VersionTLS13
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return transportStrategy.getCertificate()
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return transportStrategy.getCertificate()
+	}
+	//the certificates are self-signed, trust comes from the account signature checked in VerifyPeerCertificate instead of a CA
+	config.InsecureSkipVerify = true
+	config.ClientAuth = tls.RequireAnyClientCert
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		_, err := VerifyAccountCertificate(rawCerts)
+		return err
+	}
+	return config
+}
+
+func (transportStrategy *TLSTransportStrategy) Dial(uri string) (net.Conn, error) {
+	conn, err := transportStrategy.inner.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+	recorded := &firstByteConn{Conn: conn, first: -1}
+	tlsConn := tls.Client(recorded, transportStrategy.makeConfig())
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err = tlsConn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err == nil {
+		return tlsConn, nil
+	}
+	conn.Close()
+	//a peer that answered with a handshake record speaks TLS, and one that stayed silent may be stalling on purpose
+	if transportStrategy.required || recorded.first == tlsHandshakeRecord || errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, err
+	}
+	//the other peer hung up or sent plaintext, so it does not speak TLS, and we allow that
+	return transportStrategy.inner.Dial(uri)
+}
+
+func (transportStrategy *TLSTransportStrategy) Listen(address string) (net.Listener, string, error) {
+	return transportStrategy.inner.Listen(address)
+}
+
+func (transportStrategy *TLSTransportStrategy) Send(conn net.Conn, message []byte) error {
+	return transportStrategy.inner.Send(conn, message)
+}
+
+func (transportStrategy *TLSTransportStrategy) Receive(conn net.Conn) *bufio.Reader {
+	return transportStrategy.inner.Receive(conn)
+}
+
+func (transportStrategy *TLSTransportStrategy) Forget(conn net.Conn) {
+	transportStrategy.inner.Forget(conn)
+}
+
+func (transportStrategy *TLSTransportStrategy) Handshake(conn net.Conn, dialer bool) (net.Conn, string, error) {
+	if dialer {
+		tlsConn, isTLS := conn.(*tls.Conn)
+		if !isTLS {
+			if transportStrategy.required {
+				return nil, "", errors.New("connection is not using TLS")
+			}
+			return conn, "", nil
+		}
+		identity, err := VerifyAccountCertificate(rawCertificates(tlsConn))
+		return tlsConn, identity, err
+	}
+
+	//a TLS client always starts with a handshake record (0x16), a plaintext peer waits for our connectionsURI instead
+	conn.SetReadDeadline(time.Now().Add(tlsSniffTimeout))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	sniffed := &sniffedConn{Conn: conn, reader: reader}
+	if err != nil || first[0] != tlsHandshakeRecord {
+		if transportStrategy.required {
+			return nil, "", errors.New("peer did not start a TLS handshake")
+		}
+		return sniffed, "", nil
+	}
+	tlsConn := tls.Server(sniffed, transportStrategy.makeConfig())
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err = tlsConn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+	identity, err := VerifyAccountCertificate(rawCertificates(tlsConn))
+	return tlsConn, identity, err
+}
+
+func rawCertificates(tlsConn *tls.Conn) [][]byte {
+	rawCerts := make([][]byte, 0)
+	for _, certificate := range tlsConn.ConnectionState().PeerCertificates {
+		rawCerts = append(rawCerts, certificate.Raw)
+	}
+	return rawCerts
+}
+
+//firstByteConn remembers the first byte the other end sent (-1 until then), to tell a peer that does not speak TLS
+//from one that failed the handshake
+type firstByteConn struct {
+	net.Conn
+	first int
+}
+
+func (conn *firstByteConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	if n > 0 && conn.first < 0 {
+		conn.first = int(p[0])
+	}
+	return n, err
+}
+
+//sniffedConn gives back the bytes we peeked at while checking for a TLS handshake
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *sniffedConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+func certificateKeyString(publicKeyDER []byte) string {
+	hashed := sha256.Sum256(publicKeyDER)
+	return "TLSIDENTITY:" + hex.EncodeToString(hashed[:])
+}
+
+//MakeAccountCertificate creates a self-signed certificate for a fresh ECDSA key and signs that key with the account key
+func MakeAccountCertificate(rsa *RSA) (*tls.Certificate, error) {
+	tlsKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&tlsKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	identity := accountIdentity{
+		PublicKey: rsa.PublicKeyString(),
+		Signature: rsa.SignMessage(certificateKeyString(publicKeyDER)),
+	}
+	extension, err := asn1.Marshal(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(0).Lsh(big.NewInt(1), 62))
+	template := x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: "peer " + identity.PublicKey[:10]},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * 365 * time.Hour),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: accountIdentityOID, Value: extension}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &tlsKey.PublicKey, tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: tlsKey}, nil
+}
+
+//VerifyAccountCertificate checks that the leaf certificate's key was signed by the account key inside it, and returns that account key
+func VerifyAccountCertificate(rawCerts [][]byte) (string, error) {
+	if len(rawCerts) == 0 {
+		return "", errors.New("peer sent no certificate")
+	}
+	certificate, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return "", err
+	}
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(accountIdentityOID) {
+			continue
+		}
+		var identity accountIdentity
+		_, err := asn1.Unmarshal(extension.Value, &identity)
+		if err != nil {
+			return "", err
+		}
+		rsa := new(RSA)
+		if !rsa.VerifySignature(certificateKeyString(certificate.RawSubjectPublicKeyInfo), identity.Signature, identity.PublicKey) {
+			return "", errors.New("certificate key is not signed by the account key")
+		}
+		return identity.PublicKey, nil
+	}
+	return "", errors.New("certificate does not name an account key")
+}
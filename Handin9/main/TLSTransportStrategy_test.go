@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSPeersLearnEachOthersAccountKeys(t *testing.T) {
+	peer1, listener1 := createPeerWithTransport("fa", "fa", MakeTLSTransportStrategy(MakeTCPTransportStrategy(), true))
+	defer listener1.Close()
+
+	peer2, listener2 := createPeerWithTransport(peer1.ip, peer1.port, MakeTLSTransportStrategy(MakeTCPTransportStrategy(), true))
+	defer listener2.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	peer1.connectionsMutex.Lock()
+	connections1 := append([]net.Conn{}, peer1.connections...)
+	peer1.connectionsMutex.Unlock()
+	peer2.connectionsMutex.Lock()
+	connections2 := append([]net.Conn{}, peer2.connections...)
+	peer2.connectionsMutex.Unlock()
+
+	if len(connections1) != 1 || len(connections2) != 1 {
+		t.Fatal("Expected one connection at each peer, got", len(connections1), "and", len(connections2))
+	}
+	if peer1.GetConnectionIdentity(connections1[0]) != ConvertBigIntToString(&peer2.rsa.n) {
+		t.Error("Peer1 did not learn the account key of peer2")
+	} else if peer2.GetConnectionIdentity(connections2[0]) != ConvertBigIntToString(&peer1.rsa.n) {
+		t.Error("Peer2 did not learn the account key of peer1")
+	} else {
+		fmt.Println("TestTLSPeersLearnEachOthersAccountKeys passed")
+	}
+}
+
+func TestRequiredTLSRejectsPlaintextPeer(t *testing.T) {
+	transportStrategy := MakeTLSTransportStrategy(MakeTCPTransportStrategy(), true)
+	transportStrategy.UseAccount(MakeRSA(2000))
+	listener, port, _ := transportStrategy.Listen("localhost")
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:"+port)
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * tlsSniffTimeout)
+		}
+	}()
+	conn, _ := listener.Accept()
+	_, _, err := transportStrategy.Handshake(conn, false)
+	if err == nil {
+		t.Error("A plaintext peer should not be accepted when TLS is required")
+	} else {
+		fmt.Println("TestRequiredTLSRejectsPlaintextPeer passed")
+	}
+}
+
+func TestAccountCertificateIsBoundToAccountKey(t *testing.T) {
+	rsa := MakeRSA(2000)
+	certificate, _ := MakeAccountCertificate(rsa)
+
+	identity, err := VerifyAccountCertificate(certificate.Certificate)
+	if err != nil || identity != ConvertBigIntToString(&rsa.n) {
+		t.Error("Did not verify a correct certificate:", err)
+	}
+
+	//changing a digit of the account key inside the certificate must break the binding
+	publicKey := []byte(ConvertBigIntToString(&rsa.n))
+	tampered := append([]byte{}, certificate.Certificate[0]...)
+	index := bytes.Index(tampered, publicKey)
+	if tampered[index+5] == '1' {
+		tampered[index+5] = '2'
+	} else {
+		tampered[index+5] = '1'
+	}
+	_, err = VerifyAccountCertificate([][]byte{tampered})
+	if err == nil {
+		t.Error("Verified a certificate that was tampered with")
+	} else {
+		fmt.Println("TestAccountCertificateIsBoundToAccountKey passed")
+	}
+}
+
+func TestStalledHandshakeDoesNotBlockOtherPeers(t *testing.T) {
+	peer1, _ := startPeer(t, "fa", "fa", MakeTLSTransportStrategy(MakeTCPTransportStrategy(), true), fastHeartbeatConfig(), "")
+
+	//the stalled peer starts a TLS handshake and never finishes it
+	stalled, err := net.Dial("tcp", peer1.URI())
+	if err != nil {
+		t.Fatal("Could not dial the peer:", err)
+	}
+	defer stalled.Close()
+	stalled.Write([]byte{tlsHandshakeRecord})
+
+	peer2, _ := startPeer(t, peer1.ip, peer1.port, MakeTLSTransportStrategy(MakeTCPTransportStrategy(), true), fastHeartbeatConfig(), "")
+	defer peer2.Stop()
+	if !waitUntil(func() bool { return peer1.InboundCount() == 1 }, tlsHandshakeTimeout/2) {
+		t.Error("A stalled handshake should not keep the next peer from being accepted")
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		peer1.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		fmt.Println("TestStalledHandshakeDoesNotBlockOtherPeers passed")
+	case <-time.After(tlsHandshakeTimeout / 2):
+		t.Error("Stop should not wait for a stalled handshake")
+	}
+}
+
+func TestFailedHandshakeDoesNotFallBackToPlaintext(t *testing.T) {
+	//the other end speaks TLS, but its certificate is not signed by the account key inside it
+	rsa := MakeRSA(2000)
+	certificate, _ := MakeAccountCertificate(rsa)
+	tampered := append([]byte{}, certificate.Certificate[0]...)
+	index := bytes.Index(tampered, []byte(ConvertBigIntToString(&rsa.n)))
+	tampered[index+5] ^= 1 //another digit
+	certificate.Certificate[0] = tampered
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	defer listener.Close()
+	accepted := make(chan bool, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- true
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*certificate}, ClientAuth: tls.RequireAnyClientCert})
+			tlsConn.Handshake()
+			conn.Close()
+		}
+	}()
+
+	transportStrategy := MakeTLSTransportStrategy(MakeTCPTransportStrategy(), false)
+	transportStrategy.UseAccount(MakeRSA(2000))
+	conn, err := transportStrategy.Dial(listener.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Error("Dialing a peer with a bad certificate should fail")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(accepted) != 1 {
+		t.Error("A failed TLS handshake should not be retried in plaintext, the peer was dialed", len(accepted), "times")
+	} else {
+		fmt.Println("TestFailedHandshakeDoesNotFallBackToPlaintext passed")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//TransportStrategy hides how peers reach each other, so the peer only deals in URIs ("ip:port") and net.Conn values
+type TransportStrategy interface {
+	Dial(uri string) (net.Conn, error)
+	Listen(address string) (net.Listener, string, error) //takes a host, or host:port to ask for a port, returns the listener and the port other peers should use
+	Send(conn net.Conn, message []byte) error
+	Receive(conn net.Conn) *bufio.Reader
+	Forget(conn net.Conn) //releases the reader of a connection we are done with
+}
+
+//streamReaders makes sure that every connection only ever gets one bufio.Reader, so bytes buffered while reading the connectionsURI are not lost afterwards
+type streamReaders struct {
+	readers map[net.Conn]*bufio.Reader
+	lock    *sync.Mutex
+}
+
+func makeStreamReaders() *streamReaders {
+	readers := new(streamReaders)
+	readers.readers = make(map[net.Conn]*bufio.Reader)
+	readers.lock = &sync.Mutex{}
+	return readers
+}
+
+func (readers *streamReaders) Get(conn net.Conn) *bufio.Reader {
+	readers.lock.Lock()
+	defer readers.lock.Unlock()
+	reader, exists := readers.readers[conn]
+	if !exists {
+		reader = bufio.NewReader(conn)
+		readers.readers[conn] = reader
+	}
+	return reader
+}
+
+func (readers *streamReaders) Forget(conn net.Conn) {
+	readers.lock.Lock()
+	defer readers.lock.Unlock()
+	delete(readers.readers, conn)
+}
+
+//splitListenAddress splits what Listen is given, no port or port 0 means any free port
+func splitListenAddress(address string) (string, string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		//just a host, also an IPv6 address without brackets
+		return address, "0"
+	}
+	if port == "" {
+		port = "0"
+	}
+	return host, port
+}
+
+func writeAll(conn net.Conn, message []byte) error {
+	_, err := conn.Write(message)
+	return err
+}
+
+//TCPTransportStrategy is the real transport used when running the peer from the command line
+type TCPTransportStrategy struct {
+	readers *streamReaders
+}
+
+func MakeTCPTransportStrategy() *TCPTransportStrategy {
+	transportStrategy := new(TCPTransportStrategy)
+	transportStrategy.readers = makeStreamReaders()
+	return transportStrategy
+}
+
+func (transportStrategy *TCPTransportStrategy) Dial(uri string) (net.Conn, error) {
+	return net.Dial("tcp", uri)
+}
+
+func (transportStrategy *TCPTransportStrategy) Listen(address string) (net.Listener, string, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(splitListenAddress(address)))
+	if err != nil {
+		return nil, "", err
+	}
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	return listener, port, err
+}
+
+func (transportStrategy *TCPTransportStrategy) Send(conn net.Conn, message []byte) error {
+	return writeAll(conn, message)
+}
+
+func (transportStrategy *TCPTransportStrategy) Receive(conn net.Conn) *bufio.Reader {
+	return transportStrategy.readers.Get(conn)
+}
+
+func (transportStrategy *TCPTransportStrategy) Forget(conn net.Conn) {
+	transportStrategy.readers.Forget(conn)
+}
+
+//UnixTransportStrategy maps every URI "ip:port" to a socket file in dir, which lets several peers run on one machine without using the network
+type UnixTransportStrategy struct {
+	dir     string
+	readers *streamReaders
+}
+
+func MakeUnixTransportStrategy(dir string) *UnixTransportStrategy {
+	transportStrategy := new(UnixTransportStrategy)
+	transportStrategy.dir = dir
+	transportStrategy.readers = makeStreamReaders()
+	return transportStrategy
+}
+
+func (transportStrategy *UnixTransportStrategy) socketPath(ip string, port string) string {
+	return filepath.Join(transportStrategy.dir, "peer-"+ip+"-"+port+".sock")
+}
+
+func (transportStrategy *UnixTransportStrategy) Dial(uri string) (net.Conn, error) {
+	ip, port, err := net.SplitHostPort(uri)
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial("unix", transportStrategy.socketPath(ip, port))
+}
+
+func (transportStrategy *UnixTransportStrategy) Listen(address string) (net.Listener, string, error) {
+	ip, requested := splitListenAddress(address)
+	if requested != "0" {
+		listener, err := net.Listen("unix", transportStrategy.socketPath(ip, requested))
+		return listener, requested, err
+	}
+	//try ports until we find a socket file that is not taken
+	for port := 1; port < 65536; port++ {
+		path := transportStrategy.socketPath(ip, strconv.Itoa(port))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			continue
+		}
+		return listener, strconv.Itoa(port), nil
+	}
+	return nil, "", errors.New("no free unix socket in " + transportStrategy.dir)
+}
+
+func (transportStrategy *UnixTransportStrategy) Send(conn net.Conn, message []byte) error {
+	return writeAll(conn, message)
+}
+
+func (transportStrategy *UnixTransportStrategy) Receive(conn net.Conn) *bufio.Reader {
+	return transportStrategy.readers.Get(conn)
+}
+
+func (transportStrategy *UnixTransportStrategy) Forget(conn net.Conn) {
+	transportStrategy.readers.Forget(conn)
+}
+
+//PipeTransportStrategy keeps the whole network in memory, all peers in a test must share the same instance
+type PipeTransportStrategy struct {
+	listeners  map[string]*pipeListener
+	nextPort   int
+	lock       *sync.Mutex
+	readers    *streamReaders
+	bufferSize int //bytes each direction of a connection holds before writes block, 0 means no limit
+}
+
+func MakePipeTransportStrategy() *PipeTransportStrategy {
+	transportStrategy := new(PipeTransportStrategy)
+	transportStrategy.listeners = make(map[string]*pipeListener)
+	transportStrategy.nextPort = 1
+	transportStrategy.lock = &sync.Mutex{}
+	transportStrategy.readers = makeStreamReaders()
+	return transportStrategy
+}
+
+func (transportStrategy *PipeTransportStrategy) Dial(uri string) (net.Conn, error) {
+	transportStrategy.lock.Lock()
+	listener, exists := transportStrategy.listeners[uri]
+	transportStrategy.lock.Unlock()
+	if !exists {
+		return nil, errors.New("no pipe listener on " + uri)
+	}
+	transportStrategy.lock.Lock()
+	bufferSize := transportStrategy.bufferSize
+	transportStrategy.lock.Unlock()
+	local, remote := makePipeConnPair(pipeAddr("dialer->"+uri), pipeAddr(uri), bufferSize)
+	if !listener.push(remote) {
+		return nil, errors.New("pipe listener on " + uri + " is closed")
+	}
+	return local, nil
+}
+
+//SetBufferSize makes writes to new connections block while the reader is this many bytes behind, like a full socket
+func (transportStrategy *PipeTransportStrategy) SetBufferSize(bytes int) {
+	transportStrategy.lock.Lock()
+	defer transportStrategy.lock.Unlock()
+	transportStrategy.bufferSize = bytes
+}
+
+func (transportStrategy *PipeTransportStrategy) Listen(address string) (net.Listener, string, error) {
+	transportStrategy.lock.Lock()
+	defer transportStrategy.lock.Unlock()
+	ip, port := splitListenAddress(address)
+	if port == "0" {
+		port = strconv.Itoa(transportStrategy.nextPort)
+		transportStrategy.nextPort++
+	}
+	uri := net.JoinHostPort(ip, port)
+	if _, taken := transportStrategy.listeners[uri]; taken {
+		return nil, "", errors.New("pipe listener on " + uri + " already exists")
+	}
+	listener := makePipeListener(uri, func() {
+		transportStrategy.lock.Lock()
+		delete(transportStrategy.listeners, uri)
+		transportStrategy.lock.Unlock()
+	})
+	transportStrategy.listeners[uri] = listener
+	return listener, port, nil
+}
+
+func (transportStrategy *PipeTransportStrategy) Send(conn net.Conn, message []byte) error {
+	return writeAll(conn, message)
+}
+
+func (transportStrategy *PipeTransportStrategy) Receive(conn net.Conn) *bufio.Reader {
+	return transportStrategy.readers.Get(conn)
+}
+
+func (transportStrategy *PipeTransportStrategy) Forget(conn net.Conn) {
+	transportStrategy.readers.Forget(conn)
+}
+
+type pipeAddr string
+
+func (addr pipeAddr) Network() string { return "pipe" }
+func (addr pipeAddr) String() string  { return string(addr) }
+
+type pipeListener struct {
+	addr     pipeAddr
+	incoming chan net.Conn
+	closed   chan bool
+	once     *sync.Once
+	onClose  func()
+}
+
+func makePipeListener(uri string, onClose func()) *pipeListener {
+	listener := new(pipeListener)
+	listener.addr = pipeAddr(uri)
+	listener.incoming = make(chan net.Conn, 16)
+	listener.closed = make(chan bool)
+	listener.once = &sync.Once{}
+	listener.onClose = onClose
+	return listener
+}
+
+func (listener *pipeListener) push(conn net.Conn) bool {
+	select {
+	case <-listener.closed:
+		return false
+	case listener.incoming <- conn:
+		return true
+	}
+}
+
+func (listener *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.incoming:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *pipeListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.closed)
+		listener.onClose()
+	})
+	return nil
+}
+
+func (listener *pipeListener) Addr() net.Addr {
+	return listener.addr
+}
+
+//pipeBuffer is one direction of a pipeConn. Without a size writes never block, with one a write waits until the reader
+//has made room (or the write deadline passes), a write bigger than the whole buffer only waits for it to be empty
+type pipeBuffer struct {
+	data          bytes.Buffer
+	size          int
+	writeDeadline time.Time
+	closed        bool
+	lock          *sync.Mutex
+	cond          *sync.Cond
+}
+
+func makePipeBuffer(size int) *pipeBuffer {
+	buffer := new(pipeBuffer)
+	buffer.size = size
+	buffer.lock = &sync.Mutex{}
+	buffer.cond = sync.NewCond(buffer.lock)
+	return buffer
+}
+
+func (buffer *pipeBuffer) write(p []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	for !buffer.closed && buffer.size > 0 && buffer.data.Len() > 0 && buffer.data.Len()+len(p) > buffer.size {
+		if !buffer.writeDeadline.IsZero() && !time.Now().Before(buffer.writeDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		buffer.cond.Wait()
+	}
+	if buffer.closed {
+		return 0, io.ErrClosedPipe
+	}
+	buffer.data.Write(p)
+	buffer.cond.Broadcast()
+	return len(p), nil
+}
+
+func (buffer *pipeBuffer) setWriteDeadline(deadline time.Time) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	buffer.writeDeadline = deadline
+	if !deadline.IsZero() {
+		//wake a blocked write when the deadline passes
+		time.AfterFunc(time.Until(deadline), func() {
+			buffer.lock.Lock()
+			buffer.cond.Broadcast()
+			buffer.lock.Unlock()
+		})
+	}
+}
+
+func (buffer *pipeBuffer) read(p []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	for buffer.data.Len() == 0 && !buffer.closed {
+		buffer.cond.Wait()
+	}
+	if buffer.data.Len() == 0 {
+		return 0, io.EOF
+	}
+	buffer.cond.Broadcast() //a blocked write may fit now
+	return buffer.data.Read(p)
+}
+
+func (buffer *pipeBuffer) close() {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	buffer.closed = true
+	buffer.cond.Broadcast()
+}
+
+type pipeConn struct {
+	in     *pipeBuffer
+	out    *pipeBuffer
+	local  pipeAddr
+	remote pipeAddr
+}
+
+func makePipeConnPair(dialerAddr pipeAddr, listenerAddr pipeAddr, bufferSize int) (*pipeConn, *pipeConn) {
+	aToB := makePipeBuffer(bufferSize)
+	bToA := makePipeBuffer(bufferSize)
+	dialerSide := &pipeConn{in: bToA, out: aToB, local: dialerAddr, remote: listenerAddr}
+	listenerSide := &pipeConn{in: aToB, out: bToA, local: listenerAddr, remote: dialerAddr}
+	return dialerSide, listenerSide
+}
+
+func (conn *pipeConn) Read(p []byte) (int, error)  { return conn.in.read(p) }
+func (conn *pipeConn) Write(p []byte) (int, error) { return conn.out.write(p) }
+
+func (conn *pipeConn) Close() error {
+	conn.in.close()
+	conn.out.close()
+	return nil
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr                { return conn.local }
+func (conn *pipeConn) RemoteAddr() net.Addr               { return conn.remote }
+func (conn *pipeConn) SetDeadline(t time.Time) error      { return conn.SetWriteDeadline(t) }
+func (conn *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *pipeConn) SetWriteDeadline(t time.Time) error { conn.out.setWriteDeadline(t); return nil }
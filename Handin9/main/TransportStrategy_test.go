@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipeTransportConnectsPeersWithoutSockets(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer1, listener1 := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener1.Close()
+
+	peer2, listener2 := createPeerWithTransport(peer1.ip, peer1.port, transportStrategy)
+	defer listener2.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	length1 := peer1.peerTable.Len()
+	length2 := peer2.peerTable.Len()
+	if length1 != 2 || length2 != 2 {
+		t.Error("Both peers should know 2 URIs, got", length1, "and", length2)
+	} else {
+		fmt.Println("TestPipeTransportConnectsPeersWithoutSockets passed")
+	}
+}
+
+func TestPipeTransportDeliversMessagesInOrder(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	listener, port, _ := transportStrategy.Listen("localhost")
+	defer listener.Close()
+
+	dialed, err := transportStrategy.Dial("localhost:" + port)
+	if err != nil {
+		t.Fatal("Could not dial the pipe listener:", err)
+	}
+	accepted, _ := listener.Accept()
+
+	transportStrategy.Send(dialed, []byte("first]"))
+	transportStrategy.Send(dialed, []byte("second]"))
+	reader := transportStrategy.Receive(accepted)
+	first, _ := reader.ReadBytes(']')
+	second, _ := reader.ReadBytes(']')
+	if string(first) != "first]" || string(second) != "second]" {
+		t.Error("Got", string(first), "and", string(second))
+	} else {
+		fmt.Println("TestPipeTransportDeliversMessagesInOrder passed")
+	}
+
+	if _, err := transportStrategy.Dial("localhost:9999"); err == nil {
+		t.Error("Dialing a URI without a listener should fail")
+	}
+}
+
+func TestUnixTransportMapsURIsToSockets(t *testing.T) {
+	transportStrategy := MakeUnixTransportStrategy(t.TempDir())
+	listener, port, err := transportStrategy.Listen("localhost")
+	if err != nil {
+		t.Fatal("Could not listen on a unix socket:", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			transportStrategy.Send(conn, []byte("hello]"))
+		}
+	}()
+
+	conn, err := transportStrategy.Dial("localhost:" + port)
+	if err != nil {
+		t.Fatal("Could not dial the unix socket:", err)
+	}
+	defer conn.Close()
+	received, _ := transportStrategy.Receive(conn).ReadBytes(']')
+	if string(received) != "hello]" {
+		t.Error("Expected hello] but got", string(received))
+	} else {
+		fmt.Println("TestUnixTransportMapsURIsToSockets passed")
+	}
+}
+
+//createPeerWithTransport starts a peer that joins the network at ip:port, the configure hooks run before it joins
+func createPeerWithTransport(ip string, port string, transportStrategy TransportStrategy, configure ...func(peer *Peer)) (*Peer, net.Listener) {
+	transaction := MakeSignedTransaction("acc1", "acc2", 100, "yeet")
+
+	fixedUriStrategy := MakeFixedUriStrategy(ip, port)
+	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
+	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
+	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
+	for _, hook := range configure {
+		hook(peer)
+	}
+
+	peer.JoinNetwork(peer.GetURI())
+	listener := peer.StartListeningForConnections()
+	peer.AddSelfToConnectionsURI()
+	peer.BroadcastPresence(peer.ip + ":" + peer.port)
+
+	go peer.SendMessages()
+	trackingListener := &closeTrackingListener{Listener: listener, closed: make(chan bool)}
+	go takeNewConnectionsUntilClosed(peer, trackingListener)
+
+	return peer, trackingListener
+}
+
+//closeTrackingListener lets the accept loop stop once the test closes the listener, instead of spinning on Accept errors
+type closeTrackingListener struct {
+	net.Listener
+	closed chan bool
+}
+
+func (listener *closeTrackingListener) Close() error {
+	close(listener.closed)
+	return listener.Listener.Close()
+}
+
+func takeNewConnectionsUntilClosed(peer *Peer, listener *closeTrackingListener) {
+	for {
+		select {
+		case <-listener.closed:
+			return
+		default:
+			peer.TakeNewConnection(listener)
+		}
+	}
+}
+
+func TestReadersOfDeletedConnectionsAreReleased(t *testing.T) {
+	transportStrategy := MakePipeTransportStrategy()
+	peer, listener := createPeerWithTransport("fa", "fa", transportStrategy)
+	defer listener.Close()
+	readerCount := func() int {
+		transportStrategy.readers.lock.Lock()
+		defer transportStrategy.readers.lock.Unlock()
+		return len(transportStrategy.readers.readers)
+	}
+	before := readerCount()
+	conn, err := transportStrategy.Dial(peer.ip + ":" + peer.port)
+	if err != nil {
+		t.Fatal("Could not dial the peer:", err)
+	}
+	if !waitUntil(func() bool { return peer.InboundCount() == 1 && readerCount() > before }, 5*time.Second) {
+		t.Fatal("The connection was not accepted")
+	}
+	conn.Close()
+	if !waitUntil(func() bool { return peer.InboundCount() == 0 && readerCount() == before }, 5*time.Second) {
+		t.Error("The reader of a closed connection should be released, there are", readerCount(), "readers")
+	}
+	fmt.Println("TestReadersOfDeletedConnectionsAreReleased passed")
+}
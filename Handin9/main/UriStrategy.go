@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 )
 
@@ -29,7 +30,7 @@ type FixedUriStrategy struct {
 
 func MakeFixedUriStrategy(ip, port string) *FixedUriStrategy {
 	uriStrategy := new(FixedUriStrategy)
-	uriStrategy.uri = ip + ":" + port
+	uriStrategy.uri = net.JoinHostPort(ip, port)
 	return uriStrategy
 }
 
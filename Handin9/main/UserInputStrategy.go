@@ -2,20 +2,45 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+
+	"golang.org/x/term"
 )
 
+//HandleIncomingFromUser returns ErrUserQuit when there is no more input, the peer then stops
 type UserInputStrategy interface {
-	HandleIncomingFromUser() SignedTransaction
+	HandleIncomingFromUser() (SignedTransaction, error)
+}
+
+//AccountProvidingUserInputStrategy is implemented by input strategies that manage the peer's account themselves,
+//the peer then uses that account instead of asking for a secret key in AddNewSkUser
+type AccountProvidingUserInputStrategy interface {
+	GetAccount() *RSA
+}
+
+//MultisigSigner lets the user collect signatures for multisig transactions (see Multisig.go), the peer implements it
+type MultisigSigner interface {
+	ProposeMultisigTransaction(transaction SignedTransaction) error
+	ApproveMultisigTransaction(hash string) (SignedTransaction, error)
+	PendingMultisigTransactions() map[string]SignedTransaction
+}
+
+//MultisigUserInputStrategy is implemented by input strategies that let the user propose multisig transactions and sign
+//the ones proposed by others, the peer hands itself over before it asks for input
+type MultisigUserInputStrategy interface {
+	UseMultisigSigner(signer MultisigSigner)
 }
 
 type CommandLineUserInputStrategy struct {
 }
 
-func (inputStrategy *CommandLineUserInputStrategy) HandleIncomingFromUser() SignedTransaction {
+func (inputStrategy *CommandLineUserInputStrategy) HandleIncomingFromUser() (SignedTransaction, error) {
 
 	//prompt user to type a message
 	reader := bufio.NewReader(os.Stdin)
@@ -23,20 +48,25 @@ func (inputStrategy *CommandLineUserInputStrategy) HandleIncomingFromUser() Sign
 	fmt.Println("Type 'From' account (public key):")
 	acc1, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println("User quit the program")
-		os.Exit(0)
+		return SignedTransaction{}, ErrUserQuit
 	}
-	fmt.Println("Type 'To' account (public key):")
-	acc2, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("User quit the program")
-		os.Exit(0)
+	var acc2 Address
+	for {
+		fmt.Println("Type 'To' account (address):")
+		typed, err := reader.ReadString('\n')
+		if err != nil {
+			return SignedTransaction{}, ErrUserQuit
+		}
+		acc2, err = ParseAddress(typed)
+		if err == nil {
+			break
+		}
+		fmt.Println(err)
 	}
 	fmt.Println("Type amount (more than 0):")
 	amount, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println("User quit the program")
-		os.Exit(0)
+		return SignedTransaction{}, ErrUserQuit
 	}
 	fmt.Println("The amount before conversion: ", amount)
 	trimmed := strings.TrimSpace(amount)
@@ -48,18 +78,17 @@ func (inputStrategy *CommandLineUserInputStrategy) HandleIncomingFromUser() Sign
 	fmt.Println("Type your secret key:")
 	privateKey, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println("User quit the program")
-		os.Exit(0)
+		return SignedTransaction{}, ErrUserQuit
 	}
-	return *MakeSignedTransaction(acc1, acc2, val, privateKey)
+	return *MakeSignedTransaction(strings.TrimSpace(acc1), acc2, val, strings.TrimSpace(privateKey)), nil
 }
 
 type FixedInputStrategy struct {
 	currentInput SignedTransaction
 }
 
-func (inputStrategy *FixedInputStrategy) HandleIncomingFromUser() SignedTransaction {
-	return inputStrategy.currentInput
+func (inputStrategy *FixedInputStrategy) HandleIncomingFromUser() (SignedTransaction, error) {
+	return inputStrategy.currentInput, nil
 }
 
 func MakeFixedInputStrategy(input SignedTransaction) *FixedInputStrategy {
@@ -67,3 +96,278 @@ func MakeFixedInputStrategy(input SignedTransaction) *FixedInputStrategy {
 	inputStrategy.currentInput = input
 	return inputStrategy
 }
+
+//WalletUserInputStrategy only asks the user for the receiver, the amount and the wallet password,
+//transactions are signed by the wallet so the secret key is never typed in or printed
+type WalletUserInputStrategy struct {
+	wallet   *Wallet
+	account  string
+	reader   *bufio.Reader
+	terminal int            //file descriptor of the input if it is a terminal, otherwise -1
+	multisig MultisigSigner //nil until the peer hands itself over
+}
+
+func MakeWalletUserInputStrategy(wallet *Wallet, account string, input io.Reader) *WalletUserInputStrategy {
+	inputStrategy := new(WalletUserInputStrategy)
+	inputStrategy.wallet = wallet
+	inputStrategy.account = account
+	inputStrategy.reader = bufio.NewReader(input)
+	inputStrategy.terminal = -1
+	file, isFile := input.(*os.File)
+	if isFile && term.IsTerminal(int(file.Fd())) {
+		inputStrategy.terminal = int(file.Fd())
+	}
+	return inputStrategy
+}
+
+//Setup unlocks the account, or creates it (as a new or a genesis account) if the wallet does not have it yet
+func (inputStrategy *WalletUserInputStrategy) Setup() error {
+	if !inputStrategy.wallet.HasAccount(inputStrategy.account) {
+		err := inputStrategy.createAccount()
+		if err != nil {
+			return err
+		}
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		password, err := inputStrategy.readPassword("Password for account " + inputStrategy.account + ":")
+		if err != nil {
+			return err
+		}
+		_, err = inputStrategy.wallet.Unlock(inputStrategy.account, password)
+		if err == nil {
+			address, _ := inputStrategy.wallet.Address(inputStrategy.account)
+			fmt.Println("Unlocked account", inputStrategy.account, "with address:")
+			fmt.Println(address)
+			return nil
+		}
+		fmt.Println("Could not unlock the account:", err)
+	}
+	return errors.New("too many wrong passwords")
+}
+
+func (inputStrategy *WalletUserInputStrategy) createAccount() error {
+	fmt.Println("The wallet has no account called", inputStrategy.account)
+	decision, err := inputStrategy.readLine("Make new account = y/yes, new Ed25519 account = e/ed25519, restore from seed phrase = s/seed, genesis account = 1-10:")
+	if err != nil {
+		return err
+	}
+	switch decision {
+	case "y", "yes", "e", "ed25519", "s", "seed":
+	default:
+		if !isGenesisNumber(decision) {
+			return errors.New("unknown choice " + decision)
+		}
+	}
+	var account *RSA
+	if decision == "s" || decision == "seed" {
+		account, err = inputStrategy.readSeedPhraseAccount()
+		if err != nil {
+			return err
+		}
+	}
+	password, err := inputStrategy.readPassword("Choose a password:")
+	if err != nil {
+		return err
+	}
+	repeated, err := inputStrategy.readPassword("Repeat the password:")
+	if err != nil {
+		return err
+	}
+	if password != repeated {
+		return errors.New("the passwords are not the same")
+	}
+	if account != nil {
+		_, err = inputStrategy.wallet.ImportAccount(inputStrategy.account, password, account)
+	} else if decision == "y" || decision == "yes" {
+		_, err = inputStrategy.wallet.CreateAccount(inputStrategy.account, password)
+	} else if decision == "e" || decision == "ed25519" {
+		_, err = inputStrategy.wallet.CreateEd25519Account(inputStrategy.account, password)
+	} else {
+		_, err = inputStrategy.wallet.ImportAccount(inputStrategy.account, password, GenesisRSA(decision))
+		warnIfLegacySignaturesNotAllowed()
+	}
+	return err
+}
+
+//isGenesisNumber accepts the genesis accounts exactly as GenesisRSA names them, 1 to 10
+func isGenesisNumber(decision string) bool {
+	number, err := strconv.Atoi(decision)
+	return err == nil && number >= 1 && number <= 10 && strconv.Itoa(number) == decision
+}
+
+//readSeedPhraseAccount derives the account again from a seed phrase made with -new-seed-phrase
+func (inputStrategy *WalletUserInputStrategy) readSeedPhraseAccount() (*RSA, error) {
+	phrase, err := inputStrategy.readLine("Type the seed phrase:")
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := inputStrategy.readPassword("Type the seed passphrase (empty if you did not use one):")
+	if err != nil {
+		return nil, err
+	}
+	index, err := inputStrategy.readLine("Type the account number (0 for the first account):")
+	if err != nil {
+		return nil, err
+	}
+	accountNumber, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return nil, errors.New("the account number must be 0 or more")
+	}
+	hdWallet, err := MakeHDWallet(phrase, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return hdWallet.DeriveAccount(uint32(accountNumber)), nil
+}
+
+func (inputStrategy *WalletUserInputStrategy) GetAccount() *RSA {
+	inputStrategy.wallet.lock.Lock()
+	defer inputStrategy.wallet.lock.Unlock()
+	return inputStrategy.wallet.unlocked[inputStrategy.account]
+}
+
+func (inputStrategy *WalletUserInputStrategy) UseMultisigSigner(signer MultisigSigner) {
+	inputStrategy.multisig = signer
+}
+
+func (inputStrategy *WalletUserInputStrategy) HandleIncomingFromUser() (SignedTransaction, error) {
+	for {
+		fmt.Println("Make a new transaction from account", inputStrategy.account)
+		prompt := "Type 'To' account (address):"
+		if inputStrategy.multisig != nil {
+			prompt = "Type 'To' account (address), m to propose a multisig transaction or s to sign one:"
+		}
+		typed, err := inputStrategy.readLine(prompt)
+		if err != nil {
+			return SignedTransaction{}, ErrUserQuit
+		}
+		if inputStrategy.multisig != nil && (typed == "m" || typed == "s") {
+			if typed == "m" {
+				err = inputStrategy.proposeMultisig()
+			} else {
+				err = inputStrategy.signMultisig()
+			}
+			if err == io.EOF {
+				return SignedTransaction{}, ErrUserQuit
+			} else if err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+		to, err := ParseAddress(typed)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		amount, err := inputStrategy.readLine("Type amount (more than 0):")
+		if err != nil {
+			return SignedTransaction{}, ErrUserQuit
+		}
+		val, err := strconv.Atoi(amount)
+		if err != nil {
+			fmt.Println("The amount must be a number")
+			continue
+		}
+		transaction, err := inputStrategy.wallet.SignTransaction(inputStrategy.account, to, val)
+		if err != nil {
+			fmt.Println("Could not make the transaction:", err)
+			continue
+		}
+		return *transaction, nil
+	}
+}
+
+//proposeMultisig makes a transaction from a multisig account and signs it with our account if it is one of the signers,
+//the other signers see it with s once it reaches them
+func (inputStrategy *WalletUserInputStrategy) proposeMultisig() error {
+	threshold, err := inputStrategy.readLine("Type how many signers must sign:")
+	if err != nil {
+		return err
+	}
+	k, err := strconv.Atoi(threshold)
+	if err != nil {
+		return errors.New("the number of signers must be a number")
+	}
+	keys, err := inputStrategy.readLine("Type the public keys of all the signers, separated by commas:")
+	if err != nil {
+		return err
+	}
+	policy, err := MakeMultisigPolicy(k, strings.Split(keys, ","))
+	if err != nil {
+		return err
+	}
+	typed, err := inputStrategy.readLine("Type 'To' account (address):")
+	if err != nil {
+		return err
+	}
+	to, err := ParseAddress(typed)
+	if err != nil {
+		return err
+	}
+	amount, err := inputStrategy.readLine("Type amount (more than 0):")
+	if err != nil {
+		return err
+	}
+	val, err := strconv.Atoi(amount)
+	if err != nil || val < 1 {
+		return errors.New("the amount must be a number above 0")
+	}
+	transaction := MakeMultisigTransaction(policy, to, val)
+	err = inputStrategy.multisig.ProposeMultisigTransaction(*transaction)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Proposed paying", val, "from multisig account", policy.Address(), "to", to, "the signers are asked to sign")
+	return nil
+}
+
+//signMultisig shows the proposals waiting for our signature and signs the one the user picks
+func (inputStrategy *WalletUserInputStrategy) signMultisig() error {
+	pending := inputStrategy.multisig.PendingMultisigTransactions()
+	if len(pending) == 0 {
+		fmt.Println("No multisig transactions are waiting for signatures")
+		return nil
+	}
+	hashes := make([]string, 0, len(pending))
+	for hash := range pending {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		transaction := pending[hash]
+		fmt.Println(hash+":", transaction.Amount, "from", transaction.From, "to", transaction.To, "signatures so far:", len(transaction.Signatures))
+	}
+	hash, err := inputStrategy.readLine("Type the hash of the transaction to sign, or nothing to sign none:")
+	if err != nil || hash == "" {
+		return err
+	}
+	signed, err := inputStrategy.multisig.ApproveMultisigTransaction(hash)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Signed paying", signed.Amount, "from", signed.From, "to", signed.To)
+	return nil
+}
+
+func (inputStrategy *WalletUserInputStrategy) readLine(prompt string) (string, error) {
+	fmt.Println(prompt)
+	line, err := inputStrategy.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+//readPassword does not echo what is typed when the input is a terminal
+func (inputStrategy *WalletUserInputStrategy) readPassword(prompt string) (string, error) {
+	if inputStrategy.terminal < 0 {
+		return inputStrategy.readLine(prompt)
+	}
+	fmt.Println(prompt)
+	password, err := term.ReadPassword(inputStrategy.terminal)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(password)), nil
+}
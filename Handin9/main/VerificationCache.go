@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"runtime"
+	"sync"
+)
+
+//Signatures are checked again and again: when a transaction arrives, when its block arrives, and for every block
+//in the chain on a rollback. The cache remembers the result for each (message hash, signature, key), so only the first
+//check does the exponentiation. When it is full the oldest results are forgotten first.
+const signatureCacheSize = 100000
+
+var VerificationCache = MakeSignatureCache(signatureCacheSize)
+
+type SignatureCache struct {
+	results  map[[32]byte]bool
+	order    [][32]byte //keys in the order they were stored, used as a ring once the cache is full
+	next     int
+	capacity int
+	hits     int
+	misses   int
+	lock     *sync.Mutex
+}
+
+//MakeSignatureCache with capacity 0 gives a cache that never remembers anything
+func MakeSignatureCache(capacity int) *SignatureCache {
+	cache := new(SignatureCache)
+	cache.results = make(map[[32]byte]bool)
+	cache.order = make([][32]byte, 0)
+	cache.capacity = capacity
+	cache.lock = &sync.Mutex{}
+	return cache
+}
+
+func signatureCacheKey(message string, signature string, key string) [32]byte {
+	messageHash := sha256.Sum256([]byte(message))
+	//neither a signature nor a key contains a zero byte, so the fields can not be shifted into each other
+	combined := append(messageHash[:], []byte(signature+"\x00"+key)...)
+	return sha256.Sum256(combined)
+}
+
+func (cache *SignatureCache) Lookup(message string, signature string, key string) (bool, bool) {
+	cacheKey := signatureCacheKey(message, signature, key)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	verified, found := cache.results[cacheKey]
+	if found {
+		cache.hits++
+	} else {
+		cache.misses++
+	}
+	return verified, found
+}
+
+func (cache *SignatureCache) Store(message string, signature string, key string, verified bool) {
+	if cache.capacity == 0 {
+		return
+	}
+	cacheKey := signatureCacheKey(message, signature, key)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if _, found := cache.results[cacheKey]; found {
+		return
+	}
+	if len(cache.order) < cache.capacity {
+		cache.order = append(cache.order, cacheKey)
+	} else {
+		delete(cache.results, cache.order[cache.next])
+		cache.order[cache.next] = cacheKey
+		cache.next = (cache.next + 1) % cache.capacity
+	}
+	cache.results[cacheKey] = verified
+}
+
+//Stats returns the number of lookups that were and were not found
+func (cache *SignatureCache) Stats() (int, int) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.hits, cache.misses
+}
+
+//ParallelVerifier checks many transactions at once with a pool of workers. The results also end up in VerificationCache,
+//so checking the transactions one by one afterwards (like UpdateLedger does) is cheap
+type ParallelVerifier struct {
+	workers int
+}
+
+//MakeParallelVerifier with 0 workers uses one worker per CPU
+func MakeParallelVerifier(workers int) *ParallelVerifier {
+	verifier := new(ParallelVerifier)
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	verifier.workers = workers
+	return verifier
+}
+
+//VerifyTransactions returns whether each transaction verified, in the same order
+func (verifier *ParallelVerifier) VerifyTransactions(transactions []SignedTransaction) []bool {
+	results := make([]bool, len(transactions))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < verifier.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsa := new(RSA)
+			for index := range jobs {
+				results[index] = rsa.VerifyTransaction(transactions[index])
+			}
+		}()
+	}
+	for index := range transactions {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func makeSignedTransactions(count int) []SignedTransaction {
+	sender := MakeRSA(2048)
+	transactions := make([]SignedTransaction, count)
+	for i := range transactions {
+		transaction := MakeUnsignedTransaction(sender.Address(), GenesisRSA("1").Address(), i+1)
+		sender.SignTransaction(transaction)
+		transactions[i] = *transaction
+	}
+	return transactions
+}
+
+func TestParallelVerifierFindsTheInvalidTransactions(t *testing.T) {
+	defer func(cache *SignatureCache) { VerificationCache = cache }(VerificationCache)
+	VerificationCache = MakeSignatureCache(0)
+	transactions := makeSignedTransactions(20)
+	transactions[3].Amount = 1000
+	transactions[17].Signature = transactions[16].Signature
+
+	results := MakeParallelVerifier(4).VerifyTransactions(transactions)
+	for i, verified := range results {
+		if verified != (i != 3 && i != 17) {
+			t.Error("Transaction", i, "got the wrong result:", verified)
+		}
+	}
+	fmt.Println("TestParallelVerifierFindsTheInvalidTransactions passed")
+}
+
+func TestVerificationCacheRemembersResults(t *testing.T) {
+	defer func(cache *SignatureCache) { VerificationCache = cache }(VerificationCache)
+	VerificationCache = MakeSignatureCache(2)
+	rsa := MakeRSA(2048)
+	publicKey := rsa.PublicKeyString()
+	signature := rsa.SignMessage("hello")
+
+	rsa.VerifySignature("hello", signature, publicKey)
+	if !rsa.VerifySignature("hello", signature, publicKey) {
+		t.Error("A cached signature should still verify")
+	}
+	if rsa.VerifySignature("hellO", signature, publicKey) {
+		t.Error("The cache should not mix up messages")
+	}
+	if hits, misses := VerificationCache.Stats(); hits != 1 || misses != 2 {
+		t.Error("Expected 1 hit and 2 misses, got", hits, misses)
+	}
+	//the cache holds 2 results, so a third one pushes out the first
+	rsa.VerifySignature("third", signature, publicKey)
+	if _, found := VerificationCache.Lookup("hello", signature, publicKey); found {
+		t.Error("The oldest result should have been forgotten")
+	}
+
+	genesis := GenesisRSA("1")
+	legacy := genesis.SignMessage("hello")
+	genesis.VerifySignature("hello", legacy, genesis.PublicKeyString())
+	LegacySignaturesAllowed = false
+	defer func() { LegacySignaturesAllowed = true }()
+	if genesis.VerifySignature("hello", legacy, genesis.PublicKeyString()) {
+		t.Error("A cached legacy signature should be rejected once legacy signatures are turned off")
+	}
+	fmt.Println("TestVerificationCacheRemembersResults passed")
+}
+
+func benchmarkBlockVerification(b *testing.B, cache *SignatureCache, verify func([]SignedTransaction)) {
+	defer func(old *SignatureCache) { VerificationCache = old }(VerificationCache)
+	VerificationCache = cache
+	transactions := makeSignedTransactions(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		verify(transactions)
+	}
+}
+
+func BenchmarkVerifyBlockSequential(b *testing.B) {
+	benchmarkBlockVerification(b, MakeSignatureCache(0), func(transactions []SignedTransaction) {
+		rsa := new(RSA)
+		for _, transaction := range transactions {
+			rsa.VerifyTransaction(transaction)
+		}
+	})
+}
+
+func BenchmarkVerifyBlockParallel(b *testing.B) {
+	verifier := MakeParallelVerifier(0)
+	benchmarkBlockVerification(b, MakeSignatureCache(0), func(transactions []SignedTransaction) {
+		verifier.VerifyTransactions(transactions)
+	})
+}
+
+//a rollback replays blocks whose signatures were already checked when they arrived
+func BenchmarkVerifyBlockCached(b *testing.B) {
+	verifier := MakeParallelVerifier(0)
+	benchmarkBlockVerification(b, MakeSignatureCache(signatureCacheSize), func(transactions []SignedTransaction) {
+		verifier.VerifyTransactions(transactions)
+	})
+}
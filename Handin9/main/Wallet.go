@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Wallet keeps many named accounts, each in its own keystore file (see Keystore.go) in one directory.
+//Secret keys are only kept in memory for accounts that have been unlocked with their password.
+type Wallet struct {
+	dir      string
+	unlocked map[string]*RSA
+	lock     *sync.Mutex
+}
+
+const walletFileExtension = ".json"
+
+var walletAccountName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func MakeWallet(dir string) *Wallet {
+	wallet := new(Wallet)
+	wallet.dir = dir
+	wallet.unlocked = make(map[string]*RSA)
+	wallet.lock = &sync.Mutex{}
+	return wallet
+}
+
+//CreateAccount makes a new key pair and returns its public key, the secret key is only written to the keystore file
+func (wallet *Wallet) CreateAccount(name string, password string) (string, error) {
+	return wallet.addAccount(name, password, MakeRSA(2000))
+}
+
+//CreateEd25519Account is CreateAccount for an Ed25519 key
+func (wallet *Wallet) CreateEd25519Account(name string, password string) (string, error) {
+	return wallet.addAccount(name, password, MakeEd25519Account())
+}
+
+//ImportAccount stores keys that already exist (e.g. a genesis account) in the wallet
+func (wallet *Wallet) ImportAccount(name string, password string, rsa *RSA) (string, error) {
+	return wallet.addAccount(name, password, rsa)
+}
+
+func (wallet *Wallet) addAccount(name string, password string, rsa *RSA) (string, error) {
+	filename, err := wallet.accountFile(name)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("the password can not be empty")
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return "", errors.New("the wallet already has an account called " + name)
+	}
+	keystore, err := MakeKeystore(rsa, password)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(wallet.dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	err = WriteKeystore(filename, keystore)
+	if err != nil {
+		return "", err
+	}
+	return keystore.PublicKey.N, nil
+}
+
+//Accounts returns the names of all accounts in the wallet, sorted
+func (wallet *Wallet) Accounts() []string {
+	files, _ := filepath.Glob(filepath.Join(wallet.dir, "*"+walletFileExtension))
+	names := make([]string, 0)
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), walletFileExtension))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (wallet *Wallet) HasAccount(name string) bool {
+	filename, err := wallet.accountFile(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filename)
+	return err == nil
+}
+
+//PublicKey can be read without the password
+func (wallet *Wallet) PublicKey(name string) (string, error) {
+	filename, err := wallet.accountFile(name)
+	if err != nil {
+		return "", err
+	}
+	keystore, err := ReadKeystore(filename)
+	if err != nil {
+		return "", err
+	}
+	return keystore.PublicKey.N, nil
+}
+
+//Address is what other users send to, it is made from the public key
+func (wallet *Wallet) Address(name string) (Address, error) {
+	publicKey, err := wallet.PublicKey(name)
+	if err != nil {
+		return "", err
+	}
+	return AddressOfPublicKey(publicKey), nil
+}
+
+//Unlock decrypts the account's secret key and keeps it in memory until Lock is called
+func (wallet *Wallet) Unlock(name string, password string) (*RSA, error) {
+	filename, err := wallet.accountFile(name)
+	if err != nil {
+		return nil, err
+	}
+	rsa, err := LoadAccount(filename, password)
+	if err != nil {
+		return nil, err
+	}
+	wallet.lock.Lock()
+	wallet.unlocked[name] = rsa
+	wallet.lock.Unlock()
+	return rsa, nil
+}
+
+func (wallet *Wallet) Lock(name string) {
+	wallet.lock.Lock()
+	delete(wallet.unlocked, name)
+	wallet.lock.Unlock()
+}
+
+func (wallet *Wallet) IsUnlocked(name string) bool {
+	wallet.lock.Lock()
+	defer wallet.lock.Unlock()
+	return wallet.unlocked[name] != nil
+}
+
+//SignTransaction makes a transaction from the account, which must be unlocked
+func (wallet *Wallet) SignTransaction(name string, to Address, amount int) (*SignedTransaction, error) {
+	wallet.lock.Lock()
+	rsa := wallet.unlocked[name]
+	wallet.lock.Unlock()
+	if rsa == nil {
+		return nil, errors.New("the account " + name + " is locked")
+	}
+	if amount < 1 {
+		return nil, errors.New("the amount must be more than 0")
+	}
+	transaction := MakeUnsignedTransaction(rsa.Address(), to, amount)
+	rsa.SignTransaction(transaction)
+	return transaction, nil
+}
+
+func (wallet *Wallet) accountFile(name string) (string, error) {
+	if !walletAccountName.MatchString(name) {
+		return "", errors.New("account names may only contain letters, digits, - and _")
+	}
+	return filepath.Join(wallet.dir, name+walletFileExtension), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWalletKeepsSeveralAccountsAndSignsWhenUnlocked(t *testing.T) {
+	wallet := MakeWallet(t.TempDir())
+	alicePk, err := wallet.CreateAccount("alice", "alicepw")
+	if err != nil {
+		t.Fatal("Could not create an account:", err)
+	}
+	wallet.ImportAccount("genesis1", "genesispw", GenesisRSA("1"))
+	if _, err := wallet.CreateAccount("alice", "other"); err == nil {
+		t.Error("Creating an account with a name that is taken should fail")
+	}
+	if _, err := wallet.CreateAccount("../escape", "pw"); err == nil {
+		t.Error("Account names with path separators should be rejected")
+	}
+	if accounts := wallet.Accounts(); strings.Join(accounts, ",") != "alice,genesis1" {
+		t.Error("Expected the accounts alice and genesis1, got", accounts)
+	}
+
+	if _, err := wallet.SignTransaction("alice", "someone", 10); err == nil {
+		t.Error("Signing with a locked account should fail")
+	}
+	if _, err := wallet.Unlock("alice", "wrong"); err != ErrInvalidPassword {
+		t.Error("Unlocking with the wrong password should fail, got", err)
+	}
+	wallet.Unlock("alice", "alicepw")
+	transaction, err := wallet.SignTransaction("alice", "someone", 10)
+	if err != nil || transaction.From != AddressOfPublicKey(alicePk) {
+		t.Fatal("Could not sign with the unlocked account:", err)
+	}
+	if !MakeRSAWithKeys(alicePk, "0").VerifyTransaction(*transaction) {
+		t.Error("The transaction signed by the wallet did not verify")
+	}
+	wallet.Lock("alice")
+	if wallet.IsUnlocked("alice") {
+		t.Error("The account should be locked again")
+	}
+	fmt.Println("TestWalletKeepsSeveralAccountsAndSignsWhenUnlocked passed")
+}
+
+func TestWalletUserInputStrategyNeverAsksForSecretKey(t *testing.T) {
+	wallet := MakeWallet(t.TempDir())
+	receiver := GenesisRSA("2").Address()
+	typo := []byte(receiver)
+	typo[5] = base58Alphabet[(strings.IndexByte(base58Alphabet, typo[5])+1)%len(base58Alphabet)]
+	input := strings.NewReader("y\npw\npw\npw\n" + string(typo) + "\n" + string(receiver) + "\n5\n")
+	inputStrategy := MakeWalletUserInputStrategy(wallet, "bob", input)
+	err := inputStrategy.Setup()
+	if err != nil {
+		t.Fatal("Setup failed:", err)
+	}
+
+	peer := createPeerWithUserInput(inputStrategy)
+	peer.SetupAccount()
+	publicKey, _ := wallet.PublicKey("bob")
+	if ConvertBigIntToString(&peer.rsa.n) != publicKey {
+		t.Error("The peer should use the account from the wallet")
+	}
+
+	transaction, err := inputStrategy.HandleIncomingFromUser()
+	if err != nil {
+		t.Fatal("Could not read the transaction:", err)
+	}
+	if transaction.From != AddressOfPublicKey(publicKey) || transaction.To != receiver || transaction.Amount != 5 || !peer.rsa.VerifyTransaction(transaction) {
+		t.Error("Got an unexpected transaction", transaction)
+	} else {
+		fmt.Println("TestWalletUserInputStrategyNeverAsksForSecretKey passed")
+	}
+}
+
+func createPeerWithUserInput(userInputStrategy UserInputStrategy) *Peer {
+	fixedUriStrategy := MakeFixedUriStrategy("fa", "fa")
+	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
+	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
+	transportStrategy := MakePipeTransportStrategy()
+	return MakePeer(fixedUriStrategy, userInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
+}
+
+func TestWalletUserInputStrategyOnlyAcceptsKnownChoices(t *testing.T) {
+	for _, decision := range []string{"n", "0", "11", "01", "yes please"} {
+		wallet := MakeWallet(t.TempDir())
+		inputStrategy := MakeWalletUserInputStrategy(wallet, "bob", strings.NewReader(decision+"\npw\npw\npw\n"))
+		if inputStrategy.Setup() == nil || wallet.HasAccount("bob") {
+			t.Error("The answer", decision, "should not create an account")
+		}
+	}
+	wallet := MakeWallet(t.TempDir())
+	inputStrategy := MakeWalletUserInputStrategy(wallet, "bob", strings.NewReader("10\npw\npw\npw\n"))
+	if inputStrategy.Setup() != nil {
+		t.Error("Genesis account 10 should be accepted")
+	} else {
+		fmt.Println("TestWalletUserInputStrategyOnlyAcceptsKnownChoices passed")
+	}
+}
@@ -18,7 +18,7 @@ func TestShouldCreateLedger(t *testing.T) {
 func TestShouldCreateTransaction(t *testing.T) {
 	transaction := MakeSignedTransaction("from", "to", 0, "yeet")
 
-	if transaction.From != "from" {
+	if transaction.From != AddressOfPublicKey("from") {
 		t.Errorf("From not initialized correctly")
 	}
 	if transaction.To != "to" {
@@ -51,11 +51,12 @@ func TestShouldAddAccountToLedger(t *testing.T) {
 
 func TestShouldMoveMoneyFromAccountToAccountOnTransaction(t *testing.T) {
 	ledger := MakeLedger()
-	ledger.Accounts["acc1"] = 200
-	ledger.Accounts["acc2"] = 200
-	transaction := MakeSignedTransaction("acc1", "acc2", 100, "yeet")
+	acc1, acc2 := AddressOfPublicKey("acc1"), AddressOfPublicKey("acc2")
+	ledger.Accounts[acc1] = 200
+	ledger.Accounts[acc2] = 200
+	transaction := MakeSignedTransaction("acc1", acc2, 100, "yeet")
 	ledger.Transaction(transaction)
-	accountBalancesCorrect := ledger.Accounts["acc1"] == 100 && ledger.Accounts["acc2"] == 300
+	accountBalancesCorrect := ledger.Accounts[acc1] == 100 && ledger.Accounts[acc2] == 300
 
 	if !accountBalancesCorrect {
 		t.Errorf("Account balances should be correct")
@@ -66,9 +67,10 @@ func TestShouldMoveMoneyFromAccountToAccountOnTransaction(t *testing.T) {
 
 func TestShouldMoveMoneyOnNewAccounts(t *testing.T) {
 	ledger := MakeLedger()
-	transaction := MakeSignedTransaction("acc1", "acc2", 100, "yeet")
+	acc1, acc2 := AddressOfPublicKey("acc1"), AddressOfPublicKey("acc2")
+	transaction := MakeSignedTransaction("acc1", acc2, 100, "yeet")
 	ledger.Transaction(transaction)
-	accountBalancesCorrect := ledger.Accounts["acc1"] == -100 && ledger.Accounts["acc2"] == 100
+	accountBalancesCorrect := ledger.Accounts[acc1] == -100 && ledger.Accounts[acc2] == 100
 
 	if !accountBalancesCorrect {
 		t.Errorf("Account balances should be correct")
@@ -76,3 +78,19 @@ func TestShouldMoveMoneyOnNewAccounts(t *testing.T) {
 		fmt.Println("Ledger test TestShouldMoveMoneyOnNewAccounts passed")
 	}
 }
+
+func TestLedgerRefusesTransactionsToInvalidAddresses(t *testing.T) {
+	ledger := MakeLedger()
+	from := AddressOfPublicKey("from")
+	ledger.Accounts[from] = 200
+	for _, to := range []Address{"acc2", " " + AddressOfPublicKey("to"), AddressOfPublicKey("to") + "x"} {
+		if ledger.Transaction(MakeUnsignedTransaction(from, to, 100)) {
+			t.Error("The ledger should refuse a transaction to", to)
+		}
+	}
+	if ledger.Accounts[from] != 200 || len(ledger.Accounts) != 1 {
+		t.Error("A refused transaction should not change the ledger")
+	} else {
+		fmt.Println("Ledger test TestLedgerRefusesTransactionsToInvalidAddresses passed")
+	}
+}
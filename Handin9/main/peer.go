@@ -2,15 +2,21 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"math/big"
 	"math/rand"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -29,41 +35,72 @@ type TransactionStruct struct {
 type BlocksSent = map[string]bool
 
 type Peer struct {
-	outbound               chan SignedTransaction //The channel used to handle incoming messages, funelling them to a separate method to handle broadcast and printing
-	messagesSent           MessagesSent           //Map of the messages this peer has already sent and printed to user
-	messagesSentMutex      *sync.Mutex            //Mutex for handling concurrency when inserting into the messagesSent map
-	connections            Connections            //Map containing all the active connections for this peer
-	connectionsMutex       *sync.Mutex            //Mutex for handling concurrency when reading from and writing to the connections map.
-	uriStrategy            UriStrategy            //Strategy for getting the URI to which it tries to connect
-	userInputStrategy      UserInputStrategy
-	outboundIPStrategy     OutboundIPStrategy
-	messageSendingStrategy MessageSendingStrategy
-	port                   string //outbound port (for taking new connections)
-	ip                     string //outbound ip
-	ledger                 *Ledger
-	connectionsURI         ConnectionsURI //Holds the URIs of all peers currently present in the network.
-	connectionsURIMutex    *sync.Mutex    //Mutex for connectionsURI
-	rsa                    *RSA           //RSA object to do verification and signing
-	nextBlock              Block
-	nextBlockMutex         *sync.Mutex
-	genesisLedger          *Ledger
-	seed                   int
-	hardness               big.Int //Dis big boi be big tuf
-	genesisBlock           Block
-	blocksSent             BlocksSent
-	blocksSentMutex        *sync.Mutex
-	slotLength             float64
-	slotNumber             int
-	connectionThreshold    int
-	blockTree              *BlockTree
-	systemRunning          bool
-	winners                map[int][]string
-}
-
-func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrategy, message MessageSendingStrategy) *Peer {
+	outbound                  chan SignedTransaction //The channel used to handle incoming messages, funelling them to a separate method to handle broadcast and printing
+	messagesSent              MessagesSent           //Map of the messages this peer has already sent and printed to user
+	messagesSentMutex         *sync.Mutex            //Mutex for handling concurrency when inserting into the messagesSent map
+	connections               Connections            //Map containing all the active connections for this peer
+	connectionsMutex          *sync.Mutex            //Mutex for handling concurrency when reading from and writing to the connections map.
+	uriStrategy               UriStrategy            //Strategy for getting the URI to which it tries to connect
+	userInputStrategy         UserInputStrategy
+	outboundIPStrategy        OutboundIPStrategy
+	messageSendingStrategy    MessageSendingStrategy
+	transportStrategy         TransportStrategy //Strategy for dialing, listening and sending to other peers
+	port                      string            //outbound port (for taking new connections)
+	ip                        string            //outbound ip
+	ledger                    *Ledger
+	peerTable                 *PeerTable          //Holds a bounded number of URIs of the peers in the network, see PeerTable.go
+	outboundURIs              map[net.Conn]string //The URI we dialed for each outbound connection, guarded by connectionsMutex
+	heartbeatConfig           HeartbeatConfig
+	lastHeard                 map[net.Conn]time.Time //When each connection last sent us anything
+	lostURIs                  []string               //Outbound connections lost since the last heartbeat, to be dialed again
+	deadPeerProbes            map[string]bool        //Addresses we were told are gone and are dialing to check
+	heartbeatMutex            *sync.Mutex            //Mutex for the heartbeat fields above
+	rsa                       *RSA                   //RSA object to do verification and signing
+	nextBlock                 Block
+	nextBlockMutex            *sync.Mutex
+	genesisLedger             *Ledger
+	seed                      int
+	hardness                  big.Int //Dis big boi be big tuf
+	genesisBlock              Block
+	blocksSent                BlocksSent
+	blocksSentMutex           *sync.Mutex
+	slotLength                float64
+	slotNumber                int
+	connectionThreshold       int
+	blockTree                 *BlockTree
+	systemRunning             atomic.Bool //set once the genesis block is handled, read by the user input goroutine
+	winners                   map[int][]string
+	connectionIdentities      map[net.Conn]string //Account key of the peer at the other end of each connection, if the transport authenticated it
+	connectionIdentitiesMutex *sync.Mutex
+	registry                  *AddressRegistry             //Public keys of the addresses we have seen
+	multisigPending           map[string]*multisigProposal //Multisig transactions that do not have enough signatures yet, by MultisigProposalHash
+	multisigMutex             *sync.Mutex
+	verifier                  *ParallelVerifier //Verifies the transactions of a block at the same time
+	logger                    *Logger           //Also handed to the ledgers, the account and the block tree, see SetLogger
+	lifecycle                 *lifecycle        //Context, background loops and state file, see Lifecycle.go
+	misbehaviourConfig        MisbehaviourConfig
+	misbehaviourScores        map[net.Conn]int             //Points left on each connection before it is banned, see Misbehaviour.go
+	seenOnConnection          map[net.Conn]map[string]bool //IDs of the transactions and blocks each connection has sent us
+	bans                      map[string]time.Time         //Banned hosts and when their ban ends
+	misbehaviourMutex         *sync.Mutex
+	gossipMode                GossipMode
+	inventoryKnown            map[net.Conn]map[string]bool //Items each connection has announced, sent or been sent, see Gossip.go
+	inventoryRequested        map[string]*inventoryRequest //Items we asked a neighbour for and who else announced them
+	blockData                 map[string][]byte            //Marshalled blocks by item, to answer getdata
+	blockDataOrder            []string                     //The items in blockData, oldest first
+	inventoryMutex            *sync.Mutex
+	gossipStats               *GossipStats
+	sendQueueConfig           SendQueueConfig
+	sendQueues                map[net.Conn]*sendQueue //The writer of each connection, guarded by connectionsMutex, see SendQueue.go
+	droppedMessages           int
+	listenAddress             string //host:port to bind, see NetworkAddress.go
+	advertisedAddress         string //host:port we tell the other peers
+}
+
+func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrategy, message MessageSendingStrategy, transport TransportStrategy) *Peer {
 	//Initialize all fields
 	peer := new(Peer)
-	peer.outbound = make(chan SignedTransaction)
+	peer.outbound = make(chan SignedTransaction, outboundBufferSize)
 	peer.connectionsMutex = &sync.Mutex{}
 	peer.connections = make([]net.Conn, 0)
 	peer.messagesSent = make(map[string]TransactionStruct)
@@ -72,9 +109,15 @@ func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrate
 	peer.userInputStrategy = user
 	peer.outboundIPStrategy = outbound
 	peer.messageSendingStrategy = message
+	peer.transportStrategy = transport
 	peer.ledger = MakeLedger()
-	peer.connectionsURI = make([]string, 0)
-	peer.connectionsURIMutex = &sync.Mutex{}
+	peer.peerTable = MakePeerTable(DefaultPeerTableConfig())
+	peer.outboundURIs = make(map[net.Conn]string)
+	peer.heartbeatConfig = DefaultHeartbeatConfig()
+	peer.lastHeard = make(map[net.Conn]time.Time)
+	peer.lostURIs = make([]string, 0)
+	peer.deadPeerProbes = make(map[string]bool)
+	peer.heartbeatMutex = &sync.Mutex{}
 	peer.rsa = MakeRSA(2000)
 	peer.nextBlock = make([]string, 0)
 	peer.nextBlockMutex = &sync.Mutex{}
@@ -88,61 +131,231 @@ func MakePeer(uri UriStrategy, user UserInputStrategy, outbound OutboundIPStrate
 	peer.slotNumber = 0
 	peer.connectionThreshold = 10 // <-- Change # of peers here!
 	peer.blockTree = nil          //This will ALWAYS point to the genesis block in the tree (after HandleGenesisBlock())
-	peer.systemRunning = false
 	peer.winners = make(map[int][]string)
+	peer.connectionIdentities = make(map[net.Conn]string)
+	peer.connectionIdentitiesMutex = &sync.Mutex{}
+	peer.registry = MakeAddressRegistry()
+	peer.multisigPending = make(map[string]*multisigProposal)
+	peer.multisigMutex = &sync.Mutex{}
+	peer.verifier = MakeParallelVerifier(0)
+	peer.lifecycle = makeLifecycle()
+	peer.misbehaviourConfig = DefaultMisbehaviourConfig()
+	peer.misbehaviourScores = make(map[net.Conn]int)
+	peer.seenOnConnection = make(map[net.Conn]map[string]bool)
+	peer.bans = make(map[string]time.Time)
+	peer.misbehaviourMutex = &sync.Mutex{}
+	peer.gossipMode = InventoryGossip
+	peer.inventoryKnown = make(map[net.Conn]map[string]bool)
+	peer.inventoryRequested = make(map[string]*inventoryRequest)
+	peer.blockData = make(map[string][]byte)
+	peer.blockDataOrder = make([]string, 0)
+	peer.inventoryMutex = &sync.Mutex{}
+	peer.gossipStats = MakeGossipStats()
+	peer.sendQueueConfig = DefaultSendQueueConfig()
+	peer.sendQueues = make(map[net.Conn]*sendQueue)
+	peer.SetLogger(DefaultLogger)
+	peer.UseAccountForTransport()
 	return peer
 }
 
-func main() {
-	//Intialize strategy and peer
-	commandLineUriStrategy := new(CommandLineUriStrategy) // Strategy to get the URI from user command-line input
-	commandLineUserInputStrategy := new(CommandLineUserInputStrategy)
-	outboundIPStrategy := new(RealOutboundIPStrategy)
-	messageSendingStrategy := new(RealMessageSendingStrategy)
-	peer := MakePeer(commandLineUriStrategy, commandLineUserInputStrategy, outboundIPStrategy, messageSendingStrategy)
-	peer.run()
+//SetLogger gives the peer and everything it owns a logger for their own component made from logger
+func (peer *Peer) SetLogger(logger *Logger) {
+	peer.logger = logger.For("peer")
+	peer.injectLogger()
 }
 
-func (peer *Peer) run() {
-	//ask for IP and port of an existing peer via user input or other strategy
-	otherURI := peer.GetURI()
-
-	peer.AddNewSkUser()
-
-	//connect to the given IP and port via TCP
-	conn := peer.JoinNetwork(otherURI)
-	if conn != nil {
-		defer conn.Close()
+//Must be called whenever the ledgers, the account or the block tree are replaced
+func (peer *Peer) injectLogger() {
+	peer.ledger.SetLogger(peer.logger.For("ledger"))
+	peer.genesisLedger.SetLogger(peer.logger.For("ledger"))
+	peer.rsa.SetLogger(peer.logger.For("rsa"))
+	if peer.blockTree != nil {
+		peer.blockTree.SetLogger(peer.logger.For("blocktree"))
 	}
+}
 
-	//listen for connections on own ip and port to which other peers can connect, the listener object is passed to takeNewConnection
-	listener := peer.StartListeningForConnections()
-	defer listener.Close()
-
-	//add yourself to end of connectionsURI list which was received in the joinNetwork call
-	peer.AddSelfToConnectionsURI()
+func main() {
+	if IsBenchReportCommand(os.Args[1:]) {
+		err := RunBenchReport(os.Args[1:], os.Stdout)
+		if err != nil {
+			fmt.Println("Error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if IsGossipSimulationCommand(os.Args[1:]) {
+		err := RunGossipSimulation(os.Args[1:], os.Stdout)
+		if err != nil {
+			fmt.Println("Error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	//broadcast new presence in network so everyone can append you to connectionsURI
-	ownURI := peer.ip + ":" + peer.port
-	peer.BroadcastPresence(ownURI)
+	useTLS := flag.Bool("tls", false, "authenticate peers with TLS certificates bound to their account keys")
+	requireTLS := flag.Bool("require-tls", false, "refuse peers that do not use TLS (implies -tls)")
+	encrypt := flag.Bool("encrypt", false, "encrypt all traffic with an AES session key from a key exchange signed by the account key")
+	walletDir := flag.String("wallet", "", "directory with encrypted account keystores, secret keys are then never typed in")
+	account := flag.String("account", "default", "name of the wallet account to use")
+	legacySignatures := flag.Bool("legacy-signatures", false, "accept textbook RSA signatures (e=3) from the genesis accounts, every peer of a network running on them needs it")
+	newSeedPhrase := flag.Bool("new-seed-phrase", false, "print a new seed phrase that wallet accounts can be derived from, and exit")
+	logLevel := flag.String("log-level", "info", "only log entries at this level or above: debug, info, warn, error or off")
+	logComponents := flag.String("log-components", "", "levels for single components, overriding -log-level, e.g. rsa=debug,peer=warn")
+	logJSON := flag.Bool("log-json", false, "write log entries as JSON, one object per line")
+	peerTableConfig := DefaultPeerTableConfig()
+	flag.IntVar(&peerTableConfig.MaxAddresses, "max-addresses", peerTableConfig.MaxAddresses, "how many peer addresses to remember")
+	flag.IntVar(&peerTableConfig.TargetOutbound, "outbound", peerTableConfig.TargetOutbound, "how many peers to connect to")
+	flag.IntVar(&peerTableConfig.MaxInbound, "max-inbound", peerTableConfig.MaxInbound, "how many connections from other peers to accept")
+	flag.DurationVar(&peerTableConfig.GossipInterval, "gossip-interval", peerTableConfig.GossipInterval, "how often to exchange addresses with a random neighbour")
+	heartbeatConfig := DefaultHeartbeatConfig()
+	flag.DurationVar(&heartbeatConfig.Interval, "heartbeat-interval", heartbeatConfig.Interval, "how often to ping the connected peers")
+	flag.DurationVar(&heartbeatConfig.Timeout, "heartbeat-timeout", heartbeatConfig.Timeout, "how long a peer may be silent before the connection is closed")
+	misbehaviourConfig := DefaultMisbehaviourConfig()
+	flag.DurationVar(&misbehaviourConfig.BanDuration, "ban-duration", misbehaviourConfig.BanDuration, "how long to refuse a peer that broke the protocol")
+	sendQueueConfig := DefaultSendQueueConfig()
+	flag.IntVar(&sendQueueConfig.QueueSize, "send-queue", sendQueueConfig.QueueSize, "how many messages may wait to be written to each connection")
+	flag.DurationVar(&sendQueueConfig.WriteTimeout, "write-timeout", sendQueueConfig.WriteTimeout, "how long a write may take before the connection is closed")
+	slowPeerPolicy := flag.String("slow-peer", "drop", "what to do when a peer does not keep up and its send queue is full: drop the message or disconnect")
+	listenAddress := flag.String("listen", "", "host:port to take connections on, e.g. :5000 for every interface, default the outbound IP on a free port")
+	advertisedAddress := flag.String("advertise", "", "host:port other peers should connect to, e.g. the address of a NAT router forwarding the port")
+	gossipMode := flag.String("gossip", "inv", "how to pass on transactions and blocks: inv announces them and sends them when asked, flood sends them to everyone")
+	peersFile := flag.String("peers-file", "", "file to save the peer table in when stopping, and to load it from when starting")
+	flag.Parse()
+	LegacySignaturesAllowed = *legacySignatures
+
+	level, err := ParseLogLevel(*logLevel)
+	if err == nil {
+		err = DefaultLogger.SetComponentLevels(*logComponents)
+	}
+	if err != nil {
+		fmt.Println("Error", err)
+		os.Exit(1)
+	}
+	DefaultLogger.SetLevel(level)
+	DefaultLogger.SetJSON(*logJSON)
 
-	//take input from the user
-	go peer.HandleIncomingFromUser()
+	if *newSeedPhrase {
+		phrase, err := NewSeedPhrase()
+		if err != nil {
+			fmt.Println("Could not make a seed phrase:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Write this down, every account derived from it can be restored with it:")
+		fmt.Println(phrase)
+		return
+	}
 
-	//set up a thread to send outbound messages
-	go peer.SendMessages()
+	//Intialize strategy and peer
+	commandLineUriStrategy := new(CommandLineUriStrategy) // Strategy to get the URI from user command-line input
+	var userInputStrategy UserInputStrategy = new(CommandLineUserInputStrategy)
+	if *walletDir != "" {
+		walletInputStrategy := MakeWalletUserInputStrategy(MakeWallet(*walletDir), *account, os.Stdin)
+		err := walletInputStrategy.Setup()
+		if err != nil {
+			fmt.Println("Could not open the wallet account:", err)
+			os.Exit(1)
+		}
+		userInputStrategy = walletInputStrategy
+	}
+	outboundIPStrategy := new(RealOutboundIPStrategy)
+	messageSendingStrategy := new(RealMessageSendingStrategy)
+	var transportStrategy TransportStrategy = MakeTCPTransportStrategy()
+	if *useTLS || *requireTLS {
+		transportStrategy = MakeTLSTransportStrategy(transportStrategy, *requireTLS)
+	}
+	if *encrypt {
+		transportStrategy = MakeEncryptedTransportStrategy(transportStrategy)
+	}
+	peer := MakePeer(commandLineUriStrategy, userInputStrategy, outboundIPStrategy, messageSendingStrategy, transportStrategy)
+	err = peer.SetPeerTableConfig(peerTableConfig)
+	if err == nil {
+		err = peer.SetHeartbeatConfig(heartbeatConfig)
+	}
+	if err == nil {
+		err = peer.SetMisbehaviourConfig(misbehaviourConfig)
+	}
+	if err == nil {
+		err = peer.SetListenAddress(*listenAddress)
+	}
+	if err == nil {
+		err = peer.SetAdvertisedAddress(*advertisedAddress)
+	}
+	if err == nil {
+		sendQueueConfig.Policy, err = ParseSlowPeerPolicy(*slowPeerPolicy)
+	}
+	if err == nil {
+		err = peer.SetSendQueueConfig(sendQueueConfig)
+	}
+	if err == nil {
+		var mode GossipMode
+		mode, err = ParseGossipMode(*gossipMode)
+		peer.SetGossipMode(mode)
+	}
+	if err != nil {
+		fmt.Println("Error", err)
+		os.Exit(1)
+	}
+	peer.SetStateFile(*peersFile)
 
-	//listen for connections from other peers
-	for {
-		peer.TakeNewConnection(listener)
+	//stop gracefully on Ctrl-C, the peer also stops when the user quits
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	err = peer.Start(ctx)
+	if err != nil {
+		fmt.Println("Could not start the peer:", err)
+		os.Exit(1)
+	}
+	<-peer.Done()
+	err = peer.Stop()
+	if err != nil {
+		fmt.Println("Error while stopping:", err)
+		os.Exit(1)
 	}
 }
 
 func (peer *Peer) TakeNewConnection(listener net.Listener) {
 	in_conn, err := listener.Accept()
-	fmt.Println("Connection accepted on IP: ", listener.Addr().String())
 	if err != nil {
-		fmt.Println("New peer connection failed")
+		if peer.Running() {
+			peer.logger.Warn("New peer connection failed", "error", err)
+		}
+		return
+	}
+	peer.logger.Info("Connection accepted", "ip", listener.Addr().String())
+	if peer.IsBanned(remoteHost(in_conn)) {
+		peer.logger.Info("Refusing a banned peer", "host", remoteHost(in_conn))
+		in_conn.Close()
+		return
+	}
+	//a peer that stalls its handshake must not hold up the next accept
+	go peer.acceptConnection(in_conn)
+}
+
+func (peer *Peer) acceptConnection(in_conn net.Conn) {
+	//Stop does not wait for handshakes in progress, it closes their connections instead
+	handshakeDone := make(chan bool)
+	go func() {
+		select {
+		case <-peer.lifecycle.ctx.Done():
+			in_conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+	in_conn, err := peer.HandshakeWithPeer(in_conn, false)
+	close(handshakeDone)
+	if err != nil {
+		peer.logger.Warn("Could not authenticate new peer", "error", err)
+		return
+	}
+	if !peer.Running() {
+		in_conn.Close()
+		return
+	}
+	if peer.InboundCount() >= peer.peerTable.Config().MaxInbound {
+		//the new peer can still find others through the addresses we send it
+		peer.logger.Info("Too many inbound connections, sending addresses and closing")
+		peer.SendConnectionsURI(in_conn)
+		in_conn.Close()
 		return
 	}
 
@@ -158,18 +371,16 @@ func (peer *Peer) TakeNewConnection(listener net.Listener) {
 }
 
 func (peer *Peer) BroadcastPresence(uri string) {
-	//add a delimiter to make it easier to read on the other side
-	uriToSend := uri + "uri]"
+	//add a delimiter to make it easier to read on the other side, a peer only passes it on if it was new to its peer table
+	uriToSend := escapeDelimiter(uri) + "uri]"
+	//copy the connections, DeleteFromConnections needs the lock when a send fails
 	peer.connectionsMutex.Lock()
-	defer peer.connectionsMutex.Unlock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
 
 	//send the presence to all connections
-	for _, conn := range peer.connections {
-		_, err := fmt.Fprint(conn, uriToSend)
-		if err != nil {
-			//delete the missing connection
-			peer.DeleteFromConnections(conn)
-		}
+	for _, conn := range connections {
+		peer.send(conn, []byte(uriToSend))
 	}
 }
 
@@ -178,81 +389,180 @@ func (peer *Peer) GetURI() string {
 }
 
 func (peer *Peer) AddSelfToConnectionsURI() {
-	peer.AppendToConnectionsURI(peer.ip + ":" + peer.port)
+	peer.peerTable.SetSelf(peer.URI())
 }
 
 func (peer *Peer) StartListeningForConnections() net.Listener {
-	peer.ip = peer.outboundIPStrategy.GetOutboundIP()
-	listener, _ := net.Listen("tcp", peer.ip+":")
-	_, own_port, _ := net.SplitHostPort(listener.Addr().String())
-	peer.port = own_port
-	fmt.Println("Taking connections on " + peer.ip + ":" + own_port)
+	listener, err := peer.listen()
+	if err != nil {
+		panic(err)
+	}
 	return listener
 }
 
+func (peer *Peer) listen() (net.Listener, error) {
+	address := peer.bindAddress()
+	listener, own_port, err := peer.transportStrategy.Listen(address)
+	if err != nil {
+		peer.logger.Error("Could not listen for connections", "address", address, "error", err)
+		return nil, err
+	}
+	bindHost, _ := splitListenAddress(address)
+	peer.ip, peer.port = peer.advertise(bindHost, own_port)
+	peer.logger.Info("Taking connections", "address", net.JoinHostPort(bindHost, own_port), "uri", peer.URI())
+	return listener, nil
+}
+
 func (peer *Peer) JoinNetwork(uri string) net.Conn {
 	//connect to the given uri via TCP
-	fmt.Println("Connecting to uri: ", uri)
-	out_conn, err := net.Dial("tcp", uri)
+	peer.logger.Info("Connecting", "uri", uri)
+	out_conn, err := peer.transportStrategy.Dial(uri)
 	if err != nil {
-		fmt.Println("No peer found, starting new  peer to peer network")
+		peer.logger.Info("No peer found, starting new peer to peer network")
 		go peer.SendGenesisBlockEventually()
 		return nil
 	} else {
-		peer.AppendToConnections(out_conn)
-		//receive the peer's connectionsURI list before anything else
-		peer.connectionsURIMutex.Lock()
-		newConnectionsURI := peer.ReceiveConnectionsURI(out_conn)
-		peer.connectionsURI = newConnectionsURI
-		peer.connectionsURIMutex.Unlock()
+		out_conn, err = peer.HandshakeWithPeer(out_conn, true)
+		if err != nil {
+			peer.logger.Warn("Could not authenticate the peer", "uri", uri, "error", err)
+			return nil
+		}
+		peer.AppendToOutboundConnections(out_conn, uri)
+		//receive some of the peer's addresses before anything else
+		peer.peerTable.AddAll(peer.ReceiveConnectionsURI(out_conn))
+		peer.peerTable.MarkReachable(uri)
+		peer.peerTable.SetKey(uri, peer.GetConnectionIdentity(out_conn))
 
 		go peer.HandleIncomingMessagesFromPeer(out_conn)
 
-		//connect to the 10 peers before yourself in the list
-		peer.ConnectToFirst10PeersInConnectionsURI(peer.connectionsURI, uri)
+		//connect to more peers from the addresses we got
+		peer.ConnectToMorePeers()
 		return out_conn
 	}
 }
 
 func (peer *Peer) SendGenesisBlockEventually() {
 	peer.genesisBlock = peer.MakeGenesisBlock()
-	for {
-		time.Sleep(1)
-		peer.connectionsURIMutex.Lock()
-		if len(peer.connectionsURI) >= peer.connectionThreshold {
-			peer.connectionsURIMutex.Unlock()
-			fmt.Println("Enough peers in system, send genesis block")
+	for peer.sleep(10 * time.Millisecond) {
+		if peer.peerTable.Len() >= peer.connectionThreshold {
+			peer.logger.Info("Enough peers in system, send genesis block")
 			marshalled := peer.MarshalBlock(peer.genesisBlock)
-			peer.SendBlockToAllPeers(marshalled)
+			//with inventory gossip our own genesis block is not sent back to us, so we start from it right away
+			demarshalled, _ := peer.DemarshalBlock(marshalled)
+			peer.blocksSentMutex.Lock()
+			if !peer.blocksSent[demarshalled[len(demarshalled)-2]] {
+				peer.blocksSent[demarshalled[len(demarshalled)-2]] = true
+				peer.startFromGenesisBlock(demarshalled[:len(demarshalled)-2])
+			}
+			peer.blocksSentMutex.Unlock()
+			peer.RelayBlock(marshalled)
 			return
 		}
-		peer.connectionsURIMutex.Unlock()
 	}
 }
 
-func (peer *Peer) ConnectToPeer(uri string) {
-	out_conn, err := net.Dial("tcp", uri)
+//ConnectToPeer returns false if the peer could not be reached, its address then loses score in the peer table
+func (peer *Peer) ConnectToPeer(uri string) bool {
+	out_conn, err := peer.transportStrategy.Dial(uri)
 	if err != nil {
-		return
+		peer.peerTable.MarkFailed(uri)
+		return false
+	}
+	if peer.IsBanned(remoteHost(out_conn)) {
+		out_conn.Close()
+		return false
+	}
+	out_conn, err = peer.HandshakeWithPeer(out_conn, true)
+	if err != nil {
+		peer.logger.Warn("Could not authenticate the peer", "uri", uri, "error", err)
+		peer.peerTable.MarkFailed(uri)
+		return false
 	} else {
-		peer.AppendToConnections(out_conn)
+		peer.peerTable.MarkReachable(uri)
+		peer.peerTable.SetKey(uri, peer.GetConnectionIdentity(out_conn))
+		peer.AppendToOutboundConnections(out_conn, uri)
 		go peer.HandleIncomingMessagesFromPeer(out_conn)
+		return true
 	}
 }
 
+//Lets the transport authenticate the connection (if it can) and remembers which account the other peer proved it owns
+func (peer *Peer) HandshakeWithPeer(conn net.Conn, dialer bool) (net.Conn, error) {
+	handshakingTransport, canHandshake := peer.transportStrategy.(HandshakingTransportStrategy)
+	if !canHandshake {
+		return conn, nil
+	}
+	authenticated, identity, err := handshakingTransport.Handshake(conn, dialer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if identity != "" {
+		peer.connectionIdentitiesMutex.Lock()
+		peer.connectionIdentities[authenticated] = identity
+		peer.connectionIdentitiesMutex.Unlock()
+	}
+	return authenticated, nil
+}
+
+//Must be called whenever peer.rsa changes, so certificates presented to other peers match the account we use
+func (peer *Peer) UseAccountForTransport() {
+	accountTransport, usesAccount := peer.transportStrategy.(AccountBoundTransportStrategy)
+	if usesAccount {
+		accountTransport.UseAccount(peer.rsa)
+	}
+}
+
+func (peer *Peer) GetConnectionIdentity(conn net.Conn) string {
+	peer.connectionIdentitiesMutex.Lock()
+	defer peer.connectionIdentitiesMutex.Unlock()
+	return peer.connectionIdentities[conn]
+}
+
 func (peer *Peer) CreateGenesisLedger() {
 	peer.genesisLedger = MakeLedger()
+	peer.genesisLedger.SetLogger(peer.logger.For("ledger"))
 	publicKeys := peer.genesisBlock[:10]
 	for _, key := range publicKeys {
-		peer.genesisLedger.AddGenesisAccount(key)
+		peer.genesisLedger.AddGenesisAccount(peer.registry.Learn(key))
+	}
+}
+
+//HasGenesisBlock tells whether we have started from a genesis block
+func (peer *Peer) HasGenesisBlock() bool {
+	peer.blocksSentMutex.Lock()
+	defer peer.blocksSentMutex.Unlock()
+	return peer.slotNumber > 0
+}
+
+//IsGenesisBlock tells a received genesis block (10 keys, the seed and the hardness, then the ID and the delimiter) from
+//a block won in the lottery, which has the "BLOCK" entry after its transactions
+func IsGenesisBlock(block Block) bool {
+	if len(block) != 14 {
+		return false
 	}
+	for _, entry := range block {
+		if entry == "BLOCK" {
+			return false
+		}
+	}
+	return true
+}
+
+//startFromGenesisBlock is called with blocksSentMutex held
+func (peer *Peer) startFromGenesisBlock(genesisBlock Block) {
+	peer.genesisBlock = genesisBlock
+	peer.HandleGenesisBlock()
+	peer.SetSystemRunning()
+	peer.slotNumber += 1
 }
 
 func (peer *Peer) HandleGenesisBlock() {
 	publicKeys := peer.genesisBlock[:10]
 	for _, key := range publicKeys {
-		peer.ledger.AddGenesisAccount(key)
-		peer.genesisLedger.AddGenesisAccount(key)
+		address := peer.registry.Learn(key)
+		peer.ledger.AddGenesisAccount(address)
+		peer.genesisLedger.AddGenesisAccount(address)
 	}
 	peer.ledger.Print()
 	peer.genesisLedger.Print()
@@ -260,21 +570,21 @@ func (peer *Peer) HandleGenesisBlock() {
 	peer.seed, _ = strconv.Atoi(peer.genesisBlock[10])
 	peer.hardness = *(ConvertStringToBigInt(peer.genesisBlock[11]))
 
-	fmt.Println("Received seed", peer.seed)
-	fmt.Println("Received hardness", peer.hardness)
+	peer.logger.Info("Received genesis block", "seed", peer.seed, "hardness", &peer.hardness)
 
 	//initialize blockTree
 	genesisNode := MakeBlockTreeNode("vk", 0, "draw", peer.genesisBlock, "signature")
 	peer.blockTree = MakeBlockTree(genesisNode)
+	peer.blockTree.SetLogger(peer.logger.For("blocktree"))
 
-	go peer.HandleLottery()
+	peer.goWorker(peer.HandleLottery)
 }
 
 func (peer *Peer) ReceiveConnectionsURI(coming_from net.Conn) ConnectionsURI {
-	reader := bufio.NewReader(coming_from)
+	reader := peer.transportStrategy.Receive(coming_from)
 	marshalled1, err := reader.ReadBytes(']')
 	if err != nil {
-		fmt.Println("Lost connection to peer")
+		peer.logger.Error("Lost connection to peer", "error", err)
 		panic(-1)
 	}
 	connectionsURI := peer.DemarshalConnectionsURI(marshalled1)
@@ -282,103 +592,76 @@ func (peer *Peer) ReceiveConnectionsURI(coming_from net.Conn) ConnectionsURI {
 	return connectionsURI
 }
 
-func (peer *Peer) ConnectToFirst10PeersInConnectionsURI(connectionsURI ConnectionsURI, olduri string) {
-	peer.connectionsURIMutex.Lock()
-	defer peer.connectionsURIMutex.Unlock()
-	index := len(connectionsURI) - 1
-	i := 0
-	for i < 10 && index >= 0 {
-		uri := connectionsURI[index]
-		if uri != olduri {
-			peer.ConnectToPeer(uri)
-		}
-		i++
-		index--
-	}
-}
-
 func (peer *Peer) SendBlockToAllPeers(marshalledBlock []byte) {
-	//fmt.Println("sendBlockToAllPeers was called")
-	for _, connection := range peer.connections {
-		//fmt.Println("Sending block to", connection)
+	//copy the connections, the send queue needs the lock
+	peer.connectionsMutex.Lock()
+	connections := append(Connections{}, peer.connections...)
+	peer.connectionsMutex.Unlock()
+	for _, connection := range connections {
 		peer.SendBlock(connection, marshalledBlock)
 	}
 }
 
 func (peer *Peer) SendBlock(connection net.Conn, marshalledBlock []byte) {
-	//send the marshalled block to the connection
-	//fmt.Println("Sendblock was called")
-	_, err := connection.Write(marshalledBlock)
-	if err != nil {
-		fmt.Println("Tried to send to a lost connection")
-		//delete the missing connection
-		peer.DeleteFromConnections(connection)
-	}
+	//queue the marshalled block for the connection
+	peer.send(connection, marshalledBlock)
 }
 
+//SendMessages returns when the peer stops, Stop then sends what is left in the channel
 func (peer *Peer) SendMessages() {
 	for {
-		//get a message from the channel and check if it has been sent before
-		message := <-peer.outbound
-		peer.messagesSentMutex.Lock()
-		if !peer.messagesSent[message.ID].sent {
+		select {
+		case message := <-peer.outbound:
+			peer.sendOutbound(message)
+		case <-peer.lifecycle.ctx.Done():
+			return
+		}
+	}
+}
 
-			transactionStruct := new(TransactionStruct)
-			transactionStruct.sent = true
-			transactionStruct.transaction = message
-			peer.messagesSent[message.ID] = *transactionStruct
-			peer.messagesSentMutex.Unlock()
+func (peer *Peer) sendOutbound(message SignedTransaction) {
+	//check if the message has been sent before
+	peer.messagesSentMutex.Lock()
+	if !peer.messagesSent[message.ID].sent {
 
-			peer.nextBlockMutex.Lock()
-			peer.nextBlock = append(peer.nextBlock, message.ID)
-			peer.nextBlockMutex.Unlock()
+		transactionStruct := new(TransactionStruct)
+		transactionStruct.sent = true
+		transactionStruct.transaction = message
+		peer.messagesSent[message.ID] = *transactionStruct
+		peer.messagesSentMutex.Unlock()
 
-			//send the message out to all peers in the network
-			peer.messageSendingStrategy.SendMessageToAllPeers(message, peer)
+		peer.nextBlockMutex.Lock()
+		peer.nextBlock = append(peer.nextBlock, message.ID)
+		peer.nextBlockMutex.Unlock()
 
-		} else {
-			peer.messagesSentMutex.Unlock()
-		}
+		//send the message out to all peers in the network
+		peer.messageSendingStrategy.SendMessageToAllPeers(message, peer)
+
+	} else {
+		peer.messagesSentMutex.Unlock()
 	}
 }
 
 func (peer *Peer) SendMessage(connection net.Conn, message SignedTransaction) {
-	//send the message to the connection
-	marshalled := peer.MarshalTransaction(message)
-	_, err := connection.Write(marshalled)
-	if err != nil {
-		fmt.Println("Tried to send to a lost connection")
-		//delete the missing connection
-		peer.DeleteFromConnections(connection)
-	}
+	//queue the message for the connection
+	peer.send(connection, peer.MarshalTransaction(message))
 }
 
+//SendConnectionsURI sends a random sample of our peer table, not all of it
 func (peer *Peer) SendConnectionsURI(conn net.Conn) {
-	marshalled := peer.MarshalConnectionsURI(peer.connectionsURI)
-	_, err := conn.Write(marshalled)
-	if err != nil {
-		fmt.Println("Tried to send to a lost connection")
-		//delete the missing connection
-		peer.DeleteFromConnections(conn)
-	}
+	peer.send(conn, peer.MarshalConnectionsURI(peer.peerTable.Sample(peer.peerTable.Config().GossipSize)))
 }
 
 func (peer *Peer) AppendToConnections(conn net.Conn) {
 	peer.connectionsMutex.Lock()
-	peer.connections = append(peer.GetConnections(), conn)
+	peer.connections = append(peer.connections, conn)
+	peer.addSendQueue(conn)
 	peer.connectionsMutex.Unlock()
 }
 
 func (peer *Peer) AppendToConnectionsURI(uri string) bool {
-	peer.connectionsURIMutex.Lock()
-	defer peer.connectionsURIMutex.Unlock()
-	//only add to connectionsURI if the URI is not already in there
-	shouldAdd := !peer.contains(peer.connectionsURI, uri)
-	if shouldAdd {
-		peer.connectionsURI = append(peer.connectionsURI, uri)
-	}
 	//for broadcasting presence we need to know whether it was new or not
-	return shouldAdd
+	return peer.peerTable.Add(uri)
 }
 
 //taken from StackOverflow https://stackoverflow.com/questions/10485743/contains-method-for-a-slice
@@ -394,23 +677,28 @@ func (peer *Peer) contains(s []string, e string) bool {
 func (peer *Peer) DeleteFromConnections(conn net.Conn) {
 	peer.connectionsMutex.Lock()
 	defer peer.connectionsMutex.Unlock()
-	for index, connection := range peer.GetConnections() {
+	for index, connection := range peer.connections {
 		if connection == conn {
-			peer.connections = peer.RemoveConnection(peer.GetConnections(), index)
+			peer.connections = peer.RemoveConnection(peer.connections, index)
 			break
 		}
 	}
+	if queue, found := peer.sendQueues[conn]; found {
+		peer.closeSendQueue(queue)
+		delete(peer.sendQueues, conn)
+	}
+	peer.forgetHeartbeat(conn, peer.outboundURIs[conn])
+	peer.forgetMisbehaviour(conn)
+	peer.forgetInventory(conn)
+	peer.transportStrategy.Forget(conn)
+	delete(peer.outboundURIs, conn)
+	peer.connectionIdentitiesMutex.Lock()
+	delete(peer.connectionIdentities, conn)
+	peer.connectionIdentitiesMutex.Unlock()
 }
 
 func (peer *Peer) DeleteFromConnectionsURI(uri string) {
-	peer.connectionsURIMutex.Lock()
-	defer peer.connectionsURIMutex.Unlock()
-	for index, connection := range peer.connectionsURI {
-		if connection == uri {
-			peer.connectionsURI = peer.RemoveURI(peer.connectionsURI, index)
-			break
-		}
-	}
+	peer.peerTable.Remove(uri)
 }
 
 func (peer *Peer) RemoveConnection(slice []net.Conn, s int) []net.Conn {
@@ -421,37 +709,55 @@ func (peer *Peer) RemoveURI(slice []string, s int) []string {
 	return append(slice[:s], slice[s+1:]...)
 }
 
-//only used for manual testing
+//SetSystemRunning lets the user make transactions, it is called once we have the genesis block
+func (peer *Peer) SetSystemRunning() {
+	peer.systemRunning.Store(true)
+}
+
+//only used for manual testing, the peer stops when the user quits
 func (peer *Peer) HandleIncomingFromUser() {
-	for !peer.systemRunning {
-		time.Sleep(1)
+	for !peer.systemRunning.Load() {
+		if !peer.sleep(100 * time.Millisecond) {
+			return
+		}
 	}
-	for {
-		msg := peer.userInputStrategy.HandleIncomingFromUser()
-		peer.outbound <- msg
+	multisigInput, lets := peer.userInputStrategy.(MultisigUserInputStrategy)
+	if lets {
+		multisigInput.UseMultisigSigner(peer)
+	}
+	for peer.Running() {
+		msg, err := peer.userInputStrategy.HandleIncomingFromUser()
+		if err != nil {
+			peer.logger.Info("No more input from the user", "error", err)
+			go peer.Stop()
+			return
+		}
+		peer.enqueue(msg)
 	}
 }
 
 func (peer *Peer) HandleIncomingMessagesFromPeer(connection net.Conn) {
 	defer connection.Close()
 	//take messages from the peer
-	reader := bufio.NewReader(connection)
+	reader := peer.transportStrategy.Receive(connection)
 	for {
 		marshalled, err := reader.ReadBytes(']')
 		if err != nil {
-			fmt.Println("Lost connection to peer")
+			peer.logger.Info("Lost connection to peer", "error", err)
+			peer.DeleteFromConnections(connection)
 			return
 		}
+		peer.heardFrom(connection)
 		msg, err := peer.DemarshalTransaction(marshalled)
 		if err != nil {
 			//Tried to demarshall something that was not a transaction, trying to read as it as a presence (URI)
 			asString := string(marshalled)
 			if strings.Contains(asString, "uri]") {
 				//it is not a transaction, but a URI presence
-				uriString := asString[:len(asString)-4]
+				uriString := unescapeDelimiter(asString[:len(asString)-4])
 				//add it to connectionsURI and if it was new, keep broadcasting
 				continueBroadcasting := peer.AppendToConnectionsURI(uriString)
-				fmt.Println("Added new URI, list now has length:", len(peer.connectionsURI))
+				peer.logger.Debug("Added new URI", "uri", uriString, "peers", peer.peerTable.Len())
 				if continueBroadcasting {
 					peer.BroadcastPresence(uriString)
 				}
@@ -459,32 +765,75 @@ func (peer *Peer) HandleIncomingMessagesFromPeer(connection net.Conn) {
 				//not a new presence, let's see if it is a block
 				demarshalled, err := peer.DemarshalBlock(marshalled)
 				if err != nil {
-					//this was not a block, but a connectionsURI
-					fmt.Println("received a connectionsURI")
+					//not even a list of strings
+					peer.logger.Debug("Received a malformed message", "error", err)
+					if peer.Penalize(connection, MalformedMessage) {
+						return
+					}
 					continue
+				} else if IsAddressGossip(demarshalled) {
+					peer.HandleAddressGossip(demarshalled)
+				} else if IsPing(demarshalled) {
+					pong, _ := json.Marshal(Block{pongDelimiter})
+					peer.SendBlock(connection, pong)
+				} else if IsPong(demarshalled) {
+					continue //heardFrom has already noted that the peer is alive
+				} else if IsDeadPeerNotice(demarshalled) {
+					peer.HandleDeadPeer(demarshalled[0])
+				} else if IsLeaveMessage(demarshalled) {
+					peer.HandleLeave(demarshalled, marshalled)
+				} else if IsInventory(demarshalled) {
+					peer.HandleInventory(connection, demarshalled)
+				} else if IsGetData(demarshalled) {
+					peer.HandleGetData(connection, demarshalled)
 				} else {
 					//this was a block
-					fmt.Println("received (probably) a block")
+					peer.logger.Debug("received (probably) a block")
 					if demarshalled[len(demarshalled)-1] == "yeet" {
-						//fmt.Println("Demarshalled block") TODO: verify somehow
+						if len(demarshalled) < 8 {
+							//too short for VerifyWinningBlock, and for a genesis block
+							if peer.Penalize(connection, InvalidBlock) {
+								return
+							}
+							continue
+						}
+						if !BlockIDMatches(demarshalled) {
+							//another block under the ID we asked for, or announced
+							if peer.Penalize(connection, InvalidBlock) {
+								return
+							}
+							continue
+						}
+						peer.knows(connection, blockItem+demarshalled[len(demarshalled)-2])
+						if !peer.HasGenesisBlock() && !IsGenesisBlock(demarshalled) {
+							//the neighbour got the genesis block before we did, we can ask for this block again once we have it
+							peer.forgetRequest(blockItem + demarshalled[len(demarshalled)-2])
+							continue
+						}
+						if peer.seenFrom(connection, blockItem+demarshalled[len(demarshalled)-2]) {
+							if peer.Penalize(connection, DuplicateMessage) {
+								return
+							}
+							continue
+						}
+						invalid := false
 						peer.blocksSentMutex.Lock()
 						if !peer.blocksSent[demarshalled[len(demarshalled)-2]] {
 							//fmt.Println("Got a previously unseen block")
 							peer.blocksSent[demarshalled[len(demarshalled)-2]] = true
-							go peer.SendBlockToAllPeers(marshalled)
 							if peer.slotNumber == 0 {
-								peer.genesisBlock = demarshalled[:len(demarshalled)-2]
-								peer.HandleGenesisBlock()
-								peer.systemRunning = true
-								peer.slotNumber += 1
+								go peer.RelayBlock(marshalled)
+								peer.startFromGenesisBlock(demarshalled[:len(demarshalled)-2])
 							} else if peer.VerifyWinningBlock(*peer.rsa, demarshalled, peer.seed) { //This checks that the block actually is legit and has won
-								fmt.Println("Verified a winning block, adding to tree")
+								peer.logger.Info("Verified a winning block, adding to tree")
+								//only pass on blocks we verified, our neighbours would penalize us for invalid ones
+								go peer.RelayBlock(marshalled)
 
 								prevHash := demarshalled[len(demarshalled)-4]
 								if peer.blockTree.GetLongestChainLeaf().Node.OwnBlockHash == prevHash {
 									success := peer.UpdateLedgerWithBlock(demarshalled)
 									if success {
-										fmt.Println("All transactions valid, adding block to tree")
+										peer.logger.Debug("All transactions valid, adding block to tree")
 										peer.AddBlockToTree(demarshalled)
 										peer.nextBlockMutex.Lock() //Since transactions have been ordered, they will now be removed from transactions this peer will use itself in its next block
 										for _, transactionID := range demarshalled {
@@ -495,30 +844,58 @@ func (peer *Peer) HandleIncomingMessagesFromPeer(connection net.Conn) {
 										}
 										peer.nextBlockMutex.Unlock()
 									} else {
-										fmt.Println("One or more invalid transactions, performing rollback")
+										peer.logger.Warn("One or more invalid transactions, performing rollback")
 										peer.UpdateLedgerOnRollback()
 									}
 								} else {
-									fmt.Println("") //We should have checked whether some of the transactions are invalid
+									peer.logger.Debug("Block is not on the longest chain, adding it to the tree") //We should have checked whether some of the transactions are invalid
 									peer.AddBlockToTree(demarshalled)
 								}
 
 							} else {
-								fmt.Println("Did not verify a block winning")
+								peer.logger.Warn("Did not verify a block winning")
+								invalid = true
 							}
 						} else {
-							fmt.Println("Got a block that's seen before")
+							peer.logger.Debug("Got a block that's seen before")
 						}
 						peer.blocksSentMutex.Unlock()
+						if invalid && peer.Penalize(connection, InvalidBlock) {
+							return
+						}
 					} else {
-						fmt.Println("Rejected a block")
+						peer.logger.Warn("Rejected a block")
 					}
 				}
 			}
+		} else if IsMultisigPublicKey(msg.PublicKey) {
+			//a multisig transaction may still be collecting signatures, it is sent again with every new signature
+			if peer.seenFrom(connection, multisigItem(msg)) {
+				if peer.Penalize(connection, DuplicateMessage) {
+					return
+				}
+			} else if violation, invalid := peer.checkMultisigProposal(msg); invalid {
+				peer.logger.Debug("Received an invalid multisig proposal", "id", msg.ID)
+				if peer.Penalize(connection, violation) {
+					return
+				}
+			} else {
+				peer.HandleMultisigProposal(connection, msg)
+			}
+		} else if peer.seenFrom(connection, transactionItem+msg.ID) {
+			if peer.Penalize(connection, DuplicateMessage) {
+				return
+			}
+		} else if violation, invalid := peer.checkTransaction(msg); invalid {
+			peer.logger.Debug("Received an invalid transaction", "id", msg.ID)
+			if peer.Penalize(connection, violation) {
+				return
+			}
 		} else {
 			//demarshalled a transaction - adding message to channel
-			fmt.Println("Received a transaction, sending to all")
-			peer.outbound <- msg
+			peer.knows(connection, transactionItem+msg.ID)
+			peer.logger.Debug("Received a transaction, sending to all", "id", msg.ID)
+			peer.enqueue(msg)
 		}
 	}
 }
@@ -535,7 +912,7 @@ func (peer *Peer) AddBlockToTree(block Block) {
 	blockTreeNode := MakeBlockTreeNode(publicKey, slotNumber, draw, blockTransactions, sigma)
 	nodeAsLeaf := MakeBlockTree(blockTreeNode)
 	peer.AddChildAndRollbackIfNecessary(nodeAsLeaf, prevHash)
-	fmt.Println("peer.blockTree after addChild: ", peer.blockTree)
+	peer.logger.Debug("Added block to tree", "slot", slotNumber, "treeSize", peer.blockTree.GetTreeSize())
 	peer.blockTree.PrintTree()
 }
 
@@ -551,52 +928,195 @@ func (peer *Peer) VerifyWinningBlock(rsa RSA, block Block, seed int) bool {
 	//blockConst := block[len(block)-8] //The string "BLOCK"
 	blockTransactions := block[:len(block)-8]
 
+	drawCheckDone := make(chan bool)
+	go func() { drawCheckDone <- rsa.VerifyDraw(draw, slotNumber, seed, publicKey) }()
 	sigmaCheck := rsa.VerifyBlockSignature(slotNumber, blockTransactions, hash, sigma, publicKey)
-	drawCheck := rsa.VerifyDraw(draw, slotNumber, seed, publicKey)
+	drawCheck := <-drawCheckDone
 	if !sigmaCheck {
-		fmt.Println("Sigmacheck failed")
+		peer.logger.Warn("Sigmacheck failed", "slot", slotNumber)
 		return false
 	} else if !drawCheck {
-		fmt.Println("Drawcheck failed")
+		peer.logger.Warn("Drawcheck failed", "slot", slotNumber)
 		return false
 	}
 
 	toHash := "LOTTERY:" + strconv.Itoa(peer.seed) + ":" + strconv.Itoa(slotNumber) + ":" + publicKey + ":" + draw
 	drawHash := Hash(toHash)
+	address := peer.registry.Learn(publicKey)
 	peer.ledger.lock.Lock()
-	//dolladollabills := big.NewInt(int64(peer.ledger.Accounts[publicKey]))
-	dolladollabills := big.NewInt(int64(peer.genesisLedger.Accounts[publicKey])) //We always use the genesisblock values for the lottery, also checking
+	//dolladollabills := big.NewInt(int64(peer.ledger.Accounts[address]))
+	dolladollabills := big.NewInt(int64(peer.genesisLedger.Accounts[address])) //We always use the genesisblock values for the lottery, also checking
 	peer.ledger.lock.Unlock()
 	x := big.NewInt(0)
 	x.Mul(dolladollabills, drawHash)
 	if x.Cmp(&(peer.hardness)) == 1 {
-		peer.ledger.GiveRewardForStake(publicKey, len(blockTransactions))
-		fmt.Println("Sigmacheck, drawcheck and hardness success")
+		peer.ledger.GiveRewardForStake(address, len(blockTransactions))
+		peer.logger.Debug("Sigmacheck, drawcheck and hardness success", "slot", slotNumber)
 		return true
 	} else {
-		fmt.Println("ticket was SMALLER than hardness")
+		peer.logger.Warn("ticket was SMALLER than hardness", "slot", slotNumber)
 		return false
 	}
 }
 
 func (peer *Peer) UpdateLedger(transaction *SignedTransaction) bool {
 	success := true
-	if transaction.Amount >= 1 && peer.rsa.VerifyTransaction(*transaction) {
-		transactionSuccess := peer.ledger.Transaction(transaction)
+	resolved := peer.registry.Resolve(*transaction)
+	if transaction.Amount >= 1 && peer.rsa.VerifyTransaction(resolved) {
+		peer.registry.Learn(resolved.PublicKey)
+		transactionSuccess := peer.ledger.Transaction(&resolved)
 		if transactionSuccess {
-			fmt.Println("Message successfully put in ledger")
+			peer.logger.Debug("Message successfully put in ledger", "id", transaction.ID)
 			success = true
 		} else {
 			success = false
-			fmt.Println("Invalid transaction, brings account below 0", transaction)
+			peer.logger.Warn("Invalid transaction, brings account below 0", "transaction", transaction)
 		}
 	} else {
 		success = false
-		fmt.Println("Invalid transaction, did not verify or amount < 0", transaction)
+		peer.logger.Warn("Invalid transaction, did not verify or amount < 0", "transaction", transaction)
 	}
 	return success
 }
 
+//multisigProposal is a multisig transaction that is still collecting signatures
+type multisigProposal struct {
+	transaction *SignedTransaction
+	received    time.Time
+	source      net.Conn //the connection that sent it first, nil for our own
+	approved    bool     //the user agreed to sign it
+}
+
+//ProposeMultisigTransaction starts collecting signatures for a transaction made with MakeMultisigTransaction.
+//We sign it ourselves if our account is one of the signers
+func (peer *Peer) ProposeMultisigTransaction(transaction SignedTransaction) error {
+	_, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !transaction.To.Valid() {
+		return ErrInvalidAddress
+	}
+	peer.handleMultisigProposal(nil, transaction, true)
+	return nil
+}
+
+//ApproveMultisigTransaction lets our account sign the pending transaction with this MultisigProposalHash, and returns
+//the transaction that was signed so the user sees what they agreed to
+func (peer *Peer) ApproveMultisigTransaction(hash string) (SignedTransaction, error) {
+	peer.multisigMutex.Lock()
+	pending := peer.multisigPending[hash]
+	if pending == nil {
+		peer.multisigMutex.Unlock()
+		return SignedTransaction{}, errors.New("no pending multisig transaction has this hash")
+	}
+	pending.approved = true
+	transaction := *pending.transaction
+	peer.multisigMutex.Unlock()
+	peer.logger.Info("Approved multisig transaction", "id", transaction.ID, "from", transaction.From, "to", transaction.To, "amount", transaction.Amount)
+	peer.handleMultisigProposal(nil, transaction, false)
+	return transaction, nil
+}
+
+//PendingMultisigTransactions are the proposals that can be approved, by MultisigProposalHash
+func (peer *Peer) PendingMultisigTransactions() map[string]SignedTransaction {
+	peer.multisigMutex.Lock()
+	defer peer.multisigMutex.Unlock()
+	peer.pruneMultisigProposals()
+	pending := make(map[string]SignedTransaction)
+	for hash, proposal := range peer.multisigPending {
+		pending[hash] = *proposal.transaction
+	}
+	return pending
+}
+
+//pruneMultisigProposals drops proposals that did not get enough signatures in time, must be called with multisigMutex held
+func (peer *Peer) pruneMultisigProposals() {
+	for hash, proposal := range peer.multisigPending {
+		if time.Since(proposal.received) > multisigProposalTimeout {
+			delete(peer.multisigPending, hash)
+		}
+	}
+}
+
+//HandleMultisigProposal merges the signatures of the transaction with the ones we have, adds ours if the user approved it,
+//and passes it on if it got new signatures. Once enough signers have signed, it is sent out like any other transaction.
+//The connection it came from has already been checked with seenFrom and checkMultisigProposal
+func (peer *Peer) HandleMultisigProposal(conn net.Conn, transaction SignedTransaction) {
+	peer.handleMultisigProposal(conn, transaction, false)
+}
+
+//handleMultisigProposal only keeps a new proposal from the network if one of the signers signed it, we are one of the
+//signers or the account has the money, and only while there is room, also for the connection it came from. Our own
+//proposals are approved when they are made
+func (peer *Peer) handleMultisigProposal(conn net.Conn, transaction SignedTransaction, own bool) {
+	hash := MultisigProposalHash(transaction)
+	peer.multisigMutex.Lock()
+	pending := peer.multisigPending[hash]
+	changed := false
+	if pending == nil {
+		signatures := ValidMultisigSignatures(transaction)
+		peer.pruneMultisigProposals()
+		if !own && (len(signatures) == 0 || !transaction.To.Valid() || !peer.caresAboutMultisig(transaction) || peer.multisigProposalsFull(conn)) {
+			peer.multisigMutex.Unlock()
+			peer.logger.Debug("Ignoring a multisig proposal", "id", transaction.ID, "signatures", len(signatures))
+			return
+		}
+		pending = &multisigProposal{transaction: &transaction, received: time.Now(), source: conn, approved: own}
+		pending.transaction.Signatures = signatures
+		changed = len(signatures) > 0
+		peer.multisigPending[hash] = pending
+	} else {
+		changed = MergeMultisigSignatures(pending.transaction, transaction)
+	}
+	if pending.approved {
+		signatures := len(pending.transaction.Signatures)
+		if peer.rsa.SignMultisigTransaction(pending.transaction) == nil && len(pending.transaction.Signatures) > signatures {
+			changed = true
+		}
+	}
+	approved := MultisigApproved(*pending.transaction)
+	if approved {
+		delete(peer.multisigPending, hash)
+	}
+	proposal := *pending.transaction
+	peer.multisigMutex.Unlock()
+
+	if approved {
+		peer.logger.Info("Multisig transaction has enough signatures, sending to all", "id", proposal.ID)
+		peer.enqueue(proposal)
+	} else if changed {
+		peer.logger.Info("Multisig transaction needs more signatures, passing it on", "id", proposal.ID, "signatures", len(proposal.Signatures))
+		peer.messageSendingStrategy.SendMessageToAllPeers(proposal, peer)
+	}
+}
+
+//caresAboutMultisig is true if our account is one of the signers, or the multisig account can pay the amount
+func (peer *Peer) caresAboutMultisig(transaction SignedTransaction) bool {
+	policy, err := ParseMultisigPolicy(transaction.PublicKey)
+	if err != nil {
+		return false
+	}
+	if policy.signerIndex(peer.rsa.PublicKeyString()) >= 0 {
+		return true
+	}
+	return peer.ledger.Balance(transaction.From) >= transaction.Amount
+}
+
+//multisigProposalsFull is true when there is no room for another proposal from the connection, multisigMutex must be held
+func (peer *Peer) multisigProposalsFull(conn net.Conn) bool {
+	if len(peer.multisigPending) >= maxMultisigProposals {
+		return true
+	}
+	fromConn := 0
+	for _, proposal := range peer.multisigPending {
+		if proposal.source == conn {
+			fromConn++
+		}
+	}
+	return fromConn >= maxMultisigProposalsPerConnection
+}
+
 func (peer *Peer) AddChildAndRollbackIfNecessary(newBlockTree *BlockTree, prevhash string) {
 	currentLeaf := peer.blockTree.GetLongestChainLeaf()
 	if currentLeaf.Node.OwnBlockHash == prevhash {
@@ -607,7 +1127,7 @@ func (peer *Peer) AddChildAndRollbackIfNecessary(newBlockTree *BlockTree, prevha
 		peer.blockTree.AddChildAt(newBlockTree, prevhash)
 		longest := peer.blockTree.GetLongestChainLeaf()
 		if longest != currentLeaf {
-			fmt.Println("Rollback was necessary")
+			peer.logger.Info("Rollback was necessary")
 			peer.UpdateLedgerOnRollback()
 		}
 	}
@@ -623,14 +1143,15 @@ func (peer *Peer) UpdateLedgerOnRollback() {
 func (peer *Peer) UpdateLedgerWithSliceOfBlocks(blocks []Block) { //This is only used for rollbacks
 	for _, block := range blocks {
 		peer.UpdateLedgerWithBlock(block[:len(block)-1])
-		publicKey := block[len(block)-1]                                  //The VK has been appended on from the getlongestchainofblocksasslice method
-		blockTransactions := block[:len(block)-1]                         //The transactions within the block
-		peer.ledger.GiveRewardForStake(publicKey, len(blockTransactions)) //We also have to readd the block rewards for the winners
+		publicKey := block[len(block)-1]                                                      //The VK has been appended on from the getlongestchainofblocksasslice method
+		blockTransactions := block[:len(block)-1]                                             //The transactions within the block
+		peer.ledger.GiveRewardForStake(AddressOfPublicKey(publicKey), len(blockTransactions)) //We also have to readd the block rewards for the winners
 	}
 }
 
 func (peer *Peer) UpdateLedgerWithBlock(block Block) bool {
 	totalSuccess := true
+	peer.verifyBlockTransactions(block)
 	for _, transactionID := range block {
 		if transactionID == "BLOCK" {
 			peer.ledger.Print() //Have reached the end of the transactions in the block
@@ -639,10 +1160,10 @@ func (peer *Peer) UpdateLedgerWithBlock(block Block) bool {
 		transactionStruct := peer.messagesSent[transactionID]
 		success := peer.UpdateLedger(&(transactionStruct.transaction))
 		if success {
-			fmt.Println("Ledger was succesfully updated with transaction from block")
+			peer.logger.Debug("Ledger was succesfully updated with transaction from block", "id", transactionID)
 		} else {
 			totalSuccess = false
-			fmt.Println("updating the ledger failed")
+			peer.logger.Warn("updating the ledger failed", "id", transactionID)
 			peer.ledger.Print()
 		}
 	}
@@ -650,36 +1171,55 @@ func (peer *Peer) UpdateLedgerWithBlock(block Block) bool {
 	return totalSuccess
 }
 
+//verifyBlockTransactions checks all signatures in the block in parallel, after that UpdateLedger finds them in VerificationCache
+func (peer *Peer) verifyBlockTransactions(block Block) {
+	transactions := make([]SignedTransaction, 0)
+	peer.messagesSentMutex.Lock()
+	for _, transactionID := range block {
+		if transactionID == "BLOCK" {
+			break
+		}
+		transactions = append(transactions, peer.registry.Resolve(peer.messagesSent[transactionID].transaction))
+	}
+	peer.messagesSentMutex.Unlock()
+	peer.verifier.VerifyTransactions(transactions)
+}
+
 func (peer *Peer) HandleLottery() {
 	slotLength := peer.slotLength
 	t := time.NewTicker(time.Duration(slotLength) * time.Second)
-	for now := range t.C {
-		fmt.Println(" ")
-		fmt.Println("Time:", now)
-		won, draw := peer.EnterLottery(peer.slotNumber, peer.seed, peer.rsa.n, peer.rsa.d)
-		fmt.Println("Slotnumber is:", peer.slotNumber)
+	defer t.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-t.C:
+		case <-peer.lifecycle.ctx.Done():
+			return
+		}
+		won, draw := peer.EnterLottery(peer.slotNumber, peer.seed)
+		peer.logger.Debug("New slot", "time", now, "slot", peer.slotNumber)
 		if won {
-			fmt.Println("Won slot: ", peer.slotNumber)
+			peer.logger.Info("Won slot", "slot", peer.slotNumber)
 			peer.HandleWinning(draw)
 		}
 		peer.slotNumber += 1
 	}
 }
 
-func (peer *Peer) EnterLottery(slot int, seed int, n big.Int, d big.Int) (bool, string) {
+func (peer *Peer) EnterLottery(slot int, seed int) (bool, string) {
 	toSign := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(slot)
-	draw := peer.rsa.FullSign(toSign, n, d)
-	nString := ConvertBigIntToString(&n)
-	toHash := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(slot) + ":" + nString + ":" + ConvertBigIntToString(draw) //entry into lottery
+	draw := peer.rsa.SignDeterministic(toSign)
+	nString := peer.rsa.PublicKeyString()
+	toHash := "LOTTERY:" + strconv.Itoa(seed) + ":" + strconv.Itoa(slot) + ":" + nString + ":" + draw //entry into lottery
 	hashed := Hash(toHash)
-	numTickets := big.NewInt(int64(peer.genesisLedger.Accounts[nString]))
+	numTickets := big.NewInt(int64(peer.genesisLedger.Accounts[peer.rsa.Address()]))
 	val := big.NewInt(0) //Val(vk, slot, Draw) = accountBalance(vk) * Hash(LOTTERY, Seed, slotnumber, vk, draw), where draw = Sig_sk(LOTTERY, slot)
 	val = val.Mul(numTickets, hashed)
 	if val.Cmp(&(peer.hardness)) == 1 { //If val >= hardness
-		fmt.Println("I WON the lottery in slot: ", slot)
-		return true, (ConvertBigIntToString(draw))
+		peer.logger.Debug("I WON the lottery", "slot", slot)
+		return true, draw
 	} else {
-		fmt.Println("I lost the lottery in slot: ", slot)
+		peer.logger.Debug("I lost the lottery", "slot", slot)
 		return false, ""
 	}
 }
@@ -694,17 +1234,17 @@ func (peer *Peer) HandleWinning(draw string) {
 	peer.nextBlock = make([]string, 0)
 	peer.nextBlockMutex.Unlock()
 
-	block = append(block, "BLOCK")                            //BLOCK
-	block = append(block, ConvertBigIntToString(&peer.rsa.n)) //Public key / VK
-	block = append(block, strconv.Itoa(peer.slotNumber))      //Slotnumber
-	block = append(block, draw)                               //Draw
+	block = append(block, "BLOCK")                       //BLOCK
+	block = append(block, peer.rsa.PublicKeyString())    //Public key / VK
+	block = append(block, strconv.Itoa(peer.slotNumber)) //Slotnumber
+	block = append(block, draw)                          //Draw
 	prevBlockHash := peer.getPrevBlockHash()
 	block = append(block, prevBlockHash) //Hash
 	signature := peer.rsa.CreateBlockSignature(peer.slotNumber, blockData, prevBlockHash)
 	block = append(block, signature) //Sigma
 
 	marshalled := peer.MarshalBlock(block)
-	peer.SendBlockToAllPeers(marshalled)
+	peer.RelayBlock(marshalled)
 
 }
 
@@ -755,7 +1295,7 @@ func (peer *Peer) MakeGenesisBlock() Block {
 func (peer *Peer) MarshalTransaction(transaction SignedTransaction) []byte {
 	bytes, err := json.Marshal(transaction)
 	if err != nil {
-		fmt.Println("Marshaling transaction failed")
+		peer.logger.Error("Marshaling transaction failed", "error", err)
 	}
 	//add extra ']' as delimiter
 	bytes = append(bytes, ']')
@@ -771,20 +1311,15 @@ func (peer *Peer) DemarshalTransaction(bytes []byte) (SignedTransaction, error)
 }
 
 func (peer *Peer) MarshalConnectionsURI(connectionsURI ConnectionsURI) []byte {
-	peer.connectionsURIMutex.Lock()
-	bytes, err := json.Marshal(connectionsURI)
-	peer.connectionsURIMutex.Unlock()
-	if err != nil {
-		fmt.Println("Marshaling connectionsURI failed")
-	}
-	return bytes
+	//IPv6 URIs have a ']' that must not end the message
+	return MarshalList(connectionsURI)
 }
 
 func (peer *Peer) DemarshalConnectionsURI(bytes []byte) ConnectionsURI {
 	var connectionsURI ConnectionsURI
 	err := json.Unmarshal(bytes, &connectionsURI)
 	if err != nil {
-		fmt.Println("Demarshaling connectionsURI failed", err)
+		peer.logger.Warn("Demarshaling connectionsURI failed", "error", err)
 	}
 	return connectionsURI
 }
@@ -797,90 +1332,112 @@ func (peer *Peer) MarshalBlock(block Block) []byte {
 	block = append(block, "yeet")    //Add delimiter
 	bytes, err := json.Marshal(block)
 	if err != nil {
-		fmt.Println("Marshalling block failed")
+		peer.logger.Error("Marshalling block failed", "error", err)
 	}
 	return bytes
 }
 
+//BlockIDMatches checks the ID MarshalBlock put before the delimiter against the contents of the block
+func BlockIDMatches(block Block) bool {
+	return len(block) >= 2 && ConvertBigIntToString(Hash(strings.Join(block[:len(block)-2], ":"))) == block[len(block)-2]
+}
+
 func (peer *Peer) DemarshalBlock(bytes []byte) (Block, error) {
 	var block Block
 	err := json.Unmarshal(bytes, &block)
 	return block, err
 }
 
+//GetConnections returns a copy, the connections change when peers come and go
 func (peer *Peer) GetConnections() []net.Conn {
-	return peer.connections
+	peer.connectionsMutex.Lock()
+	defer peer.connectionsMutex.Unlock()
+	return append(Connections{}, peer.connections...)
+}
+
+//SetupAccount uses the account of the user input strategy if it has one (e.g. a wallet), otherwise the keys are typed in
+func (peer *Peer) SetupAccount() error {
+	accountInputStrategy, providesAccount := peer.userInputStrategy.(AccountProvidingUserInputStrategy)
+	if providesAccount && accountInputStrategy.GetAccount() != nil {
+		peer.rsa = accountInputStrategy.GetAccount()
+		return nil
+	}
+	return peer.AddNewSkUser()
 }
 
-func (peer *Peer) AddNewSkUser() {
+func (peer *Peer) AddNewSkUser() error {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Setup account:")
 	fmt.Println("Make new account = y/yes, preexisting account = n/no, genesis account = 1-10:")
 	decision, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println("User quit the program")
-		os.Exit(0)
+		return ErrUserQuit
 	}
 	trimmedDecision := strings.TrimRight(decision, "\r\n")
 	if trimmedDecision == "y" || trimmedDecision == "yes" {
 		newRsa := MakeRSA(2000)
 		publicKey := ConvertBigIntToString(&newRsa.n)
 		secretKey := ConvertBigIntToString(&newRsa.d)
-		success := peer.ledger.AddAccount(publicKey)
+		success := peer.ledger.AddAccount(newRsa.Address())
 		if success {
 			fmt.Println("Successfully created new account, this is your secret Key:")
 			fmt.Println(secretKey) //notice that both secret and public key are formatted as strings corresponding to the value inside the BigInt, and NOT bytes translated into string from the bigInt.
-			fmt.Println("And this is your public name:")
+			fmt.Println("This is your public key:")
 			fmt.Println(publicKey)
+			fmt.Println("And this is your address, which others send to:")
+			fmt.Println(newRsa.Address())
 		}
 	} else if trimmedDecision == "n" || trimmedDecision == "no" {
 		fmt.Println("You have chosen to use a preexisting account. Enter the public key:")
 		publicKey, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Println("User quit the program")
-			os.Exit(0)
+			return ErrUserQuit
 		}
 		fmt.Println("This is what you entered:", publicKey)
 		fmt.Println("Now enter your secretKey:")
 		secretKey, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Println("User quit the program")
-			os.Exit(0)
+			return ErrUserQuit
 		}
 		fmt.Println("This is what you entered:", secretKey)
 		peer.rsa = MakeRSAWithKeys(publicKey, secretKey)
 		fmt.Println("You can now make transactions using your public and secret key.")
 		fmt.Println("---------------------------------------------------------------------------------------------------------")
-	} else if trimmedDecision == "1" {
-		peer.rsa = MakeRSAWithKeys("99220599159528886888088184316939863466036751390102525224276426598372453374970490581931623644823947730183615834970415935110413997957190268925746447986875045619423695530354351164666747197504575160571344765059782114834542464872778036174724267595394424527864340941278010670086469948102379662561982997267164040169642087775263921101619527508747168787150255148601076426931391934490878646150913881272213119308249212668240473054293497445413288931672488505288383846988700744069430536032867719784270610575963716882208428391419536387867514792667272504971046836440153259514944143565853403498339935380048998940846417", "66147066106352591258725456211293242310691167593401683482850951065581635583313660387954415763215965153455743889980277290073609331971460179283830965324583363746282463686902900776444498131669716773714229843373188076556361643248518690783149511730262949685242893960852007113390979965401586441707988664844762745508117637359502895110544591891447889581986360912541538288351271783269725834355782179249158683359773911535600994935883950084494585598131890180600348693060660169070167869890819212774506630114016303382665745688834796844837019895605694433162313497206230710528213636589702842149997136746634853051713867")
-		fmt.Println("You now use GenKey 1!")
-	} else if trimmedDecision == "2" {
-		peer.rsa = MakeRSAWithKeys("85599412879953205917443492336639751512406088883204488437353959378513522416955024172185792293853309086614045424001070998033302348930881810677829310464668788989108423815275401435146750989817827599726082819178311301126706125959538642880756008147465621540684475755420770483545469382177500313066980466028571318051567830522706186098394127053090432052404629932589506015468201259035009870260298981986568167423584202889539017410056236371005761663036714192956723654474436989600592958683850034049398561016771652916907371747535483041614739176853531168948855088182175940234786700494940589098849136266341141384155161", "57066275253302137278295661557759834341604059255469658958235972919009014944636682781457194862568872724409363616000713998688868232620587873785219540309779192659405615876850267623431167326545218399817388546118874200751137417306359095253837338764977081027122983836947180322363646254785000208711320310685701811559846153402328139644121775388847982512106893024434013483758676091513272361707236043468694775351691990371111047305227069747581372080012677826929336064868485779336318206441324807451529044360430322327105045986377923610860674048722625908251886363769200610782611957129406730807694517303759791724147883")
-		fmt.Println("You now use GenKey 2!")
-	} else if trimmedDecision == "3" {
-		peer.rsa = MakeRSAWithKeys("94938669199553053778857680890888139261052515031742833094394381264005413538787479617048724551658947047884930786878545592643341156546341629355279941159238226952308048437799632118321605345240931468890113418679236203299986663053672098258710173978852424933276878715147922334893146561517950444275025644591250775616495714369301297920912844517777635723745293144772908702674201382610931091114936463191905169309350953666108512426366528125453362350408077328372072616595844746964708723653945189478227150662157838762184542167781442195750766257212784191074842194010540524247230081017641149812905846481771040387362473", "63292446133035369185905120593925426174035010021161888729596254176003609025858319744699149701105964698589953857919030395095560771030894419570186627439492151301538698958533088078881070230160620979260075612452824135533324442035781398839140115985901616622184585810098614889928764374345300296183350429727487513247907826811332243853037976223252383517850387930734758706368641950566574540183286993467902730480342892768574995901365843993484181587657388877051442097120415616997954645795326298701270004588959437930315518222758790083847871266845126016838669344583388972271861405121067179022340927614093613385620747")
-		fmt.Println("You now use GenKey 3!")
-	} else if trimmedDecision == "4" {
-		peer.rsa = MakeRSAWithKeys("86452583383266791348634781602421438878698534614119180980968848675882051691784360216437184392986096403545804536088141253936277211321077867008928351117078687752098828115507303231608265820845626805071434231628014647390168434917827717171761542393062938452743852651638618758631815804918108293956355994913095700264201221183679675084999195848794748227364469628612623985152910407381138458527275020855508562816993941567564049116010190501316109460348488259049824369447635049113942952733472839266362177569036225918382937248188277295907694169924415652794045804910426213945443411813621594154348105518818184294346249", "57635055588844527565756521068280959252465689742746120653979232450588034461189573477624789595324064269030536357392094169290851474214051911339285567411385791834732552077004868821072177213897084536714289487752009764926778956611885144781174361595375292301829235101092412505754543869945405529304237329942051377748136662340932984463101236766809521029118372315113914738854726914491432436095678435744369554205214824941855772892744662093065231091360991479412976539166537893744224448305815567518191356700827230606962081724820505633499922128887121521862665873647845984096294415483390872767806744329110111292872707")
-		fmt.Println("You now use GenKey 4!")
-	} else if trimmedDecision == "5" {
-		peer.rsa = MakeRSAWithKeys("81594135348859355889822650216374879117537833325687870348641933990660498172029614843858960160893543004971705050678775284039487254233047107626982118266040228151804438920763314751390066459108316561422333596643117831355245977759296418702636935930016592216482955679278425203161695636496952978681695659108476606655855138949339170964644615516779164639460908768229783291708712591905418163269629394026883090207088407617292418141057959266029941628814934364965311043536457231536747940040915132263532817583457081113622394066389240032727746638150825261278748778368829057825231315703499329107889498851283808455800053", "54396090232572903926548433477583252745025222217125246899094622660440332114686409895905973440595695336647803367119183522692991502822031405084654745510693485434536292613842209834260044306072211040948222397762078554236830651839530945801757957286677728144321970452852283468774463757664635319121130439405638970909854179038382504969020111450170410451417348364551804794839643872324455490156426963773821964652385519393075073646193260017039391994317289516698863165631189739683305288120447200424391498376646686085100647822777706297051468474204958854675868534108069068727135675736578916481170092283193250304079147")
-		fmt.Println("You now use GenKey 5!")
-	} else if trimmedDecision == "6" {
-		peer.rsa = MakeRSAWithKeys("68712351107036437585558705394329932588021453857860002869669357991132586124756852552170712566827012427858101219618342474924223484377006108583647716433567632020410463771552291860494123411766514967952437791952149536595761761768628201669701017186368206738007739214514369690280938959709348367114444332007867429293050870824281366539775693622799966913656661963258346342063688423549783151910273001303698713413753940866803337598653489349374695693083666618952340366801511166307844828148472432093460461261574440793050106855026669923821302882500596809281593084599607212884045166225929286154650189492119251332062599", "45808234071357625057039136929553288392014302571906668579779571994088390749837901701447141711218008285238734146412228316616148989584670739055765144289045088013606975847701527906996082274511009978634958527968099691063841174512418801113134011457578804492005159476342913126853959306472898911409629554671900562891662517910310816976048692788121342291618014156450442211850894825573035111466101918246333531693242849867852990861822107357625185920032273742245112863764196731704101713955660553343809048635685453501018233883060616462317688347186989653622928858407676200919168956518687164103793355239275219789957227")
-		fmt.Println("You now use GenKey 6!")
-	} else if trimmedDecision == "7" {
-		peer.rsa = MakeRSAWithKeys("91273955433896845510861081477211261393472951931840460054284580008859771006155212340140914787944390979120878930868609996748058338377907640267677073912279817976565958447357541072604121964619900931316478594350118528701705448279141426514958466636086268075062290138629777662471099352388822521900394668942098551444827506570358387824412636648945544583564346168203783434336720700601682369105551602717253212251522431865494980399642085639650473281351181921205615546964230228380781298938116442898519184131549614047592945564571080985964305710217520577386421357275728766359526997545444234524045366917234420371495547", "60849303622597897007240720984807507595648634621226973369523053339239847337436808226760609858629593986080585953912406664498705558918605093511784715941519878651043972298238360715069414643079933954210985729566745685801136965519427617676638977757390845383374860092419851774980732901592548347933596445961386267208512158936772511903756932088854615177782343747050024542617812991958723910140318864835765370320427471027760923989513917970228497157097274228721872030968193465764382197457306647061271751006469270808021101220186242685745944137832107706962543049504351247550396764180885509402107674673111483343461787")
-		fmt.Println("You now use GenKey 7!")
-	} else if trimmedDecision == "8" {
-		peer.rsa = MakeRSAWithKeys("93794657355404229319431128515003855336007553691660833870546161779998773890778963918610615793046674621435648393754855465140820703534244156001995273674589296398055231116947487910019524886278710170329803131021053795853655945796124400948014542456370545523186541835638621833119654376852910734839180322005666717956119166548684174165313913116800090123914818313442355435582269314510251304868551601140242896427931023264834388541950156747332715777556673901039316230552233797602769052653802383292709024448454683898134884115648887478942523963719887462236797537388653795357302782037638846557126523009545287101796433", "62529771570269486212954085676669236890671702461107222580364107853332515927185975945740410528697783080957098929169903643427213802356162770667996849116392864265370154077964991940013016590852473446886535420680702530569103963864082933965343028304247030348791027890425747888746436251235273823226120214670431563924686410594852264011717447911867965421792457711609768721398045113825769696270387407334328842613191390579920555424156978849748835588041653519311851435698539337440204744765491196153239257941728858976517501464047535513082183750917382887638010506211749109922606527099218176875462650114570015548353827")
-		fmt.Println("You now use GenKey 8!")
-	} else if trimmedDecision == "9" {
-		peer.rsa = MakeRSAWithKeys("84128649689229141748476650346323678486437898780555562239274803331023504201661721263725702164844226716062121140282102659840393631295501503873123285321391312474481459683725818683741140427611468317891812252761172109050348451968978555634590357968074943405603291955327118302707346701747767186913466136936866428063285478486512344926095099958608452772818854082739431085262847212088429662485943109069166647077422997592232691907767336984413736921170670999915528283134193422301868063392769631810144899315337178298078695275092232062924257321021504333397583129567164544715249346168224769592996908555919759005032463", "56085766459486094498984433564215785657625265853703708159516535554015669467774480842483801443229484477374747426854735106560262420863667669248748856880927541649654306455817212455827426951740978878594541501840781406033565634645985703756393571978716628937068861303551412201804897801165178124608977424624565383660090020108279414641760356014618805194026664668950447058660780645842250453168342552799489026854312248658849391273762450578714474116884341495389211345085680851007877682232233392631306652138191781996971108044388337365555941608247827596381515834264967330548744937537617112889038432355010227570468939")
-		fmt.Println("You now use GenKey 9!")
 	} else {
-		peer.rsa = MakeRSAWithKeys("70621195703417734770343058091658851655361561261137761610884679258670223399663358264109783765671832663936755207486891476183124001771723250631352914064206920117635941024601491293081835825560660689267333988928709259887345879848794348613877724159584075736686419675997111138048781709667966034965470003107432057065409218721592297689386038371608402840880519345471603774550343846256833649393555592852268617261622997674448275202937119980781626022142094513891745877971273140692436832862996188468524311487549280326916150648541763048073345791515098905458134163062720372875194208748523538910421681658158526277952333", "47080797135611823180228705394439234436907707507425174407256452839113482266442238842739855843781221775957836804991260984122082667847815500420901942709471280078423960683067660862054557217040440459511555992619139506591563919899196232409251816106389383824457613117331407425365854473111977356643646668738276832537633132845787805744042592646044493003311754064041832749213975665596294833811787512683785544903252943781383044290811821447018358747441605357665865259282762476668907306423923701622064148912823436616682105409323617355598360462566986128445001798326379207490257344784110525958297045948387829570574059")
-		fmt.Println("You now use GenKey 10! (Maybe you asked for that, maybe I'm just nice)")
+		peer.rsa = GenesisRSA(trimmedDecision)
+		number, err := strconv.Atoi(trimmedDecision)
+		if err == nil && number >= 1 && number <= 9 {
+			fmt.Println("You now use GenKey " + trimmedDecision + "!")
+		} else {
+			fmt.Println("You now use GenKey 10! (Maybe you asked for that, maybe I'm just nice)")
+		}
+		warnIfLegacySignaturesNotAllowed()
+	}
+	return nil
+}
+
+//GenesisRSA returns the keys of genesis account 1-9, anything else gives genesis account 10
+func GenesisRSA(number string) *RSA {
+	if number == "1" {
+		return MakeRSAWithKeys("99220599159528886888088184316939863466036751390102525224276426598372453374970490581931623644823947730183615834970415935110413997957190268925746447986875045619423695530354351164666747197504575160571344765059782114834542464872778036174724267595394424527864340941278010670086469948102379662561982997267164040169642087775263921101619527508747168787150255148601076426931391934490878646150913881272213119308249212668240473054293497445413288931672488505288383846988700744069430536032867719784270610575963716882208428391419536387867514792667272504971046836440153259514944143565853403498339935380048998940846417", "66147066106352591258725456211293242310691167593401683482850951065581635583313660387954415763215965153455743889980277290073609331971460179283830965324583363746282463686902900776444498131669716773714229843373188076556361643248518690783149511730262949685242893960852007113390979965401586441707988664844762745508117637359502895110544591891447889581986360912541538288351271783269725834355782179249158683359773911535600994935883950084494585598131890180600348693060660169070167869890819212774506630114016303382665745688834796844837019895605694433162313497206230710528213636589702842149997136746634853051713867")
+	} else if number == "2" {
+		return MakeRSAWithKeys("85599412879953205917443492336639751512406088883204488437353959378513522416955024172185792293853309086614045424001070998033302348930881810677829310464668788989108423815275401435146750989817827599726082819178311301126706125959538642880756008147465621540684475755420770483545469382177500313066980466028571318051567830522706186098394127053090432052404629932589506015468201259035009870260298981986568167423584202889539017410056236371005761663036714192956723654474436989600592958683850034049398561016771652916907371747535483041614739176853531168948855088182175940234786700494940589098849136266341141384155161", "57066275253302137278295661557759834341604059255469658958235972919009014944636682781457194862568872724409363616000713998688868232620587873785219540309779192659405615876850267623431167326545218399817388546118874200751137417306359095253837338764977081027122983836947180322363646254785000208711320310685701811559846153402328139644121775388847982512106893024434013483758676091513272361707236043468694775351691990371111047305227069747581372080012677826929336064868485779336318206441324807451529044360430322327105045986377923610860674048722625908251886363769200610782611957129406730807694517303759791724147883")
+	} else if number == "3" {
+		return MakeRSAWithKeys("94938669199553053778857680890888139261052515031742833094394381264005413538787479617048724551658947047884930786878545592643341156546341629355279941159238226952308048437799632118321605345240931468890113418679236203299986663053672098258710173978852424933276878715147922334893146561517950444275025644591250775616495714369301297920912844517777635723745293144772908702674201382610931091114936463191905169309350953666108512426366528125453362350408077328372072616595844746964708723653945189478227150662157838762184542167781442195750766257212784191074842194010540524247230081017641149812905846481771040387362473", "63292446133035369185905120593925426174035010021161888729596254176003609025858319744699149701105964698589953857919030395095560771030894419570186627439492151301538698958533088078881070230160620979260075612452824135533324442035781398839140115985901616622184585810098614889928764374345300296183350429727487513247907826811332243853037976223252383517850387930734758706368641950566574540183286993467902730480342892768574995901365843993484181587657388877051442097120415616997954645795326298701270004588959437930315518222758790083847871266845126016838669344583388972271861405121067179022340927614093613385620747")
+	} else if number == "4" {
+		return MakeRSAWithKeys("86452583383266791348634781602421438878698534614119180980968848675882051691784360216437184392986096403545804536088141253936277211321077867008928351117078687752098828115507303231608265820845626805071434231628014647390168434917827717171761542393062938452743852651638618758631815804918108293956355994913095700264201221183679675084999195848794748227364469628612623985152910407381138458527275020855508562816993941567564049116010190501316109460348488259049824369447635049113942952733472839266362177569036225918382937248188277295907694169924415652794045804910426213945443411813621594154348105518818184294346249", "57635055588844527565756521068280959252465689742746120653979232450588034461189573477624789595324064269030536357392094169290851474214051911339285567411385791834732552077004868821072177213897084536714289487752009764926778956611885144781174361595375292301829235101092412505754543869945405529304237329942051377748136662340932984463101236766809521029118372315113914738854726914491432436095678435744369554205214824941855772892744662093065231091360991479412976539166537893744224448305815567518191356700827230606962081724820505633499922128887121521862665873647845984096294415483390872767806744329110111292872707")
+	} else if number == "5" {
+		return MakeRSAWithKeys("81594135348859355889822650216374879117537833325687870348641933990660498172029614843858960160893543004971705050678775284039487254233047107626982118266040228151804438920763314751390066459108316561422333596643117831355245977759296418702636935930016592216482955679278425203161695636496952978681695659108476606655855138949339170964644615516779164639460908768229783291708712591905418163269629394026883090207088407617292418141057959266029941628814934364965311043536457231536747940040915132263532817583457081113622394066389240032727746638150825261278748778368829057825231315703499329107889498851283808455800053", "54396090232572903926548433477583252745025222217125246899094622660440332114686409895905973440595695336647803367119183522692991502822031405084654745510693485434536292613842209834260044306072211040948222397762078554236830651839530945801757957286677728144321970452852283468774463757664635319121130439405638970909854179038382504969020111450170410451417348364551804794839643872324455490156426963773821964652385519393075073646193260017039391994317289516698863165631189739683305288120447200424391498376646686085100647822777706297051468474204958854675868534108069068727135675736578916481170092283193250304079147")
+	} else if number == "6" {
+		return MakeRSAWithKeys("68712351107036437585558705394329932588021453857860002869669357991132586124756852552170712566827012427858101219618342474924223484377006108583647716433567632020410463771552291860494123411766514967952437791952149536595761761768628201669701017186368206738007739214514369690280938959709348367114444332007867429293050870824281366539775693622799966913656661963258346342063688423549783151910273001303698713413753940866803337598653489349374695693083666618952340366801511166307844828148472432093460461261574440793050106855026669923821302882500596809281593084599607212884045166225929286154650189492119251332062599", "45808234071357625057039136929553288392014302571906668579779571994088390749837901701447141711218008285238734146412228316616148989584670739055765144289045088013606975847701527906996082274511009978634958527968099691063841174512418801113134011457578804492005159476342913126853959306472898911409629554671900562891662517910310816976048692788121342291618014156450442211850894825573035111466101918246333531693242849867852990861822107357625185920032273742245112863764196731704101713955660553343809048635685453501018233883060616462317688347186989653622928858407676200919168956518687164103793355239275219789957227")
+	} else if number == "7" {
+		return MakeRSAWithKeys("91273955433896845510861081477211261393472951931840460054284580008859771006155212340140914787944390979120878930868609996748058338377907640267677073912279817976565958447357541072604121964619900931316478594350118528701705448279141426514958466636086268075062290138629777662471099352388822521900394668942098551444827506570358387824412636648945544583564346168203783434336720700601682369105551602717253212251522431865494980399642085639650473281351181921205615546964230228380781298938116442898519184131549614047592945564571080985964305710217520577386421357275728766359526997545444234524045366917234420371495547", "60849303622597897007240720984807507595648634621226973369523053339239847337436808226760609858629593986080585953912406664498705558918605093511784715941519878651043972298238360715069414643079933954210985729566745685801136965519427617676638977757390845383374860092419851774980732901592548347933596445961386267208512158936772511903756932088854615177782343747050024542617812991958723910140318864835765370320427471027760923989513917970228497157097274228721872030968193465764382197457306647061271751006469270808021101220186242685745944137832107706962543049504351247550396764180885509402107674673111483343461787")
+	} else if number == "8" {
+		return MakeRSAWithKeys("93794657355404229319431128515003855336007553691660833870546161779998773890778963918610615793046674621435648393754855465140820703534244156001995273674589296398055231116947487910019524886278710170329803131021053795853655945796124400948014542456370545523186541835638621833119654376852910734839180322005666717956119166548684174165313913116800090123914818313442355435582269314510251304868551601140242896427931023264834388541950156747332715777556673901039316230552233797602769052653802383292709024448454683898134884115648887478942523963719887462236797537388653795357302782037638846557126523009545287101796433", "62529771570269486212954085676669236890671702461107222580364107853332515927185975945740410528697783080957098929169903643427213802356162770667996849116392864265370154077964991940013016590852473446886535420680702530569103963864082933965343028304247030348791027890425747888746436251235273823226120214670431563924686410594852264011717447911867965421792457711609768721398045113825769696270387407334328842613191390579920555424156978849748835588041653519311851435698539337440204744765491196153239257941728858976517501464047535513082183750917382887638010506211749109922606527099218176875462650114570015548353827")
+	} else if number == "9" {
+		return MakeRSAWithKeys("84128649689229141748476650346323678486437898780555562239274803331023504201661721263725702164844226716062121140282102659840393631295501503873123285321391312474481459683725818683741140427611468317891812252761172109050348451968978555634590357968074943405603291955327118302707346701747767186913466136936866428063285478486512344926095099958608452772818854082739431085262847212088429662485943109069166647077422997592232691907767336984413736921170670999915528283134193422301868063392769631810144899315337178298078695275092232062924257321021504333397583129567164544715249346168224769592996908555919759005032463", "56085766459486094498984433564215785657625265853703708159516535554015669467774480842483801443229484477374747426854735106560262420863667669248748856880927541649654306455817212455827426951740978878594541501840781406033565634645985703756393571978716628937068861303551412201804897801165178124608977424624565383660090020108279414641760356014618805194026664668950447058660780645842250453168342552799489026854312248658849391273762450578714474116884341495389211345085680851007877682232233392631306652138191781996971108044388337365555941608247827596381515834264967330548744937537617112889038432355010227570468939")
+	} else {
+		return MakeRSAWithKeys("70621195703417734770343058091658851655361561261137761610884679258670223399663358264109783765671832663936755207486891476183124001771723250631352914064206920117635941024601491293081835825560660689267333988928709259887345879848794348613877724159584075736686419675997111138048781709667966034965470003107432057065409218721592297689386038371608402840880519345471603774550343846256833649393555592852268617261622997674448275202937119980781626022142094513891745877971273140692436832862996188468524311487549280326916150648541763048073345791515098905458134163062720372875194208748523538910421681658158526277952333", "47080797135611823180228705394439234436907707507425174407256452839113482266442238842739855843781221775957836804991260984122082667847815500420901942709471280078423960683067660862054557217040440459511555992619139506591563919899196232409251816106389383824457613117331407425365854473111977356643646668738276832537633132845787805744042592646044493003311754064041832749213975665596294833811787512683785544903252943781383044290811821447018358747441605357665865259282762476668907306423923701622064148912823436616682105409323617355598360462566986128445001798326379207490257344784110525958297045948387829570574059")
 	}
 }
 
@@ -895,7 +1452,7 @@ func SearchAndRemove(slice Block, elem string) Block { //Takes first element in
 		//fmt.Println("Did not remove element from nextBlock")
 		return slice
 	}
-	fmt.Println("Removed element from nextBlock")
+	DefaultLogger.For("peer").Debug("Removed element from nextBlock", "id", elem)
 	slice[pos] = slice[0]
 	return slice[1:]
 }
@@ -3,16 +3,23 @@ package main
 import (
 	"fmt"
 	"net"
+	"os"
 	"testing"
 	"time"
 )
 
+//the test networks run on the genesis accounts, like a network started with -legacy-signatures
+func TestMain(m *testing.M) {
+	LegacySignaturesAllowed = true
+	os.Exit(m.Run())
+}
+
 func TestShouldReadMarshalledValuesCorrectlyFromConn(t *testing.T) { //pass
 	peer1, _, listener, listener2 := connectTwoPeers(t)
 	defer listener.Close()
 	defer listener2.Close()
 	time.Sleep(1 * time.Second)
-	realConn := peer1.connectionsURI
+	realConn := peer1.peerTable.URIs()
 	fmt.Println("Testing on this array:", realConn, "with length: ", len(realConn))
 	marshalledConn := peer1.MarshalConnectionsURI(realConn)
 	fmt.Println("Marshalled ConnectionsURI: ", marshalledConn)
@@ -54,14 +61,17 @@ func TestShouldSendVerifiedTransactionCorrectlyToAnotherPeer(t *testing.T) {
 	publicKey := (newRsa.n).String()
 	secretKey := (newRsa.d).String()
 
-	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, "bob", 200, secretKey)
+	bob := AddressOfPublicKey(MakeRSA(1024).PublicKeyString())
+	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, bob, 200, secretKey)
 	defer listener3.Close()
 	time.Sleep(2 * time.Second)
-	if !(peer3.ledger.Accounts[publicKey] == -200) {
-		fmt.Println("ledger3 account not right, has value", peer1.ledger.Accounts[publicKey])
-	} else if !(peer2.ledger.Accounts[publicKey] == -200) {
+	if peer1.ledger.Accounts[bob] != 0 || peer3.ledger.Accounts[bob] != 0 {
+		fmt.Println("bob got money from an account without any")
+	} else if !(peer3.ledger.Accounts[AddressOfPublicKey(publicKey)] == -200) {
+		fmt.Println("ledger3 account not right, has value", peer1.ledger.Accounts[AddressOfPublicKey(publicKey)])
+	} else if !(peer2.ledger.Accounts[AddressOfPublicKey(publicKey)] == -200) {
 		fmt.Println("ledger2 account not right")
-	} else if !(peer1.ledger.Accounts[publicKey] == -200) {
+	} else if !(peer1.ledger.Accounts[AddressOfPublicKey(publicKey)] == -200) {
 		fmt.Println("ledger1 account not right")
 	} else {
 		fmt.Println("Test passed, the tranasction was succesfully sent and verified at all peers")
@@ -79,14 +89,18 @@ func TestShouldNotSendUnVerifiedTransactionCorrectlyToAnotherPeer(t *testing.T)
 	publicKey := (newRsa.n).String()
 	secretKey := "wrong"
 
-	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, "bob", 200, secretKey)
+	//to a valid address, so only the signature is wrong
+	bob := AddressOfPublicKey(MakeRSA(1024).PublicKeyString())
+	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, bob, 200, secretKey)
 	defer listener3.Close()
 	time.Sleep(2 * time.Second)
-	if !(peer3.ledger.Accounts[publicKey] == 0) {
-		fmt.Println("ledger3 account not right, has value", peer1.ledger.Accounts[publicKey])
-	} else if !(peer2.ledger.Accounts[publicKey] == 0) {
+	if peer1.ledger.Accounts[bob] != 0 || peer3.ledger.Accounts[bob] != 0 {
+		fmt.Println("bob got money from a transaction with a wrong signature")
+	} else if !(peer3.ledger.Accounts[AddressOfPublicKey(publicKey)] == 0) {
+		fmt.Println("ledger3 account not right, has value", peer1.ledger.Accounts[AddressOfPublicKey(publicKey)])
+	} else if !(peer2.ledger.Accounts[AddressOfPublicKey(publicKey)] == 0) {
 		fmt.Println("ledger2 account not right")
-	} else if !(peer1.ledger.Accounts[publicKey] == 0) {
+	} else if !(peer1.ledger.Accounts[AddressOfPublicKey(publicKey)] == 0) {
 		fmt.Println("ledger1 account not right")
 	} else {
 		fmt.Println("Test passed, the tranasction was not sent and thus was not verified")
@@ -129,7 +143,7 @@ func TestConnurishouldhavelen10after10peers(t *testing.T) { //pass
 	}
 
 	time.Sleep(2 * time.Second)
-	conns := peer1.connectionsURI
+	conns := peer1.peerTable.URIs()
 
 	if len(conns) == 10 {
 		fmt.Println("Test passed with conns:", conns)
@@ -148,7 +162,7 @@ func TestConnuriANDConnsshouldhavelen10and9after10peers(t *testing.T) { //pass
 	}
 
 	time.Sleep(2 * time.Second)
-	conns := peer1.connectionsURI
+	conns := peer1.peerTable.URIs()
 	conns2 := peer1.connections
 
 	if len(conns) == 10 && len(conns2) == 9 {
@@ -170,7 +184,7 @@ func TestShouldOnlyConnectTo10lastConnURI(t *testing.T) { //fails
 	defer listener2.Close()
 
 	time.Sleep(2 * time.Second)
-	conns := peer2.connectionsURI
+	conns := peer2.peerTable.URIs()
 	conns2 := peer2.connections
 	//Conns2 should be 22, 12 peers connected to it, it connected to 10 peers.
 	if len(conns) == 14 && len(conns2) >= 10 && len(conns2) <= 11 {
@@ -187,8 +201,9 @@ func peerFixture() *Peer {
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
+	transportStrategy := MakeTCPTransportStrategy()
 
-	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 	return peer
 }
 
@@ -198,7 +213,8 @@ func connectTwoPeers(t *testing.T) (*Peer, *Peer, net.Listener, net.Listener) {
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	realOutboundIPStrategy := new(RealOutboundIPStrategy)
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
-	peer1 := MakePeer(fixedUriStrategy1, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy)
+	transportStrategy := MakeTCPTransportStrategy()
+	peer1 := MakePeer(fixedUriStrategy1, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 
 	peer1.JoinNetwork(peer1.GetURI())
 	listener := peer1.StartListeningForConnections()
@@ -206,7 +222,7 @@ func connectTwoPeers(t *testing.T) (*Peer, *Peer, net.Listener, net.Listener) {
 	go peer1.TakeNewConnection(listener)
 
 	fixedUriStrategy2 := MakeFixedUriStrategy(peer1.ip, peer1.port)
-	peer2 := MakePeer(fixedUriStrategy2, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy)
+	peer2 := MakePeer(fixedUriStrategy2, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 
 	peer2.JoinNetwork(peer2.GetURI())
 	listener2 := peer2.StartListeningForConnections()
@@ -231,7 +247,8 @@ func connectNewPeer(peer *Peer, t *testing.T) {
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	realOutboundIPStrategy := new(RealOutboundIPStrategy)
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
-	newPeer := MakePeer(fixedUriStrategy1, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy)
+	transportStrategy := MakeTCPTransportStrategy()
+	newPeer := MakePeer(fixedUriStrategy1, fixedInputStrategy, realOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 	newPeer.JoinNetwork(newPeer.GetURI())
 	listener := newPeer.StartListeningForConnections()
 	defer listener.Close()
@@ -265,7 +282,8 @@ func createPeer(ip string, port string) (*Peer, net.Listener) {
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
-	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	transportStrategy := MakeTCPTransportStrategy()
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 
 	uri := peer.GetURI()
 
@@ -286,14 +304,15 @@ func createPeer(ip string, port string) (*Peer, net.Listener) {
 	return peer, listener
 }
 
-func createPeerWithTransaction(ip string, port string, from string, to string, amount int, secret string) (*Peer, net.Listener) {
+func createPeerWithTransaction(ip string, port string, from string, to Address, amount int, secret string) (*Peer, net.Listener) {
 	transaction := MakeSignedTransaction(from, to, amount, secret)
 
 	fixedUriStrategy := MakeFixedUriStrategy(ip, port)
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
 	messageSendingStrategy := new(RealMessageSendingStrategy)
-	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	transportStrategy := MakeTCPTransportStrategy()
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 
 	peer.slotLength = 1           //Set slotlength
 	peer.connectionThreshold = 10 //Set threshold to begin network
@@ -323,7 +342,7 @@ func takeNewConnectionsHelp(peer *Peer, listener net.Listener) {
 }
 
 func send1message(peer *Peer) {
-	msg := peer.userInputStrategy.HandleIncomingFromUser()
+	msg, _ := peer.userInputStrategy.HandleIncomingFromUser()
 	peer.outbound <- msg
 }
 
@@ -377,10 +396,13 @@ func TestLedgerGetsUpdatedWithValidTransAcc(t *testing.T) { //This test relies o
 	publicKey := "84128649689229141748476650346323678486437898780555562239274803331023504201661721263725702164844226716062121140282102659840393631295501503873123285321391312474481459683725818683741140427611468317891812252761172109050348451968978555634590357968074943405603291955327118302707346701747767186913466136936866428063285478486512344926095099958608452772818854082739431085262847212088429662485943109069166647077422997592232691907767336984413736921170670999915528283134193422301868063392769631810144899315337178298078695275092232062924257321021504333397583129567164544715249346168224769592996908555919759005032463"
 	secretKey := "56085766459486094498984433564215785657625265853703708159516535554015669467774480842483801443229484477374747426854735106560262420863667669248748856880927541649654306455817212455827426951740978878594541501840781406033565634645985703756393571978716628937068861303551412201804897801165178124608977424624565383660090020108279414641760356014618805194026664668950447058660780645842250453168342552799489026854312248658849391273762450578714474116884341495389211345085680851007877682232233392631306652138191781996971108044388337365555941608247827596381515834264967330548744937537617112889038432355010227570468939"
 
-	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, "bob", 25000, secretKey)
+	bob := AddressOfPublicKey(MakeRSA(1024).PublicKeyString())
+	peer3, listener3 := createPeerWithTransaction(peer1.ip, peer1.port, publicKey, bob, 25000, secretKey)
 	defer listener3.Close()
 	time.Sleep(6 * time.Second)
-	if peer1.ledger.Accounts[publicKey] <= 975000 && peer3.ledger.Accounts[publicKey] <= 975000 {
+	//the receiver gets the amount minus the fee of 1
+	if peer1.ledger.Accounts[AddressOfPublicKey(publicKey)] <= 975000 && peer3.ledger.Accounts[AddressOfPublicKey(publicKey)] <= 975000 &&
+		peer1.ledger.Accounts[bob] == 24999 && peer3.ledger.Accounts[bob] == 24999 {
 		fmt.Println("PASS: The transaction to Bob Went through!")
 		fmt.Println("This is Peer1's ledger:")
 		peer1.ledger.Print()
@@ -397,7 +419,8 @@ func createGenPeerX(ip string, port string, number int, slotLength float64) (*Pe
 	fixedInputStrategy := MakeFixedInputStrategy(*transaction)
 	fixedOutboundIPStrategy := MakeFixedOutboundIPStrategy("localhost")
 	messageSendingStrategy := MakeStubbedMessageSendingStrategy()
-	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy)
+	transportStrategy := MakeTCPTransportStrategy()
+	peer := MakePeer(fixedUriStrategy, fixedInputStrategy, fixedOutboundIPStrategy, messageSendingStrategy, transportStrategy)
 	peer.rsa = makeGenesisRSAX(number)
 	peer.slotLength = slotLength  //Set slotlength
 	peer.connectionThreshold = 10 //Set threshold to begin network